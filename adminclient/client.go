@@ -0,0 +1,142 @@
+package adminclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Client talks to the /admin endpoints of a gorag server
+type Client struct {
+	BaseURL string
+	APIKey  string
+	HTTP    *http.Client
+}
+
+func New(baseURL, apiKey string) *Client {
+	return &Client{BaseURL: baseURL, APIKey: apiKey, HTTP: &http.Client{}}
+}
+
+// ErrNotFound is returned when the named object does not exist
+var ErrNotFound = fmt.Errorf("not found")
+
+func (c *Client) do(method, kind, name string, in, out interface{}) error {
+	u := c.BaseURL + "/admin/" + kind
+	if name != "" {
+		u += "/" + url.PathEscape(name)
+	}
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s: %s", method, u, resp.Status, data)
+	}
+	if out != nil && len(data) > 0 {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+func (c *Client) ListProfiles() ([]Profile, error) {
+	var out []Profile
+	return out, c.do("GET", "profiles", "", nil, &out)
+}
+
+func (c *Client) GetProfile(name string) (*Profile, error) {
+	var out Profile
+	return &out, c.do("GET", "profiles", name, nil, &out)
+}
+
+func (c *Client) PutProfile(p Profile) (*Profile, error) {
+	var out Profile
+	return &out, c.do("PUT", "profiles", p.Name, p, &out)
+}
+
+func (c *Client) DeleteProfile(name string) error {
+	return c.do("DELETE", "profiles", name, nil, nil)
+}
+
+func (c *Client) ListDenyRules() ([]DenyRule, error) {
+	var out []DenyRule
+	return out, c.do("GET", "deny-rules", "", nil, &out)
+}
+
+func (c *Client) GetDenyRule(name string) (*DenyRule, error) {
+	var out DenyRule
+	return &out, c.do("GET", "deny-rules", name, nil, &out)
+}
+
+func (c *Client) PutDenyRule(r DenyRule) (*DenyRule, error) {
+	var out DenyRule
+	return &out, c.do("PUT", "deny-rules", r.Name, r, &out)
+}
+
+func (c *Client) DeleteDenyRule(name string) error {
+	return c.do("DELETE", "deny-rules", name, nil, nil)
+}
+
+func (c *Client) ListSavedQuestions() ([]SavedQuestion, error) {
+	var out []SavedQuestion
+	return out, c.do("GET", "saved-questions", "", nil, &out)
+}
+
+func (c *Client) GetSavedQuestion(name string) (*SavedQuestion, error) {
+	var out SavedQuestion
+	return &out, c.do("GET", "saved-questions", name, nil, &out)
+}
+
+func (c *Client) PutSavedQuestion(q SavedQuestion) (*SavedQuestion, error) {
+	var out SavedQuestion
+	return &out, c.do("PUT", "saved-questions", q.Name, q, &out)
+}
+
+func (c *Client) DeleteSavedQuestion(name string) error {
+	return c.do("DELETE", "saved-questions", name, nil, nil)
+}
+
+func (c *Client) ListAPIKeys() ([]APIKey, error) {
+	var out []APIKey
+	return out, c.do("GET", "api-keys", "", nil, &out)
+}
+
+func (c *Client) GetAPIKey(name string) (*APIKey, error) {
+	var out APIKey
+	return &out, c.do("GET", "api-keys", name, nil, &out)
+}
+
+// PutAPIKey creates or replaces a key. If k.Key is empty, the server generates one and returns it.
+func (c *Client) PutAPIKey(k APIKey) (*APIKey, error) {
+	var out APIKey
+	return &out, c.do("PUT", "api-keys", k.Name, k, &out)
+}
+
+func (c *Client) DeleteAPIKey(name string) error {
+	return c.do("DELETE", "api-keys", name, nil, nil)
+}
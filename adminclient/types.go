@@ -0,0 +1,65 @@
+package adminclient
+
+/*
+  These are the objects a gorag deployment is configured with. The
+  server keeps them in its config file, and this package is what you
+  use to manage them remotely (eg: from a terraform provider).
+  Every object is keyed by name, and PUT replaces it, so applying the
+  same definition twice is harmless.
+*/
+
+// Profile is a database that questions can be asked against
+type Profile struct {
+	Name        string `json:"name"`
+	DSN         string `json:"dsn"`
+	Metadata    string `json:"metadata,omitempty"`
+	SchemaCache string `json:"schema_cache,omitempty"`
+	Credentials string `json:"credentials,omitempty"` // the provider minting its logins; empty is the server's -db-credentials
+	// which models write the SQL, and which see the result data; empty is the server's default
+	SQLModel  *ModelEndpoint `json:"sql_model,omitempty"`
+	DataModel *ModelEndpoint `json:"data_model,omitempty"`
+	// the tables generated SQL may touch here, instead of the config's, and more it never may
+	AllowedTables []string `json:"allowed_tables,omitempty"`
+	DeniedTables  []string `json:"denied_tables,omitempty"`
+}
+
+// ModelEndpoint is an OpenAI compatible api, hosted or local
+type ModelEndpoint struct {
+	URL       string `json:"url,omitempty"`         // base url, eg: http://localhost:11434/v1
+	Model     string `json:"model,omitempty"`       // model name at that url
+	APIKeyEnv string `json:"api_key_env,omitempty"` // environment variable holding its key, if it needs one
+}
+
+// DenyRule refuses generated SQL that touches tables or matches a pattern
+type DenyRule struct {
+	Name    string   `json:"name"`
+	Profile string   `json:"profile,omitempty"` // empty means every profile
+	Tables  []string `json:"tables,omitempty"`
+	Pattern string   `json:"pattern,omitempty"` // regexp against the SQL
+	Reason  string   `json:"reason,omitempty"`
+}
+
+// SavedQuestion is a named prompt that can be run on demand
+type SavedQuestion struct {
+	Name    string `json:"name"`
+	Profile string `json:"profile,omitempty"`
+	Prompt  string `json:"prompt"`
+	// where each answer goes when it runs, as -sink takes them
+	Sinks []string `json:"sinks,omitempty"`
+	// why it doesn't run, set when the schema dropped what its query used; putting it again clears it
+	Broken string `json:"broken,omitempty"`
+}
+
+/*
+  APIKey grants access to the server. Only the hash of the key is
+  stored; Key is only filled in when the server generated one for you.
+*/
+type APIKey struct {
+	Name    string `json:"name"`
+	Key     string `json:"key,omitempty"`
+	KeyHash string `json:"key_hash,omitempty"`
+	Admin   bool   `json:"admin,omitempty"`
+	// may override purpose restrictions, with a justification that is audited
+	CanOverride bool     `json:"can_override,omitempty"`
+	Profiles    []string `json:"profiles,omitempty"` // empty means every profile
+}
@@ -0,0 +1,370 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+  gorag advise reads the audit log for queries that keep coming back
+  and cost the planner a lot, and asks the model for the indexes or
+  materialized views that would make them cheap. It prints DDL for a
+  person to review; it never creates anything. With -hypopg, proposed
+  indexes are tried as hypothetical indexes (the hypopg extension)
+  in a transaction that is rolled back, and the before and after
+  costs are shown.
+
+  With -pg-stat-statements it also reads what the database measured
+  for our queries, the ones tagged gorag, which is better than the
+  audit log's durations (those include the model) and covers queries
+  the audit log never saw. The slow ones go to the model too, which
+  can suggest prompt hints as well as DDL, for when the problem is how
+  the query is written rather than what is indexed; -save-hints puts
+  them in the config's query_hints, which the SQL prompt includes.
+  -every repeats all of it, for a long running advisor.
+*/
+type queryPattern struct {
+	Fingerprint string
+	Example     string
+	Count       int
+	TotalMs     int64
+	Cost        float64 // 0 when it couldn't be planned
+	Normalized  bool    // the Example has $1 for its literals
+}
+
+// fingerprint is the query with literals taken out, so repeats with different values group together
+func fingerprint(query string) string {
+	tokens := lexSQL(query)
+	parts := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		switch t.Kind {
+		case sqlString, sqlNumber:
+			parts = append(parts, "?")
+		case sqlWord:
+			if len(t.Text) > 1 && t.Text[0] == '$' && strings.Trim(t.Text[1:], "0123456789") == "" {
+				// pg_stat_statements' $1 is a literal taken out already
+				parts = append(parts, "?")
+				continue
+			}
+			parts = append(parts, strings.ToLower(t.Text))
+		default:
+			parts = append(parts, t.Text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// recurringQueries groups the successful asks for a profile, keeping those seen at least minCount times
+func recurringQueries(filename, profile string, minCount int) ([]*queryPattern, error) {
+	byPrint := make(map[string]*queryPattern)
+	err := readAuditLog(filename, func(e AuditEvent) {
+		if e.Event != "ask" || e.Error != "" || e.Query == "" || e.Profile != profile {
+			return
+		}
+		fp := fingerprint(e.Query)
+		p, ok := byPrint[fp]
+		if !ok {
+			p = &queryPattern{Fingerprint: fp, Example: e.Query}
+			byPrint[fp] = p
+		}
+		p.Count++
+		p.TotalMs += e.DurationMs
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	out := make([]*queryPattern, 0)
+	for _, p := range byPrint {
+		if p.Count >= minCount {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+/*
+  slowStatements reads pg_stat_statements for our queries averaging
+  over minMeanMs. The text is normalized, $1 for each literal, so
+  these usually can't be planned as they are.
+*/
+func slowStatements(db queryer, minMeanMs float64, limit int) ([]*queryPattern, error) {
+	query := `SELECT query, calls, total_exec_time FROM pg_stat_statements
+		WHERE dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+		AND query LIKE '/* gorag%%' AND mean_exec_time >= $1
+		ORDER BY total_exec_time DESC LIMIT $2`
+	rows, err := db.Query(query, minMeanMs, limit)
+	if err != nil {
+		// before postgres 13 the columns were total_time and mean_time
+		old := strings.NewReplacer("total_exec_time", "total_time", "mean_exec_time", "mean_time").Replace(query)
+		var oldErr error
+		if rows, oldErr = db.Query(old, minMeanMs, limit); oldErr != nil {
+			return nil, fmt.Errorf("failed to read pg_stat_statements (is the extension created?): %v", err)
+		}
+	}
+	defer rows.Close()
+	out := make([]*queryPattern, 0)
+	for rows.Next() {
+		var text string
+		var calls int
+		var total float64
+		if err := rows.Scan(&text, &calls, &total); err != nil {
+			return nil, err
+		}
+		text = strings.TrimSpace(text[strings.Index(text, "*/")+2:])
+		out = append(out, &queryPattern{Fingerprint: fingerprint(text), Example: text, Count: calls, TotalMs: int64(total), Normalized: true})
+	}
+	return out, rows.Err()
+}
+
+// mergeMeasured adds the database's numbers to the audit log's patterns, and the patterns it didn't have
+func mergeMeasured(patterns, measured []*queryPattern) []*queryPattern {
+	byPrint := make(map[string]*queryPattern)
+	for _, p := range patterns {
+		byPrint[p.Fingerprint] = p
+	}
+	for _, m := range measured {
+		if p, ok := byPrint[m.Fingerprint]; ok {
+			// keep the audited example, which has its values and can be planned
+			p.Count, p.TotalMs = m.Count, m.TotalMs
+			continue
+		}
+		patterns = append(patterns, m)
+	}
+	return patterns
+}
+
+type Advice struct {
+	Kind    string `json:"kind"` // index or materialized_view
+	DDL     string `json:"ddl"`
+	Reason  string `json:"reason"`
+	Queries []int  `json:"queries"`
+	// filled in by -hypopg, per query
+	Before []float64 `json:"-"`
+	After  []float64 `json:"-"`
+}
+
+func (c *Client) advise(patterns []*queryPattern) ([]*Advice, []string, error) {
+	var sb strings.Builder
+	for i, p := range patterns {
+		cost := "unknown"
+		if p.Cost > 0 {
+			cost = fmt.Sprintf("%.0f", p.Cost)
+		}
+		sb.WriteString(fmt.Sprintf("Query %d, asked %d times, average %dms, planner cost %s:\n%s\n\n",
+			i+1, p.Count, p.TotalMs/int64(p.Count), cost, p.Example))
+	}
+	var out struct {
+		Advice []*Advice `json:"advice"`
+		Hints  []string  `json:"hints"`
+	}
+	err := c.llmJSON(fmt.Sprintf(`
+You are a PostgreSQL performance expert. The database schema is as follows:
+
+%s
+These queries are asked over and over, and are expensive:
+
+%s
+Propose the few indexes or materialized views that would speed them up the most.
+Don't propose indexes that the primary keys already provide. For a materialized
+view, say how it would be refreshed in the reason.
+These queries were written by a model from people's questions. Where a query is
+slow because of how it is written (eg: a function on an indexed column, a
+correlated subquery, an unneeded DISTINCT), also give a short hint, one sentence
+each, that would steer whoever writes the SQL away from that pattern.
+http response must be application/json:
+{ "advice": [ { "kind": "index or materialized_view", "ddl": "CREATE ...", "reason": "...", "queries": [1, 2] } ],
+  "hints": [ "..." ] }
+`, formatSchema(c.Schema), sb.String()), &out)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get advice: %v", err)
+	}
+	return out.Advice, out.Hints, nil
+}
+
+// saveHints adds new hints to the config, and says how many were new
+func (c *Client) saveHints(hints []string) (int, error) {
+	c.Config.mu.Lock()
+	defer c.Config.mu.Unlock()
+	have := make(map[string]bool)
+	for _, h := range c.Config.QueryHints {
+		have[strings.ToLower(h)] = true
+	}
+	added := 0
+	for _, h := range hints {
+		if h = strings.TrimSpace(h); h != "" && !have[strings.ToLower(h)] {
+			c.Config.QueryHints = append(c.Config.QueryHints, h)
+			have[strings.ToLower(h)] = true
+			added++
+		}
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, c.Config.save()
+}
+
+// tryIndex costs the advice's queries with the index as a hypothetical one
+func (c *Client) tryIndex(a *Advice, patterns []*queryPattern) error {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("SELECT * FROM hypopg_create_index($1)", a.DDL); err != nil {
+		return fmt.Errorf("hypopg: %v", err)
+	}
+	defer tx.Exec("SELECT hypopg_reset()")
+	for _, n := range a.Queries {
+		if n < 1 || n > len(patterns) {
+			continue
+		}
+		plan, err := explainQuery(c.runContext(), tx, patterns[n-1].Example)
+		if err != nil {
+			return err
+		}
+		a.Before = append(a.Before, patterns[n-1].Cost)
+		a.After = append(a.After, plan.TotalCost)
+	}
+	return nil
+}
+
+func formatAdvice(advice []*Advice, hints []string, patterns []*queryPattern) string {
+	var sb strings.Builder
+	sb.WriteString("-- Suggested by gorag advise from the audit log. Review before running.\n\n")
+	for i, p := range patterns {
+		sb.WriteString(fmt.Sprintf("-- query %d (asked %d times, average %dms, cost %.0f): %s\n",
+			i+1, p.Count, p.TotalMs/int64(p.Count), p.Cost, strings.Join(strings.Fields(p.Example), " ")))
+	}
+	if len(hints) > 0 {
+		sb.WriteString("\n")
+		for _, h := range hints {
+			sb.WriteString(fmt.Sprintf("-- prompt hint: %s\n", h))
+		}
+	}
+	for _, a := range advice {
+		sb.WriteString(fmt.Sprintf("\n-- %s: %s\n", strings.ReplaceAll(a.Kind, "_", " "), a.Reason))
+		if len(a.Queries) > 0 {
+			nums := make([]string, len(a.Queries))
+			for i, n := range a.Queries {
+				nums[i] = fmt.Sprint(n)
+			}
+			sb.WriteString(fmt.Sprintf("-- for queries %s\n", strings.Join(nums, ", ")))
+		}
+		for i := range a.After {
+			sb.WriteString(fmt.Sprintf("-- hypothetical: query %d cost %.0f -> %.0f\n", a.Queries[i], a.Before[i], a.After[i]))
+		}
+		sb.WriteString(strings.TrimRight(strings.TrimSpace(a.DDL), ";") + ";\n")
+	}
+	return sb.String()
+}
+
+func runAdvise(args []string) {
+	fs := commandFlags("advise")
+	minCount := fs.Int("min-count", 3, "only queries asked at least this many times")
+	top := fs.Int("top", 10, "how many of the most expensive recurring queries to advise on")
+	hypopg := fs.Bool("hypopg", false, "check proposed indexes with hypothetical index EXPLAIN (needs the hypopg extension)")
+	out := fs.String("out", "", "write the DDL here instead of stdout")
+	statStatements := fs.Bool("pg-stat-statements", false, "also advise on our slow queries as pg_stat_statements measured them")
+	minMeanMs := fs.Float64("min-mean-ms", 100, "with -pg-stat-statements, only queries averaging at least this")
+	saveHints := fs.Bool("save-hints", false, "add the prompt hints to the config's query_hints")
+	every := fs.Duration("every", 0, "advise again at this interval, eg: 24h; 0 advises once")
+	fs.Parse(args)
+	if err := requirePostgres("gorag advise"); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	client, done := setupClient()
+	defer done()
+	if client.Audit.Filename() == "" && !*statStatements {
+		log.Fatalf("advise needs -audit-log, or -pg-stat-statements")
+	}
+	for {
+		if err := adviseOnce(client, *minCount, *top, *hypopg, *statStatements, *minMeanMs, *saveHints, *out); err != nil {
+			if *every == 0 {
+				log.Fatalf("%v", err)
+			}
+			log.Printf("%v", err)
+		}
+		if *every == 0 {
+			return
+		}
+		time.Sleep(*every)
+	}
+}
+
+func adviseOnce(client *Client, minCount, top int, hypopg, statStatements bool, minMeanMs float64, saveHints bool, out string) error {
+	patterns := make([]*queryPattern, 0)
+	if client.Audit.Filename() != "" {
+		var err error
+		if patterns, err = recurringQueries(client.Audit.Filename(), client.Profile, minCount); err != nil {
+			return err
+		}
+	}
+	if statStatements {
+		measured, err := slowStatements(client.DB, minMeanMs, top)
+		if err != nil {
+			return err
+		}
+		patterns = mergeMeasured(patterns, measured)
+	}
+	for _, p := range patterns {
+		if p.Normalized {
+			// there are no values to plan it with
+			continue
+		}
+		plan, err := explainQuery(client.runContext(), client.DB, p.Example)
+		if err != nil {
+			log.Printf("Can't cost a recurring query, schema may have changed: %v", err)
+			continue
+		}
+		p.Cost = plan.TotalCost
+	}
+	// weigh cost by how often it is paid, or use the time the database measured when we have it
+	sort.Slice(patterns, func(i, j int) bool {
+		if statStatements {
+			return patterns[i].TotalMs > patterns[j].TotalMs
+		}
+		return patterns[i].Cost*float64(patterns[i].Count) > patterns[j].Cost*float64(patterns[j].Count)
+	})
+	if len(patterns) > top {
+		patterns = patterns[:top]
+	}
+	if len(patterns) == 0 {
+		log.Printf("No query was asked %d times yet, or is slow, nothing to advise", minCount)
+		return nil
+	}
+	advice, hints, err := client.advise(patterns)
+	if err != nil {
+		return err
+	}
+	if hypopg {
+		for _, a := range advice {
+			if a.Kind != "index" {
+				continue
+			}
+			if err := client.tryIndex(a, patterns); err != nil {
+				log.Printf("Couldn't check %s: %v", a.DDL, err)
+			}
+		}
+	}
+	if saveHints && len(hints) > 0 {
+		added, err := client.saveHints(hints)
+		if err != nil {
+			return fmt.Errorf("failed to save hints: %v", err)
+		}
+		log.Printf("Added %d prompt hints to %s", added, client.Config.filename)
+	}
+	script := formatAdvice(advice, hints, patterns)
+	if out == "" {
+		fmt.Print(script)
+		return nil
+	}
+	if err := os.WriteFile(out, []byte(script), 0644); err != nil {
+		return fmt.Errorf("failed to write advice: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package gorag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+/*
+  Written in one go, a query for a hard question is a guess about
+  what the data looks like: which status values there are, whether a
+  join fans out, what a column really holds. With -agent-steps, the
+  model is given an execute_sql tool instead, to try queries and see
+  their rows or errors before it answers with the one that answers
+  the question, up to that many queries. Each of them is a single
+  SELECT, checked by the policies like any query before it runs, and
+  at most -agent-bytes of its rows go back. Those rows are data, so
+  the loop goes to the data model rather than the sql model, writing
+  with the sql temperature. Only providers that can call tools
+  (openai) can do this; the others write the query in one go.
+*/
+var agentSteps = Flags.Int("agent-steps", 0, "let the model try up to this many queries with an execute_sql tool before it answers; 0 writes the query in one go")
+var agentBytes = Flags.Int("agent-bytes", 8000, "bytes of each tried query's rows the model sees, with -agent-steps")
+
+// Tool is a function the model may call, as OpenAI describes one
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// ToolCall is the model calling a Tool, with its arguments as json
+type ToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// toolProvider is a provider that can offer the model tools; its reply may call them instead of answering
+type toolProvider interface {
+	CompleteTools(ctx context.Context, messages []Message, tools []Tool, opts CompletionOptions) (Message, error)
+}
+
+var executeSQLTool = Tool{
+	Type: "function",
+	Function: ToolFunction{
+		Name:        "execute_sql",
+		Description: "Run one read-only SELECT against the database, and see its rows or its error",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{"type": "string", "description": "the SQL query"},
+			},
+			"required": []string{"query"},
+		},
+	},
+}
+
+type agentGenerator struct {
+	c *Client
+}
+
+func (g agentGenerator) GenerateSQL(schema *DBMetadata, examples []*Example, question, feedback string) (string, error) {
+	return g.c.agentSQL(schema, examples, question, feedback)
+}
+
+// agentSQL has the model try queries with execute_sql until it answers with one
+func (c *Client) agentSQL(schema *DBMetadata, examples []*Example, userInput, feedback string) (string, error) {
+	provider, err := currentProvider()
+	if err != nil {
+		return "", err
+	}
+	p, ok := provider.(toolProvider)
+	if !ok {
+		log.Printf("-llm %s can't call tools, so the query is written in one go", *llmProvider)
+		return c.generateSQL(schema, examples, userInput, feedback)
+	}
+	t, err := c.budgeted(c.target("data", ""))
+	if err != nil {
+		return "", err
+	}
+	t.Temperature = stageTemperature("sql")
+	prompt := c.sqlPrompt(schema, examples, userInput, feedback) + fmt.Sprintf(`
+Before answering, you can run up to %d queries with the execute_sql tool, to
+look at the data and to try the query out. When you have the query that answers
+the request, reply with just its json, calling no tool.
+`, c.AgentSteps)
+	log.Printf("SQL prompt is about %d tokens", estimateTokens(prompt))
+	messages := []Message{{Role: "user", Content: c.Pseudonyms.hide(prompt)}}
+	tools := []Tool{executeSQLTool}
+	// each turn but the last runs at least one query, so a model still calling tools after that won't stop
+	steps := 0
+	for turn := 0; turn <= c.AgentSteps; turn++ {
+		reply, err := p.CompleteTools(t.context(), messages, tools, t.CompletionOptions)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate SQL: %v", err)
+		}
+		if t.OnCall != nil {
+			t.OnCall(messages[len(messages)-1].Content, agentReplyText(reply), false)
+		}
+		if len(reply.ToolCalls) == 0 {
+			var queryResponse struct {
+				Query string `json:"query"`
+			}
+			if err := parseJSONReply(c.Pseudonyms.reveal(reply.Content), &queryResponse); err != nil {
+				return "", fmt.Errorf("failed to generate SQL: %v", err)
+			}
+			return queryResponse.Query, nil
+		}
+		messages = append(messages, reply)
+		for _, call := range reply.ToolCalls {
+			var result string
+			if steps >= c.AgentSteps {
+				result = "No more queries can run. Reply with the query that answers the request."
+			} else {
+				steps++
+				result = c.agentTool(call)
+			}
+			messages = append(messages, Message{Role: "tool", ToolCallID: call.ID, Content: c.Pseudonyms.hide(result)})
+		}
+		// the last turn can only answer
+		if steps >= c.AgentSteps {
+			tools = nil
+		}
+	}
+	return "", fmt.Errorf("failed to generate SQL: the model was still calling tools after %d turns", c.AgentSteps+1)
+}
+
+// agentTool runs the model's call, answering with the rows or what went wrong, as the model should see them
+func (c *Client) agentTool(call ToolCall) string {
+	if call.Function.Name != executeSQLTool.Function.Name {
+		return fmt.Sprintf("There is no tool %s, only %s.", call.Function.Name, executeSQLTool.Function.Name)
+	}
+	var args struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+		return fmt.Sprintf("error: the arguments aren't json with a query: %v", err)
+	}
+	query := c.Pseudonyms.reveal(args.Query)
+	log.Printf("The model tries: %s", query)
+	if ops := sqlOperations(query); len(ops) != 1 || ops[0] != "SELECT" {
+		return "error: only one SELECT can run"
+	}
+	q := c.Run
+	if q == nil {
+		q = &Question{}
+	}
+	query, err := c.Validate(q, query)
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	buf, err := c.runQuery(c.reader(), query, 0, 0)
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	defer buf.Close()
+	if buf.rows == 0 {
+		return "(no rows)"
+	}
+	max := c.AgentBytes
+	if c.MaxLLMBytes > 0 && c.MaxLLMBytes < max {
+		max = c.MaxLLMBytes
+	}
+	s, truncated := buf.head(max)
+	if truncated {
+		s += fmt.Sprintf("\n(only the first %d bytes of %d rows are shown)", max, buf.rows)
+	}
+	return s
+}
+
+// agentReplyText is what a reply says, or the tools it calls, for -bundle-dir
+func agentReplyText(reply Message) string {
+	if len(reply.ToolCalls) == 0 {
+		return reply.Content
+	}
+	calls := make([]string, len(reply.ToolCalls))
+	for i, call := range reply.ToolCalls {
+		calls[i] = call.Function.Name + " " + call.Function.Arguments
+	}
+	return strings.Join(calls, "\n")
+}
@@ -0,0 +1,121 @@
+package gorag
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+/*
+  -summary-data aggregates keeps raw rows away from the model. Instead
+  of the rows, the summary prompt gets what a follow-up query computes
+  over the result: the row count, sums, averages and ranges of the
+  numbers, ranges of the dates, and how many distinct values the other
+  columns have, naming only the common ones (at least -min-label-rows
+  rows each), so a single customer's name never goes. The rows are
+  still in the answer for whoever asked; only the model is kept from
+  them, and the judge sees the same aggregates.
+*/
+var summaryData = Flags.String("summary-data", "rows", "what the model summarizes: rows, or aggregates (counts, sums, ranges and common values only)")
+var minLabelRows = Flags.Int("min-label-rows", 5, "with -summary-data aggregates, a value is only named if at least this many rows have it")
+
+// columnKind says which aggregates make sense for a database type name
+func columnKind(dbType string) string {
+	switch dbType {
+	case "INT2", "INT4", "INT8", "NUMERIC", "FLOAT4", "FLOAT8":
+		return "number"
+	case "DATE", "TIMESTAMP", "TIMESTAMPTZ", "TIME", "TIMETZ", "INTERVAL":
+		return "time"
+	case "TEXT", "VARCHAR", "BPCHAR", "CHAR", "NAME", "BOOL", "UUID", "CITEXT":
+		return "label"
+	}
+	return "other"
+}
+
+// aggregateQuery computes everything in one pass over the result, which is materialized once
+func aggregateQuery(query string, kinds []string, minRows int) string {
+	aliases := make([]string, len(kinds))
+	for i := range kinds {
+		aliases[i] = fmt.Sprintf("c%d", i+1)
+	}
+	selects := []string{"count(*)::text"}
+	for i, kind := range kinds {
+		c := aliases[i]
+		selects = append(selects, fmt.Sprintf("count(%s)::text", c))
+		switch kind {
+		case "number":
+			selects = append(selects, fmt.Sprintf("sum(%s)::text, avg(%s)::text, min(%s)::text, max(%s)::text", c, c, c, c))
+		case "time":
+			selects = append(selects, fmt.Sprintf("min(%s)::text, max(%s)::text", c, c))
+		case "label":
+			selects = append(selects, fmt.Sprintf("count(DISTINCT %s)::text", c))
+			selects = append(selects, fmt.Sprintf(`(SELECT string_agg(coalesce(v, 'null') || ' (' || n || ' rows)', ', ' ORDER BY n DESC, v)
+				FROM (SELECT %s::text AS v, count(*) AS n FROM r GROUP BY 1 HAVING count(*) >= %d ORDER BY 2 DESC, 1 LIMIT 5) t)`, c, minRows))
+		}
+	}
+	return fmt.Sprintf("WITH r(%s) AS MATERIALIZED (\n%s\n)\nSELECT %s FROM r",
+		strings.Join(aliases, ", "), query, strings.Join(selects, ",\n"))
+}
+
+// aggregates describes the result of query without any of its rows
+func (c *Client) aggregates(query string) (string, error) {
+	if err := requirePostgres("-summary-data aggregates"); err != nil {
+		return "", err
+	}
+	query = trimStatement(query)
+	rows, err := c.reader().Query("SELECT * FROM (\n" + query + "\n) AS r LIMIT 0")
+	if err != nil {
+		return "", fmt.Errorf("failed to get result columns: %v", err)
+	}
+	types, err := rows.ColumnTypes()
+	rows.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to get result columns: %v", err)
+	}
+	kinds := make([]string, len(types))
+	for i, t := range types {
+		kinds[i] = columnKind(t.DatabaseTypeName())
+	}
+
+	agg := aggregateQuery(query, kinds, c.MinLabelRows)
+	n := 1
+	for _, kind := range kinds {
+		n += map[string]int{"number": 5, "time": 3, "label": 3, "other": 1}[kind]
+	}
+	values := make([]sql.NullString, n)
+	dest := make([]interface{}, n)
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := c.reader().QueryRow(c.annotate(agg)).Scan(dest...); err != nil {
+		return "", fmt.Errorf("failed to aggregate result: %v", err)
+	}
+	next := func() string {
+		v := values[0]
+		values = values[1:]
+		if !v.Valid {
+			return "none"
+		}
+		return v.String
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("The result has %s rows. Only these aggregates of it are shown, not the rows:\n\n", next()))
+	for i, kind := range kinds {
+		name := types[i].Name()
+		sb.WriteString(fmt.Sprintf("%s: %s values", name, next()))
+		switch kind {
+		case "number":
+			sb.WriteString(fmt.Sprintf(", sum %s, average %s, from %s to %s", next(), next(), next(), next()))
+		case "time":
+			sb.WriteString(fmt.Sprintf(", from %s to %s", next(), next()))
+		case "label":
+			sb.WriteString(fmt.Sprintf(", %s distinct", next()))
+			if common := next(); common != "none" {
+				sb.WriteString(", most common " + common)
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,227 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+  gorag analytics reads the ask events in -audit-log, and reports how
+  often the model managed to write a query (generation) and how often
+  the database ran the query it wrote (execution), per -bucket, for
+  each model, each -easy-model and -hard-model route, or each table:
+
+    gorag analytics -audit-log audit.jsonl -by model -bucket 24h
+
+  Drift compares the last -recent with the -baseline before it, and a
+  rate that fell by -drop or more, with -min-attempts in both periods,
+  is reported; with -alert it also goes to the -sink sinks and the
+  command fails, so a scheduler notices, eg: after the model behind a
+  name changed upstream. By table, only execution is counted, since a
+  query that was never written has no tables. Events from before the
+  audit log counted attempts count as one of each.
+*/
+func runAnalytics(args []string) {
+	fs := commandFlags("analytics")
+	by := fs.String("by", "model", "model, route or table")
+	bucket := fs.Duration("bucket", 24*time.Hour, "the period each rate is over")
+	since := fs.Duration("since", 30*24*time.Hour, "how far back to report")
+	recent := fs.Duration("recent", 24*time.Hour, "for drift, the period that is compared")
+	baseline := fs.Duration("baseline", 7*24*time.Hour, "for drift, the period before -recent it is compared with")
+	drop := fs.Float64("drop", 0.1, "for drift, how far a rate has to fall, eg: 0.1 is 10 points")
+	minAttempts := fs.Int("min-attempts", 20, "for drift, the fewest attempts in each period to compare them")
+	alert := fs.Bool("alert", false, "send drift to the -sink sinks, and exit 1 when there is any")
+	fs.Parse(args)
+	enforceNoExternalCalls()
+
+	if *auditLog == "" {
+		log.Fatalf("analytics needs -audit-log")
+	}
+	if *by != "model" && *by != "route" && *by != "table" {
+		log.Fatalf("-by must be model, route or table")
+	}
+	now := time.Now()
+	from := now.Add(-*since)
+	if d := now.Add(-*recent - *baseline); d.Before(from) {
+		from = d
+	}
+	events := make([]AuditEvent, 0)
+	err := readAuditLog(*auditLog, func(e AuditEvent) {
+		if e.Event == "ask" && !e.Time.Before(from) && (*profileName == "" || e.Profile == *profileName) {
+			events = append(events, e)
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", *auditLog, err)
+	}
+	fmt.Print(formatAnalytics(successByBucket(events, *by, *bucket, now.Add(-*since)), *by))
+
+	drifts := findDrift(events, *by, now, *recent, *baseline, *drop, *minAttempts)
+	for _, d := range drifts {
+		fmt.Println(d)
+	}
+	if !*alert || len(drifts) == 0 {
+		return
+	}
+	sinks, err := parseSinks(strings.Split(*sinkSpecs, ","))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	answer := &Answer{
+		RunID:   newRunID(),
+		Prompt:  "Query success dropped",
+		Summary: strings.Join(drifts, "\n"),
+	}
+	if err := sendAll(sinks, answer, ""); err != nil {
+		log.Printf("%v", err)
+	}
+	os.Exit(1)
+}
+
+// successCounts are attempts to write and run queries, and how many of them worked
+type successCounts struct {
+	Generations, Generated int
+	Executions, Executed   int
+}
+
+func (s *successCounts) add(e AuditEvent, generation bool) {
+	generations, failedGenerations := e.Generations, e.FailedGenerations
+	executions, failedExecutions := e.Executions, e.FailedExecutions
+	if generations == 0 && executions == 0 {
+		// logged before attempts were
+		if e.Query == "" && e.Error != "" {
+			generations, failedGenerations = 1, 1
+		} else if e.Query != "" {
+			generations, executions = 1, 1
+			if e.Error != "" {
+				failedExecutions = 1
+			}
+		}
+	}
+	if generation {
+		s.Generations += generations
+		s.Generated += generations - failedGenerations
+	}
+	s.Executions += executions
+	s.Executed += executions - failedExecutions
+}
+
+func rate(ok, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return float64(ok) / float64(of)
+}
+
+// eventKeys is what the event is counted under: its model, or each of its tables
+func eventKeys(e AuditEvent, by string) []string {
+	if by == "table" {
+		return e.Tables
+	}
+	if by == "route" {
+		if e.Route == "" {
+			return []string{"unrouted"}
+		}
+		return []string{e.Route + " " + e.Model}
+	}
+	if e.Model == "" {
+		return []string{"unknown"}
+	}
+	return []string{e.Model}
+}
+
+// successByBucket is the counts for each key, and each bucket from since
+func successByBucket(events []AuditEvent, by string, bucket time.Duration, since time.Time) map[string]map[time.Time]*successCounts {
+	out := make(map[string]map[time.Time]*successCounts)
+	for _, e := range events {
+		if e.Time.Before(since) {
+			continue
+		}
+		at := e.Time.UTC().Truncate(bucket)
+		for _, key := range eventKeys(e, by) {
+			if out[key] == nil {
+				out[key] = make(map[time.Time]*successCounts)
+			}
+			if out[key][at] == nil {
+				out[key][at] = &successCounts{}
+			}
+			out[key][at].add(e, by != "table")
+		}
+	}
+	return out
+}
+
+func formatAnalytics(counts map[string]map[time.Time]*successCounts, by string) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for _, key := range keys {
+		sb.WriteString(fmt.Sprintf("%s %s\n", by, key))
+		times := make([]time.Time, 0, len(counts[key]))
+		for at := range counts[key] {
+			times = append(times, at)
+		}
+		sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+		for _, at := range times {
+			s := counts[key][at]
+			generation := "-"
+			if by != "table" {
+				generation = fmt.Sprintf("%.1f%% (%d/%d)", 100*rate(s.Generated, s.Generations), s.Generated, s.Generations)
+			}
+			sb.WriteString(fmt.Sprintf("  %s  generation %s  execution %.1f%% (%d/%d)\n",
+				at.Format("2006-01-02 15:04"), generation, 100*rate(s.Executed, s.Executions), s.Executed, s.Executions))
+		}
+	}
+	return sb.String()
+}
+
+// findDrift is each rate that fell from the baseline to the recent period
+func findDrift(events []AuditEvent, by string, now time.Time, recent, baseline time.Duration, drop float64, minAttempts int) []string {
+	before := make(map[string]*successCounts)
+	after := make(map[string]*successCounts)
+	for _, e := range events {
+		var into map[string]*successCounts
+		switch {
+		case !e.Time.Before(now.Add(-recent)):
+			into = after
+		case !e.Time.Before(now.Add(-recent - baseline)):
+			into = before
+		default:
+			continue
+		}
+		for _, key := range eventKeys(e, by) {
+			if into[key] == nil {
+				into[key] = &successCounts{}
+			}
+			into[key].add(e, by != "table")
+		}
+	}
+	drifts := make([]string, 0)
+	for key, a := range after {
+		b, ok := before[key]
+		if !ok {
+			continue
+		}
+		compare := func(what string, okBefore, ofBefore, okAfter, ofAfter int) {
+			if ofBefore < minAttempts || ofAfter < minAttempts {
+				return
+			}
+			was, is := rate(okBefore, ofBefore), rate(okAfter, ofAfter)
+			if was-is >= drop {
+				drifts = append(drifts, fmt.Sprintf("drift: %s %s %s fell from %.1f%% to %.1f%% in the last %s (%d attempts, against %d in the %s before)",
+					by, key, what, 100*was, 100*is, recent, ofAfter, ofBefore, baseline))
+			}
+		}
+		compare("generation", b.Generated, b.Generations, a.Generated, a.Generations)
+		compare("execution", b.Executed, b.Executions, a.Executed, a.Executions)
+	}
+	sort.Strings(drifts)
+	return drifts
+}
@@ -0,0 +1,195 @@
+package gorag
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+  "Is anything unusual about signups?" is a question about a series,
+  and the model is poor at spotting the odd point in a column of
+  numbers. So a question worded like that asks for one row per time
+  bucket instead, and the anomalies are found here, in each numeric
+  column in turn: the trend, a moving median over -anomaly-window
+  buckets, is taken out, and a bucket is flagged when what's left is
+  more than -anomaly-z robust z-scores (from the median and median
+  absolute deviation, so the outliers don't hide themselves) from the
+  rest. The summary is given the flagged buckets with the rows, to
+  say what stands out and when. "Odd" or "unusual" describes rows as
+  often as a series ("customers with an odd number of orders"), so
+  a question worded like this is put to the model to confirm before
+  its rows are bucketed. -anomaly-z 0 answers these questions as
+  usual.
+*/
+var anomalyZ = Flags.Float64("anomaly-z", 3.5, "flag buckets this many robust z-scores from the trend, for questions about anything unusual; 0 answers them as usual")
+var anomalyWindow = Flags.Int("anomaly-window", 7, "buckets in the moving median taken as the trend, before looking for anomalies; 0 or 1 for no trend")
+
+// anomalyBuckets is as many rows as are looked at for anomalies, or forecast from
+const anomalyBuckets = 10000
+
+// Anomaly is a bucket whose value stands out
+type Anomaly struct {
+	Bucket   string  `json:"bucket"`
+	Column   string  `json:"column"`
+	Value    float64 `json:"value"`
+	Expected float64 `json:"expected"` // the trend there
+	Z        float64 `json:"z"`
+}
+
+var unusual = regexp.MustCompile(`(?i)\b(unusual|anomal\w*|outliers?|spikes?|dips?|abnormal\w*|irregular\w*|(anything|something) (odd|strange|weird|unexpected)|out of the ordinary)\b`)
+
+// wantsAnomalies is whether the question asks what is unusual in a series
+func (c *Client) wantsAnomalies(question string) (bool, error) {
+	if c.AnomalyZ <= 0 || !unusual.MatchString(question) {
+		return false, nil
+	}
+	var answer struct {
+		Anomalies bool `json:"anomalies"`
+	}
+	err := c.llmJSON(fmt.Sprintf(`
+A question about a database may ask what is unusual in a measure over time,
+like "were there any unusual spikes in signups?". Those are found by looking
+for the buckets that stand out from the trend.
+
+Say whether this question is one. It isn't when the words describe the rows it
+asks for ("customers with an unusual shipping address") rather than points in
+a series: %s
+http response must be application/json:
+{ "anomalies": true or false }
+`, question), &answer)
+	if err != nil {
+		return false, fmt.Errorf("failed to ask whether the question wants anomalies: %v", err)
+	}
+	return answer.Anomalies, nil
+}
+
+// anomalyQuestion asks for the question's series, one row per bucket
+func anomalyQuestion(question string) string {
+	return question + `
+
+Answer with one row per time bucket (hour, day, week or month, as suits the
+question and the data), the bucket in the first column and the rows in its
+order, and every measure the question is about as a number in the other columns.`
+}
+
+// median of xs, which it sorts
+func median(xs []float64) float64 {
+	sort.Float64s(xs)
+	n := len(xs)
+	if n%2 == 1 {
+		return xs[n/2]
+	}
+	return (xs[n/2-1] + xs[n/2]) / 2
+}
+
+// trend is the centered moving median of window values around each one, which a spike doesn't drag along
+func trend(values []float64, window int) []float64 {
+	out := make([]float64, len(values))
+	if window <= 1 {
+		m := median(append([]float64(nil), values...))
+		for i := range out {
+			out[i] = m
+		}
+		return out
+	}
+	for i := range values {
+		from, to := i-window/2, i+(window-1)/2+1
+		if from < 0 {
+			from = 0
+		}
+		if to > len(values) {
+			to = len(values)
+		}
+		out[i] = median(append([]float64(nil), values[from:to]...))
+	}
+	return out
+}
+
+// seriesAnomalies is the robust z-score of each value from its trend, and the trend
+func seriesAnomalies(values []float64, window int) ([]float64, []float64) {
+	expected := trend(values, window)
+	residuals := make([]float64, len(values))
+	for i, v := range values {
+		residuals[i] = v - expected[i]
+	}
+	center := median(append([]float64(nil), residuals...))
+	deviations := make([]float64, len(residuals))
+	for i, r := range residuals {
+		deviations[i] = math.Abs(r - center)
+	}
+	// 1.4826 makes the MAD a standard deviation, for normally distributed residuals
+	scale := 1.4826 * median(deviations)
+	if scale == 0 {
+		var sum float64
+		for _, r := range residuals {
+			sum += (r - center) * (r - center)
+		}
+		scale = math.Sqrt(sum / float64(len(residuals)))
+	}
+	scores := make([]float64, len(values))
+	if scale == 0 {
+		return scores, expected
+	}
+	for i, r := range residuals {
+		scores[i] = (r - center) / scale
+	}
+	return scores, expected
+}
+
+// findAnomalies looks through each numeric column of a result with a bucket first
+func findAnomalies(t *resultTable, window int, z float64) []Anomaly {
+	var found []Anomaly
+	if t == nil || len(t.Columns) < 2 || len(t.Rows) < 3 {
+		return nil
+	}
+	for col := 1; col < len(t.Columns); col++ {
+		var buckets []string
+		var values []float64
+		for _, row := range t.Rows {
+			if row[col] == nil {
+				continue
+			}
+			v, err := strconv.ParseFloat(*row[col], 64)
+			if err != nil {
+				values = nil
+				break
+			}
+			bucket := ""
+			if row[0] != nil {
+				bucket = *row[0]
+			}
+			buckets = append(buckets, bucket)
+			values = append(values, v)
+		}
+		if len(values) < 3 {
+			continue
+		}
+		scores, expected := seriesAnomalies(values, window)
+		for i, s := range scores {
+			if math.Abs(s) > z {
+				found = append(found, Anomaly{Bucket: buckets[i], Column: t.Columns[col], Value: values[i], Expected: expected[i], Z: s})
+			}
+		}
+	}
+	return found
+}
+
+// anomalyPrompt is the question and the flagged buckets, as the summary should take them
+func anomalyPrompt(question string, found []Anomaly, t *resultTable) string {
+	var sb strings.Builder
+	sb.WriteString(question)
+	sb.WriteString("\n\n")
+	if len(found) == 0 {
+		sb.WriteString(fmt.Sprintf("A check of the %d buckets against their trend flagged nothing; say that nothing stands out.", len(t.Rows)))
+		return sb.String()
+	}
+	sb.WriteString(fmt.Sprintf("A check of the %d buckets against their trend flagged these; say what stands out, when, and by how much:\n", len(t.Rows)))
+	for _, a := range found {
+		sb.WriteString(fmt.Sprintf("- %s %s: %g, against a trend of %.4g (z = %.1f)\n", a.Bucket, a.Column, a.Value, a.Expected, a.Z))
+	}
+	return sb.String()
+}
@@ -0,0 +1,51 @@
+package gorag
+
+import "testing"
+
+func TestUnusualWording(t *testing.T) {
+	cases := []struct {
+		question string
+		want     bool
+	}{
+		{"is anything unusual about signups?", true},
+		{"were there spikes in refunds last year?", true},
+		{"find outliers in daily revenue", true},
+		{"did anything odd happen to logins in march?", true},
+
+		{"customers with an odd number of orders", false},
+		{"which product stands out by revenue?", false},
+		{"list strange characters in names", false},
+		{"orders with unexpected delays", false},
+	}
+	for _, tc := range cases {
+		if got := unusual.MatchString(tc.question); got != tc.want {
+			t.Errorf("unusual.MatchString(%q) = %v, want %v", tc.question, got, tc.want)
+		}
+	}
+}
+
+func TestWantsAnomalies(t *testing.T) {
+	cases := []struct {
+		z        float64
+		question string
+		reply    string
+		want     bool
+		calls    int
+	}{
+		{3.5, "is anything unusual about signups?", `{"anomalies": true}`, true, 1},
+		{3.5, "customers with an unusual shipping address", `{"anomalies": false}`, false, 1},
+		{3.5, "customers with an odd number of orders", `{"anomalies": true}`, false, 0},
+		{0, "is anything unusual about signups?", `{"anomalies": true}`, false, 0},
+	}
+	for _, tc := range cases {
+		calls := withAnswer(t, tc.reply)
+		c := &Client{AnomalyZ: tc.z}
+		got, err := c.wantsAnomalies(tc.question)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want || *calls != tc.calls {
+			t.Errorf("wantsAnomalies(%q) with -anomaly-z %v = %v after %d calls, want %v after %d", tc.question, tc.z, got, *calls, tc.want, tc.calls)
+		}
+	}
+}
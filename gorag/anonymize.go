@@ -0,0 +1,154 @@
+package gorag
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+  -anonymize is for deployments that can't send schema names to a
+  hosted model. Every table and column name gets a pseudonym, t_ for
+  tables and c_ for columns, and every prompt has the real names
+  replaced before it leaves, including the question and the rows in a
+  summary prompt. What comes back has the pseudonyms replaced with the
+  real names, so the generated SQL runs as is. The pseudonyms are an
+  HMAC of the name, so they are stable across runs and schema changes,
+  and can't be reversed by guessing common names unless the key
+  (GORAG_ANON_KEY) is known. Only names in the schema are hidden:
+  index names in a plan, or values in sample rows, go as they are.
+*/
+var anonymize = Flags.Bool("anonymize", false, "replace table and column names with pseudonyms in everything sent to the model")
+
+type pseudonyms struct {
+	fake   map[string]string // lower case real name -> pseudonym
+	real   map[string]string // pseudonym -> real name
+	names  *regexp.Regexp
+	hidden *regexp.Regexp
+}
+
+func newPseudonyms(schema *DBMetadata, key string) *pseudonyms {
+	p := &pseudonyms{fake: make(map[string]string), real: make(map[string]string)}
+	add := func(prefix, name string) {
+		lower := strings.ToLower(name)
+		if _, ok := p.fake[lower]; ok || lower == "" {
+			return
+		}
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(lower))
+		sum := hex.EncodeToString(mac.Sum(nil))
+		// a collision just takes more of the hash
+		for n := 8; ; n++ {
+			fake := prefix + sum[:n]
+			if _, taken := p.real[fake]; !taken {
+				p.fake[lower] = fake
+				p.real[fake] = name
+				return
+			}
+		}
+	}
+	tables := make([]string, 0, len(schema.Tables))
+	for t := range schema.Tables {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	for _, t := range tables {
+		add("t_", t)
+	}
+	for _, t := range tables {
+		for _, col := range schema.Tables[t] {
+			add("c_", col)
+		}
+	}
+
+	// longest first, so order_items is replaced before order
+	names := make([]string, 0, len(p.fake))
+	for name := range p.fake {
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > 0 {
+		// a trailing s too, so "customers" hides the table customer
+		p.names = regexp.MustCompile(`(?i)\b(` + strings.Join(names, "|") + `)s?\b`)
+	}
+	p.hidden = regexp.MustCompile(`"?\b[tc]_[0-9a-f]{8,}\b"?`)
+	return p
+}
+
+/*
+  hide replaces every schema name in text with its pseudonym. A name
+  that is also a keyword, like a table called order, is only replaced
+  where it is quoted or plural, so ORDER BY in a literal query stays.
+*/
+func (p *pseudonyms) hide(text string) string {
+	if p == nil || p.names == nil {
+		return text
+	}
+	var sb strings.Builder
+	last := 0
+	for _, m := range p.names.FindAllStringIndex(text, -1) {
+		word := strings.ToLower(text[m[0]:m[1]])
+		fake, ok := p.fake[word]
+		if !ok {
+			fake = p.fake[strings.TrimSuffix(word, "s")]
+		} else if isSQLKeyword(strings.ToUpper(word)) && (m[0] == 0 || text[m[0]-1] != '"') {
+			continue
+		}
+		sb.WriteString(text[last:m[0]])
+		sb.WriteString(fake)
+		last = m[1]
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
+
+// reveal puts the real names back, quoted where SQL needs them to be
+func (p *pseudonyms) reveal(text string) string {
+	if p == nil {
+		return text
+	}
+	return p.hidden.ReplaceAllStringFunc(text, func(word string) string {
+		if name, ok := p.real[strings.Trim(word, `"`)]; ok {
+			return quoteIdent(name)
+		}
+		return word
+	})
+}
+
+// revealAll reveals every string in a decoded JSON value
+func (p *pseudonyms) revealAll(v interface{}) interface{} {
+	switch x := v.(type) {
+	case string:
+		return p.reveal(x)
+	case []interface{}:
+		for i := range x {
+			x[i] = p.revealAll(x[i])
+		}
+	case map[string]interface{}:
+		for k := range x {
+			x[k] = p.revealAll(x[k])
+		}
+	}
+	return v
+}
+
+func pseudonymsFor(schema *DBMetadata) *pseudonyms {
+	if !*anonymize {
+		return nil
+	}
+	key := os.Getenv("GORAG_ANON_KEY")
+	if key == "" {
+		log.Printf("Warning: GORAG_ANON_KEY is not set, so pseudonyms of common names can be guessed")
+	}
+	return newPseudonyms(schema, key)
+}
@@ -0,0 +1,86 @@
+package gorag
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+var anonymizedSchema = &DBMetadata{Tables: map[string][]string{
+	"customers": {"id", "email", "signup_date"},
+	"orders":    {"id", "customer_id", "total"},
+}}
+
+func TestPseudonymsAreStable(t *testing.T) {
+	p := newPseudonyms(anonymizedSchema, "key")
+	again := newPseudonyms(anonymizedSchema, "key")
+	grown := newPseudonyms(&DBMetadata{Tables: map[string][]string{
+		"accounts":  {"owner"},
+		"customers": {"email", "signup_date", "id"},
+		"orders":    {"total", "id", "customer_id"},
+	}}, "key")
+	other := newPseudonyms(anonymizedSchema, "another key")
+	for name, fake := range p.fake {
+		if again.fake[name] != fake {
+			t.Errorf("%s is %s, and %s the next time", name, fake, again.fake[name])
+		}
+		if grown.fake[name] != fake {
+			t.Errorf("%s is %s, and %s with another table", name, fake, grown.fake[name])
+		}
+		if other.fake[name] == fake {
+			t.Errorf("%s is %s with either key", name, fake)
+		}
+		if p.real[fake] != name {
+			t.Errorf("%s reveals %s, not %s", fake, p.real[fake], name)
+		}
+	}
+}
+
+// recording is a provider that keeps each prompt it is sent, and replies with one completion
+type recording struct {
+	reply   string
+	prompts *[]string
+}
+
+func (r recording) Complete(ctx context.Context, messages []Message, opts CompletionOptions) (string, error) {
+	for _, m := range messages {
+		*r.prompts = append(*r.prompts, m.Content)
+	}
+	return r.reply, nil
+}
+
+func TestAnonymizedPrompts(t *testing.T) {
+	withDriver(t, "postgres")
+	p := newPseudonyms(anonymizedSchema, "key")
+	prompts := make([]string, 0)
+	reply := fmt.Sprintf(`{"query": "SELECT %s, %s FROM %s"}`, p.fake["email"], p.fake["total"], p.fake["customers"])
+	RegisterLLMProvider("recording", recording{reply: reply, prompts: &prompts})
+	old := *llmProvider
+	*llmProvider = "recording"
+	t.Cleanup(func() {
+		*llmProvider = old
+		delete(llmProviders, "recording")
+	})
+
+	c := &Client{Pseudonyms: p}
+	query, err := c.llmQuery(`Which Customers spent the most? Tables:
+customers(id, email, signup_date)
+orders(id, customer_id, total)
+Rows: email=a@b.c, "total"=10, Orders.customer_id=7`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "SELECT email, total FROM customers"; query != want {
+		t.Errorf("the query came back as %s, want %s", query, want)
+	}
+	leak := regexp.MustCompile(`(?i)\b(customers?|orders?|email|signup_date|customer_id|total)\b`)
+	for _, prompt := range prompts {
+		if names := leak.FindAllString(prompt, -1); len(names) > 0 {
+			t.Errorf("the prompt has the real names %v:\n%s", names, prompt)
+		}
+	}
+	if len(prompts) == 0 {
+		t.Errorf("nothing was sent to the model")
+	}
+}
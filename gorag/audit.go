@@ -0,0 +1,132 @@
+package gorag
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+  The audit log is one json object per line, appended as things
+  happen, so it's trivial to ship to a log pipeline and to read back
+  for analysis. Every question gets an "ask" event; policy overrides
+  get their own event so they stand out.
+*/
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	RunID      string    `json:"run_id,omitempty"`
+	User       string    `json:"user,omitempty"`
+	Profile    string    `json:"profile,omitempty"`
+	Prompt     string    `json:"prompt,omitempty"`
+	Purpose    string    `json:"purpose,omitempty"`
+	Query      string    `json:"query,omitempty"`
+	Tables     []string  `json:"tables,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Rewrite    string    `json:"rewrite,omitempty"` // for opa: the query the policy ran instead
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms,omitempty"`
+	TraceID    string    `json:"trace_id,omitempty"`
+	Examples   []string  `json:"examples,omitempty"` // in the sql prompt, by exampleKey
+	Helpful    *bool     `json:"helpful,omitempty"`  // for feedback
+	// for asks: the stage that failed, and how many times the query was written and run, and failed to be
+	Stage             string `json:"stage,omitempty"`
+	Generations       int    `json:"generations,omitempty"`
+	FailedGenerations int    `json:"failed_generations,omitempty"`
+	Executions        int    `json:"executions,omitempty"`
+	FailedExecutions  int    `json:"failed_executions,omitempty"`
+	// for asks routed by difficulty: easy or hard, and the difficulty from 0 to 1
+	Route      string  `json:"route,omitempty"`
+	Difficulty float64 `json:"difficulty,omitempty"`
+	// for model calls
+	Model            string  `json:"model,omitempty"`
+	Provider         string  `json:"provider,omitempty"`
+	PromptTokens     int     `json:"prompt_tokens,omitempty"`
+	CompletionTokens int     `json:"completion_tokens,omitempty"`
+	CostUSD          float64 `json:"cost_usd,omitempty"`
+}
+
+type AuditLog struct {
+	mu       sync.Mutex
+	f        *os.File
+	filename string
+}
+
+// openAuditLog returns nil when filename is empty, and a nil log records nothing
+func openAuditLog(filename string) (*AuditLog, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{f: f, filename: filename}, nil
+}
+
+func (a *AuditLog) Record(e AuditEvent) {
+	if a == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Failed to marshal audit event: %v", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to write audit event: %v", err)
+	}
+}
+
+// Filename is where the log is, for reading it back, and "" when there is no log
+func (a *AuditLog) Filename() string {
+	if a == nil {
+		return ""
+	}
+	return a.filename
+}
+
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.f.Close()
+}
+
+// readAuditLog calls fn with each event in the log, skipping lines that don't parse
+func readAuditLog(filename string, fn func(AuditEvent)) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e AuditEvent
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		fn(e)
+	}
+	return scanner.Err()
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
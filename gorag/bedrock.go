@@ -0,0 +1,285 @@
+package gorag
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+  -llm bedrock calls models through AWS Bedrock's InvokeModel, signed
+  with SigV4 from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+  AWS_SESSION_TOKEN environment variables, so nothing goes to OpenAI.
+  Those are the only credentials read; there is no SDK credential
+  chain here, so a profile, SSO or an instance role has to be
+  exported to the environment first. Claude models (anthropic.*, and the us./eu. inference
+  profiles of them) get the messages api, pictures included; Titan
+  text models get inputText. Embeddings are a Titan embedding model,
+  one call per text:
+
+    AWS_REGION=us-east-1 ./gorag -llm bedrock \
+      -bedrock-model anthropic.claude-3-5-sonnet-20240620-v1:0
+
+  A profile's sql_model or data_model url is then another Bedrock
+  endpoint, eg: a VPC endpoint, and its model one enabled there.
+*/
+var bedrockRegion = Flags.String("bedrock-region", "", "with -llm bedrock, the region, AWS_REGION by default")
+var bedrockModel = Flags.String("bedrock-model", "anthropic.claude-3-5-sonnet-20240620-v1:0", "with -llm bedrock, the model id, a Claude or Titan text model")
+var bedrockEmbedModel = Flags.String("bedrock-embed-model", "amazon.titan-embed-text-v2:0", "with -llm bedrock, the Titan embedding model")
+var bedrockMaxTokens = Flags.Int("bedrock-max-tokens", 4096, "with -llm bedrock, the most tokens a reply may have, unless -max-tokens says")
+
+func bedrockCredentials() (awsCredentials, error) {
+	creds, err := awsCredentialsFromEnv()
+	if err != nil {
+		return creds, fmt.Errorf("bedrock: %v (only the environment is read, not ~/.aws or an instance role)", err)
+	}
+	if *bedrockRegion != "" {
+		creds.Region = *bedrockRegion
+	}
+	return creds, nil
+}
+
+// bedrockURL is the regional runtime endpoint
+func bedrockURL() string {
+	region := *bedrockRegion
+	if region == "" {
+		creds, _ := awsCredentialsFromEnv()
+		region = creds.Region
+	}
+	return "https://bedrock-runtime." + region + ".amazonaws.com"
+}
+
+// bedrockInvoke posts the body to the model's invoke, and returns the body of a 200
+func bedrockInvoke(ctx context.Context, t CompletionOptions, model string, v interface{}) ([]byte, error) {
+	creds, err := bedrockCredentials()
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// model ids have colons, and arns slashes
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(t.URL, "/")+"/model/"+awsURIEncode(model, true)+"/invoke", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if t.TraceParent != "" {
+		req.Header.Set("traceparent", t.TraceParent)
+	}
+	signV4(req, body, "bedrock", creds, time.Now())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(out, &e) == nil && e.Message != "" {
+			return nil, fmt.Errorf("bedrock: %s: %s", resp.Status, e.Message)
+		}
+		return nil, fmt.Errorf("bedrock: %s: %s", resp.Status, out)
+	}
+	return out, nil
+}
+
+// bedrockTokens is -max-tokens, or -bedrock-max-tokens, which Bedrock needs one of
+func bedrockTokens(t CompletionOptions) int {
+	if t.MaxTokens > 0 {
+		return t.MaxTokens
+	}
+	return *bedrockMaxTokens
+}
+
+func isClaude(model string) bool {
+	return strings.Contains(model, "anthropic.")
+}
+
+func isTitanText(model string) bool {
+	return strings.HasPrefix(model, "amazon.titan-text")
+}
+
+type claudeContent struct {
+	Type   string        `json:"type"`
+	Text   string        `json:"text,omitempty"`
+	Source *claudeSource `json:"source,omitempty"`
+}
+
+type claudeSource struct {
+	Type      string `json:"type"` // base64
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type claudeMessage struct {
+	Role    string          `json:"role"`
+	Content []claudeContent `json:"content"`
+}
+
+// claudeRequest is what InvokeModel takes for Claude
+func claudeRequest(messages []Message, t CompletionOptions) (interface{}, error) {
+	system := make([]string, 0)
+	out := make([]claudeMessage, 0, len(messages))
+	for _, m := range messages {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+			continue
+		}
+		msg := claudeMessage{Role: m.Role}
+		if m.Content != "" {
+			msg.Content = append(msg.Content, claudeContent{Type: "text", Text: m.Content})
+		}
+		for _, image := range m.Images {
+			kind, err := imageType(image)
+			if err != nil {
+				return nil, err
+			}
+			msg.Content = append(msg.Content, claudeContent{Type: "image", Source: &claudeSource{Type: "base64", MediaType: kind, Data: base64.StdEncoding.EncodeToString(image)}})
+		}
+		out = append(out, msg)
+	}
+	req := map[string]interface{}{
+		"anthropic_version": "bedrock-2023-05-31",
+		"max_tokens":        bedrockTokens(t),
+		"temperature":       t.Temperature,
+		"messages":          out,
+	}
+	if t.TopP > 0 {
+		req["top_p"] = t.TopP
+	}
+	if len(system) > 0 {
+		req["system"] = strings.Join(system, "\n")
+	}
+	return req, nil
+}
+
+type bedrockProvider struct{}
+
+func (bedrockProvider) Endpoint() (string, string) {
+	return bedrockURL(), *bedrockModel
+}
+
+// Complete sends the messages to the model, as its family wants them
+func (bedrockProvider) Complete(ctx context.Context, messages []Message, t CompletionOptions) (string, error) {
+	var text string
+	var promptTokens, completionTokens int
+	switch {
+	case isClaude(t.Model):
+		req, err := claudeRequest(messages, t)
+		if err != nil {
+			return "", err
+		}
+		body, err := bedrockInvoke(ctx, t, t.Model, req)
+		if err != nil {
+			return "", err
+		}
+		var out struct {
+			Content []claudeContent `json:"content"`
+			Usage   struct {
+				InputTokens  int `json:"input_tokens"`
+				OutputTokens int `json:"output_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal(body, &out); err != nil {
+			return "", fmt.Errorf("bedrock: %v", err)
+		}
+		for _, c := range out.Content {
+			text += c.Text
+		}
+		promptTokens, completionTokens = out.Usage.InputTokens, out.Usage.OutputTokens
+	case isTitanText(t.Model):
+		prompts := make([]string, 0, len(messages))
+		for _, m := range messages {
+			if len(m.Images) > 0 {
+				return "", fmt.Errorf("bedrock: %s can't see pictures", t.Model)
+			}
+			prompts = append(prompts, m.Content)
+		}
+		config := map[string]interface{}{
+			"temperature":   t.Temperature,
+			"maxTokenCount": bedrockTokens(t),
+		}
+		if t.TopP > 0 {
+			config["topP"] = t.TopP
+		}
+		body, err := bedrockInvoke(ctx, t, t.Model, map[string]interface{}{
+			"inputText":            strings.Join(prompts, "\n\n"),
+			"textGenerationConfig": config,
+		})
+		if err != nil {
+			return "", err
+		}
+		var out struct {
+			InputTextTokenCount int `json:"inputTextTokenCount"`
+			Results             []struct {
+				TokenCount int    `json:"tokenCount"`
+				OutputText string `json:"outputText"`
+			} `json:"results"`
+		}
+		if err := json.Unmarshal(body, &out); err != nil {
+			return "", fmt.Errorf("bedrock: %v", err)
+		}
+		promptTokens = out.InputTextTokenCount
+		for _, r := range out.Results {
+			text += r.OutputText
+			completionTokens += r.TokenCount
+		}
+	default:
+		return "", fmt.Errorf("bedrock: %s isn't a Claude or Titan text model", t.Model)
+	}
+	if t.OnUsage != nil {
+		t.OnUsage(promptTokens, completionTokens)
+	}
+	return text, nil
+}
+
+func (bedrockProvider) EmbeddingModel() string {
+	return *bedrockEmbedModel
+}
+
+// Embed gets one embedding per text from the Titan embedding model
+func (bedrockProvider) Embed(ctx context.Context, texts []string, opts CompletionOptions) ([][]float64, error) {
+	out := make([][]float64, 0, len(texts))
+	for _, text := range texts {
+		body, err := bedrockInvoke(ctx, CompletionOptions{URL: bedrockURL()}, *bedrockEmbedModel, map[string]interface{}{"inputText": text})
+		if err != nil {
+			return nil, err
+		}
+		var e struct {
+			Embedding []float64 `json:"embedding"`
+		}
+		if err := json.Unmarshal(body, &e); err != nil {
+			return nil, fmt.Errorf("bedrock: %v", err)
+		}
+		if len(e.Embedding) == 0 {
+			return nil, fmt.Errorf("bedrock: %s returned no embedding", *bedrockEmbedModel)
+		}
+		out = append(out, e.Embedding)
+	}
+	return out, nil
+}
+
+// Check is that there are credentials, and the model is one we can talk to
+func (bedrockProvider) Check(ctx context.Context, t CompletionOptions) error {
+	if _, err := bedrockCredentials(); err != nil {
+		return err
+	}
+	if !isClaude(t.Model) && !isTitanText(t.Model) {
+		return fmt.Errorf("bedrock: %s isn't a Claude or Titan text model", t.Model)
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package gorag
+
+import (
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+/*
+  With -driver bigquery, -host is the Google Cloud project and -dbname
+  the dataset, or GORAG_DSN is bigquery://project/dataset?location=EU.
+  The credentials are the application default ones
+  (GOOGLE_APPLICATION_CREDENTIALS, or gcloud auth application-default
+  login). BigQuery isn't a database/sql database, so the driver here
+  runs each query as a job, with the dataset as its default, and steps
+  through the job's rows as database/sql rows, so the rest of the
+  pipeline is none the wiser. The schema is read from the tables' own
+  metadata: columns (a RECORD's fields as record.field), descriptions
+  and the unenforced keys. Names are kept in lower case, which is how
+  the checks compare them: BigQuery ignores the case of column names,
+  and tables are nearly always named in lower case anyway.
+*/
+const bigqueryPrompt = `
+This is BigQuery, so write GoogleSQL (standard SQL), not PostgreSQL. Quote
+identifiers with backticks, never double quotes, which are strings; tables are
+in the default dataset, so leave them unqualified. There is no ILIKE (use
+LOWER(x) LIKE), and no :: casts (CAST or SAFE_CAST). Dates use CURRENT_DATE(),
+DATE_SUB(CURRENT_DATE(), INTERVAL 7 DAY), DATE_TRUNC(x, MONTH), DATE_DIFF(a, b, DAY),
+TIMESTAMP_TRUNC and FORMAT_DATE('%Y-%m', x). Use SAFE_DIVIDE, COUNTIF, QUALIFY,
+and UNNEST for ARRAY columns; a RECORD's fields are record.field. Queries are
+billed by the columns they read, so never SELECT * and filter on the partition
+column when there is one.
+`
+
+func init() {
+	sql.Register("bigquery", bigqueryDriver{})
+	RegisterDialect("bigquery", builtinDialect{
+		name:       "BigQuery",
+		hints:      bigqueryPrompt,
+		dsn:        func(user, password, dbname, host string) string { return bigqueryDSN(host, dbname) },
+		connect:    func(dsn string) (*sql.DB, error) { return sql.Open("bigquery", dsn) },
+		introspect: getBigQuerySchema,
+		quote:      func(name string) string { return "`" + strings.NewReplacer(`\`, `\\`, "`", "\\`").Replace(name) + "`" },
+	})
+}
+
+func bigqueryDSN(project, dataset string) string {
+	return (&url.URL{Scheme: "bigquery", Host: project, Path: "/" + dataset}).String()
+}
+
+type bigqueryDriver struct{}
+
+func (bigqueryDriver) Open(dsn string) (sqldriver.Conn, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme != "bigquery" || u.Host == "" {
+		return nil, fmt.Errorf("a bigquery dsn is bigquery://project/dataset, not %s", dsn)
+	}
+	client, err := bigquery.NewClient(context.Background(), u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to bigquery: %v", err)
+	}
+	client.Location = u.Query().Get("location")
+	return &bigqueryConn{client: client, dataset: strings.Trim(u.Path, "/")}, nil
+}
+
+type bigqueryConn struct {
+	client  *bigquery.Client
+	dataset string
+}
+
+func (c *bigqueryConn) Prepare(query string) (sqldriver.Stmt, error) {
+	return &bigqueryStmt{c, query}, nil
+}
+
+func (c *bigqueryConn) Close() error {
+	return c.client.Close()
+}
+
+func (c *bigqueryConn) Begin() (sqldriver.Tx, error) {
+	return nil, fmt.Errorf("bigquery questions don't run in transactions")
+}
+
+func (c *bigqueryConn) QueryContext(ctx context.Context, query string, args []sqldriver.NamedValue) (sqldriver.Rows, error) {
+	q := c.client.Query(query)
+	q.DefaultProjectID = c.client.Project()
+	q.DefaultDatasetID = c.dataset
+	for _, a := range args {
+		q.Parameters = append(q.Parameters, bigquery.QueryParameter{Name: a.Name, Value: a.Value})
+	}
+	it, err := q.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the schema is only known once the first page is
+	rows := &bigqueryRows{it: it}
+	if rows.err = it.Next(&rows.first); rows.err != nil && rows.err != iterator.Done {
+		return nil, rows.err
+	}
+	return rows, nil
+}
+
+func (c *bigqueryConn) ExecContext(ctx context.Context, query string, args []sqldriver.NamedValue) (sqldriver.Result, error) {
+	rows, err := c.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+	return sqldriver.RowsAffected(0), nil
+}
+
+type bigqueryStmt struct {
+	conn  *bigqueryConn
+	query string
+}
+
+func (s *bigqueryStmt) Close() error  { return nil }
+func (s *bigqueryStmt) NumInput() int { return -1 }
+
+func (s *bigqueryStmt) Exec(args []sqldriver.Value) (sqldriver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *bigqueryStmt) Query(args []sqldriver.Value) (sqldriver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []sqldriver.Value) []sqldriver.NamedValue {
+	named := make([]sqldriver.NamedValue, len(args))
+	for i, a := range args {
+		named[i] = sqldriver.NamedValue{Ordinal: i + 1, Value: a}
+	}
+	return named
+}
+
+type bigqueryRows struct {
+	it    *bigquery.RowIterator
+	first []bigquery.Value
+	err   error // of the first Next
+	read  bool  // whether first was handed out
+}
+
+func (r *bigqueryRows) Columns() []string {
+	columns := make([]string, len(r.it.Schema))
+	for i, f := range r.it.Schema {
+		columns[i] = f.Name
+	}
+	return columns
+}
+
+func (r *bigqueryRows) ColumnTypeDatabaseTypeName(i int) string {
+	f := r.it.Schema[i]
+	if f.Repeated {
+		return "ARRAY<" + string(f.Type) + ">"
+	}
+	return string(f.Type)
+}
+
+func (r *bigqueryRows) Close() error {
+	return nil
+}
+
+func (r *bigqueryRows) Next(dest []sqldriver.Value) error {
+	row := r.first
+	if r.read {
+		row = nil
+		if err := r.it.Next(&row); err != nil {
+			if err == iterator.Done {
+				return io.EOF
+			}
+			return err
+		}
+	} else {
+		r.read = true
+		if r.err == iterator.Done {
+			return io.EOF
+		}
+	}
+	for i, v := range row {
+		dest[i] = bigqueryValue(v)
+	}
+	return nil
+}
+
+// bigqueryValue is a value as database/sql takes them: what it has no type for becomes text
+func bigqueryValue(v bigquery.Value) sqldriver.Value {
+	switch v := v.(type) {
+	case nil, int64, float64, bool, string, []byte, time.Time:
+		return v
+	case *big.Rat:
+		return strings.TrimRight(strings.TrimRight(v.FloatString(9), "0"), ".")
+	case fmt.Stringer:
+		return v.String()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func getBigQuerySchema(db *sql.DB) (*DBMetadata, error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	metadata := DBMetadata{
+		Tables:      make(map[string][]string),
+		PrimaryKeys: make(map[string][]string),
+		ForeignKeys: make([]ForeignKey, 0),
+		Comments:    make(map[string]string),
+	}
+	err = conn.Raw(func(dc interface{}) error {
+		c := dc.(*bigqueryConn)
+		tables := c.client.Dataset(c.dataset).Tables(ctx)
+		for {
+			t, err := tables.Next()
+			if err == iterator.Done {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			md, err := t.Metadata(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", t.TableID, err)
+			}
+			readBigQueryTable(&metadata, strings.ToLower(t.TableID), md)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata.Tables) == 0 {
+		log.Printf("There are no tables in the dataset")
+	}
+	return &metadata, nil
+}
+
+func readBigQueryTable(metadata *DBMetadata, table string, md *bigquery.TableMetadata) {
+	var columns func(prefix string, schema bigquery.Schema)
+	columns = func(prefix string, schema bigquery.Schema) {
+		for _, f := range schema {
+			name := prefix + strings.ToLower(f.Name)
+			metadata.Tables[table] = append(metadata.Tables[table], name)
+			if f.Description != "" {
+				metadata.Comments[table+"."+name] = f.Description
+			}
+			if f.Type == bigquery.RecordFieldType {
+				columns(name+".", f.Schema)
+			}
+		}
+	}
+	columns("", md.Schema)
+	if md.Description != "" {
+		metadata.Comments[table] = md.Description
+	}
+	tc := md.TableConstraints
+	if tc == nil {
+		return
+	}
+	if tc.PrimaryKey != nil {
+		for _, col := range tc.PrimaryKey.Columns {
+			metadata.PrimaryKeys[table] = append(metadata.PrimaryKeys[table], strings.ToLower(col))
+		}
+	}
+	for _, fk := range tc.ForeignKeys {
+		if fk.ReferencedTable == nil {
+			continue
+		}
+		for _, ref := range fk.ColumnReferences {
+			metadata.ForeignKeys = append(metadata.ForeignKeys, ForeignKey{
+				Name:      fk.Name,
+				Table:     table,
+				Column:    strings.ToLower(ref.ReferencingColumn),
+				RefTable:  strings.ToLower(fk.ReferencedTable.TableID),
+				RefColumn: strings.ToLower(ref.ReferencedColumn),
+			})
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package gorag
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+  A bytea, a blob, or a text column holding bytes that aren't UTF-8
+  means nothing to a model, and a few of them can fill the prompt with
+  noise, or break the json it is sent in. What the model sees of such a
+  value, in a result, the sample rows or the table a refinement works
+  on, is a placeholder with its type and size, eg: <bytea 1.2KB>, so it
+  still knows there is a value there. Exports have the value, as hex
+  (\x0102..., the way postgres writes a bytea), once a result has one.
+  Column values with bytes that aren't UTF-8 are never embedded.
+*/
+
+// binaryTypes are the database types whose values are bytes, by upper case name
+var binaryTypes = map[string]bool{
+	"BYTEA": true, "BLOB": true, "TINYBLOB": true, "MEDIUMBLOB": true, "LONGBLOB": true,
+	"BINARY": true, "VARBINARY": true, "IMAGE": true, "RAW": true, "LONG RAW": true, "BYTES": true,
+}
+
+// isBinary is whether a value of the type isn't text
+func isBinary(typeName string, v []byte) bool {
+	return binaryTypes[strings.ToUpper(typeName)] || !utf8.Valid(v) || bytes.IndexByte(v, 0) >= 0
+}
+
+// binaryPlaceholder stands for a value that isn't text, or is "" for one that is
+func binaryPlaceholder(typeName string, v []byte) string {
+	if !isBinary(typeName, v) {
+		return ""
+	}
+	name := strings.ToLower(typeName)
+	if name == "" {
+		name = "binary"
+	}
+	if !binaryTypes[strings.ToUpper(typeName)] {
+		return fmt.Sprintf("<%s %s, not UTF-8>", name, byteSize(len(v)))
+	}
+	return fmt.Sprintf("<%s %s>", name, byteSize(len(v)))
+}
+
+// promptValue is the bytes as the model sees them
+func promptValue(typeName string, v []byte) string {
+	if p := binaryPlaceholder(typeName, v); p != "" {
+		return p
+	}
+	return string(v)
+}
+
+// exportValue is the bytes as an export has them
+func exportValue(typeName string, v []byte) string {
+	if isBinary(typeName, v) {
+		return `\x` + hex.EncodeToString(v)
+	}
+	return string(v)
+}
+
+func byteSize(n int) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%dB", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1fKB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%.1fMB", float64(n)/(1024*1024))
+}
@@ -0,0 +1,206 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+  Budgets cap what the models cost per calendar month (UTC), in tokens
+  or in dollars, for a provider (the host of the model endpoint), a
+  profile (the tenant), or both; an empty one of either means all.
+  When a budget is used up, calls it covers go to its fallback_model
+  instead, or are refused with an error saying which budget it was,
+  so nothing quietly keeps spending. Budgets compose: a low one with a
+  cheap fallback and a higher one without a fallback degrade first and
+  refuse later.
+
+  Dollars come from model_prices, per million tokens. Every call is an
+  audit event with its tokens and cost, and the month so far is read
+  back from the audit log at startup, so budgets need -audit-log to
+  survive a restart.
+*/
+type Budget struct {
+	Provider      string  `json:"provider,omitempty"` // eg: api.openai.com
+	Profile       string  `json:"profile,omitempty"`
+	MonthlyTokens int64   `json:"monthly_tokens,omitempty"`
+	MonthlyUSD    float64 `json:"monthly_usd,omitempty"`
+	FallbackModel string  `json:"fallback_model,omitempty"` // "" refuses once the budget is used up
+}
+
+// ModelPrice is in dollars per million tokens
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+type spendKey struct {
+	provider, profile string
+}
+
+type spendLedger struct {
+	mu       sync.Mutex
+	month    string
+	tokens   map[spendKey]int64
+	usd      map[spendKey]float64
+	unpriced map[string]bool
+}
+
+// spend is for the whole process, across profiles
+var spend = &spendLedger{}
+
+func (l *spendLedger) roll(now time.Time) {
+	if month := now.UTC().Format("2006-01"); month != l.month {
+		l.month = month
+		l.tokens = make(map[spendKey]int64)
+		l.usd = make(map[spendKey]float64)
+	}
+}
+
+func (l *spendLedger) add(at time.Time, provider, profile string, tokens int64, usd float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.roll(time.Now())
+	if at.UTC().Format("2006-01") != l.month {
+		return
+	}
+	k := spendKey{provider, profile}
+	l.tokens[k] += tokens
+	l.usd[k] += usd
+}
+
+// used is the month so far for what a budget covers
+func (l *spendLedger) used(b *Budget) (int64, float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.roll(time.Now())
+	var tokens int64
+	var usd float64
+	for k := range l.tokens {
+		if (b.Provider == "" || b.Provider == k.provider) && (b.Profile == "" || b.Profile == k.profile) {
+			tokens += l.tokens[k]
+			usd += l.usd[k]
+		}
+	}
+	return tokens, usd
+}
+
+// load counts this month's calls from the audit log
+func (l *spendLedger) load(filename string) error {
+	if filename == "" {
+		return nil
+	}
+	return readAuditLog(filename, func(e AuditEvent) {
+		if e.Event == "model_call" {
+			l.add(e.Time, e.Provider, e.Profile, int64(e.PromptTokens+e.CompletionTokens), e.CostUSD)
+		}
+	})
+}
+
+func providerOf(endpoint string) string {
+	if *llmProvider == "mock" {
+		return "mock"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	return u.Host
+}
+
+/*
+  budgeted checks the call against every budget that covers it, and
+  switches it to a fallback model or refuses it. The budgets are
+  looked at in name order, so which fallback wins is stable.
+*/
+func (c *Client) budgeted(t modelTarget) (modelTarget, error) {
+	provider := providerOf(t.URL)
+	if c.Config != nil {
+		c.Config.mu.RLock()
+		defer c.Config.mu.RUnlock()
+		names := make([]string, 0, len(c.Config.Budgets))
+		for name := range c.Config.Budgets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fallback := ""
+		for _, name := range names {
+			b := c.Config.Budgets[name]
+			if b.Provider != "" && b.Provider != provider || b.Profile != "" && b.Profile != c.Profile {
+				continue
+			}
+			tokens, usd := spend.used(b)
+			over := ""
+			switch {
+			case b.MonthlyTokens > 0 && tokens >= b.MonthlyTokens:
+				over = fmt.Sprintf("%d of %d tokens", tokens, b.MonthlyTokens)
+			case b.MonthlyUSD > 0 && usd >= b.MonthlyUSD:
+				over = fmt.Sprintf("$%.2f of $%.2f", usd, b.MonthlyUSD)
+			}
+			if over == "" {
+				continue
+			}
+			if b.FallbackModel == "" {
+				return t, fmt.Errorf("the monthly model budget %s is used up (%s this month); an operator can raise it in the config", name, over)
+			}
+			if fallback == "" {
+				fallback = b.FallbackModel
+				log.Printf("Budget %s is used up (%s), using %s instead of %s", name, over, fallback, t.Model)
+			}
+		}
+		if fallback != "" {
+			t.Model = fallback
+		}
+	}
+	model := t.Model
+	t.OnUsage = func(promptTokens, completionTokens int) {
+		c.recordUsage(model, provider, promptTokens, completionTokens)
+	}
+	if c.recording != nil {
+		t.OnCall = c.recording.onCall(t)
+	}
+	return t, nil
+}
+
+func (c *Client) recordUsage(model, provider string, promptTokens, completionTokens int) {
+	usd := 0.0
+	if c.Config != nil {
+		c.Config.mu.RLock()
+		price, ok := c.Config.ModelPrices[model]
+		priced := len(c.Config.ModelPrices) > 0
+		c.Config.mu.RUnlock()
+		if ok {
+			usd = (float64(promptTokens)*price.Input + float64(completionTokens)*price.Output) / 1e6
+		} else if priced {
+			spend.mu.Lock()
+			if spend.unpriced == nil {
+				spend.unpriced = make(map[string]bool)
+			}
+			if !spend.unpriced[model] {
+				spend.unpriced[model] = true
+				log.Printf("No model_prices for %s, its calls count as $0 against budgets", model)
+			}
+			spend.mu.Unlock()
+		}
+	}
+	spend.add(time.Now(), provider, c.Profile, int64(promptTokens+completionTokens), usd)
+	event := AuditEvent{
+		Event:            "model_call",
+		Profile:          c.Profile,
+		Model:            model,
+		Provider:         provider,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		CostUSD:          usd,
+		TraceID:          c.Trace.id(),
+	}
+	// so a question's cost adds up from its calls
+	if q := c.Run; q != nil {
+		event.RunID, event.User = q.RunID, q.User
+	}
+	c.Audit.Record(event)
+}
@@ -0,0 +1,325 @@
+package gorag
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+  With -bundle-dir, every answer leaves a directory named by its run
+  id, with what it was made of:
+
+    question.json      the question, who asked it, and when
+    answer.json        the answer, with the error if it failed
+    schema.json        the schema the profile had, and the part the model saw
+    model_calls.jsonl  each prompt sent to a model, the model, and what it said
+    result.txt         all of the rows, not just what the model saw
+    config.json        the config as it was, api keys hashed as always
+    environment.json   the gorag build, the driver, models, pipeline and settings
+    image              the screenshot, for a question about one
+
+  gorag bundle <run-id> packs that, and the run's audit events, into
+  one tar.gz with a SHA256SUMS, for an auditor to keep, or to ask the
+  same models the same prompts again. The prompts are what was sent,
+  so with -anonymize they have the pseudonyms. The rows are stored as
+  they came back, so the directory needs the care the database does.
+*/
+var bundleDir = Flags.String("bundle-dir", "", "record what each answer was made of here, for gorag bundle")
+
+var runIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type recordedCall struct {
+	Time        time.Time `json:"time"`
+	Model       string    `json:"model"`
+	Provider    string    `json:"provider"`
+	Temperature float64   `json:"temperature"`
+	Prompt      string    `json:"prompt"`
+	Response    string    `json:"response"`
+	Cached      bool      `json:"cached,omitempty"`
+}
+
+// runRecording collects the model calls of one run
+type runRecording struct {
+	mu    sync.Mutex
+	calls []recordedCall
+}
+
+// onCall is the hook for calls to t
+func (rec *runRecording) onCall(t modelTarget) func(prompt, response string, cached bool) {
+	return func(prompt, response string, cached bool) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.calls = append(rec.calls, recordedCall{
+			Time:        time.Now().UTC(),
+			Model:       t.Model,
+			Provider:    providerOf(t.URL),
+			Temperature: t.Temperature,
+			Prompt:      prompt,
+			Response:    response,
+			Cached:      cached,
+		})
+	}
+}
+
+type recordedModel struct {
+	URL         string  `json:"url"`
+	Model       string  `json:"model"`
+	Temperature float64 `json:"temperature"`
+	MaxTokens   int     `json:"max_tokens,omitempty"`
+	TopP        float64 `json:"top_p,omitempty"`
+}
+
+type recordedEnvironment struct {
+	Version       string          `json:"version"`
+	Revision      string          `json:"revision,omitempty"`
+	Modified      bool            `json:"modified,omitempty"`
+	GoVersion     string          `json:"go_version"`
+	Driver        string          `json:"driver"`
+	Profile       string          `json:"profile,omitempty"`
+	LLM           string          `json:"llm"`
+	SQLModel      recordedModel   `json:"sql_model"`
+	DataModel     recordedModel   `json:"data_model"`
+	JudgeModel    string          `json:"judge_model,omitempty"`
+	Pipeline      []string        `json:"pipeline"`
+	PromptParts   map[string]bool `json:"prompt_parts,omitempty"`
+	SummaryData   string          `json:"summary_data,omitempty"`
+	Snapshot      bool            `json:"snapshot,omitempty"`
+	SchemaVersion string          `json:"schema_fingerprint"`
+}
+
+func (c *Client) environment() recordedEnvironment {
+	env := recordedEnvironment{
+		Version:       "(unknown)",
+		Driver:        dialect().Name(),
+		Profile:       c.Profile,
+		LLM:           *llmProvider,
+		JudgeModel:    c.JudgeModel,
+		PromptParts:   c.PromptParts,
+		SummaryData:   c.SummaryData,
+		Snapshot:      c.Snapshot,
+		SchemaVersion: schemaFingerprint(c.Schema),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		env.Version, env.GoVersion = info.Main.Version, info.GoVersion
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				env.Revision = s.Value
+			case "vcs.modified":
+				env.Modified = s.Value == "true"
+			}
+		}
+	}
+	for _, m := range []struct {
+		stage string
+		into  *recordedModel
+	}{{"sql", &env.SQLModel}, {"data", &env.DataModel}} {
+		t := c.target(m.stage, "")
+		*m.into = recordedModel{URL: t.URL, Model: t.Model, Temperature: t.Temperature, MaxTokens: t.MaxTokens, TopP: t.TopP}
+	}
+	stages := c.Pipeline
+	if stages == nil {
+		stages = defaultPipeline()
+	}
+	for _, s := range stages {
+		env.Pipeline = append(env.Pipeline, s.String())
+	}
+	return env
+}
+
+// saveRecording writes the run's directory in -bundle-dir
+func (c *Client) saveRecording(q *Question, r *askRun, askErr error) error {
+	if !runIDPattern.MatchString(q.RunID) {
+		return fmt.Errorf("run id %q can't name a directory", q.RunID)
+	}
+	dir := filepath.Join(c.BundleDir, q.RunID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	write := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, name), append(data, '\n'), 0600)
+	}
+
+	question := struct {
+		*Question
+		RunID   string    `json:"run_id"`
+		Profile string    `json:"profile,omitempty"`
+		Time    time.Time `json:"time"`
+	}{q, q.RunID, c.Profile, time.Now().UTC()}
+	if err := write("question.json", question); err != nil {
+		return err
+	}
+	answer := struct {
+		*Answer
+		Error string `json:"error,omitempty"`
+	}{Answer: r.answer}
+	if askErr != nil {
+		answer.Error = askErr.Error()
+	}
+	if err := write("answer.json", answer); err != nil {
+		return err
+	}
+	schema := struct {
+		Schema *DBMetadata `json:"schema"`
+		Shown  *DBMetadata `json:"shown,omitempty"` // what -prune left the model
+	}{c.Schema, r.schema}
+	if err := write("schema.json", schema); err != nil {
+		return err
+	}
+	if err := write("environment.json", c.environment()); err != nil {
+		return err
+	}
+	if c.Config != nil {
+		c.Config.mu.RLock()
+		err := write("config.json", c.Config)
+		c.Config.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	var calls strings.Builder
+	c.recording.mu.Lock()
+	for _, call := range c.recording.calls {
+		data, err := json.Marshal(call)
+		if err != nil {
+			c.recording.mu.Unlock()
+			return err
+		}
+		calls.Write(append(data, '\n'))
+	}
+	c.recording.mu.Unlock()
+	if err := os.WriteFile(filepath.Join(dir, "model_calls.jsonl"), []byte(calls.String()), 0600); err != nil {
+		return err
+	}
+	if r.buf != nil {
+		f, err := os.OpenFile(filepath.Join(dir, "result.txt"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = r.buf.WriteTo(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if len(q.Image) > 0 {
+		if err := os.WriteFile(filepath.Join(dir, "image"), q.Image, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runBundle(args []string) {
+	fs := commandFlags("bundle")
+	out := fs.String("o", "", "the archive to write, <run-id>.tar.gz by default")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("usage: gorag bundle [-o run.tar.gz] <run-id>")
+	}
+	runID := fs.Arg(0)
+	if !runIDPattern.MatchString(runID) {
+		log.Fatalf("%q isn't a run id", runID)
+	}
+	if *bundleDir == "" {
+		log.Fatalf("gorag bundle needs the -bundle-dir the run was recorded in")
+	}
+	dir := filepath.Join(*bundleDir, runID)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		log.Fatalf("There is no recording of run %s in %s; answers are only recorded with -bundle-dir", runID, *bundleDir)
+	}
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", dir, err)
+	}
+	files := make(map[string][]byte)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", e.Name(), err)
+		}
+		files[e.Name()] = data
+	}
+	if *auditLog != "" {
+		var events strings.Builder
+		err := readAuditLog(*auditLog, func(e AuditEvent) {
+			if e.RunID == runID {
+				data, _ := json.Marshal(e)
+				events.Write(append(data, '\n'))
+			}
+		})
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", *auditLog, err)
+		}
+		files["audit.jsonl"] = []byte(events.String())
+	} else {
+		log.Printf("Without -audit-log, the bundle has no audit events")
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sums strings.Builder
+	for _, name := range names {
+		sums.WriteString(fmt.Sprintf("%s  %s\n", sha256Hex(files[name]), name))
+	}
+	files["SHA256SUMS"] = []byte(sums.String())
+	names = append(names, "SHA256SUMS")
+
+	if *out == "" {
+		*out = runID + ".tar.gz"
+	}
+	if err := writeBundle(*out, runID, names, files); err != nil {
+		log.Fatalf("Failed to write %s: %v", *out, err)
+	}
+	log.Printf("Wrote %s: %s", *out, strings.Join(names, ", "))
+}
+
+// writeBundle writes the files into a tar.gz, under a directory named by the run
+func writeBundle(filename, runID string, names []string, files map[string][]byte) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, name := range names {
+		hdr := &tar.Header{Name: runID + "/" + name, Mode: 0600, Size: int64(len(files[name])), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,103 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+  Capabilities is the "what can I ask?" summary: the model reads the
+  schema and everything we know about it, and describes the kinds of
+  questions it can answer, with examples. It's onboarding material, so
+  it is cached rather than regenerated for every visitor.
+*/
+func (c *Client) Capabilities() (string, error) {
+	savedQuestions := make([]string, 0)
+	if c.Config != nil {
+		c.Config.mu.RLock()
+		for _, q := range c.Config.SavedQuestions {
+			if q.Profile == "" || q.Profile == c.Profile {
+				savedQuestions = append(savedQuestions, q.Prompt)
+			}
+		}
+		c.Config.mu.RUnlock()
+	}
+	sort.Strings(savedQuestions)
+
+	summary, err := c.llmText(fmt.Sprintf(`
+We are doing RAG against a %s database. Here is its schema:
+
+%s
+
+Extra information about the tables and columns:
+
+%v
+%s%s
+Questions people already ask:
+
+%s
+
+Write a short guide for a new analyst on what they can ask this database.
+Group it into a handful of topics, say in plain language what each topic
+covers and what it can't answer, and give two or three example questions
+for each. Use markdown headings and bullets. Don't mention SQL.
+`, sqlDialect(), formatSchema(c.accessibleSchema(c.Schema)), c.ExtraMetadata, relationshipsPrompt(c.accessibleSchema(c.Schema), ""), c.glossaryPrompt(),
+		strings.Join(savedQuestions, "\n")))
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize capabilities: %v", err)
+	}
+	return summary, nil
+}
+
+// capabilitiesCache remembers the summary per client, since it only changes with the schema
+type capabilitiesCache struct {
+	mu       sync.Mutex
+	byClient map[*Client]string
+}
+
+func (s *Server) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	key, err := s.authenticate(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	profile := r.URL.Query().Get("profile")
+	if key != nil && !allowsProfile(key.Profiles, profile) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("key %s may not use profile %s", key.Name, profile))
+		return
+	}
+	client, err := s.clientFor(profile)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.capabilities.mu.Lock()
+	defer s.capabilities.mu.Unlock()
+	summary, ok := s.capabilities.byClient[client]
+	if !ok {
+		summary, err = client.Capabilities()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		s.capabilities.byClient[client] = summary
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"profile": profile, "capabilities": summary})
+}
+
+func runCapabilities(args []string) {
+	fs := commandFlags("capabilities")
+	fs.Parse(args)
+	client, done := setupClient()
+	defer done()
+	summary, err := client.Capabilities()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	fmt.Println(summary)
+}
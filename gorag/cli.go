@@ -0,0 +1,327 @@
+package gorag
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+/*
+  Commands come first, eg: gorag gdpr -subject-table customer ...
+  Every command also accepts the global flags, so the connection and
+  config flags work the same everywhere.
+*/
+var commands = map[string]func(args []string){
+	"advise":       runAdvise,
+	"analytics":    runAnalytics,
+	"bundle":       runBundle,
+	"capabilities": runCapabilities,
+	"db":           runDB,
+	"estimate":     runEstimate,
+	"eval":         runEval,
+	"explain-sql":  runExplainSQL,
+	"export-state": runExportState,
+	"gdpr":         runGDPR,
+	"import-state": runImportState,
+	"ingest":       runIngest,
+	"loadtest":     runLoadTest,
+	"migrate":      runMigrate,
+	"optimize":     runOptimize,
+	"quality":      runQuality,
+	"repl":         runREPL,
+	"schema":       runSchema,
+	"script":       runScriptCommand,
+	"seed":         runSeed,
+}
+
+func commandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	Flags.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	return fs
+}
+
+/*
+  setupClient does what every command needs first: load the config,
+  pick the profile, connect, and load the schema and extra metadata.
+  The returned func closes what was opened.
+*/
+func setupClient() (*Client, func()) {
+	enforceNoExternalCalls()
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	// A saved question brings its own prompt, and maybe its own profile
+	if *saved != "" {
+		q, ok := config.SavedQuestions[*saved]
+		if !ok {
+			log.Fatalf("No such saved question: %s", *saved)
+		}
+		if q.Broken != "" {
+			log.Fatalf("Saved question %s is broken: %s", *saved, q.Broken)
+		}
+		*prompt = q.Prompt
+		if *profileName == "" {
+			*profileName = q.Profile
+		}
+	}
+	dsn := dsnFromFlags()
+	cache := *schemaCache
+	metadataFile := "metadata.json"
+	var sqlModel, dataModel *ModelEndpoint
+	if *profileName != "" {
+		p, ok := config.Profiles[*profileName]
+		if !ok {
+			log.Fatalf("No such profile: %s", *profileName)
+		}
+		dsn = p.DSN
+		if p.SchemaCache != "" {
+			cache = p.SchemaCache
+		}
+		if p.Metadata != "" {
+			metadataFile = p.Metadata
+		}
+		sqlModel, dataModel = p.SQLModel, p.DataModel
+	}
+	if *usageFlag {
+		if err := checkUsage(); err != nil {
+			log.Fatalf("%v", err)
+		}
+		if dsn = *stateDSN; dsn == "" {
+			dsn = dsnFromFlags()
+		}
+	}
+
+	audit, err := openAuditLog(*auditLog)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	if err := spend.load(audit.Filename()); err != nil {
+		log.Fatalf("Failed to count this month's model spend: %v", err)
+	}
+
+	// Connect to database
+	db, err := Connect(dsn)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	log.Println("Connected to database")
+
+	// Retrieve schema
+	var schema *DBMetadata
+	var extraMetadata map[string]string
+	if *usageFlag {
+		n, err := loadAuditEvents(db, audit.Filename())
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Printf("Loaded %d new audit events", n)
+		schema, extraMetadata = usageSchema(), usageMetadata
+	} else {
+		if schema, err = loadSchema(db, cache); err != nil {
+			log.Fatalf("Failed to retrieve schema: %v", err)
+		}
+		log.Println("Retrieved schema")
+
+		// Load additional metadata (if any)
+		extraMetadata = loadExtraMetadataOrEmpty(metadataFile)
+		log.Printf("Loaded metadata")
+	}
+
+	client := &Client{
+		DB:            db,
+		APIKey:        apiKey,
+		Schema:        schema,
+		ExtraMetadata: extraMetadata,
+		Config:        config,
+		Profile:       *profileName,
+		Audit:         audit,
+		SQLModel:      sqlModel,
+		DataModel:     dataModel,
+	}
+	if err := client.applyFlags(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	return client, func() {
+		db.Close()
+		audit.Close()
+	}
+}
+
+// Main is the gorag command, given its arguments
+func Main(args []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		run, ok := commands[args[0]]
+		if !ok {
+			log.Fatalf("Unknown command: %s", args[0])
+		}
+		run(args[1:])
+		return
+	}
+	Flags.Parse(args)
+	if lambdaMain != nil {
+		lambdaMain()
+		return
+	}
+
+	client, done := setupClient()
+	defer done()
+
+	if *dumpSchema != "" {
+		if err := saveSchemaCache(*dumpSchema, client.Schema); err != nil {
+			log.Fatalf("Failed to write schema: %v", err)
+		}
+		log.Printf("Wrote schema to %s", *dumpSchema)
+		return
+	}
+
+	if *serve != "" {
+		server := newServer(client.Config, client.APIKey, os.Getenv("GORAG_ADMIN_KEY"), client)
+		pushIngest, err := newPushIngest(*ingestOnPush)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		server.pushIngest = pushIngest
+		if *warm {
+			if err := server.warmUp(); err != nil {
+				log.Fatalf("Warm up failed: %v", err)
+			}
+		}
+		if *schemaWatch > 0 {
+			sinks, err := parseSinks(strings.Split(*schemaSinks, ","))
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			go server.watchSchemas(*schemaWatch, sinks)
+		}
+		log.Fatal(server.ListenAndServe(*serve))
+	}
+
+	if *voice != "" {
+		heard, err := spokenQuestion(client.APIKey)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Printf("Heard: %s", heard)
+		*prompt = heard
+	}
+	var image []byte
+	if *imageFile != "" {
+		var err error
+		if image, err = readImageFile(*imageFile); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	question := Question{
+		Image:       image,
+		Prompt:      *prompt,
+		User:        os.Getenv("USER"),
+		Purpose:     *purpose,
+		Category:    *category,
+		Override:    *override,
+		CanOverride: true,
+	}
+	specs := strings.Split(*sinkSpecs, ",")
+	if q, ok := client.Config.SavedQuestions[*saved]; ok {
+		specs = append(specs, q.Sinks...)
+	}
+	sinks, err := parseSinks(specs)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	result := *resultOut
+	if result != "" {
+		f, err := os.Create(result)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", result, err)
+		}
+		defer f.Close()
+		question.Export = f
+	} else if len(sinks) > 0 {
+		// the sinks that archive get the whole result, not just what the model saw
+		f, err := os.CreateTemp("", "gorag-result-*")
+		if err != nil {
+			log.Fatalf("Failed to create a result file: %v", err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		question.Export = f
+		result = f.Name()
+	}
+
+	out := newAnswerRenderer(os.Stdout)
+	showQuery := func(answer *Answer) {
+		if answer.Screenshot != "" {
+			log.Printf("The image shows: %s", answer.Screenshot)
+		}
+		if answer.Query != "" {
+			out.label("Query")
+			out.sql(answer.Query)
+		}
+	}
+	showResult := func(answer *Answer) {
+		out.label("Result")
+		out.text(answer.Result)
+		out.label("Answer")
+	}
+	// the query and rows are shown once the summary starts, and it as it comes
+	streamed := false
+	if *streamFlag {
+		question.OnToken = func(answer *Answer, text string) {
+			if !streamed {
+				streamed = true
+				showQuery(answer)
+				showResult(answer)
+			}
+			out.Write(text)
+		}
+	}
+
+	// Generate the SQL query, check it, execute it, and summarize; ^C stops the query and the model
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	answer, err := client.AskContext(ctx, question)
+	stop()
+	if streamed {
+		out.Flush()
+	} else {
+		showQuery(answer)
+	}
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if client.DryRun {
+		if answer.SQLPrompt != "" {
+			log.Printf("The query was written from this prompt:\n%s", answer.SQLPrompt)
+		}
+		log.Printf("Dry run, so the query wasn't run")
+		return
+	}
+	if !streamed {
+		showResult(answer)
+		out.markdown(answer.Summary)
+	}
+	if answer.SuggestedQuery != "" {
+		out.label("Try instead")
+		out.sql(answer.SuggestedQuery)
+	}
+	if err := sendAll(sinks, answer, result); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *voice != "" {
+		speech, err := speak(client.APIKey, spokenAnswer(answer))
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if err := os.WriteFile(*voiceOut, speech, 0644); err != nil {
+			log.Fatalf("Failed to write %s: %v", *voiceOut, err)
+		}
+		log.Printf("Spoke the answer into %s", *voiceOut)
+	}
+}
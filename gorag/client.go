@@ -0,0 +1,731 @@
+package gorag
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+  Client is the whole RAG flow against one database: it holds the
+  connection, the schema we hand to the model, and any extra metadata.
+  The CLI, and anything else that wants answers, goes through Ask.
+*/
+type Client struct {
+	DB              *sql.DB
+	APIKey          string
+	Schema          *DBMetadata
+	ExtraMetadata   map[string]string
+	Config          *Config
+	Profile         string
+	OPAURL          string
+	MinGroupMode    string // rewrite or reject
+	Audit           *AuditLog
+	JoinCheck       string           // warn, block or off
+	DeprecatedCheck string           // warn, block or off
+	PruneTables     int              // 0 sends the whole schema
+	MaxComplexity   int              // above this score, generated sql is staged; 0 never stages
+	ScratchRows     int              // stages become temp tables of at most this many rows; 0 chains CTEs
+	Snapshot        bool             // an answer's queries all read one REPEATABLE READ snapshot
+	Retries         int              // times to regenerate a query the database rejects
+	JudgeModel      string           // a second model that checks summaries against the rows; "" for none
+	JudgeRetries    int              // times to rewrite a summary the judge rejects
+	PromptParts     map[string]bool  // which optional parts go in the sql prompt; nil for the defaults
+	BufferBytes     int              // result bytes kept in memory before spilling to disk
+	MaxLLMBytes     int              // result bytes the model gets to see; 0 is no limit
+	MaxRows         int              // rows a query may return; 0 is no limit
+	QueryTimeout    time.Duration    // how long one query may run before it is cancelled; 0 is no limit
+	FetchDeadline   time.Duration    // how long a question's rows are read before those so far are summarized; 0 reads them all
+	DryRun          bool             // queries are written and validated, but not run
+	MaxPlanCost     float64          // the most the postgres planner may estimate a query costs; 0 doesn't ask it
+	MaxPlanRows     float64          // the most rows it may estimate a query returns; 0 doesn't ask it
+	PlanGate        string           // simplify, to have a query over them written again first, or refuse
+	Confirm         Confirmer        // asked before a question's query runs; nil runs it without asking
+	ConfirmWrites   WriteConfirmer   // asked before a write commits; nil lets only queries that read run
+	Pseudonyms      *pseudonyms      // schema names are hidden from the model; nil sends them
+	SummaryData     string           // rows, or aggregates to keep rows from the model
+	SQLModel        *ModelEndpoint   // writes the SQL; nil for -llm-url
+	DataModel       *ModelEndpoint   // sees result data; nil for the same as SQLModel
+	Pipeline        []*PipelineStage // the stages Ask runs; nil for the default
+	Examples        ExampleSelector  // which examples the sql prompt gets; nil for all of them
+	Generator       QueryGenerator   // writes the queries; nil for the model
+	Vectors         VectorStore      // where embeddings are searched; nil keeps them in memory
+	Docs            Retriever        // finds documents for the sql prompt; nil for none
+	MinLabelRows    int              // with aggregates, values are only named when this many rows have them
+	WhatIf          bool             // hypothetical questions are answered as a baseline and a scenario over it
+	AnomalyZ        float64          // robust z-score beyond which a bucket is unusual; 0 leaves those questions to the model
+	AgentSteps      int              // queries the model may try with execute_sql before answering; 0 writes it in one go
+	AgentBytes      int              // bytes of each tried query's rows the model sees, with AgentSteps
+	AnomalyWindow   int              // buckets in the moving median taken as the trend; 0 or 1 for no trend
+	ForecastSeason  int              // buckets in a season, for a seasonal forecast; 0 for trend only
+	BundleDir       string           // each run is recorded here, for gorag bundle; "" records none
+	ForecastHorizon int              // buckets forecast ahead for questions about what will happen; 0 leaves those to the model
+	Trace           *traceContext    // the W3C trace of the request being answered; nil outside one
+	Run             *Question        // the question being answered, named in the comment on its queries
+	snapshot        *readSnapshot    // what the run's queries read, with Snapshot; nil outside a run
+	recording       *runRecording    // the run's model calls, with -bundle-dir
+	streamTo        func(string)     // where the summary goes as it is written, when the run's question streams
+	route           *modelRoute      // the model the run's sql goes to, with -easy-model or -hard-model
+	ctx             context.Context  // the run's request; nil outside a run
+}
+
+// forProfile is a copy of this client's settings, pointed at another database
+func (c *Client) forProfile(profile string, db *sql.DB, schema *DBMetadata, extraMetadata map[string]string) *Client {
+	pc := *c
+	pc.Profile = profile
+	pc.DB = db
+	pc.Schema = schema
+	pc.ExtraMetadata = extraMetadata
+	if c.Pseudonyms != nil {
+		pc.Pseudonyms = pseudonymsFor(schema)
+	}
+	return &pc
+}
+
+// Question is a prompt, and who is asking it and why
+type Question struct {
+	Prompt   string `json:"prompt"`
+	User     string `json:"user,omitempty"`
+	Purpose  string `json:"purpose,omitempty"`
+	Override string `json:"override,omitempty"` // justification for reading restricted tags
+	Category string `json:"category,omitempty"` // which answer template, when the config has them
+	RunID    string `json:"-"`
+	// whether this caller is allowed to override at all
+	CanOverride bool `json:"-"`
+	// when set, the whole result is written here, however big
+	Export io.Writer `json:"-"`
+	// when set, up to this many rows are also kept in Answer.Table, to refine
+	Keep int `json:"-"`
+	// a screenshot the question is about
+	Image []byte `json:"-"`
+	// how the asker writes numbers and dates, eg: de-DE; -locale when empty
+	Locale string `json:"locale,omitempty"`
+	// when set, told the summary a piece at a time as it is written, with the answer so far
+	OnToken func(answer *Answer, text string) `json:"-"`
+}
+
+// Answer is everything we learned while answering one prompt
+type Answer struct {
+	RunID   string `json:"run_id"`
+	Prompt  string `json:"prompt"`
+	Query   string `json:"query"`
+	Result  string `json:"result"` // the rows, as much as -max-llm-bytes allows
+	Rows    int    `json:"rows"`
+	Summary string `json:"summary"`
+	// a corrected query, when no rows came back because of a filter
+	SuggestedQuery string `json:"suggested_query,omitempty"`
+	// what the judge made of the summary, when there is one
+	Judge *JudgeVerdict `json:"judge,omitempty"`
+	// what each stage does, when the query was decomposed
+	Stages []string `json:"stages,omitempty"`
+	// what the model saw instead of the rows, with -summary-data aggregates
+	Aggregates string `json:"aggregates,omitempty"`
+	// the examples in the sql prompt, by exampleKey
+	Examples []string `json:"examples,omitempty"`
+	// the answer template the summary was written to
+	Category string `json:"category,omitempty"`
+	// the documents in the prompts, which the summary may cite
+	Documents []Document `json:"documents,omitempty"`
+	// what the vision model read in Question.Image
+	Screenshot string `json:"screenshot,omitempty"`
+	// the prompt the query was written from, with -dry-run
+	SQLPrompt string `json:"sql_prompt,omitempty"`
+	// what changed, when this is -schema-watch telling the sinks
+	SchemaChange *SchemaDiff `json:"schema_change,omitempty"`
+	// the baseline and scenario, when the question was a what-if
+	WhatIf *WhatIf `json:"what_if,omitempty"`
+	// the buckets that stood out, when the question asked what was unusual
+	Anomalies []Anomaly `json:"anomalies,omitempty"`
+	// what the history forecasts, when the question asked what will happen
+	Forecast []Forecast `json:"forecast,omitempty"`
+	// the query had more rows than -max-rows, and only those are in the result
+	Truncated bool `json:"truncated,omitempty"`
+	// reading the rows stopped at -fetch-deadline, and only those read are in the result
+	Partial bool `json:"partial,omitempty"`
+	// the first Question.Keep rows, as values
+	Table *resultTable `json:"-"`
+}
+
+// sqlPrompt asks for a query; feedback is about a previous attempt that failed, if any
+func (c *Client) sqlPrompt(schema *DBMetadata, examples []*Example, userInput, feedback string) string {
+	var parts strings.Builder
+	for _, p := range c.sqlPromptParts(schema, examples, userInput) {
+		parts.WriteString(p.Text)
+	}
+	return fmt.Sprintf(`
+You are an AI that generates %s SQL queries based on a user's natural language request.
+The database schema is as follows:
+
+%s
+%s%s%s
+If the prompt is a valid %s query, then take it literally and
+just return json with the query field set to the prompt.
+The SQL queries can be complex, joined, with subqueries, etc;
+because the schema can be consulted to figure it out.
+http response must be application/json, with the sql query in it:
+{ "query": "<SQL query here>" }
+
+User's request: %s
+`, sqlDialect(), formatSchema(schema), parts.String(), dialectPrompt(), feedback, sqlDialect(), userInput)
+}
+
+type promptPart struct {
+	Name string
+	Text string
+}
+
+// sqlPromptParts are what the sql prompt has between the schema and the instructions, in order
+func (c *Client) sqlPromptParts(schema *DBMetadata, examples []*Example, userInput string) []promptPart {
+	var parts []promptPart
+	add := func(name, text string) {
+		parts = append(parts, promptPart{name, text})
+	}
+	if c.usePart("metadata") {
+		add("metadata", fmt.Sprintf("\nAdditionally, here is some extra information that might help interpret specific tables or columns:\n\n%v\n", c.ExtraMetadata))
+	}
+	if c.usePart("comments") {
+		add("comments", commentsPrompt(schema))
+	}
+	if c.usePart("stats") {
+		add("stats", statsPrompt(schema))
+	}
+	if c.usePart("fk") {
+		add("fk", relationshipsPrompt(schema, userInput))
+	}
+	if c.usePart("glossary") {
+		add("glossary", c.glossaryPrompt())
+	}
+	if c.usePart("deprecations") {
+		add("deprecations", c.deprecationsPrompt(schema))
+	}
+	// sample rows are data, which a separate data model is there to keep from this one
+	if c.usePart("samples") && c.DataModel == nil {
+		add("samples", c.samplesPrompt(schema))
+	}
+	add("examples", examplesPrompt(examples))
+	add("docs", c.docsPrompt(userInput))
+	if c.usePart("hints") {
+		add("hints", c.hintsPrompt())
+	}
+	if c.usePart("values") {
+		add("values", c.valuesPrompt(userInput))
+	}
+	if c.usePart("quoting") {
+		add("quoting", quotingPrompt(schema))
+	}
+	if c.usePart("recipes") {
+		add("recipes", c.recipesPrompt(schema, userInput))
+	}
+	// not a part: without it, the model writes queries that masking refuses
+	add("masks", c.masksPrompt(schema))
+	if c.ConfirmWrites != nil {
+		// nor is this one, since without it the model only ever reads
+		add("writes", writesPrompt)
+	}
+	return parts
+}
+
+// hintsPrompt is what we learned about writing fast SQL against this database
+func (c *Client) hintsPrompt() string {
+	if c.Config == nil {
+		return ""
+	}
+	c.Config.mu.RLock()
+	defer c.Config.mu.RUnlock()
+	if len(c.Config.QueryHints) == 0 {
+		return ""
+	}
+	return "\nWhen writing the query:\n\n- " + strings.Join(c.Config.QueryHints, "\n- ") + "\n"
+}
+
+// glossaryPrompt explains the business terms people use in questions
+func (c *Client) glossaryPrompt() string {
+	if c.Config == nil {
+		return ""
+	}
+	c.Config.mu.RLock()
+	defer c.Config.mu.RUnlock()
+	if len(c.Config.Glossary) == 0 {
+		return ""
+	}
+	terms := make([]string, 0, len(c.Config.Glossary))
+	for term := range c.Config.Glossary {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	var sb strings.Builder
+	sb.WriteString("\nGlossary of terms used in requests:\n\n")
+	for _, term := range terms {
+		sb.WriteString(fmt.Sprintf("%s: %s\n", term, c.Config.Glossary[term]))
+	}
+	return sb.String()
+}
+
+// relationshipsPrompt tells the model which joins are real, when we know the foreign keys
+func relationshipsPrompt(schema *DBMetadata, userInput string) string {
+	if len(schema.ForeignKeys) == 0 {
+		return ""
+	}
+	s := "\nForeign keys, which are the relationships to join on:\n\n" + formatForeignKeys(schema)
+	if paths := suggestJoinPaths(schema, userInput); paths != "" {
+		s += "\nSuggested join paths for this request:\n\n" + paths
+	}
+	return s
+}
+
+func (c *Client) summaryPrompt(userInput, resultStr string, t *AnswerTemplate) string {
+	return fmt.Sprintf(`
+	We are doing RAG atainst a database with this schema
+
+	%s
+
+	with some extra metadata possibly
+
+	%s
+
+	The user prompt was
+
+	%s
+
+	And the resulting query was
+
+	%s
+	%s%s`, formatSchema(c.accessibleSchema(c.Schema)), c.ExtraMetadata, userInput, resultStr, c.citePrompt(userInput), t.prompt())
+}
+
+// citePrompt has the documents for the question, for the summary to cite
+func (c *Client) citePrompt(userInput string) string {
+	docs := c.docsPrompt(userInput)
+	if docs == "" {
+		return ""
+	}
+	return docs + "Where one of these documents explains the result, say so, and give its link.\n"
+}
+
+// GenerateSQL asks the model for a query, without running it
+func (c *Client) GenerateSQL(userInput string) (string, error) {
+	schema := c.promptSchema(userInput)
+	return c.generator().GenerateSQL(schema, c.promptExamples(schema, userInput), userInput, "")
+}
+
+// modelGenerator is the QueryGenerator a client has by default, which asks the sql model
+type modelGenerator struct {
+	c *Client
+}
+
+func (g modelGenerator) GenerateSQL(schema *DBMetadata, examples []*Example, question, feedback string) (string, error) {
+	return g.c.generateSQL(schema, examples, question, feedback)
+}
+
+func (c *Client) generator() QueryGenerator {
+	if c.Generator != nil {
+		return c.Generator
+	}
+	if c.AgentSteps > 0 && !c.holdsQueries() {
+		return agentGenerator{c}
+	}
+	return modelGenerator{c}
+}
+
+func (c *Client) generateSQL(schema *DBMetadata, examples []*Example, userInput, feedback string) (string, error) {
+	prompt := c.sqlPrompt(schema, examples, userInput, feedback)
+	log.Printf("SQL prompt is about %d tokens", estimateTokens(prompt))
+	query, err := c.llmQuery(prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate SQL: %v", err)
+	}
+	return query, nil
+}
+
+/*
+  Everything the client sends to a model goes through these, so that
+  -anonymize covers every prompt, and the stage picks the model: sql
+  for prompts with the schema and the question, data for prompts with
+  rows in them.
+*/
+func (c *Client) complete(stage, model, prompt string) (string, error) {
+	return c.completeStream(stage, model, prompt, nil)
+}
+
+// completeStream is complete, telling onToken the reply as it is written
+func (c *Client) completeStream(stage, model, prompt string, onToken func(string)) (string, error) {
+	t, err := c.budgeted(c.target(stage, model))
+	if err != nil {
+		return "", err
+	}
+	t.OnToken = onToken
+	text, err := callModelText(t, c.Pseudonyms.hide(prompt))
+	return c.Pseudonyms.reveal(text), err
+}
+
+func (c *Client) completeJSON(stage, model, prompt string, out interface{}) error {
+	return c.completeSchema(stage, model, prompt, nil, out)
+}
+
+// completeSchema is completeJSON, with the reply held to schema where the provider can
+func (c *Client) completeSchema(stage, model, prompt string, schema *ResponseSchema, out interface{}) error {
+	t, err := c.budgeted(c.target(stage, model))
+	if err != nil {
+		return err
+	}
+	t.Schema = schema
+	if c.Pseudonyms == nil {
+		return callModelJSON(t, prompt, out)
+	}
+	var v interface{}
+	if err := callModelJSON(t, c.Pseudonyms.hide(prompt), &v); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c.Pseudonyms.revealAll(v))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (c *Client) llmText(prompt string) (string, error) {
+	return c.complete("sql", "", prompt)
+}
+
+func (c *Client) llmJSON(prompt string, out interface{}) error {
+	return c.completeJSON("sql", "", prompt, out)
+}
+
+func (c *Client) dataText(prompt string) (string, error) {
+	return c.complete("data", "", prompt)
+}
+
+func (c *Client) dataJSON(prompt string, out interface{}) error {
+	return c.completeJSON("data", "", prompt, out)
+}
+
+// llmQuery is the query field of the response, as callOpenAI does it
+func (c *Client) llmQuery(prompt string) (string, error) {
+	var queryResponse struct {
+		Query string `json:"query"`
+	}
+	if err := c.completeSchema("sql", "", prompt, querySchema, &queryResponse); err != nil {
+		return "", err
+	}
+	// a reply held to nothing sometimes has its json again in the query; SQL has braces of its own
+	if query := strings.TrimSpace(queryResponse.Query); strings.HasPrefix(query, "{") || strings.HasPrefix(query, "`") {
+		return findJson(query), nil
+	}
+	return queryResponse.Query, nil
+}
+
+func (c *Client) embed(texts []string) ([][]float64, error) {
+	if c.Pseudonyms != nil {
+		hidden := make([]string, len(texts))
+		for i, t := range texts {
+			hidden[i] = c.Pseudonyms.hide(t)
+		}
+		texts = hidden
+	}
+	return embedTexts(c.runContext(), c.APIKey, texts)
+}
+
+/*
+  Validate refuses queries that the deployment's policies forbid, and
+  returns the query that should actually run, since an external policy
+  is allowed to rewrite it. What the policy returns is checked from the
+  top, as the generated query was.
+*/
+func (c *Client) Validate(q *Question, query string) (string, error) {
+	if err := c.checkStatement(query); err != nil {
+		return "", err
+	}
+	rewritten, err := c.checkOPA(*q, query)
+	if err != nil {
+		return "", err
+	}
+	if rewritten != query {
+		if err := c.checkStatement(rewritten); err != nil {
+			return "", fmt.Errorf("the policy's rewrite was refused: %v", err)
+		}
+		query = rewritten
+	}
+	if err := c.checkPurpose(q, query); err != nil {
+		return "", err
+	}
+	if err := c.checkJoins(query); err != nil {
+		return "", err
+	}
+	if err := c.checkDeprecated(query); err != nil {
+		return "", err
+	}
+	if err := c.checkTableAccess(query); err != nil {
+		return "", err
+	}
+	if c.Config != nil {
+		if err := checkDenyRules(c.Config.denyRulesFor(c.Profile), query); err != nil {
+			return "", err
+		}
+	}
+	query, err = c.applyMasks(query)
+	if err != nil {
+		return "", err
+	}
+	if query, err = c.enforceMinGroupSize(query); err != nil {
+		return "", err
+	}
+	if err := c.checkPlan(query); err != nil {
+		return "", err
+	}
+	return query, nil
+}
+
+// checkStatement refuses a query that does more than read, or a write that does more than -allow-writes lets it
+func (c *Client) checkStatement(query string) error {
+	if c.ConfirmWrites != nil && isWrite(query) {
+		return c.checkWrite(query)
+	}
+	return checkReadOnly(query)
+}
+
+// RunQuery executes the query and renders rows as col: value lines, as many as the model may see
+func (c *Client) RunQuery(query string) (string, error) {
+	buf, err := c.runQuery(c.DB, query, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	defer buf.Close()
+	return c.resultForLLM(buf), nil
+}
+
+// runQuery reads the query's rows, keeping the first keep as values; after deadline, when it isn't 0, it stops with those read
+func (c *Client) runQuery(db queryer, query string, keep int, deadline time.Duration) (*resultBuffer, error) {
+	if c.MaxRows > 0 {
+		// one more than it keeps, to know whether any were left out
+		query = limitQuery(query, c.MaxRows+1)
+	}
+	ctx, cancel := c.queryContext()
+	defer cancel()
+	fetchCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	var late atomic.Bool
+	if deadline > 0 {
+		t := time.AfterFunc(deadline, func() {
+			late.Store(true)
+			stop()
+		})
+		defer t.Stop()
+	}
+	rows, err := db.QueryContext(fetchCtx, c.annotate(query))
+	if err != nil {
+		if late.Load() {
+			return nil, fmt.Errorf("failed to execute query: no rows came back within the fetch deadline of %s", deadline)
+		}
+		return nil, fmt.Errorf("failed to execute query: %v", c.queryError(ctx, err))
+	}
+	defer rows.Close()
+
+	// Dynamically process query results based on returned columns
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %v", err)
+	}
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %v", err)
+	}
+	typeNames := make([]string, len(types))
+	for i, t := range types {
+		typeNames[i] = t.DatabaseTypeName()
+	}
+	buf := newResultBuffer(c.BufferBytes)
+	if keep > 0 {
+		buf.table = &resultTable{Columns: columns, Types: typeNames}
+	}
+	row := make([]string, len(columns))
+	exported := make([]string, len(columns))
+	for rows.Next() {
+		if c.MaxRows > 0 && buf.rows >= c.MaxRows {
+			buf.cut = true
+			break
+		}
+		if late.Load() {
+			// drivers that don't cancel a query still stop here
+			buf.partial = true
+			break
+		}
+		err := rows.Scan(valuePtrs...)
+		if err != nil {
+			buf.Close()
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+
+		// Print row values; binary ones differ in exports
+		binary := false
+		for i, col := range columns {
+			var v interface{}
+			switch x := values[i].(type) {
+			case []byte:
+				v = promptValue(typeNames[i], x)
+				if isBinary(typeNames[i], x) {
+					binary = true
+				}
+			default:
+				v = values[i]
+			}
+			row[i] = fmt.Sprintf("%s: %v", col, v)
+		}
+		var export []string
+		if binary {
+			for i, col := range columns {
+				exported[i] = row[i]
+				if x, ok := values[i].([]byte); ok {
+					exported[i] = fmt.Sprintf("%s: %s", col, exportValue(typeNames[i], x))
+				}
+			}
+			export = exported
+		}
+		if buf.table != nil {
+			buf.table.keep(values, keep)
+		}
+		if err := buf.writeRow(row, export); err != nil {
+			buf.Close()
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil && late.Load() && buf.rows > 0 {
+		buf.partial = true
+	} else if err != nil {
+		buf.Close()
+		if late.Load() {
+			return nil, fmt.Errorf("failed to read rows: none came back within the fetch deadline of %s", deadline)
+		}
+		return nil, c.queryError(ctx, err)
+	}
+	if buf.partial && buf.table != nil {
+		buf.table.Truncated = true
+	}
+	return buf, nil
+}
+
+// resultForLLM is the head of the result, saying so when the rest is left out
+func (c *Client) resultForLLM(buf *resultBuffer) string {
+	s, truncated := buf.head(c.MaxLLMBytes)
+	if truncated {
+		s += fmt.Sprintf("\n(only the first %d bytes of %d rows are shown)", c.MaxLLMBytes, buf.rows)
+	}
+	if buf.cut {
+		s += fmt.Sprintf("\n(the query has more rows than these %d, which are all -max-rows allows)", c.MaxRows)
+	}
+	if buf.partial {
+		s += fmt.Sprintf("\n(reading stopped at the fetch deadline of %s, with more rows left than these %d)", c.FetchDeadline, buf.rows)
+	}
+	return s
+}
+
+// Summarize has the model explain the result in terms of the question
+func (c *Client) Summarize(userInput, resultStr string) (string, error) {
+	return c.summarize(userInput, resultStr, nil)
+}
+
+// summarize writes the summary to a template, when there is one
+func (c *Client) summarize(userInput, resultStr string, t *AnswerTemplate) (string, error) {
+	summary, err := c.completeStream("data", "", c.summaryPrompt(userInput, resultStr, t), c.summaryStream())
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize: %v", err)
+	}
+	return summary, nil
+}
+
+// Ask runs the whole flow: generate SQL, execute it, summarize the rows, as the pipeline says
+func (c *Client) Ask(q Question) (*Answer, error) {
+	return c.AskContext(context.Background(), q)
+}
+
+// AskContext is Ask, stopping its queries and model calls when ctx is done
+func (c *Client) AskContext(ctx context.Context, q Question) (answer *Answer, err error) {
+	if q.RunID == "" {
+		q.RunID = newRunID()
+	}
+	userInput := q.Prompt
+	answer = &Answer{RunID: q.RunID, Prompt: userInput}
+	r := &askRun{q: &q, answer: answer}
+	defer r.close()
+	start := time.Now()
+	defer func() {
+		event := AuditEvent{
+			Event:             "ask",
+			RunID:             q.RunID,
+			User:              q.User,
+			Profile:           c.Profile,
+			Prompt:            q.Prompt,
+			Purpose:           q.Purpose,
+			Query:             answer.Query,
+			Tables:            referencedTables(answer.Query),
+			Examples:          answer.Examples,
+			DurationMs:        time.Since(start).Milliseconds(),
+			TraceID:           c.Trace.id(),
+			Model:             c.target("sql", "").Model,
+			Route:             r.routeName(),
+			Difficulty:        r.difficulty(),
+			Generations:       r.generations,
+			FailedGenerations: r.failedGenerations,
+			Executions:        r.executions,
+			FailedExecutions:  r.failedExecutions,
+		}
+		if r.route != nil && r.route.Model != "" {
+			event.Model = r.route.Model
+		}
+		if err != nil {
+			event.Error = err.Error()
+			event.Stage = r.stage
+		}
+		c.Audit.Record(event)
+	}()
+
+	rc := c.forRun(&q)
+	rc.ctx = ctx
+	if c.BundleDir != "" {
+		rc.recording = &runRecording{}
+		defer func() {
+			if err := rc.saveRecording(&q, r, err); err != nil {
+				log.Printf("Failed to record run %s: %v", q.RunID, err)
+			}
+		}()
+	} else if c.DryRun {
+		// only for the prompt it shows
+		rc.recording = &runRecording{}
+	}
+	if c.Snapshot && !c.DryRun {
+		r.stage = "snapshot"
+		if rc.snapshot, err = rc.openSnapshot(); err != nil {
+			return answer, err
+		}
+		defer rc.snapshot.Close()
+	}
+
+	r.stage = "locale"
+	if err = normalizeQuestion(&q); err != nil {
+		return answer, err
+	}
+
+	// the picture is read first, so the prompt checks see what it says too
+	if len(q.Image) > 0 {
+		r.stage = "image"
+		if answer.Screenshot, err = rc.readScreenshot(&q); err != nil {
+			return answer, err
+		}
+		q.Prompt = withScreenshot(q.Prompt, answer.Screenshot)
+	}
+
+	err = rc.runPipeline(r)
+	return answer, err
+}
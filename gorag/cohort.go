@@ -0,0 +1,316 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+  Cohort retention and funnels are the questions models get wrong
+  most: counting events instead of users, putting a user in every
+  period's cohort rather than the first, counting a funnel's steps
+  without their order. A question about either gets the recipe for
+  it in the sql prompt, written out for each event table the config
+  declares in its tables:
+
+    "tables": {"events": {"events": {"user_key": "user_id", "time": "occurred_at",
+                                     "event": "event_name", "grain": "week"}}}
+
+  with the grain (day, week or month, week by default) in this
+  database's SQL; without one, the recipe has placeholders. The query
+  is then checked for those mistakes, and written again with what was
+  wrong, up to -retries times, before it runs anyway with a warning.
+*/
+
+// EventTable says a table is an event log, for cohort and funnel questions
+type EventTable struct {
+	UserKey string `json:"user_key"`        // who did it
+	Time    string `json:"time"`            // when
+	Event   string `json:"event,omitempty"` // what happened, for funnels
+	Grain   string `json:"grain,omitempty"` // cohorts are by day, week or month; week by default
+}
+
+var (
+	cohortQuestion = regexp.MustCompile(`(?i)\b(cohorts?|retention|retained|retain|come back|came back|returning users)\b`)
+	funnelQuestion = regexp.MustCompile(`(?i)\b(funnels?|conversion|convert(ed|s)?|drop[- ]?off|dropped off)\b`)
+)
+
+// recipeKind is cohort or funnel, for questions that are one
+func recipeKind(question string) string {
+	switch {
+	case cohortQuestion.MatchString(question):
+		return "cohort"
+	case funnelQuestion.MatchString(question):
+		return "funnel"
+	}
+	return ""
+}
+
+// periodSQL writes a database's date arithmetic for a grain
+type periodSQL struct {
+	start   func(col, grain string) string
+	between func(from, to, grain string) string
+}
+
+var postgresPeriods = periodSQL{
+	start: func(col, grain string) string { return fmt.Sprintf("date_trunc('%s', %s)", grain, col) },
+	between: func(from, to, grain string) string {
+		switch grain {
+		case "day":
+			return fmt.Sprintf("(%s::date - %s::date)", to, from)
+		case "week":
+			return fmt.Sprintf("(%s::date - %s::date) / 7", to, from)
+		}
+		return fmt.Sprintf("((EXTRACT(YEAR FROM %s) - EXTRACT(YEAR FROM %s)) * 12 + EXTRACT(MONTH FROM %s) - EXTRACT(MONTH FROM %s))", to, from, to, from)
+	},
+}
+
+// diffPeriods is for databases with a DATEDIFF taking the unit first
+func diffPeriods(format string) periodSQL {
+	return periodSQL{
+		start:   func(col, grain string) string { return fmt.Sprintf("date_trunc('%s', %s)", grain, col) },
+		between: func(from, to, grain string) string { return fmt.Sprintf(format, grain, from, to) },
+	}
+}
+
+var periodFunctions = map[string]periodSQL{
+	"postgres":  postgresPeriods,
+	"duckdb":    diffPeriods("date_diff('%s', %s, %s)"),
+	"snowflake": diffPeriods("DATEDIFF('%s', %s, %s)"),
+	"sqlserver": {
+		start:   func(col, grain string) string { return fmt.Sprintf("DATETRUNC(%s, %s)", grain, col) },
+		between: func(from, to, grain string) string { return fmt.Sprintf("DATEDIFF(%s, %s, %s)", grain, from, to) },
+	},
+	"bigquery": {
+		start: func(col, grain string) string {
+			return fmt.Sprintf("DATE_TRUNC(DATE(%s), %s)", col, strings.ToUpper(grain))
+		},
+		between: func(from, to, grain string) string {
+			return fmt.Sprintf("DATE_DIFF(%s, %s, %s)", to, from, strings.ToUpper(grain))
+		},
+	},
+	"clickhouse": {
+		start: func(col, grain string) string {
+			return fmt.Sprintf("toStartOf%s(%s)", strings.ToUpper(grain[:1])+grain[1:], col)
+		},
+		between: func(from, to, grain string) string { return fmt.Sprintf("dateDiff('%s', %s, %s)", grain, from, to) },
+	},
+	"mysql": {
+		start: func(col, grain string) string {
+			switch grain {
+			case "day":
+				return fmt.Sprintf("DATE(%s)", col)
+			case "week":
+				return fmt.Sprintf("DATE_SUB(DATE(%s), INTERVAL WEEKDAY(%s) DAY)", col, col)
+			}
+			return fmt.Sprintf("DATE_FORMAT(%s, '%%Y-%%m-01')", col)
+		},
+		between: func(from, to, grain string) string {
+			return fmt.Sprintf("TIMESTAMPDIFF(%s, %s, %s)", strings.ToUpper(grain), from, to)
+		},
+	},
+	"sqlite": {
+		start: func(col, grain string) string {
+			switch grain {
+			case "day":
+				return fmt.Sprintf("date(%s)", col)
+			case "week":
+				return fmt.Sprintf("date(%s, 'weekday 0', '-6 days')", col)
+			}
+			return fmt.Sprintf("date(%s, 'start of month')", col)
+		},
+		between: func(from, to, grain string) string {
+			switch grain {
+			case "day":
+				return fmt.Sprintf("CAST(julianday(%s) - julianday(%s) AS INTEGER)", to, from)
+			case "week":
+				return fmt.Sprintf("CAST((julianday(%s) - julianday(%s)) / 7 AS INTEGER)", to, from)
+			}
+			return fmt.Sprintf("((strftime('%%Y', %s) - strftime('%%Y', %s)) * 12 + strftime('%%m', %s) - strftime('%%m', %s))", to, from, to, from)
+		},
+	},
+}
+
+func periods() periodSQL {
+	if p, ok := periodFunctions[*driver]; ok {
+		return p
+	}
+	return postgresPeriods
+}
+
+// grain is the table's, or week
+func (e *EventTable) grain() string {
+	switch g := strings.ToLower(e.Grain); g {
+	case "day", "week", "month":
+		return g
+	}
+	return "week"
+}
+
+// eventTables are the schema's tables the config says are event logs, by name
+func (c *Client) eventTables(schema *DBMetadata) map[string]*EventTable {
+	if c.Config == nil || schema == nil {
+		return nil
+	}
+	out := make(map[string]*EventTable)
+	for table := range schema.Tables {
+		if t := c.Config.tableConfig(table); t != nil && t.Events != nil && t.Events.UserKey != "" && t.Events.Time != "" {
+			out[table] = t.Events
+		}
+	}
+	return out
+}
+
+// recipeIdent quotes a name in a recipe, leaving placeholders as they are
+func recipeIdent(name string) string {
+	if strings.HasPrefix(name, "<") {
+		return name
+	}
+	return quoteIdent(name)
+}
+
+// cohortRecipe is the cohort retention query for an event table
+func cohortRecipe(table string, e *EventTable) string {
+	p, grain := periods(), e.grain()
+	user, at := recipeIdent(e.UserKey), recipeIdent(e.Time)
+	return fmt.Sprintf(`WITH firsts AS (
+  SELECT %s AS user_key, MIN(%s) AS cohort FROM %s GROUP BY %s
+), activity AS (
+  SELECT DISTINCT f.user_key, f.cohort, %s AS period
+  FROM %s e JOIN firsts f ON f.user_key = e.%s
+)
+SELECT cohort, %s AS periods_later, COUNT(DISTINCT user_key) AS users
+FROM activity
+GROUP BY cohort, %s
+ORDER BY cohort, periods_later`,
+		user, p.start(at, grain), recipeIdent(table), user,
+		p.start("e."+at, grain), recipeIdent(table), user,
+		p.between("cohort", "period", grain), p.between("cohort", "period", grain))
+}
+
+// funnelRecipe is the funnel query for an event table, with two steps and room for more
+func funnelRecipe(table string, e *EventTable) string {
+	user, at, event := recipeIdent(e.UserKey), recipeIdent(e.Time), "<event column>"
+	if e.Event != "" {
+		event = recipeIdent(e.Event)
+	}
+	return fmt.Sprintf(`WITH step1 AS (
+  SELECT %s AS user_key, MIN(%s) AS first_at FROM %s WHERE %s = '<first step>' GROUP BY %s
+), step2 AS (
+  SELECT e.%s AS user_key, MIN(e.%s) AS first_at
+  FROM %s e JOIN step1 s ON s.user_key = e.%s AND e.%s >= s.first_at
+  WHERE e.%s = '<second step>' GROUP BY e.%s
+)
+SELECT 1 AS step, '<first step>' AS event, COUNT(*) AS users FROM step1
+UNION ALL
+SELECT 2, '<second step>', COUNT(*) FROM step2
+ORDER BY step`,
+		user, at, recipeIdent(table), event, user,
+		user, at, recipeIdent(table), user, at, event, user)
+}
+
+// recipesPrompt is how to write the question's cohort or funnel query, if it is one
+func (c *Client) recipesPrompt(schema *DBMetadata, userInput string) string {
+	kind := recipeKind(userInput)
+	if kind == "" {
+		return ""
+	}
+	tables := c.eventTables(schema)
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		names = []string{"<events>"}
+		tables = map[string]*EventTable{"<events>": {UserKey: "<user id>", Time: "<event time>"}}
+	}
+	var sb strings.Builder
+	if kind == "cohort" {
+		sb.WriteString(`
+This is a cohort retention question. Put each user in one cohort, the period of
+their first event, count distinct users (never events), and number the periods
+from each cohort's start. For example:
+`)
+		for _, name := range names {
+			sb.WriteString("\n" + cohortRecipe(name, tables[name]) + "\n")
+		}
+		return sb.String()
+	}
+	sb.WriteString(`
+This is a funnel question. Count distinct users (never events) reaching each step,
+and only count a step for a user who reached the step before it, at or after the
+time they did, with one CTE per step in order. For example:
+`)
+	for _, name := range names {
+		sb.WriteString("\n" + funnelRecipe(name, tables[name]) + "\n")
+	}
+	return sb.String()
+}
+
+// commonUserKeys are taken for user keys, besides those the event tables declare
+var commonUserKeys = []string{"user_id", "customer_id", "account_id"}
+
+// recipeProblem is what the query gets wrong for its cohort or funnel question, or ""
+func recipeProblem(userInput, query string, userKeys []string) string {
+	kind := recipeKind(userInput)
+	if kind == "" {
+		return ""
+	}
+	keys := make(map[string]bool)
+	for _, k := range append(userKeys, commonUserKeys...) {
+		keys[strings.ToLower(k)] = true
+	}
+	tokens := lexSQL(query)
+	distinct, first, ordered := false, false, false
+	for i, t := range tokens {
+		switch t.upper() {
+		case "DISTINCT":
+			distinct = true
+		case "MIN":
+			first = true
+		case "BY":
+			// a CTE with a row per user counts users, however it is counted after
+			if i > 0 && tokens[i-1].upper() == "GROUP" {
+				for j := i + 1; j < len(tokens) && j < i+8; j++ {
+					distinct = distinct || keys[tokens[j].ident()]
+				}
+			}
+		}
+		if t.Kind == sqlPunct && (t.Text == ">" || t.Text == "<") {
+			ordered = true
+		}
+	}
+	switch {
+	case !distinct:
+		return "it counts events, not distinct users"
+	case kind == "cohort" && !first:
+		return "it doesn't put each user in the cohort of their first period, with MIN"
+	case kind == "funnel" && !ordered:
+		return "it counts each step without checking it came after the step before"
+	}
+	return ""
+}
+
+// checkRecipe asks for the query again when it gets its cohort or funnel question wrong
+func (c *Client) checkRecipe(r *askRun, schema *DBMetadata, userInput, query string) error {
+	var keys []string
+	for _, e := range c.eventTables(schema) {
+		keys = append(keys, e.UserKey)
+	}
+	problem := recipeProblem(userInput, query, keys)
+	if problem == "" {
+		return nil
+	}
+	if r.attempts >= c.Retries {
+		log.Printf("The query may be wrong for the question, since %s", problem)
+		return nil
+	}
+	r.attempts++
+	log.Printf("The query is wrong for the question, since %s; generating it again", problem)
+	r.feedback = fmt.Sprintf("\nA previous attempt at this request was\n\n%s\n\nwhich is wrong because %s.\n", query, problem)
+	return errRegenerate
+}
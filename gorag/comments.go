@@ -0,0 +1,58 @@
+package gorag
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// getComments reads COMMENT ON TABLE/COLUMN text, keyed by table or table.column
+func getComments(db *sql.DB) (map[string]string, error) {
+	rows, err := db.Query(`
+		SELECT c.relname, COALESCE(a.attname, ''), d.description
+		FROM pg_description d
+		JOIN pg_class c ON c.oid = d.objoid AND d.classoid = 'pg_class'::regclass
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum = d.objsubid AND d.objsubid > 0
+		WHERE n.nspname = 'public'
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments := make(map[string]string)
+	for rows.Next() {
+		var table, column, description string
+		if err := rows.Scan(&table, &column, &description); err != nil {
+			return nil, err
+		}
+		key := table
+		if column != "" {
+			key = table + "." + column
+		}
+		comments[key] = description
+	}
+	return comments, rows.Err()
+}
+
+// commentsPrompt has the comments for the tables in this schema
+func commentsPrompt(schema *DBMetadata) string {
+	keys := make([]string, 0)
+	for key := range schema.Comments {
+		table, _, _ := strings.Cut(key, ".")
+		if _, ok := schema.Tables[table]; ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString("\nComments on tables and columns:\n\n")
+	for _, key := range keys {
+		sb.WriteString(fmt.Sprintf("%s: %s\n", key, schema.Comments[key]))
+	}
+	return sb.String()
+}
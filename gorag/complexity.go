@@ -0,0 +1,250 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+/*
+  Big one-shot queries are where the model goes wrong, so we score
+  the generated SQL and, over -max-complexity, ask for it again as a
+  series of small named stages. The stages become a CTE chain that we
+  run one prefix at a time, so a broken stage is found (and fixed)
+  on its own instead of as one opaque error from the whole thing.
+*/
+type Complexity struct {
+	Joins      int `json:"joins"`
+	Subqueries int `json:"subqueries"`
+	Windows    int `json:"windows"`
+	SetOps     int `json:"set_ops"`
+	Score      int `json:"score"`
+}
+
+func (x Complexity) String() string {
+	return fmt.Sprintf("score %d: %d joins, %d subqueries, %d window functions, %d set operations",
+		x.Score, x.Joins, x.Subqueries, x.Windows, x.SetOps)
+}
+
+func sqlComplexity(query string) Complexity {
+	var x Complexity
+	tokens := lexSQL(query)
+	for i, t := range tokens {
+		switch t.upper() {
+		case "JOIN":
+			x.Joins++
+		case "OVER":
+			x.Windows++
+		case "UNION", "INTERSECT", "EXCEPT":
+			x.SetOps++
+		case "SELECT":
+			if i > 0 && tokens[i-1].Text == "(" {
+				x.Subqueries++
+			}
+		}
+	}
+	x.Score = x.Joins + 2*x.Subqueries + 2*x.Windows + 2*x.SetOps
+	return x
+}
+
+type queryStage struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Query       string `json:"query"`
+}
+
+type stagedQuery struct {
+	Stages []queryStage `json:"steps"`
+	Final  string       `json:"final"`
+}
+
+var stageName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// upTo is the CTE chain for the first n stages, followed by tail
+func (sq *stagedQuery) upTo(n int, tail string) string {
+	parts := make([]string, 0, n)
+	for _, st := range sq.Stages[:n] {
+		parts = append(parts, fmt.Sprintf("%s AS (\n%s\n)", st.Name, trimStatement(st.Query)))
+	}
+	return "WITH " + strings.Join(parts, ",\n") + "\n" + trimStatement(tail)
+}
+
+// trimStatement drops the trailing semicolon, so a query can be nested
+func trimStatement(query string) string {
+	return strings.TrimRight(strings.TrimSpace(query), "; \t\n")
+}
+
+func (sq *stagedQuery) assemble() string {
+	return sq.upTo(len(sq.Stages), sq.Final)
+}
+
+func (sq *stagedQuery) check() error {
+	if len(sq.Stages) == 0 || sq.Final == "" {
+		return fmt.Errorf("decomposition has no stages")
+	}
+	for _, st := range sq.Stages {
+		if !stageName.MatchString(st.Name) {
+			return fmt.Errorf("bad stage name %q", st.Name)
+		}
+		if kind := sqlOperations(st.Query); len(kind) != 1 || kind[0] != "SELECT" {
+			return fmt.Errorf("stage %s is not a single SELECT", st.Name)
+		}
+	}
+	return nil
+}
+
+func (c *Client) decompose(userInput, query string, x Complexity) (*stagedQuery, error) {
+	var sq stagedQuery
+	err := c.llmJSON(fmt.Sprintf(`
+You are an AI that generates %s SQL queries based on a user's natural language request.
+The database schema is as follows:
+
+%s
+%s%s
+This query was generated for the request, but it is too complex to trust (%s):
+
+%s
+
+Rewrite it as a sequence of simple stages. Each stage is one SELECT that may
+read the tables and any earlier stage by name, and does one thing: a filter,
+a join, or an aggregation. The final query reads the stages to produce the answer.
+Stage names are lower case identifiers.
+http response must be application/json:
+{ "steps": [ { "name": "...", "description": "...", "query": "SELECT ..." } ], "final": "SELECT ..." }
+
+User's request: %s
+`, sqlDialect(), formatSchema(c.promptSchema(userInput)), relationshipsPrompt(c.Schema, userInput), dialectPrompt(), x, query, userInput), &sq)
+	if err != nil {
+		return nil, err
+	}
+	return &sq, sq.check()
+}
+
+// repairStage asks the model to fix one stage, given the stages before it and the error
+func (c *Client) repairStage(userInput string, sq *stagedQuery, i int, stageErr error) error {
+	var fixed queryStage
+	err := c.llmJSON(fmt.Sprintf(`
+We are answering this request in stages of %s: %s
+
+The schema is:
+
+%s
+These stages run fine:
+
+%s
+
+But stage %s (%s) fails:
+
+%s
+
+with this error: %v
+
+Fix that one stage. http response must be application/json:
+{ "name": "%s", "description": "...", "query": "SELECT ..." }
+`, sqlDialect(), userInput, formatSchema(c.promptSchema(userInput)), sq.upTo(i, "SELECT 1"),
+		sq.Stages[i].Name, sq.Stages[i].Description, sq.Stages[i].Query, stageErr, sq.Stages[i].Name), &fixed)
+	if err != nil {
+		return err
+	}
+	fixed.Name = sq.Stages[i].Name
+	sq.Stages[i] = fixed
+	return sq.check()
+}
+
+/*
+  runStages executes the chain one stage at a time, only counting rows,
+  so each stage is known to work before the next is built on it. With
+  repair, the first failing stage goes back to the model once and we
+  stop there, because the changed chain has to be validated again.
+*/
+func (c *Client) runStages(userInput string, sq *stagedQuery, repair bool) (bool, error) {
+	for i, st := range sq.Stages {
+		probe := sq.upTo(i+1, "SELECT count(*) FROM "+st.Name)
+		var n int64
+		if err := c.reader().QueryRow(c.annotate(probe)).Scan(&n); err != nil {
+			if !repair {
+				return false, fmt.Errorf("stage %s failed: %v", st.Name, err)
+			}
+			log.Printf("Stage %s failed, asking for a fix: %v", st.Name, err)
+			if rerr := c.repairStage(userInput, sq, i, err); rerr != nil {
+				return false, fmt.Errorf("stage %s failed: %v (and repair failed: %v)", st.Name, err, rerr)
+			}
+			return true, nil
+		}
+		log.Printf("Stage %s: %d rows (%s)", st.Name, n, st.Description)
+	}
+	return false, nil
+}
+
+// stagedRun is a decomposed query that passed validation, ready to run
+type stagedRun struct {
+	Query   string
+	Chain   string        // the whole validated chain, which is what gets audited
+	scratch *scratchSpace // when set, Query runs here, over the stage tables
+}
+
+/*
+  stageQuery is the complexity gate in Ask: it returns nil when the
+  query is fine as it is (or couldn't be staged), and otherwise the
+  validated staged query. In scratch mode that is just the final query
+  over the stage tables, unless policy rewrote the chain, in which case
+  the rewritten chain is what runs.
+*/
+func (c *Client) stageQuery(q *Question, answer *Answer, query string) (*stagedRun, error) {
+	if c.MaxComplexity <= 0 || c.holdsQueries() || c.ConfirmWrites != nil && isWrite(query) {
+		return nil, nil
+	}
+	x := sqlComplexity(query)
+	if x.Score <= c.MaxComplexity {
+		return nil, nil
+	}
+	log.Printf("Query is too complex (%s), decomposing", x)
+	sq, err := c.decompose(q.Prompt, query, x)
+	if err != nil {
+		log.Printf("Decomposition failed, using the original query: %v", err)
+		return nil, nil
+	}
+	var staged string
+	validate := func() (err error) {
+		staged, err = c.Validate(q, sq.assemble())
+		return err
+	}
+	if err := validate(); err != nil {
+		return nil, err
+	}
+	run := &stagedRun{}
+	if c.ScratchRows > 0 && staged == sq.assemble() {
+		s, err := c.runScratch(q.Prompt, sq, validate)
+		if err != nil {
+			return nil, err
+		}
+		if staged == sq.assemble() {
+			run.Query = trimStatement(sq.Final)
+			run.scratch = s
+		} else {
+			s.Close()
+			run.Query = staged
+		}
+	} else {
+		repaired, err := c.runStages(q.Prompt, sq, true)
+		if err != nil {
+			return nil, err
+		}
+		if repaired {
+			if err := validate(); err != nil {
+				return nil, err
+			}
+			if _, err := c.runStages(q.Prompt, sq, false); err != nil {
+				return nil, err
+			}
+		}
+		run.Query = staged
+	}
+	run.Chain = staged
+	answer.Stages = make([]string, 0, len(sq.Stages))
+	for _, st := range sq.Stages {
+		answer.Stages = append(answer.Stages, st.Name+": "+st.Description)
+	}
+	return run, nil
+}
@@ -0,0 +1,255 @@
+package gorag
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/rfielding/gorag/adminclient"
+)
+
+type Profile = adminclient.Profile
+type ModelEndpoint = adminclient.ModelEndpoint
+type DenyRule = adminclient.DenyRule
+type SavedQuestion = adminclient.SavedQuestion
+type APIKey = adminclient.APIKey
+
+// TableConfig is per table policy, keyed by table name (optionally schema qualified)
+type TableConfig struct {
+	// results must not expose groups of fewer than this many rows
+	MinGroupSize int `json:"min_group_size,omitempty"`
+	// classification tags on columns, which purposes are allowed to read
+	Columns map[string]*ColumnConfig `json:"columns,omitempty"`
+	// for -prune: similarity is multiplied by weight (default 1), and
+	// prune "always" keeps the table in every prompt, "never" in none
+	Weight float64 `json:"weight,omitempty"`
+	Prune  string  `json:"prune,omitempty"`
+	// what to use instead, when the table is deprecated
+	Deprecated string `json:"deprecated,omitempty"`
+	// the table is an event log, and how, for cohort and funnel questions
+	Events *EventTable `json:"events,omitempty"`
+}
+
+// restricted is whether the table's rows are protected by group sizes or tags
+func (t *TableConfig) restricted() bool {
+	if t.MinGroupSize > 0 {
+		return true
+	}
+	for _, col := range t.Columns {
+		if col != nil && (len(col.Tags) > 0 || col.Mask != "") {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+  Config is what a deployment is managed with: the databases it can
+  reach, what it refuses to run, and who may call it. It lives in one
+  json file, which the admin api rewrites on every change so hosts
+  never need hand edits.
+*/
+type Config struct {
+	Profiles        map[string]*Profile        `json:"profiles"`
+	DenyRules       map[string]*DenyRule       `json:"deny_rules"`
+	SavedQuestions  map[string]*SavedQuestion  `json:"saved_questions"`
+	APIKeys         map[string]*APIKey         `json:"api_keys"`
+	Tables          map[string]*TableConfig    `json:"tables,omitempty"`
+	Purposes        map[string]*Purpose        `json:"purposes,omitempty"`
+	AnswerTemplates map[string]*AnswerTemplate `json:"answer_templates,omitempty"` // by question category
+	Glossary        map[string]string          `json:"glossary,omitempty"`         // business term -> what it means in this database
+	Safety          *SafetyConfig              `json:"safety,omitempty"`
+	Examples        []*Example                 `json:"examples,omitempty"`
+	QueryHints      []string                   `json:"query_hints,omitempty"` // how to write SQL here, eg: from gorag advise
+	Budgets         map[string]*Budget         `json:"budgets,omitempty"`
+	MaskTags        map[string]string          `json:"mask_tags,omitempty"`      // column tag -> mask
+	ModelPrices     map[string]*ModelPrice     `json:"model_prices,omitempty"`   // model -> dollars per million tokens
+	Monitors        map[string]*QualityCheck   `json:"monitors,omitempty"`       // gorag quality -register
+	AllowedTables   []string                   `json:"allowed_tables,omitempty"` // generated SQL may touch only these, when set
+	DeniedTables    []string                   `json:"denied_tables,omitempty"`  // and never these
+
+	AllowMigrationDrafts bool `json:"allow_migration_drafts,omitempty"` // gorag migrate draft
+	AllowSeed            bool `json:"allow_seed,omitempty"`             // gorag seed writes made up rows
+	AllowScripts         bool `json:"allow_scripts,omitempty"`          // gorag script changes data
+
+	mu       sync.RWMutex
+	filename string
+}
+
+func newConfig(filename string) *Config {
+	return &Config{
+		Profiles:       make(map[string]*Profile),
+		DenyRules:      make(map[string]*DenyRule),
+		SavedQuestions: make(map[string]*SavedQuestion),
+		APIKeys:        make(map[string]*APIKey),
+		Tables:         make(map[string]*TableConfig),
+		filename:       filename,
+	}
+}
+
+// loadConfig reads the config file, and a missing file is just an empty config
+func loadConfig(filename string) (*Config, error) {
+	config := newConfig(filename)
+	if filename == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", filename, err)
+	}
+	config.fillDefaults()
+	return config, nil
+}
+
+// fillDefaults makes sure maps exist, and names match their keys
+func (c *Config) fillDefaults() {
+	if c.Profiles == nil {
+		c.Profiles = make(map[string]*Profile)
+	}
+	if c.DenyRules == nil {
+		c.DenyRules = make(map[string]*DenyRule)
+	}
+	if c.SavedQuestions == nil {
+		c.SavedQuestions = make(map[string]*SavedQuestion)
+	}
+	if c.APIKeys == nil {
+		c.APIKeys = make(map[string]*APIKey)
+	}
+	if c.Tables == nil {
+		c.Tables = make(map[string]*TableConfig)
+	}
+	for k, v := range c.Profiles {
+		v.Name = k
+	}
+	for k, v := range c.DenyRules {
+		v.Name = k
+	}
+	for k, v := range c.SavedQuestions {
+		v.Name = k
+	}
+	for k, v := range c.APIKeys {
+		v.Name = k
+	}
+}
+
+// save must be called with the write lock held
+func (c *Config) save() error {
+	if c.filename == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.filename)
+}
+
+func hashAPIKey(key string) string {
+	return sha256Hex([]byte(key))
+}
+
+func generateAPIKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "gorag_" + hex.EncodeToString(b), nil
+}
+
+// lookupAPIKey finds the key record for a presented secret
+func (c *Config) lookupAPIKey(secret string) *APIKey {
+	if secret == "" {
+		return nil
+	}
+	h := hashAPIKey(secret)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, k := range c.APIKeys {
+		if k.KeyHash == h {
+			return k
+		}
+	}
+	return nil
+}
+
+func allowsProfile(profiles []string, profile string) bool {
+	if len(profiles) == 0 {
+		return true
+	}
+	for _, p := range profiles {
+		if p == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// denyRulesFor returns the rules that apply to a profile
+func (c *Config) denyRulesFor(profile string) []*DenyRule {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rules := make([]*DenyRule, 0)
+	for _, r := range c.DenyRules {
+		if r.Profile == "" || r.Profile == profile {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+/*
+  checkDenyRules is deliberately blunt: a table is considered touched
+  if its name appears as a word anywhere in the query. False positives
+  are much better than letting a denied table through.
+*/
+func checkDenyRules(rules []*DenyRule, query string) error {
+	lower := strings.ToLower(query)
+	for _, r := range rules {
+		for _, t := range r.Tables {
+			re := regexp.MustCompile(`(^|[^a-z0-9_])` + regexp.QuoteMeta(strings.ToLower(t)) + `($|[^a-z0-9_])`)
+			if re.MatchString(lower) {
+				return fmt.Errorf("query denied by rule %s: table %s: %s", r.Name, t, r.Reason)
+			}
+		}
+		if r.Pattern != "" {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return fmt.Errorf("deny rule %s has a bad pattern: %v", r.Name, err)
+			}
+			if re.MatchString(query) {
+				return fmt.Errorf("query denied by rule %s: %s", r.Name, r.Reason)
+			}
+		}
+	}
+	return nil
+}
+
+// tableConfig finds a table's settings by its full name, or without the schema
+func (c *Config) tableConfig(name string) *TableConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if t, ok := c.Tables[name]; ok {
+		return t
+	}
+	if _, bare, ok := strings.Cut(name, "."); ok {
+		if t, ok := c.Tables[bare]; ok {
+			return t
+		}
+	}
+	return nil
+}
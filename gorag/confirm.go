@@ -0,0 +1,54 @@
+package gorag
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+  -confirm shows each question's query before it runs, as it will run,
+  masks and limits in, and runs it only when the answer is y. Anything
+  else, the end of the input included, stops the question with an
+  error, and a query written again after a failure is asked about
+  again. So that nothing the model wrote reaches the database unseen,
+  a query over -max-complexity isn't decomposed and -agent-steps
+  writes it in one go, as with -dry-run; a min_group_size that rejects
+  still counts the small groups first, which only returns a number.
+  It needs someone at the terminal, so it can't be served; gorag repl
+  asks on the same input it reads questions from. A program that
+  embeds gorag can set Client.Confirm to ask its own way.
+*/
+var confirmFlag = Flags.Bool("confirm", false, "show each query and run it only when the answer is y")
+
+type Confirmer interface {
+	// Confirm is whether the query, which the question will run as it is, may run
+	Confirm(query string) bool
+}
+
+// terminalConfirmer asks on out, and reads the answer from in
+type terminalConfirmer struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+func (t terminalConfirmer) Confirm(query string) bool {
+	fmt.Fprintf(t.out, "\n%s\n\nRun this query? [y/N] ", strings.TrimSpace(query))
+	return t.yes()
+}
+
+// yes reads the answer to what was asked, which is no unless it is y
+func (t terminalConfirmer) yes() bool {
+	if !t.in.Scan() {
+		fmt.Fprintln(t.out)
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(t.in.Text()))
+	return answer == "y" || answer == "yes"
+}
+
+// holdsQueries is whether the model's SQL may only run once it is shown, so nothing runs it on the way
+func (c *Client) holdsQueries() bool {
+	return c.DryRun || c.Confirm != nil
+}
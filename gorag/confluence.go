@@ -0,0 +1,177 @@
+package gorag
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+  confluence:https://acme.atlassian.net/wiki/ENG is the pages of the ENG
+  space, from the REST api under that url (without /wiki for a server
+  that isn't cloud). CONFLUENCE_USER and CONFLUENCE_TOKEN are an email
+  and api token on cloud; a CONFLUENCE_TOKEN alone is a personal access
+  token, on a server. CQL compares lastmodified in the time zone of the
+  user, which we don't know, so an incremental sync looks back a day
+  further than it needs to, and writes a few pages again.
+*/
+type confluence struct {
+	base   string
+	space  string
+	header http.Header
+}
+
+func newConfluence(raw string) (*confluence, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("confluence needs the url of a space, eg: confluence:https://acme.atlassian.net/wiki/ENG")
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 || path[i+1:] == "" {
+		return nil, fmt.Errorf("confluence needs the url of a space, eg: confluence:https://acme.atlassian.net/wiki/ENG")
+	}
+	c := &confluence{space: path[i+1:], header: make(http.Header)}
+	u.Path = path[:i]
+	c.base = strings.TrimSuffix(u.String(), "/")
+	user, token := os.Getenv("CONFLUENCE_USER"), os.Getenv("CONFLUENCE_TOKEN")
+	switch {
+	case user != "":
+		c.header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user+":"+token)))
+	case token != "":
+		c.header.Set("Authorization", "Bearer "+token)
+	}
+	return c, nil
+}
+
+func (c *confluence) Source() string {
+	return "confluence:" + c.space
+}
+
+func (c *confluence) Crawl(since time.Time, page func(Page) error) error {
+	cql := fmt.Sprintf("space = %q AND type = page", c.space)
+	if !since.IsZero() {
+		cql += fmt.Sprintf(" AND lastmodified >= %q", since.Add(-24*time.Hour).Format("2006-01-02 15:04"))
+	}
+	cql += " ORDER BY lastmodified"
+	next := c.base + "/rest/api/content/search?" + url.Values{
+		"cql":    {cql},
+		"expand": {"body.storage,version"},
+		"limit":  {"50"},
+	}.Encode()
+	for next != "" {
+		var out struct {
+			Results []struct {
+				ID      string `json:"id"`
+				Title   string `json:"title"`
+				Version struct {
+					When time.Time `json:"when"`
+				} `json:"version"`
+				Body struct {
+					Storage struct {
+						Value string `json:"value"`
+					} `json:"storage"`
+				} `json:"body"`
+				Links struct {
+					WebUI string `json:"webui"`
+				} `json:"_links"`
+			} `json:"results"`
+			Links struct {
+				Base string `json:"base"`
+				Next string `json:"next"`
+			} `json:"_links"`
+		}
+		found, err := jsonRequest("GET", next, c.header, nil, &out)
+		if err != nil {
+			return fmt.Errorf("failed to search confluence: %v", err)
+		}
+		if !found {
+			return fmt.Errorf("failed to search confluence: %s has no content api", c.base)
+		}
+		base := out.Links.Base
+		if base == "" {
+			base = c.base
+		}
+		for _, r := range out.Results {
+			text, err := storageText(r.Body.Storage.Value)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", r.Title, err)
+			}
+			p := Page{ID: r.ID, Title: r.Title, URL: base + r.Links.WebUI, Text: text, Updated: r.Version.When}
+			if err := page(p); err != nil {
+				return err
+			}
+		}
+		next = ""
+		if out.Links.Next != "" {
+			next = base + out.Links.Next
+		}
+	}
+	return nil
+}
+
+// lineStarts are the storage format elements that start a line, with what starts it
+var lineStarts = map[string]string{
+	"p": "", "div": "", "br": "", "tr": "", "pre": "", "blockquote": "> ",
+	"h1": "# ", "h2": "## ", "h3": "### ", "h4": "#### ", "h5": "##### ", "h6": "###### ",
+	"li": "- ",
+}
+
+/*
+  storageText is a page's storage format, which is XHTML with
+  Confluence's own elements for macros and links, as plain lines of
+  text: headings and list items marked the way markdown would, and
+  table cells split with |. Macro parameters are settings rather than
+  words, so they are left out.
+*/
+func storageText(storage string) (string, error) {
+	d := xml.NewDecoder(strings.NewReader("<page>" + storage + "</page>"))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	var sb strings.Builder
+	skip := 0
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if name == "parameter" {
+				skip++
+			}
+			if start, ok := lineStarts[name]; ok {
+				sb.WriteString("\n" + start)
+			} else if name == "td" || name == "th" {
+				sb.WriteString(" | ")
+			}
+		case xml.EndElement:
+			if strings.ToLower(t.Name.Local) == "parameter" && skip > 0 {
+				skip--
+			}
+		case xml.CharData:
+			if skip == 0 {
+				sb.Write(t)
+			}
+		}
+	}
+	lines := make([]string, 0)
+	for _, line := range strings.Split(sb.String(), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" && line != "-" && line != ">" && strings.Trim(line, "#") != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
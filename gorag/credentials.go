@@ -0,0 +1,185 @@
+package gorag
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/*
+  A CredentialsProvider mints the login of each new database
+  connection, for deployments where a password in the dsn won't do:
+  short lived tokens (RDS IAM, Vault database secrets, Azure AD), or
+  a ticket from Kerberos. -db-credentials names the provider, and a
+  profile's "credentials" names its own. What a provider returns is
+  reused until it expires, and minted again then, or when the database
+  refuses it, so a long running server never needs a restart for it;
+  connections are also not kept past the expiry of what they logged
+  in with.
+
+  The built in one, command, runs -db-credentials-command with the
+  configured dsn in GORAG_DSN, and connects with the dsn it prints,
+  which it says is good for -db-credentials-ttl, eg:
+
+    -db-credentials command -db-credentials-command \
+      'echo "$GORAG_DSN password=$(aws rds generate-db-auth-token --hostname db --port 5432 --username gorag)"'
+
+  A program using gorag as a library can RegisterCredentialsProvider
+  its own. For Kerberos with Postgres, that is a provider adding
+  krbsrvname to the dsn, after pq.RegisterGSSProvider with the
+  github.com/lib/pq/auth/kerberos package.
+*/
+type CredentialsProvider interface {
+	// DSN is what to open a new connection with, given the configured dsn, and when it expires; the zero time is never
+	DSN(ctx context.Context, dsn string) (string, time.Time, error)
+}
+
+var dbCredentials = Flags.String("db-credentials", "", "mint each database connection's login with this credentials provider: command, or one the program registered")
+var dbCredentialsCommand = Flags.String("db-credentials-command", "", "with -db-credentials command, a shell command given GORAG_DSN that prints the dsn to connect with")
+var dbCredentialsTTL = Flags.Duration("db-credentials-ttl", 15*time.Minute, "with -db-credentials command, how long the dsn it prints is good for")
+
+var credentialsProviders = map[string]CredentialsProvider{
+	"command": commandCredentials{},
+}
+
+// RegisterCredentialsProvider makes a provider available as -db-credentials name
+func RegisterCredentialsProvider(name string, p CredentialsProvider) {
+	credentialsProviders[name] = p
+}
+
+type commandCredentials struct{}
+
+func (commandCredentials) DSN(ctx context.Context, dsn string) (string, time.Time, error) {
+	if *dbCredentialsCommand == "" {
+		return "", time.Time{}, fmt.Errorf("-db-credentials command needs -db-credentials-command")
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", *dbCredentialsCommand)
+	cmd.Env = append(os.Environ(), "GORAG_DSN="+dsn)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// what it prints is a secret, so it's never in an error
+	out, err := cmd.Output()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("-db-credentials-command failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	minted := strings.TrimSpace(string(out))
+	if minted == "" {
+		return "", time.Time{}, fmt.Errorf("-db-credentials-command printed nothing")
+	}
+	expires := time.Time{}
+	if *dbCredentialsTTL > 0 {
+		expires = time.Now().Add(*dbCredentialsTTL)
+	}
+	return minted, expires, nil
+}
+
+// credentialsConnector opens each connection with what the provider minted last, until it expires
+type credentialsConnector struct {
+	dsn      string
+	provider CredentialsProvider
+	driver   sqldriver.Driver
+	db       *sql.DB
+
+	mu      sync.Mutex
+	minted  string
+	expires time.Time
+}
+
+// current is the minted dsn, minting it again when it expired or fresh is asked for
+func (c *credentialsConnector) current(ctx context.Context, fresh bool) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// a minute early, so a connection isn't opened with a token about to go
+	stale := !c.expires.IsZero() && time.Now().Add(time.Minute).After(c.expires)
+	if c.minted != "" && !fresh && !stale {
+		return c.minted, nil
+	}
+	minted, expires, err := c.provider.DSN(ctx, c.dsn)
+	if err != nil {
+		return "", fmt.Errorf("failed to get database credentials: %v", err)
+	}
+	c.minted, c.expires = minted, expires
+	if !expires.IsZero() && c.db != nil {
+		if ttl := time.Until(expires); ttl > 0 {
+			c.db.SetConnMaxLifetime(ttl)
+		}
+	}
+	return minted, nil
+}
+
+func (c *credentialsConnector) open(ctx context.Context, dsn string) (sqldriver.Conn, error) {
+	if *driver == "postgres" {
+		dsn = withStatementTimeout(dsn, *queryTimeout)
+	}
+	dc, ok := c.driver.(sqldriver.DriverContext)
+	if !ok {
+		return c.driver.Open(dsn)
+	}
+	connector, err := dc.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if pc, ok := connector.(*pq.Connector); ok && egress != nil {
+		pc.Dialer(egress)
+	}
+	return connector.Connect(ctx)
+}
+
+func (c *credentialsConnector) Connect(ctx context.Context) (sqldriver.Conn, error) {
+	dsn, err := c.current(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.open(ctx, dsn)
+	if err == nil {
+		return conn, nil
+	}
+	// revoked or expired early: mint again, once
+	dsn, rerr := c.current(ctx, true)
+	if rerr != nil {
+		return nil, fmt.Errorf("%v (and %v)", err, rerr)
+	}
+	return c.open(ctx, dsn)
+}
+
+func (c *credentialsConnector) Driver() sqldriver.Driver {
+	return c.driver
+}
+
+// ConnectWithCredentials opens the database with each connection's login minted by p
+func ConnectWithCredentials(dsn string, p CredentialsProvider) (*sql.DB, error) {
+	if err := checkDriver(); err != nil {
+		return nil, err
+	}
+	// only for its driver; opening doesn't connect
+	probe, err := dialect().Connect(dsn)
+	if err != nil {
+		return nil, err
+	}
+	drv := probe.Driver()
+	probe.Close()
+	c := &credentialsConnector{dsn: dsn, provider: p, driver: drv}
+	c.db = sql.OpenDB(c)
+	return c.db, nil
+}
+
+// connectAs opens the database with the named credentials provider, or with Connect for ""
+func connectAs(dsn, provider string) (*sql.DB, error) {
+	if provider == "" {
+		return Connect(dsn)
+	}
+	p, ok := credentialsProviders[provider]
+	if !ok {
+		return nil, fmt.Errorf("no such credentials provider: %s", provider)
+	}
+	return ConnectWithCredentials(dsn, p)
+}
@@ -0,0 +1,109 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+/*
+  Tables and columns can be marked deprecated in the config, with what
+  to use instead:
+
+    "tables": {
+      "orders_legacy": {"deprecated": "orders"},
+      "orders": {"columns": {"total": {"deprecated": "total_cents, which is in cents"}}}
+    }
+
+  The prompt tells the model about the replacements, and Validate
+  warns about (-deprecated-check warn) or refuses (block) queries
+  that still use the old names.
+*/
+
+// deprecations maps table or table.column to its replacement
+func (c *Config) deprecations() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make(map[string]string)
+	for table, tc := range c.Tables {
+		if tc.Deprecated != "" {
+			out[table] = tc.Deprecated
+		}
+		for column, cc := range tc.Columns {
+			if cc != nil && cc.Deprecated != "" {
+				out[table+"."+column] = cc.Deprecated
+			}
+		}
+	}
+	return out
+}
+
+func (c *Client) deprecationsPrompt(schema *DBMetadata) string {
+	if c.Config == nil {
+		return ""
+	}
+	deprecated := c.Config.deprecations()
+	keys := make([]string, 0, len(deprecated))
+	for key := range deprecated {
+		table, _, _ := strings.Cut(key, ".")
+		if _, ok := schema.Tables[table]; ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString("\nThese are deprecated. Don't use them, use the replacement instead:\n\n")
+	for _, key := range keys {
+		sb.WriteString(fmt.Sprintf("%s: use %s\n", key, deprecated[key]))
+	}
+	return sb.String()
+}
+
+// deprecatedUses lists what the query uses that is deprecated, with the replacements
+func (c *Client) deprecatedUses(query string) []string {
+	deprecated := c.Config.deprecations()
+	if len(deprecated) == 0 {
+		return nil
+	}
+	idents := make(map[string]bool)
+	for _, t := range lexSQL(query) {
+		if id := t.ident(); id != "" {
+			idents[id] = true
+		}
+	}
+	uses := make([]string, 0)
+	for _, table := range referencedTables(query) {
+		if use, ok := deprecated[table]; ok {
+			uses = append(uses, fmt.Sprintf("table %s is deprecated, use %s", table, use))
+		}
+		for key, use := range deprecated {
+			t, column, ok := strings.Cut(key, ".")
+			if ok && t == table && idents[strings.ToLower(column)] {
+				uses = append(uses, fmt.Sprintf("column %s is deprecated, use %s", key, use))
+			}
+		}
+	}
+	sort.Strings(uses)
+	return uses
+}
+
+func (c *Client) checkDeprecated(query string) error {
+	if c.DeprecatedCheck == "off" || c.Config == nil {
+		return nil
+	}
+	uses := c.deprecatedUses(query)
+	if len(uses) == 0 {
+		return nil
+	}
+	if c.DeprecatedCheck == "block" {
+		return fmt.Errorf("query uses deprecated objects: %s", strings.Join(uses, "; "))
+	}
+	for _, use := range uses {
+		log.Printf("Warning: %s", use)
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package gorag
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+  -docs retrieves the documents most relevant to the question (runbooks,
+  data dictionaries, wiki pages about the tables) and puts them in the
+  sql prompt as the docs part. A Retriever finds them; the one here
+  searches an Elasticsearch or OpenSearch index that is already there:
+
+    -docs elasticsearch:http://localhost:9200/wiki
+    -docs opensearch:https://search.internal/wiki
+
+  It is BM25 over -docs-fields, the first of which is the title when
+  there is more than one. With -docs-knn-field, the question is also
+  embedded and searched against that dense vector field, and the two
+  scores add up; the vectors must have been made with the embedding
+  model gorag uses. ES_API_KEY is sent as an ApiKey, or the url can
+  carry a user and password.
+*/
+var docsSpec = Flags.String("docs", "", "retrieve documents for the sql prompt from elasticsearch:URL/index or opensearch:URL/index")
+var docsFields = Flags.String("docs-fields", "title,content", "the document fields searched, the first is the title")
+var docsKNNField = Flags.String("docs-knn-field", "", "a dense vector field to search too, with the question's embedding")
+var docsCount = Flags.Int("docs-count", 3, "documents in a prompt")
+var docsMaxBytes = Flags.Int("docs-max-bytes", 2000, "of each document's text in the prompt")
+
+// Document is something retrieved for a question, as the prompt shows it
+type Document struct {
+	ID     string  `json:"id"`
+	Title  string  `json:"title,omitempty"`
+	Text   string  `json:"text"`
+	URL    string  `json:"url,omitempty"`    // of the page it is from, when it was ingested
+	Source string  `json:"source,omitempty"` // where it came from, eg: the index
+	Score  float64 `json:"score"`
+}
+
+type Retriever interface {
+	// Retrieve is the k documents most relevant to the question, best first; vector is its embedding, when the retriever wants one
+	Retrieve(question string, vector []float64, k int) ([]Document, error)
+	// WantsVector is whether Retrieve needs the question embedded
+	WantsVector() bool
+}
+
+func parseRetriever(spec string) (Retriever, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	kind, rawURL, _ := strings.Cut(spec, ":")
+	if kind != "elasticsearch" && kind != "opensearch" {
+		return nil, fmt.Errorf("-docs must be elasticsearch:URL/index or opensearch:URL/index")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("-docs %s: %v", kind, err)
+	}
+	index := strings.Trim(u.Path, "/")
+	if u.Host == "" || index == "" || strings.Contains(index, "/") {
+		return nil, fmt.Errorf("-docs %s needs a url with the index, eg: %s:http://localhost:9200/wiki", kind, kind)
+	}
+	fields := make([]string, 0)
+	for _, f := range strings.Split(*docsFields, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("-docs-fields needs a field")
+	}
+	es := &searchIndex{
+		openSearch: kind == "opensearch",
+		index:      index,
+		fields:     fields,
+		knnField:   *docsKNNField,
+		apiKey:     os.Getenv("ES_API_KEY"),
+	}
+	if u.User != nil {
+		es.user = u.User.Username()
+		es.password, _ = u.User.Password()
+		u.User = nil
+	}
+	u.Path = ""
+	es.url = strings.TrimSuffix(u.String(), "/")
+	return es, nil
+}
+
+// searchIndex is an Elasticsearch or OpenSearch index, which speak the same search api but for kNN
+type searchIndex struct {
+	openSearch     bool
+	url            string
+	index          string
+	fields         []string
+	knnField       string
+	apiKey         string
+	user, password string
+}
+
+func (s *searchIndex) WantsVector() bool {
+	return s.knnField != ""
+}
+
+func (s *searchIndex) header() http.Header {
+	h := make(http.Header)
+	if s.apiKey != "" {
+		h.Set("Authorization", "ApiKey "+s.apiKey)
+	} else if s.user != "" {
+		h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(s.user+":"+s.password)))
+	}
+	return h
+}
+
+func (s *searchIndex) query(question string, vector []float64, k int) map[string]interface{} {
+	fields := make([]string, len(s.fields))
+	copy(fields, s.fields)
+	if len(fields) > 1 {
+		fields[0] += "^2"
+	}
+	bm25 := map[string]interface{}{"multi_match": map[string]interface{}{"query": question, "fields": fields}}
+	q := map[string]interface{}{"size": k, "_source": append([]string{"url"}, s.fields...)}
+	switch {
+	case vector == nil || s.knnField == "":
+		q["query"] = bm25
+	case s.openSearch:
+		knn := map[string]interface{}{"knn": map[string]interface{}{s.knnField: map[string]interface{}{"vector": vector, "k": k}}}
+		q["query"] = map[string]interface{}{"bool": map[string]interface{}{"should": []interface{}{bm25, knn}}}
+	default:
+		q["query"] = bm25
+		q["knn"] = map[string]interface{}{"field": s.knnField, "query_vector": vector, "k": k, "num_candidates": 10 * k}
+	}
+	return q
+}
+
+func (s *searchIndex) Retrieve(question string, vector []float64, k int) ([]Document, error) {
+	var out struct {
+		Hits struct {
+			Hits []struct {
+				ID     string                     `json:"_id"`
+				Score  float64                    `json:"_score"`
+				Source map[string]json.RawMessage `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	u := s.url + "/" + url.PathEscape(s.index) + "/_search"
+	found, err := jsonRequest("POST", u, s.header(), s.query(question, vector, k), &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %s: %v", s.index, err)
+	}
+	if !found {
+		return nil, fmt.Errorf("failed to search %s: no such index", s.index)
+	}
+	docs := make([]Document, 0, len(out.Hits.Hits))
+	for _, h := range out.Hits.Hits {
+		d := Document{ID: h.ID, URL: sourceText(h.Source["url"]), Source: s.index, Score: h.Score}
+		texts := make([]string, 0, len(s.fields))
+		for i, f := range s.fields {
+			v := sourceText(h.Source[f])
+			if i == 0 && len(s.fields) > 1 {
+				d.Title = v
+			} else if v != "" {
+				texts = append(texts, v)
+			}
+		}
+		d.Text = strings.Join(texts, "\n")
+		docs = append(docs, d)
+	}
+	return docs, nil
+}
+
+// sourceText is a field of a hit as text, which is usually a string but needn't be
+func sourceText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return strings.Join(list, "\n")
+	}
+	return string(raw)
+}
+
+/*
+  The sql prompt and the summary prompt want the same documents, and
+  the answer says which they were, so what was retrieved for a
+  question is remembered for a little while instead of searched for
+  three times.
+*/
+const docsMemoFor = time.Minute
+
+var docsMemo struct {
+	sync.Mutex
+	retriever Retriever
+	question  string
+	at        time.Time
+	docs      []Document
+}
+
+// retrieveDocs is what the retriever finds for the question; failing to retrieve only leaves them out
+func (c *Client) retrieveDocs(question string) []Document {
+	if c.Docs == nil || !c.usePart("docs") {
+		return nil
+	}
+	// a Retriever needn't be comparable, and then it isn't remembered
+	remember := reflect.TypeOf(c.Docs).Comparable()
+	docsMemo.Lock()
+	if remember && docsMemo.retriever == c.Docs && docsMemo.question == question && time.Since(docsMemo.at) < docsMemoFor {
+		docs := docsMemo.docs
+		docsMemo.Unlock()
+		return docs
+	}
+	docsMemo.Unlock()
+	var vector []float64
+	if c.Docs.WantsVector() {
+		v, err := c.embed([]string{question})
+		if err != nil {
+			log.Printf("Retrieving documents without the question's embedding: %v", err)
+		} else {
+			vector = v[0]
+		}
+	}
+	docs, err := c.Docs.Retrieve(question, vector, *docsCount)
+	if err != nil {
+		log.Printf("No documents in the prompt: %v", err)
+		return nil
+	}
+	if remember {
+		docsMemo.Lock()
+		docsMemo.retriever, docsMemo.question, docsMemo.at, docsMemo.docs = c.Docs, question, time.Now(), docs
+		docsMemo.Unlock()
+	}
+	return docs
+}
+
+// docsPrompt has the documents for the question, with their links
+func (c *Client) docsPrompt(question string) string {
+	docs := c.retrieveDocs(question)
+	if len(docs) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\nDocumentation that may help, most relevant first:\n\n")
+	for _, d := range docs {
+		text := d.Text
+		if *docsMaxBytes > 0 && len(text) > *docsMaxBytes {
+			text = strings.ToValidUTF8(text[:*docsMaxBytes], "") + "..."
+		}
+		if d.Title != "" {
+			sb.WriteString(fmt.Sprintf("## %s\n", d.Title))
+		}
+		if d.URL != "" {
+			sb.WriteString(fmt.Sprintf("(from %s)\n", d.URL))
+		}
+		sb.WriteString(text + "\n\n")
+	}
+	return sb.String()
+}
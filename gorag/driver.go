@@ -0,0 +1,163 @@
+package gorag
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+  -driver picks the database questions are asked of. Postgres is what
+  everything is built for; on the others, the schema is read from
+  their own catalogs, the model is told which SQL to write, and the
+  features that rewrite queries or plan them in Postgres SQL (masks,
+  group sizes, aggregates summaries, scratch tables, EXPLAIN, the
+  empty result diagnosis, and the advise, optimize, seed, migrate, gdpr
+  and db commands) refuse rather than send the database something it
+  can't run.
+*/
+var driver = Flags.String("driver", "postgres", "the database: postgres, mysql (which also covers mariadb), sqlite, sqlserver, duckdb, snowflake, bigquery or clickhouse")
+
+/*
+  A Dialect is everything the pipeline needs to know about a kind of
+  database, so adding one is a file that registers it, not a change to
+  the pipeline. Each of the built in ones is in its own file.
+*/
+type Dialect interface {
+	// Name is the SQL the model is asked to write, eg: PostgreSQL
+	Name() string
+	// DSN is the connection string from -user, -password, -dbname and -host
+	DSN(user, password, dbname, host string) string
+	// Connect opens the database, with the egress guard on the connections when there is one
+	Connect(dsn string) (*sql.DB, error)
+	// Introspect reads the schema from the database's catalogs
+	Introspect(db *sql.DB) (*DBMetadata, error)
+	// QuoteIdentifier quotes a table or column name
+	QuoteIdentifier(name string) string
+	// LimitClause is a plain SELECT limited to its first n rows
+	LimitClause(query string, n int) string
+	// PromptHints is what the model needs to be told about writing this SQL
+	PromptHints() string
+}
+
+var dialects = make(map[string]Dialect)
+
+// RegisterDialect makes a database available as -driver name
+func RegisterDialect(name string, d Dialect) {
+	dialects[name] = d
+}
+
+// dialect is the database -driver says this is
+func dialect() Dialect {
+	if d, ok := dialects[*driver]; ok {
+		return d
+	}
+	return dialects["postgres"]
+}
+
+func checkDriver() error {
+	if _, ok := dialects[*driver]; ok {
+		return nil
+	}
+	names := make([]string, 0, len(dialects))
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("-driver must be one of %s, not %s", strings.Join(names, ", "), *driver)
+}
+
+/*
+  builtinDialect is a Dialect made of the functions in a driver's file.
+  quote nil is double quotes, and top puts the limit up front as TOP n.
+*/
+type builtinDialect struct {
+	name       string
+	hints      string
+	dsn        func(user, password, dbname, host string) string
+	connect    func(dsn string) (*sql.DB, error)
+	introspect func(db *sql.DB) (*DBMetadata, error)
+	quote      func(name string) string
+	top        bool
+}
+
+func (d builtinDialect) Name() string        { return d.name }
+func (d builtinDialect) PromptHints() string { return d.hints }
+
+func (d builtinDialect) DSN(user, password, dbname, host string) string {
+	return d.dsn(user, password, dbname, host)
+}
+
+func (d builtinDialect) Connect(dsn string) (*sql.DB, error) {
+	return d.connect(dsn)
+}
+
+func (d builtinDialect) Introspect(db *sql.DB) (*DBMetadata, error) {
+	return d.introspect(db)
+}
+
+func (d builtinDialect) QuoteIdentifier(name string) string {
+	if d.quote != nil {
+		return d.quote(name)
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (d builtinDialect) LimitClause(query string, n int) string {
+	if !d.top {
+		return fmt.Sprintf("%s LIMIT %d", query, n)
+	}
+	head := len("SELECT ")
+	if strings.HasPrefix(strings.ToUpper(query), "SELECT DISTINCT ") {
+		head = len("SELECT DISTINCT ")
+	}
+	return fmt.Sprintf("%sTOP %d %s", query[:head], n, query[head:])
+}
+
+func init() {
+	RegisterDialect("postgres", builtinDialect{
+		name:       "PostgreSQL",
+		dsn:        postgresDSN,
+		connect:    openPostgres,
+		introspect: getSchema,
+	})
+}
+
+func isPostgres() bool {
+	return *driver == "postgres"
+}
+
+// requirePostgres is an error for what only works on postgres, on any other database
+func requirePostgres(what string) error {
+	if isPostgres() {
+		return nil
+	}
+	return fmt.Errorf("%s needs -driver postgres, not %s", what, *driver)
+}
+
+// sqlDialect is the SQL the model is asked to write
+func sqlDialect() string {
+	return dialect().Name()
+}
+
+// dialectPrompt is what the model needs to be told about writing this database's SQL
+func dialectPrompt() string {
+	return dialect().PromptHints()
+}
+
+// introspect reads the schema from the catalogs of the database -driver says this is
+func introspect(db *sql.DB) (*DBMetadata, error) {
+	return dialect().Introspect(db)
+}
+
+// distinctQuery is up to n of the distinct values in a column
+func distinctQuery(table, column string, n int) string {
+	col := quoteIdent(column)
+	return dialect().LimitClause(fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s IS NOT NULL", col, quoteIdent(table), col), n)
+}
+
+// sampleQuery is the first n rows of a table
+func sampleQuery(table string, n int) string {
+	return dialect().LimitClause("SELECT * FROM "+quoteIdent(table), n)
+}
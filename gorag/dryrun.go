@@ -0,0 +1,29 @@
+package gorag
+
+/*
+  -dry-run writes the query for a question and shows it, with the
+  prompt it was written from, without running it, eg: to see what the
+  model makes of a question before it goes near production. The query
+  is validated as it would be, so a refusal shows too, but nothing the
+  model wrote runs (EXPLAIN, for OPA or -max-plan-cost, only plans
+  it): a query over -max-complexity isn't decomposed, whose stages
+  would run, -agent-steps writes the query in one go, and a
+  min_group_size that rejects isn't probed. What the
+  prompt is made of is still read as usual, the catalog (or
+  -schema-cache) and, when the prompt has them, sample rows. Sinks,
+  -result and -voice get nothing.
+*/
+var dryRun = Flags.Bool("dry-run", false, "write and validate the query, and show it with its prompt, but don't run it")
+
+// lastPrompt is the prompt of the call recorded last, or ""
+func (rec *runRecording) lastPrompt() string {
+	if rec == nil {
+		return ""
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.calls) == 0 {
+		return ""
+	}
+	return rec.calls[len(rec.calls)-1].Prompt
+}
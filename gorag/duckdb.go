@@ -0,0 +1,196 @@
+package gorag
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+/*
+  With -driver duckdb, questions are asked of DuckDB, in process, so
+  Parquet, CSV and JSON files can be explored without loading them
+  anywhere. -dbname is a .duckdb file, opened read only, or :memory:;
+  each of -duckdb-files becomes a view, which is what the model sees:
+
+    -driver duckdb -dbname :memory: -duckdb-files orders=data/orders/*.parquet,customers=customers.csv
+
+  The views are made on every connection, since they are temporary
+  (which is what lets a read only database have them). DuckDB needs
+  cgo, so it is only in a binary built with -tags duckdb.
+*/
+var duckdbFiles = Flags.String("duckdb-files", "", "with -driver duckdb, name=path views over parquet, csv or json files (globs work), comma separated")
+
+// openDuckDB is set when built with -tags duckdb
+var openDuckDB func(dsn string, views []string) (*sql.DB, error)
+
+const duckdbPrompt = `
+This is DuckDB, whose SQL is PostgreSQL's with more: QUALIFY, GROUP BY ALL,
+PIVOT, median(x), quantile_cont(x, 0.9), arg_max(a, b), list and struct values,
+strftime(x, '%Y-%m') and date_trunc('month', x). Tables may be views over files,
+so prefer filters on the columns they are partitioned by.
+`
+
+func init() {
+	RegisterDialect("duckdb", builtinDialect{
+		name:       "DuckDB",
+		hints:      duckdbPrompt,
+		dsn:        func(user, password, dbname, host string) string { return duckdbDSN(dbname) },
+		connect:    connectDuckDB,
+		introspect: getDuckDBSchema,
+	})
+}
+
+func duckdbDSN(file string) string {
+	if file == ":memory:" {
+		return ""
+	}
+	return file + "?access_mode=read_only"
+}
+
+// duckdbViews are the statements that make -duckdb-files into views
+func duckdbViews(spec string) ([]string, error) {
+	views := make([]string, 0)
+	if spec == "" {
+		return views, nil
+	}
+	for _, f := range strings.Split(spec, ",") {
+		name, path, ok := strings.Cut(strings.TrimSpace(f), "=")
+		if !ok || name == "" || path == "" {
+			return nil, fmt.Errorf("-duckdb-files takes name=path, not %s", f)
+		}
+		var reader string
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".parquet":
+			reader = "read_parquet"
+		case ".csv", ".tsv", ".txt":
+			reader = "read_csv_auto"
+		case ".json", ".ndjson", ".jsonl":
+			reader = "read_json_auto"
+		default:
+			return nil, fmt.Errorf("-duckdb-files can't tell what %s is: it reads .parquet, .csv and .json", path)
+		}
+		views = append(views, fmt.Sprintf("CREATE OR REPLACE TEMP VIEW %s AS SELECT * FROM %s(%s)", quoteIdent(strings.ToLower(name)), reader, sqlLiteral(path)))
+	}
+	return views, nil
+}
+
+func connectDuckDB(dsn string) (*sql.DB, error) {
+	if openDuckDB == nil {
+		return nil, fmt.Errorf("-driver duckdb needs a binary built with -tags duckdb")
+	}
+	views, err := duckdbViews(*duckdbFiles)
+	if err != nil {
+		return nil, err
+	}
+	return openDuckDB(dsn, views)
+}
+
+func getDuckDBSchema(db *sql.DB) (*DBMetadata, error) {
+	rows, err := db.Query(`
+		SELECT table_name, column_name
+		FROM information_schema.columns
+		WHERE table_schema = 'main'
+		ORDER BY table_name, ordinal_position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metadata := DBMetadata{Tables: make(map[string][]string), Comments: make(map[string]string)}
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		table = strings.ToLower(table)
+		metadata.Tables[table] = append(metadata.Tables[table], strings.ToLower(column))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// keys and comments are nice to have, so a failure here is not fatal
+	if metadata.PrimaryKeys, err = getDuckDBPrimaryKeys(db); err != nil {
+		log.Printf("Failed to retrieve primary keys: %v", err)
+	}
+	if metadata.ForeignKeys, err = getDuckDBForeignKeys(db); err != nil {
+		log.Printf("Failed to retrieve foreign keys: %v", err)
+	}
+	if err := getDuckDBComments(db, metadata.Comments); err != nil {
+		log.Printf("Failed to retrieve comments: %v", err)
+	}
+	return &metadata, nil
+}
+
+func getDuckDBPrimaryKeys(db *sql.DB) (map[string][]string, error) {
+	rows, err := db.Query(`
+		SELECT table_name, array_to_string(constraint_column_names, ',')
+		FROM duckdb_constraints()
+		WHERE schema_name = 'main' AND constraint_type = 'PRIMARY KEY'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make(map[string][]string)
+	for rows.Next() {
+		var table, columns string
+		if err := rows.Scan(&table, &columns); err != nil {
+			return nil, err
+		}
+		keys[strings.ToLower(table)] = strings.Split(strings.ToLower(columns), ",")
+	}
+	return keys, rows.Err()
+}
+
+func getDuckDBForeignKeys(db *sql.DB) ([]ForeignKey, error) {
+	rows, err := db.Query(`
+		SELECT k.constraint_name, k.table_name, k.column_name, r.table_name, r.column_name
+		FROM information_schema.referential_constraints c
+		JOIN information_schema.key_column_usage k
+			ON k.constraint_name = c.constraint_name AND k.constraint_schema = c.constraint_schema
+		JOIN information_schema.key_column_usage r
+			ON r.constraint_name = c.unique_constraint_name AND r.constraint_schema = c.unique_constraint_schema
+			AND r.ordinal_position = k.position_in_unique_constraint
+		WHERE k.table_schema = 'main'
+		ORDER BY k.table_name, k.constraint_name, k.ordinal_position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	fks := make([]ForeignKey, 0)
+	for rows.Next() {
+		var fk ForeignKey
+		if err := rows.Scan(&fk.Name, &fk.Table, &fk.Column, &fk.RefTable, &fk.RefColumn); err != nil {
+			return nil, err
+		}
+		fk.Table, fk.Column = strings.ToLower(fk.Table), strings.ToLower(fk.Column)
+		fk.RefTable, fk.RefColumn = strings.ToLower(fk.RefTable), strings.ToLower(fk.RefColumn)
+		fks = append(fks, fk)
+	}
+	return fks, rows.Err()
+}
+
+func getDuckDBComments(db *sql.DB, comments map[string]string) error {
+	rows, err := db.Query(`
+		SELECT table_name, '', comment FROM duckdb_tables() WHERE schema_name = 'main' AND comment IS NOT NULL
+		UNION ALL
+		SELECT table_name, column_name, comment FROM duckdb_columns() WHERE schema_name = 'main' AND comment IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, column, comment string
+		if err := rows.Scan(&table, &column, &comment); err != nil {
+			return err
+		}
+		key := strings.ToLower(table)
+		if column != "" {
+			key += "." + strings.ToLower(column)
+		}
+		comments[key] = comment
+	}
+	return rows.Err()
+}
@@ -0,0 +1,28 @@
+//go:build duckdb
+
+package gorag
+
+import (
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
+
+	"github.com/marcboeker/go-duckdb"
+)
+
+func init() {
+	openDuckDB = func(dsn string, views []string) (*sql.DB, error) {
+		connector, err := duckdb.NewConnector(dsn, func(execer sqldriver.ExecerContext) error {
+			for _, v := range views {
+				if _, err := execer.ExecContext(context.Background(), v, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return sql.OpenDB(connector), nil
+	}
+}
@@ -0,0 +1,271 @@
+package gorag
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/*
+  The editor endpoints are for an extension (eg: VS Code) that has a
+  SQL file open. POST /editor/edit takes a question and the file, and
+  answers with the edit as a unified diff against what the editor
+  has, so the extension can show it and apply it, plus what changed
+  and why. POST /editor/explain explains the file or a selection.
+  Nothing is run; the edited statements are planned with EXPLAIN, and
+  whatever doesn't plan, or uses deprecated names, comes back as a
+  warning next to the diff.
+*/
+type editRequest struct {
+	Prompt  string `json:"prompt"`
+	File    string `json:"file,omitempty"` // name for the diff headers
+	Content string `json:"content"`
+	Profile string `json:"profile,omitempty"`
+}
+
+type editProposal struct {
+	Diff        string   `json:"diff"`
+	Explanation string   `json:"explanation"`
+	Content     string   `json:"content"` // the whole file after the edit
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+func (c *Client) EditSQL(question, file, content string) (*editProposal, error) {
+	schema := c.promptSchema(question + "\n" + content)
+	var out struct {
+		Content     string `json:"content"`
+		Explanation string `json:"explanation"`
+	}
+	err := c.llmJSON(fmt.Sprintf(`
+You are helping someone edit a %s file in their editor. The database schema is:
+
+%s
+%s%s%s%s%s
+The file %s currently holds:
+
+%s
+
+Change it to do what they ask: %s
+
+Keep everything they didn't ask to change as it is, including comments and
+formatting, and return the whole file.
+http response must be application/json:
+{ "content": "the whole file after the edit", "explanation": "what changed and why" }
+`, sqlDialect(), formatSchema(schema), relationshipsPrompt(schema, question), commentsPrompt(schema), c.glossaryPrompt(),
+		c.deprecationsPrompt(schema), dialectPrompt(), file, content, question), &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to edit SQL: %v", err)
+	}
+
+	p := &editProposal{Explanation: out.Explanation, Content: out.Content}
+	if content != "" && !strings.HasSuffix(p.Content, "\n") && strings.HasSuffix(content, "\n") {
+		p.Content += "\n"
+	}
+	p.Diff = unifiedDiff(file, content, p.Content)
+	for _, stmt := range splitStatements(lexSQL(p.Content)) {
+		if len(stmt) == 0 || stmt[0].upper() != "SELECT" && stmt[0].upper() != "WITH" {
+			continue
+		}
+		text := p.Content[stmt[0].Pos : stmt[len(stmt)-1].Pos+len(stmt[len(stmt)-1].Text)]
+		if _, err := explainQuery(c.runContext(), c.DB, text); err != nil && isPostgres() {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("doesn't plan: %v", err))
+		}
+		p.Warnings = append(p.Warnings, c.deprecatedUses(text)...)
+	}
+	return p, nil
+}
+
+/*
+  unifiedDiff is the line diff of a to b, in the format patch and
+  editors take, with three lines of context around each change.
+*/
+func unifiedDiff(file, a, b string) string {
+	if a == b {
+		return ""
+	}
+	if file == "" {
+		file = "query.sql"
+	}
+	x, y := diffLines(a), diffLines(b)
+
+	// ops are ' ', '-' and '+', from the longest common subsequence
+	type op struct {
+		kind byte
+		line string
+	}
+	ops := make([]op, 0, len(x)+len(y))
+	if len(x)*len(y) > 4000000 {
+		// too big to be worth aligning, replace the whole thing
+		for _, l := range x {
+			ops = append(ops, op{'-', l})
+		}
+		for _, l := range y {
+			ops = append(ops, op{'+', l})
+		}
+	} else {
+		lcs := make([][]int, len(x)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(y)+1)
+		}
+		for i := len(x) - 1; i >= 0; i-- {
+			for j := len(y) - 1; j >= 0; j-- {
+				if x[i] == y[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(x) || j < len(y) {
+			switch {
+			case i < len(x) && j < len(y) && x[i] == y[j]:
+				ops = append(ops, op{' ', x[i]})
+				i++
+				j++
+			case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+				ops = append(ops, op{'-', x[i]})
+				i++
+			default:
+				ops = append(ops, op{'+', y[j]})
+				j++
+			}
+		}
+	}
+
+	const context = 3
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("--- a/%s\n+++ b/%s\n", file, file))
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+		// a hunk runs until there are more than two contexts' worth of unchanged lines
+		end := start
+		for k := start; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				end = k + 1
+			} else if k-end >= 2*context {
+				break
+			}
+		}
+		from := start - context
+		if from < 0 {
+			from = 0
+		}
+		to := end + context
+		if to > len(ops) {
+			to = len(ops)
+		}
+		// line numbers of the hunk in a and b
+		aLine, bLine := 1, 1
+		for _, o := range ops[:from] {
+			if o.kind != '+' {
+				aLine++
+			}
+			if o.kind != '-' {
+				bLine++
+			}
+		}
+		aCount, bCount := 0, 0
+		for _, o := range ops[from:to] {
+			if o.kind != '+' {
+				aCount++
+			}
+			if o.kind != '-' {
+				bCount++
+			}
+		}
+		// an empty side starts at the line before, as diff -u does it
+		if aCount == 0 {
+			aLine--
+		}
+		if bCount == 0 {
+			bLine--
+		}
+		sb.WriteString(fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", aLine, aCount, bLine, bCount))
+		for _, o := range ops[from:to] {
+			sb.WriteString(string(o.kind) + o.line + "\n")
+		}
+		start = to
+	}
+	return sb.String()
+}
+
+func diffLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// editorClient authenticates the caller and finds the profile they asked for, and who they are
+func (s *Server) editorClient(w http.ResponseWriter, r *http.Request, req *editRequest) (*Client, string) {
+	key, err := s.authenticate(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return nil, ""
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, ""
+	}
+	user := ""
+	if key != nil {
+		if !allowsProfile(key.Profiles, req.Profile) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("key %s may not use profile %s", key.Name, req.Profile))
+			return nil, ""
+		}
+		user = key.Name
+	}
+	client, err := s.clientFor(req.Profile)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, ""
+	}
+	return client.withTrace(traceFromRequest(r)), user
+}
+
+func (s *Server) handleEditorEdit(w http.ResponseWriter, r *http.Request) {
+	var req editRequest
+	client, user := s.editorClient(w, r, &req)
+	if client == nil {
+		return
+	}
+	if req.Prompt == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("prompt is required"))
+		return
+	}
+	if err := client.checkPrompt(&Question{Prompt: req.Prompt, User: user}); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	p, err := client.EditSQL(req.Prompt, req.File, req.Content)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (s *Server) handleEditorExplain(w http.ResponseWriter, r *http.Request) {
+	var req editRequest
+	client, _ := s.editorClient(w, r, &req)
+	if client == nil {
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("content is required"))
+		return
+	}
+	explanation, err := client.ExplainSQL(req.Content)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"explanation": explanation})
+}
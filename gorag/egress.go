@@ -0,0 +1,154 @@
+package gorag
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/*
+  -no-external-calls is for air gapped deployments. Every http request
+  gorag makes goes through the default transport, and every database
+  connection through our dialer, so both check the host they are about
+  to dial against -allow-hosts and refuse anything else. That covers
+  the model, embeddings, moderation, OPA, S3 and whatever is added
+  later, without each of them having to remember. On top of that the
+  configured endpoints are checked at startup, so a deployment that
+  would call out fails before it answers anything, not halfway through
+  a question. -llm-url points the model calls at a local server that
+  speaks the OpenAI API.
+*/
+var noExternalCalls = Flags.Bool("no-external-calls", false, "refuse to connect to any host not in -allow-hosts")
+var allowHosts = Flags.String("allow-hosts", "localhost,127.0.0.1,::1", "hosts, or host:port, that -no-external-calls lets us connect to")
+var llmURL = Flags.String("llm-url", "https://api.openai.com/v1", "base url of the OpenAI compatible api, eg: http://localhost:8000/v1")
+
+type hostAllowlist map[string]bool
+
+func parseAllowlist(s string) hostAllowlist {
+	hosts := make(hostAllowlist)
+	for _, h := range strings.Split(s, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts[strings.Trim(h, "[]")] = true
+		}
+	}
+	return hosts
+}
+
+// allows takes a dial address, host:port or just a host
+func (a hostAllowlist) allows(address string) bool {
+	address = strings.ToLower(address)
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = strings.Trim(address, "[]")
+	}
+	return a[host] || a[address]
+}
+
+func (a hostAllowlist) check(address string) error {
+	if !a.allows(address) {
+		log.Printf("Blocked a connection to %s (-no-external-calls)", address)
+		return fmt.Errorf("connection to %s is blocked by -no-external-calls; add it to -allow-hosts if it is local", address)
+	}
+	return nil
+}
+
+// egressDialer refuses hosts that aren't allowed, for http and for postgres alike
+type egressDialer struct {
+	allow hostAllowlist
+	net.Dialer
+}
+
+func (d *egressDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	// unix sockets can't leave the machine
+	if network != "unix" {
+		if err := d.allow.check(address); err != nil {
+			return nil, err
+		}
+	}
+	return d.Dialer.DialContext(ctx, network, address)
+}
+
+func (d *egressDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *egressDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
+
+var egress *egressDialer
+var egressOnce sync.Once
+
+/*
+  enforceNoExternalCalls installs the guard, once, and checks what the
+  flags would have us call. Anything that talks to the network calls
+  this before it does.
+*/
+func enforceNoExternalCalls() {
+	if !*noExternalCalls {
+		return
+	}
+	egressOnce.Do(func() {
+		egress = &egressDialer{allow: parseAllowlist(*allowHosts), Dialer: net.Dialer{Timeout: 30 * time.Second}}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// a proxy would be dialed instead of the host, so there is none
+		transport.Proxy = nil
+		transport.DialContext = egress.DialContext
+		http.DefaultTransport = transport
+
+		endpoints := make([]string, 0)
+		if *llmProvider != "mock" {
+			endpoints = append(endpoints, defaultOptions("").URL)
+		}
+		if *opaURL != "" {
+			endpoints = append(endpoints, *opaURL)
+		}
+		if *speechURL != "" {
+			endpoints = append(endpoints, *speechURL)
+		}
+		if u := vectorStoreURL(); u != "" {
+			endpoints = append(endpoints, u)
+		}
+		if _, u, ok := strings.Cut(*docsSpec, ":"); ok {
+			endpoints = append(endpoints, u)
+		}
+		for _, e := range endpoints {
+			u, err := url.Parse(e)
+			if err != nil || u.Host == "" {
+				log.Fatalf("Refusing to start with -no-external-calls: bad url %s", e)
+			}
+			if !egress.allow.allows(u.Host) {
+				log.Fatalf("Refusing to start with -no-external-calls: %s is not in -allow-hosts", u.Host)
+			}
+		}
+		if strings.HasPrefix(*schemaCache, "s3://") {
+			log.Fatalf("Refusing to start with -no-external-calls: the schema cache is in S3")
+		}
+		log.Printf("No external calls: only %s", *allowHosts)
+	})
+}
+
+// openPostgres is sql.Open, with the egress guard on the connections when there is one
+func openPostgres(dsn string) (*sql.DB, error) {
+	dsn = withStatementTimeout(dsn, *queryTimeout)
+	if egress == nil {
+		return sql.Open("postgres", dsn)
+	}
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	connector.Dialer(egress)
+	return sql.OpenDB(connector), nil
+}
@@ -0,0 +1,97 @@
+package gorag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+)
+
+var embeddingModel = "text-embedding-3-small"
+
+type embeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// embedTexts gets one embedding per input, in order
+func embedTexts(ctx context.Context, apiKey string, texts []string) ([][]float64, error) {
+	p, err := currentProvider()
+	if err != nil {
+		return nil, err
+	}
+	e, ok := p.(embeddingProvider)
+	if !ok {
+		return nil, fmt.Errorf("-llm %s can't embed text", *llmProvider)
+	}
+	return e.Embed(ctx, texts, defaultOptions(apiKey))
+}
+
+// openaiEmbed gets the embeddings from an OpenAI compatible server's /embeddings
+func openaiEmbed(ctx context.Context, texts []string, opts CompletionOptions) ([][]float64, error) {
+	requestBody, err := json.Marshal(embeddingRequest{Model: embeddingModel, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(opts.URL, "/")+"/embeddings", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+opts.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var out embeddingResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, err
+	}
+	if out.Error != nil {
+		return nil, fmt.Errorf("embeddings: %s", out.Error.Message)
+	}
+	if len(out.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings: asked for %d, got %d", len(texts), len(out.Data))
+	}
+	vectors := make([][]float64, len(texts))
+	for _, d := range out.Data {
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		if i >= len(b) {
+			break
+		}
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,105 @@
+package gorag
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+/*
+  -embeddings-cache keeps table and value embeddings in a file, keyed
+  by a hash of the model and the exact text embedded, so a restart,
+  another instance or an imported deployment doesn't pay to embed an
+  unchanged schema again. A changed table is a different text, and is embedded
+  again; entries nothing asks for any more just stay.
+*/
+var embeddingsCache = Flags.String("embeddings-cache", "", "keep table and value embeddings in this file, so restarts don't embed them again")
+
+type embeddingCache struct {
+	mu      sync.Mutex
+	loaded  string
+	Vectors map[string][]float64 `json:"vectors"`
+}
+
+var embeddingsOnDisk = &embeddingCache{}
+
+func embeddingKey(text string) string {
+	sum := sha256.Sum256([]byte(providerEmbeddingModel() + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+// load reads the file the first time, and a missing file is an empty cache
+func (e *embeddingCache) load(filename string) error {
+	if e.loaded == filename {
+		return nil
+	}
+	e.Vectors = make(map[string][]float64)
+	e.loaded = filename
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, e); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", filename, err)
+	}
+	return nil
+}
+
+/*
+  cachedEmbed embeds only the texts the cache doesn't have. keys are
+  what the texts are known by, which is the text as the embedding model
+  sees it.
+*/
+func cachedEmbed(filename string, keys []string, embed func(missing []int) ([][]float64, error)) ([][]float64, error) {
+	out := make([][]float64, len(keys))
+	if filename == "" {
+		all := make([]int, len(keys))
+		for i := range all {
+			all[i] = i
+		}
+		return embed(all)
+	}
+	e := embeddingsOnDisk
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.load(filename); err != nil {
+		return nil, err
+	}
+	missing := make([]int, 0)
+	for i, k := range keys {
+		if v, ok := e.Vectors[embeddingKey(k)]; ok {
+			out[i] = v
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return out, nil
+	}
+	vectors, err := embed(missing)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range missing {
+		out[i] = vectors[j]
+		e.Vectors[embeddingKey(keys[i])] = vectors[j]
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to save embeddings: %v", err)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return nil, fmt.Errorf("failed to save embeddings: %v", err)
+	}
+	return out, nil
+}
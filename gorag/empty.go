@@ -0,0 +1,160 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+/*
+  No rows usually means a filter that doesn't match the data: 'NY'
+  where the column says 'New York', or a status with different case.
+  So before summarizing nothing, we check each literal filter in the
+  query against its column, look for values close to the ones that
+  match nothing, and have the model explain the empty result and
+  suggest a corrected query. The suggestion is not run. Tables with
+  a minimum group size are not probed, since listing values is the
+  kind of row level read they forbid.
+*/
+type literalFilter struct {
+	Table  string
+	Column string
+	Op     string
+	Value  string // as written in the query, quotes and all
+}
+
+func (f literalFilter) String() string {
+	return fmt.Sprintf("%s.%s %s %s", f.Table, f.Column, f.Op, f.Value)
+}
+
+// literalFilters finds col = 'x' (and LIKE, ILIKE) comparisons whose column we can place
+func literalFilters(schema *DBMetadata, query string) []literalFilter {
+	tokens := lexSQL(query)
+	aliases := tableAliases(tokens)
+	tables := referencedTables(query)
+	out := make([]literalFilter, 0)
+	for i := 0; i+2 < len(tokens); i++ {
+		op := tokens[i+1].upper()
+		if tokens[i+1].Text == "=" {
+			op = "="
+		}
+		lit := tokens[i+2]
+		if op != "=" && op != "LIKE" && op != "ILIKE" || (lit.Kind != sqlString && lit.Kind != sqlNumber) {
+			continue
+		}
+		column := tokens[i].ident()
+		if column == "" {
+			continue
+		}
+		table := ""
+		if i >= 2 && tokens[i-1].Text == "." {
+			table = aliases[tokens[i-2].ident()]
+		} else {
+			for _, t := range tables {
+				if schema.hasColumn(t, column) {
+					table = t
+					break
+				}
+			}
+		}
+		if table == "" || !schema.hasColumn(table, column) {
+			continue
+		}
+		out = append(out, literalFilter{table, column, op, lit.Text})
+	}
+	return out
+}
+
+// checkFilter counts what one filter matches on its own, and finds near misses when that is nothing
+func (c *Client) checkFilter(f literalFilter) (string, error) {
+	from := quoteIdent(f.Table)
+	col := quoteIdent(f.Column)
+	var n int64
+	err := c.reader().QueryRow(c.annotate(fmt.Sprintf("SELECT count(*) FROM %s WHERE %s %s %s", from, col, f.Op, f.Value))).Scan(&n)
+	if err != nil {
+		return "", err
+	}
+	if n > 0 {
+		return fmt.Sprintf("%s matches %d rows on its own", f, n), nil
+	}
+	// similar values are row values, which aggregates mode keeps from the model
+	if c.SummaryData == "aggregates" {
+		return fmt.Sprintf("%s matches no rows", f), nil
+	}
+	needle := strings.Trim(strings.Trim(f.Value, "'"), "%")
+	probe := fmt.Sprintf("SELECT DISTINCT %s::text FROM %s WHERE %s::text ILIKE %s LIMIT 5", col, from, col, sqlLiteral("%"+needle+"%"))
+	if needle == "" {
+		probe = fmt.Sprintf("SELECT %s::text FROM %s GROUP BY 1 ORDER BY count(*) DESC LIMIT 5", col, from)
+	}
+	rows, err := c.reader().Query(c.annotate(probe))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	values := make([]string, 0)
+	for rows.Next() {
+		var v *string
+		if err := rows.Scan(&v); err != nil {
+			return "", err
+		}
+		if v != nil {
+			values = append(values, sqlLiteral(*v))
+		}
+	}
+	if len(values) == 0 {
+		return fmt.Sprintf("%s matches no rows, and no value contains %s", f, sqlLiteral(needle)), rows.Err()
+	}
+	return fmt.Sprintf("%s matches no rows; similar values are %s", f, strings.Join(values, ", ")), rows.Err()
+}
+
+type emptyDiagnosis struct {
+	Explanation string `json:"explanation"`
+	Query       string `json:"query"`
+}
+
+func (c *Client) diagnoseEmpty(userInput, query string) (*emptyDiagnosis, error) {
+	if err := requirePostgres("diagnosing an empty result"); err != nil {
+		return nil, err
+	}
+	findings := make([]string, 0)
+	for _, f := range literalFilters(c.Schema, query) {
+		if c.Config != nil {
+			if tc := c.Config.tableConfig(f.Table); tc != nil && tc.MinGroupSize > 0 {
+				continue
+			}
+		}
+		finding, err := c.checkFilter(f)
+		if err != nil {
+			log.Printf("Couldn't check filter %s: %v", f, err)
+			continue
+		}
+		findings = append(findings, finding)
+	}
+	if len(findings) == 0 {
+		findings = append(findings, "no literal filters could be checked")
+	}
+	var d emptyDiagnosis
+	err := c.dataJSON(fmt.Sprintf(`
+We are doing RAG against a PostgreSQL database with this schema
+
+%s
+The user asked: %s
+
+This query returned no rows:
+
+%s
+
+Checking its filters against the data found:
+
+%s
+
+Explain to the user why there are probably no rows. If a filter doesn't match
+how the data is written, give a corrected query, otherwise leave query empty.
+http response must be application/json:
+{ "explanation": "...", "query": "SELECT ... or empty" }
+`, formatSchema(c.promptSchema(userInput)), userInput, query, strings.Join(findings, "\n")), &d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,173 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+/*
+  gorag estimate says how big the prompts for a question would be, part
+  by part, and what a question like it costs with each model in
+  model_prices, without calling a model or embedding anything:
+
+    gorag estimate -question "revenue by region last quarter"
+
+  It is for tuning -prompt-parts, -prune-tables and budgets: the parts
+  that cost the most are the ones to look at first. Tokens are counted
+  as a quarter of the bytes, which is near enough for English and SQL
+  on the models gorag talks to; a provider's bill counts for real. The
+  parts that need a call to be made, the values near what the question
+  names and the docs, are named but not counted, and a schema that
+  -prune-tables would cut is counted whole, with its biggest tables
+  listed to show what pruning saves. The summary prompt is counted with
+  as many result bytes as -max-llm-bytes lets the model see, or none
+  when there is no limit. The replies are -completion-tokens and
+  -summary-tokens.
+*/
+func runEstimate(args []string) {
+	fs := commandFlags("estimate")
+	question := fs.String("question", "", "the question to estimate the prompts of")
+	completionTokens := fs.Int("completion-tokens", 150, "how long the reply with the query is taken to be")
+	summaryTokens := fs.Int("summary-tokens", 300, "how long the summary is taken to be")
+	largest := fs.Int("largest", 10, "how many of the biggest tables to list")
+	fs.Parse(args)
+	if *question == "" {
+		log.Fatalf("estimate needs -question")
+	}
+	client, done := setupClient()
+	defer done()
+	fmt.Print(client.estimate(*question, *completionTokens, *summaryTokens, *largest))
+}
+
+// estimateTokens is roughly how many tokens the text is for the model
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+// estimate is the report of gorag estimate
+func (c *Client) estimate(question string, completionTokens, summaryTokens, largest int) string {
+	// a copy that can't call anything: no retriever, and no values part
+	ec := *c
+	ec.Docs = nil
+	ec.PromptParts = make(map[string]bool)
+	for _, name := range promptPartNames {
+		ec.PromptParts[name] = c.usePart(name) && name != "values"
+	}
+	var uncounted []string
+	if c.Docs != nil && c.usePart("docs") {
+		uncounted = append(uncounted, "docs")
+	}
+	if c.usePart("values") && c.DataModel == nil && len(c.embedValueColumns()) > 0 {
+		uncounted = append(uncounted, "values")
+	}
+
+	var sb strings.Builder
+	schema := c.accessibleSchema(c.Schema)
+	pruned := c.PruneTables > 0 && len(schema.Tables) > c.PruneTables
+	if !pruned {
+		schema = c.promptSchema(question)
+	}
+	examples := ec.promptExamples(schema, question)
+	prompt := ec.sqlPrompt(schema, examples, question, "")
+	schemaText := formatSchema(schema)
+	sqlTokens := estimateTokens(prompt)
+	sb.WriteString(fmt.Sprintf("sql prompt: about %d tokens\n", sqlTokens))
+	rows := [][2]string{{"schema", fmt.Sprintf("%d (%d tables)", estimateTokens(schemaText), len(schema.Tables))}}
+	rest := len(prompt) - len(schemaText) - len(question)
+	for _, p := range ec.sqlPromptParts(schema, examples, question) {
+		rest -= len(p.Text)
+		if p.Text != "" {
+			rows = append(rows, [2]string{p.Name, fmt.Sprint(estimateTokens(p.Text))})
+		}
+	}
+	rows = append(rows, [2]string{"question", fmt.Sprint(estimateTokens(question))}, [2]string{"instructions", fmt.Sprint((rest + 3) / 4)})
+	for _, row := range rows {
+		sb.WriteString(fmt.Sprintf("  %-14s %s\n", row[0], row[1]))
+	}
+	if len(uncounted) > 0 {
+		sb.WriteString(fmt.Sprintf("  not counted, since they call out: %s\n", strings.Join(uncounted, ", ")))
+	}
+	if pruned {
+		sb.WriteString(fmt.Sprintf("  -prune-tables %d would keep %d of these %d tables\n", c.PruneTables, c.PruneTables, len(schema.Tables)))
+	}
+	if largest > 0 {
+		sb.WriteString(largestTables(schema, largest))
+	}
+
+	resultTokens := (c.MaxLLMBytes + 3) / 4
+	summaryTokensIn := estimateTokens(ec.summaryPrompt(question, "", nil)) + resultTokens
+	if c.MaxLLMBytes > 0 {
+		sb.WriteString(fmt.Sprintf("summary prompt: about %d tokens, %d of them the result -max-llm-bytes allows\n", summaryTokensIn, resultTokens))
+	} else {
+		sb.WriteString(fmt.Sprintf("summary prompt: about %d tokens and the result, which -max-llm-bytes doesn't limit\n", summaryTokensIn))
+	}
+
+	sb.WriteString(fmt.Sprintf("a question, with a %d token query and a %d token summary:\n", completionTokens, summaryTokens))
+	sqlTarget, dataTarget := c.target("sql", ""), c.target("data", "")
+	sb.WriteString(fmt.Sprintf("  sql to %s on %s, data to %s on %s\n",
+		modelName(sqlTarget.Model), providerOf(sqlTarget.URL), modelName(dataTarget.Model), providerOf(dataTarget.URL)))
+	if c.Config == nil {
+		sb.WriteString("  no config, so no model_prices to cost it with\n")
+		return sb.String()
+	}
+	c.Config.mu.RLock()
+	defer c.Config.mu.RUnlock()
+	if len(c.Config.ModelPrices) == 0 {
+		sb.WriteString("  no model_prices to cost it with\n")
+		return sb.String()
+	}
+	models := make([]string, 0, len(c.Config.ModelPrices))
+	for model := range c.Config.ModelPrices {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		p := c.Config.ModelPrices[model]
+		usd := (float64(sqlTokens+summaryTokensIn)*p.Input + float64(completionTokens+summaryTokens)*p.Output) / 1e6
+		sb.WriteString(fmt.Sprintf("  %-24s $%.4f\n", model, usd))
+	}
+	for _, t := range []modelTarget{sqlTarget, dataTarget} {
+		if _, ok := c.Config.ModelPrices[t.Model]; !ok {
+			sb.WriteString(fmt.Sprintf("  %s has no model_prices\n", modelName(t.Model)))
+			break
+		}
+	}
+	return sb.String()
+}
+
+// modelName is the model, or what it is when the provider picks it
+func modelName(model string) string {
+	if model == "" {
+		return "the provider's default model"
+	}
+	return model
+}
+
+// largestTables lists the n tables that take the most of the schema's tokens
+func largestTables(schema *DBMetadata, n int) string {
+	type sized struct {
+		table  string
+		tokens int
+	}
+	tables := make([]sized, 0, len(schema.Tables))
+	for t, columns := range schema.Tables {
+		tables = append(tables, sized{t, estimateTokens(fmt.Sprintf("Table: %s\nColumns: %s\n", t, strings.Join(columns, ", ")))})
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].tokens != tables[j].tokens {
+			return tables[i].tokens > tables[j].tokens
+		}
+		return tables[i].table < tables[j].table
+	})
+	if len(tables) > n {
+		tables = tables[:n]
+	}
+	var sb strings.Builder
+	sb.WriteString("  biggest tables:\n")
+	for _, t := range tables {
+		sb.WriteString(fmt.Sprintf("    %-30s %d\n", t.table, t.tokens))
+	}
+	return sb.String()
+}
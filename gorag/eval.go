@@ -0,0 +1,259 @@
+package gorag
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*
+  gorag eval asks a benchmark's questions and checks the answers by
+  running them: a generated query is right when it returns the same
+  rows as the benchmark's query (in any order, whatever the columns
+  are called). The benchmark is a json list like the config's
+  examples, [{"prompt": "...", "query": "..."}], and a question
+  without a query is only checked for robustness.
+
+  Robustness is about people asking the same thing in other words:
+  with -paraphrases N the model rewrites each question N ways, and a
+  paraphrase is consistent when its query returns the same rows as
+  the original question's did. The report gives accuracy, and the
+  share of paraphrases that were consistent (and written to the same
+  query, literals aside) next to it. Both queries go through Validate,
+  so masks and group sizes apply to each the same way.
+*/
+type evalCase struct {
+	Prompt      string        `json:"prompt"`
+	Expected    string        `json:"expected,omitempty"`
+	Query       string        `json:"query"`
+	Error       string        `json:"error,omitempty"`
+	Correct     *bool         `json:"correct,omitempty"` // nil without an expected query
+	Paraphrases []*evalAnswer `json:"paraphrases,omitempty"`
+}
+
+type evalAnswer struct {
+	Prompt     string `json:"prompt"`
+	Query      string `json:"query"`
+	Error      string `json:"error,omitempty"`
+	Consistent bool   `json:"consistent"` // the same rows as the original question
+	SameQuery  bool   `json:"same_query"`
+	Correct    *bool  `json:"correct,omitempty"`
+}
+
+// evalRows is as many rows as are compared; a bigger result compares its first rows
+const evalRows = 10000
+
+// resultRows runs a query the way Ask would, and returns its rows in a comparable order
+func (c *Client) resultRows(prompt, query string) ([]string, string, error) {
+	q := &Question{RunID: newRunID(), Prompt: prompt, User: os.Getenv("USER")}
+	rc := c.forRun(q)
+	validated, err := rc.Validate(q, query)
+	if err != nil {
+		return nil, query, err
+	}
+	query = validated
+	buf, err := rc.runQuery(rc.DB, query, evalRows, 0)
+	if err != nil {
+		return nil, query, err
+	}
+	defer buf.Close()
+	rows := make([]string, 0, len(buf.table.Rows))
+	for _, row := range buf.table.Rows {
+		fields := make([]string, len(row))
+		for i, v := range row {
+			fields[i] = "NULL"
+			if v != nil {
+				fields[i] = *v
+			}
+		}
+		rows = append(rows, strings.Join(fields, "\x1f"))
+	}
+	sort.Strings(rows)
+	return rows, query, nil
+}
+
+func sameRows(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// paraphrase has the model ask the same question n other ways; it sees only the question
+func (c *Client) paraphrase(prompt string, n int) ([]string, error) {
+	var out struct {
+		Paraphrases []string `json:"paraphrases"`
+	}
+	err := c.llmJSON(fmt.Sprintf(`
+Rewrite this database question %d different ways, as different people might
+ask it: other words, other order, more or less formal. Each must ask for
+exactly the same data; don't add or drop any condition, and keep names,
+numbers and dates as they are.
+Respond with json: { "paraphrases": ["...", ...] }
+
+Question: %s
+`, n, prompt), &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to paraphrase: %v", err)
+	}
+	if len(out.Paraphrases) > n {
+		out.Paraphrases = out.Paraphrases[:n]
+	}
+	return out.Paraphrases, nil
+}
+
+func (c *Client) evalOne(b *Example, paraphrases int) *evalCase {
+	ec := &evalCase{Prompt: b.Prompt, Expected: b.Query}
+	var expected []string
+	if b.Query != "" {
+		rows, _, err := c.resultRows(b.Prompt, b.Query)
+		if err != nil {
+			ec.Error = fmt.Sprintf("the benchmark's own query failed: %v", err)
+			return ec
+		}
+		expected = rows
+	}
+	check := func(prompt string) (string, []string, *bool, error) {
+		query, err := c.GenerateSQL(prompt)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		rows, query, err := c.resultRows(prompt, query)
+		var correct *bool
+		if b.Query != "" {
+			ok := err == nil && sameRows(rows, expected)
+			correct = &ok
+		}
+		return query, rows, correct, err
+	}
+	query, rows, correct, err := check(b.Prompt)
+	ec.Query, ec.Correct = query, correct
+	if err != nil {
+		ec.Error = err.Error()
+	}
+	if paraphrases <= 0 {
+		return ec
+	}
+	others, err := c.paraphrase(b.Prompt, paraphrases)
+	if err != nil {
+		log.Printf("%v", err)
+		return ec
+	}
+	for _, p := range others {
+		a := &evalAnswer{Prompt: p}
+		var prows []string
+		a.Query, prows, a.Correct, err = check(p)
+		if err != nil {
+			a.Error = err.Error()
+		}
+		a.Consistent = err == nil && ec.Error == "" && sameRows(prows, rows)
+		a.SameQuery = a.Query != "" && fingerprint(a.Query) == fingerprint(query)
+		ec.Paraphrases = append(ec.Paraphrases, a)
+	}
+	return ec
+}
+
+func formatEval(cases []*evalCase) string {
+	var sb strings.Builder
+	graded, correct, asked, consistent, sameQuery, pgraded, pcorrect := 0, 0, 0, 0, 0, 0, 0
+	for _, ec := range cases {
+		status := "ungraded"
+		if ec.Correct != nil {
+			graded++
+			status = "wrong"
+			if *ec.Correct {
+				correct++
+				status = "right"
+			}
+		}
+		if ec.Error != "" {
+			status = "error"
+		}
+		n := 0
+		for _, a := range ec.Paraphrases {
+			asked++
+			if a.Consistent {
+				consistent++
+				n++
+			}
+			if a.SameQuery {
+				sameQuery++
+			}
+			if a.Correct != nil {
+				pgraded++
+				if *a.Correct {
+					pcorrect++
+				}
+			}
+		}
+		line := fmt.Sprintf("%-8s %s", status, ec.Prompt)
+		if len(ec.Paraphrases) > 0 {
+			line = fmt.Sprintf("%-8s %d/%d consistent  %s", status, n, len(ec.Paraphrases), ec.Prompt)
+		}
+		sb.WriteString(line + "\n")
+		if ec.Error != "" {
+			sb.WriteString("         " + ec.Error + "\n")
+		}
+		for _, a := range ec.Paraphrases {
+			if !a.Consistent {
+				sb.WriteString(fmt.Sprintf("         inconsistent: %s\n", a.Prompt))
+			}
+		}
+	}
+	pct := func(n, of int) string {
+		if of == 0 {
+			return "n/a"
+		}
+		return fmt.Sprintf("%.1f%% (%d/%d)", 100*float64(n)/float64(of), n, of)
+	}
+	sb.WriteString(fmt.Sprintf("\naccuracy: %s\n", pct(correct, graded)))
+	if asked > 0 {
+		sb.WriteString(fmt.Sprintf("robustness: %s of paraphrases return the same rows\n", pct(consistent, asked)))
+		sb.WriteString(fmt.Sprintf("same query: %s\n", pct(sameQuery, asked)))
+		sb.WriteString(fmt.Sprintf("paraphrase accuracy: %s\n", pct(pcorrect, pgraded)))
+	}
+	return sb.String()
+}
+
+func runEval(args []string) {
+	fs := commandFlags("eval")
+	benchmark := fs.String("benchmark", "benchmark.json", `questions to ask, as [{"prompt": "...", "query": "..."}]`)
+	paraphrases := fs.Int("paraphrases", 0, "also ask each question this many other ways, and score how consistent the answers are")
+	out := fs.String("out", "", "also write every case as json here")
+	fs.Parse(args)
+
+	data, err := os.ReadFile(*benchmark)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	var bench []*Example
+	if err := json.Unmarshal(data, &bench); err != nil {
+		log.Fatalf("Failed to parse %s: %v", *benchmark, err)
+	}
+	client, done := setupClient()
+	defer done()
+
+	cases := make([]*evalCase, 0, len(bench))
+	for i, b := range bench {
+		log.Printf("Question %d of %d: %s", i+1, len(bench), b.Prompt)
+		cases = append(cases, client.evalOne(b, *paraphrases))
+	}
+	fmt.Print(formatEval(cases))
+	if *out != "" {
+		data, err := json.MarshalIndent(cases, "", "  ")
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if err := os.WriteFile(*out, data, 0644); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package gorag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// PlanEstimate is the planner's top level guess at what a query costs
+type PlanEstimate struct {
+	TotalCost float64 `json:"total_cost"`
+	PlanRows  float64 `json:"plan_rows"`
+}
+
+/*
+  explainQuery asks postgres for the plan without running the query.
+  Plain EXPLAIN never executes anything, even for writes, so this is
+  safe to do before any policy decision.
+*/
+func explainQuery(ctx context.Context, db queryer, query string) (*PlanEstimate, error) {
+	if err := requirePostgres("EXPLAIN"); err != nil {
+		return nil, err
+	}
+	var raw []byte
+	if err := db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+query).Scan(&raw); err != nil {
+		return nil, fmt.Errorf("failed to explain query: %v", err)
+	}
+	var plans []struct {
+		Plan struct {
+			TotalCost float64 `json:"Total Cost"`
+			PlanRows  float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &plans); err != nil {
+		return nil, err
+	}
+	if len(plans) == 0 {
+		return nil, fmt.Errorf("explain returned no plan")
+	}
+	return &PlanEstimate{
+		TotalCost: plans[0].Plan.TotalCost,
+		PlanRows:  plans[0].Plan.PlanRows,
+	}, nil
+}
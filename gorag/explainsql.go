@@ -0,0 +1,81 @@
+package gorag
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+/*
+  gorag explain-sql goes the other way: given a query, say in plain
+  English what it does. The model gets the tables the query reads
+  (the whole schema when it can't tell), their foreign keys and
+  comments, and the glossary, so it can say "orders placed by
+  customers in the EU" and not just "joins orders to customers".
+*/
+func (c *Client) ExplainSQL(query string) (string, error) {
+	schema := c.Schema
+	if tables := referencedTables(query); len(tables) > 0 {
+		known := make([]string, 0, len(tables))
+		for _, t := range tables {
+			if _, ok := c.Schema.Tables[t]; ok {
+				known = append(known, t)
+			}
+		}
+		if len(known) > 0 {
+			schema = c.Schema.subset(known)
+		}
+	}
+	explanation, err := c.llmText(fmt.Sprintf(`
+You are reviewing a %s query for a colleague. The tables it uses are:
+
+%s
+%s%s%s
+Explain in plain English what this query does:
+
+%s
+
+Cover what each join connects and whether it could drop or duplicate rows, what
+the filters keep, how rows are grouped and aggregated, and what the result
+means. Point out anything that looks like a mistake. Don't repeat the SQL back.
+`, sqlDialect(), formatSchema(schema), relationshipsPrompt(schema, ""), commentsPrompt(schema), c.glossaryPrompt(), query))
+	if err != nil {
+		return "", fmt.Errorf("failed to explain query: %v", err)
+	}
+	return explanation, nil
+}
+
+// readQueryFile reads a query for a command, from stdin when file is "" or -
+func readQueryFile(file string) string {
+	var data []byte
+	var err error
+	if file == "" || file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		log.Fatalf("Failed to read query: %v", err)
+	}
+	return string(data)
+}
+
+func runExplainSQL(args []string) {
+	fs := commandFlags("explain-sql")
+	file := fs.String("file", "", "file with the query to explain, - or empty for stdin")
+	fs.Parse(args)
+
+	query := strings.TrimSpace(readQueryFile(*file))
+	if query == "" {
+		log.Fatalf("No query to explain")
+	}
+	client, done := setupClient()
+	defer done()
+	explanation, err := client.ExplainSQL(query)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	fmt.Println(explanation)
+}
@@ -0,0 +1,204 @@
+package gorag
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+/*
+  Which of the config's examples go in the sql prompt is up to an
+  ExampleSelector. The default puts in every example that fits the
+  schema, as it always has; -example-selection bandit learns from the
+  audit log which examples help.
+
+  Every answered question's audit event names the examples its prompt
+  had, and a run is a success when it got an answer and nobody said it
+  wasn't helpful (POST /feedback {"run_id": "...", "helpful": false}).
+  For a new question, each example's chance of helping is estimated
+  from the runs it was in, weighted by how much their questions look
+  like this one, plus an exploration bonus for examples with little
+  history (UCB1), so new examples get tried. That is multiplied by how
+  relevant the example looks to the question, and the best
+  -max-examples go in. The audit log is read again every
+  -examples-refresh.
+*/
+var exampleSelection = Flags.String("example-selection", "all", "which examples go in the sql prompt: all, or bandit to prefer the ones that helped similar questions")
+var maxExamples = Flags.Int("max-examples", 5, "with -example-selection bandit, how many examples a prompt gets")
+var examplesRefresh = Flags.Duration("examples-refresh", 10*time.Minute, "how often -example-selection bandit reads the audit log again")
+
+type ExampleSelector interface {
+	// Select orders the examples that fit and returns those to show, best first
+	Select(question string, examples []*Example) []*Example
+}
+
+func newExampleSelector(name, auditFilename string) (ExampleSelector, error) {
+	switch name {
+	case "all":
+		return nil, nil
+	case "bandit":
+		return &banditExamples{max: *maxExamples, filename: auditFilename, refresh: *examplesRefresh}, nil
+	}
+	return nil, fmt.Errorf("-example-selection must be all or bandit")
+}
+
+// exampleKey names an example in the audit log, and survives the config being reordered
+func exampleKey(ex *Example) string {
+	return questionHash(ex.Prompt)
+}
+
+func questionWords(s string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(w) > 2 {
+			words[w] = true
+		}
+	}
+	return words
+}
+
+func similarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	both := 0
+	for w := range a {
+		if b[w] {
+			both++
+		}
+	}
+	return float64(both) / float64(len(a)+len(b)-both)
+}
+
+type exampleTrial struct {
+	words   map[string]bool // of the question it was shown for
+	success bool
+}
+
+type banditExamples struct {
+	max      int
+	filename string
+	refresh  time.Duration
+
+	mu     sync.Mutex
+	read   time.Time
+	trials map[string][]exampleTrial
+}
+
+// history is the trials of every example, from an audit log read at most every refresh
+func (b *banditExamples) history() map[string][]exampleTrial {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.filename == "" || b.trials != nil && time.Since(b.read) < b.refresh {
+		return b.trials
+	}
+	type run struct {
+		prompt   string
+		examples []string
+		success  bool
+	}
+	runs := make(map[string]*run)
+	helpful := make(map[string]bool)
+	err := readAuditLog(b.filename, func(e AuditEvent) {
+		switch {
+		case e.Event == "ask" && e.RunID != "" && len(e.Examples) > 0:
+			runs[e.RunID] = &run{e.Prompt, e.Examples, e.Error == ""}
+		case e.Event == "feedback" && e.Helpful != nil:
+			helpful[e.RunID] = *e.Helpful
+		}
+	})
+	b.read = time.Now()
+	if err != nil {
+		return b.trials
+	}
+	trials := make(map[string][]exampleTrial)
+	for id, r := range runs {
+		success := r.success
+		if h, ok := helpful[id]; ok {
+			success = r.success && h
+		}
+		words := questionWords(r.prompt)
+		for _, key := range r.examples {
+			trials[key] = append(trials[key], exampleTrial{words, success})
+		}
+	}
+	b.trials = trials
+	return trials
+}
+
+func (b *banditExamples) Select(question string, examples []*Example) []*Example {
+	history := b.history()
+	words := questionWords(question)
+	type scored struct {
+		ex    *Example
+		score float64
+	}
+	// the runs like this question count most, but every run counts a little
+	n := make([]float64, len(examples))
+	wins := make([]float64, len(examples))
+	total := 0.0
+	for i, ex := range examples {
+		for _, t := range history[exampleKey(ex)] {
+			w := 0.1 + similarity(words, t.words)
+			n[i] += w
+			if t.success {
+				wins[i] += w
+			}
+		}
+		total += n[i]
+	}
+	out := make([]scored, len(examples))
+	for i, ex := range examples {
+		mean := (wins[i] + 1) / (n[i] + 2)
+		bonus := math.Sqrt(2 * math.Log(total+1) / (n[i] + 1))
+		relevance := 0.1 + similarity(words, questionWords(ex.Prompt))
+		out[i] = scored{ex, relevance * (mean + bonus)}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].score > out[j].score })
+	if b.max > 0 && len(out) > b.max {
+		out = out[:b.max]
+	}
+	chosen := make([]*Example, len(out))
+	for i, s := range out {
+		chosen[i] = s.ex
+	}
+	return chosen
+}
+
+type feedbackRequest struct {
+	RunID   string `json:"run_id"`
+	Helpful *bool  `json:"helpful"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// handleFeedback records whether an answer helped, which is what bandit example selection learns from
+func (s *Server) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	key, err := s.authenticate(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	var req feedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.RunID == "" || req.Helpful == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("run_id and helpful are required"))
+		return
+	}
+	event := AuditEvent{Event: "feedback", RunID: req.RunID, Helpful: req.Helpful, Reason: req.Comment}
+	if key != nil {
+		event.User = key.Name
+	}
+	s.defaultClient().Audit.Record(event)
+	w.WriteHeader(http.StatusNoContent)
+}
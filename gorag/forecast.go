@@ -0,0 +1,250 @@
+package gorag
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+  "What will signups look like next month?" isn't in the database,
+  and a model asked to extrapolate from rows makes a number up with
+  confidence. So a question worded like that asks for the history,
+  one row per time bucket, and each numeric column is forecast here,
+  -forecast-horizon buckets ahead: by Holt's linear trend, or with
+  -forecast-season, the buckets in a season (12 for months, 7 for
+  days), by additive Holt-Winters once there are two seasons of
+  history. The smoothing is whatever predicted the history best one
+  bucket ahead, and the interval is 95% from how far off those
+  predictions were, widening with the square root of the steps
+  ahead; a rough interval, and the summary is told to say so. The
+  summary is given the forecast with the history, to explain it with
+  its uncertainty. Words like "project" or "expected to" turn up in
+  ordinary questions too, so the model is asked first whether the
+  question really looks ahead. -forecast-horizon 0 answers these
+  questions as usual.
+*/
+var forecastHorizon = Flags.Int("forecast-horizon", 3, "buckets to forecast ahead, for questions about what will happen; 0 answers them as usual")
+var forecastSeason = Flags.Int("forecast-season", 0, "buckets in a season, eg: 12 for monthly history, for a seasonal forecast; 0 for trend only")
+
+// Forecast is a column's predicted value for a bucket after the history
+type Forecast struct {
+	Bucket string  `json:"bucket"`
+	Column string  `json:"column"`
+	Value  float64 `json:"value"`
+	Low    float64 `json:"low"` // the 95% interval
+	High   float64 `json:"high"`
+	Model  string  `json:"model"`
+}
+
+var future = regexp.MustCompile(`(?i)\b(forecast\w*|predict\w*|projected|projections?|will \w+( \w+)? (be|look|reach|grow|drop|fall|rise)|going to (be|look|reach|grow|drop|fall|rise)|expect(ed)? to (be|reach|grow|drop|fall|rise)|next (day|week|month|quarter|year)|coming (weeks|months|quarters|year))\b`)
+
+// wantsForecast is whether the question asks what a series will do
+func (c *Client) wantsForecast(question string) (bool, error) {
+	if c.ForecastHorizon <= 0 || !future.MatchString(question) {
+		return false, nil
+	}
+	var answer struct {
+		Forecast bool `json:"forecast"`
+	}
+	err := c.llmJSON(fmt.Sprintf(`
+A question about a database may ask what a measure will do in the future, like
+"what will signups be next month?". The database only has the history, so such
+a question is answered by forecasting from it.
+
+Say whether this question is one; words like "project", "going to" or "expected
+to" alone don't make it one ("which employees are on each project?" and "list
+orders expected to ship this week" are about rows as they are): %s
+http response must be application/json:
+{ "forecast": true or false }
+`, question), &answer)
+	if err != nil {
+		return false, fmt.Errorf("failed to ask whether the question wants a forecast: %v", err)
+	}
+	return answer.Forecast, nil
+}
+
+// forecastQuestion asks for the history of the question's series, one row per bucket
+func forecastQuestion(question string) string {
+	return question + `
+
+The future isn't in the database, so answer with its history instead: one row
+per time bucket (day, week, month or quarter, as suits how far ahead the question
+looks), as far back as is useful, the bucket in the first column and the rows
+oldest first, and every measure the question is about as a number in the other
+columns.`
+}
+
+// holt is double exponential smoothing, or additive Holt-Winters when season > 1:
+// its one step predictions of values, and a forecast h steps past them
+func holt(values []float64, alpha, beta, gamma float64, season int) ([]float64, func(h int) float64) {
+	var level, trend float64
+	seasonal := make([]float64, season)
+	start := 1
+	if season > 1 {
+		var first, second float64
+		for i := 0; i < season; i++ {
+			first += values[i]
+			second += values[season+i]
+		}
+		first, second = first/float64(season), second/float64(season)
+		// the first season's mean is its middle; the level starts at its end
+		trend = (second - first) / float64(season)
+		middle := float64(season-1) / 2
+		level = first + trend*middle
+		for i := 0; i < season; i++ {
+			seasonal[i] = values[i] - (first + trend*(float64(i)-middle))
+		}
+		start = season
+	} else {
+		level, trend = values[0], values[1]-values[0]
+	}
+	predicted := make([]float64, 0, len(values)-start)
+	for t := start; t < len(values); t++ {
+		s := 0.0
+		if season > 1 {
+			s = seasonal[t%season]
+		}
+		predicted = append(predicted, level+trend+s)
+		last := level
+		level = alpha*(values[t]-s) + (1-alpha)*(level+trend)
+		trend = beta*(level-last) + (1-beta)*trend
+		if season > 1 {
+			seasonal[t%season] = gamma*(values[t]-level) + (1-gamma)*s
+		}
+	}
+	n := len(values)
+	return predicted, func(h int) float64 {
+		s := 0.0
+		if season > 1 {
+			s = seasonal[(n+h-1)%season]
+		}
+		return level + float64(h)*trend + s
+	}
+}
+
+// fitForecast picks the smoothing that best predicts values, and forecasts horizon steps with it
+func fitForecast(values []float64, horizon, season int) (points, spread []float64, model string) {
+	if season > 1 && len(values) < 2*season {
+		season = 0
+	}
+	model = "Holt linear trend"
+	if season > 1 {
+		model = fmt.Sprintf("Holt-Winters, season of %d", season)
+	}
+	grid := []float64{0.1, 0.3, 0.5, 0.7, 0.9}
+	gammas := []float64{0}
+	if season > 1 {
+		gammas = grid
+	}
+	best, sigma := math.Inf(1), 0.0
+	var forecast func(h int) float64
+	for _, alpha := range grid {
+		for _, beta := range grid {
+			for _, gamma := range gammas {
+				predicted, f := holt(values, alpha, beta, gamma, season)
+				offset := len(values) - len(predicted)
+				var sse float64
+				for i, p := range predicted {
+					sse += (values[offset+i] - p) * (values[offset+i] - p)
+				}
+				if sse < best {
+					// the interval is drawn from the one step errors' standard deviation
+					best, forecast, sigma = sse, f, math.Sqrt(sse/float64(len(predicted)))
+				}
+			}
+		}
+	}
+	for h := 1; h <= horizon; h++ {
+		points = append(points, forecast(h))
+		spread = append(spread, 1.96*sigma*math.Sqrt(float64(h)))
+	}
+	return points, spread, model
+}
+
+var bucketLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02", "2006-01"}
+
+// nextBuckets names the horizon buckets after the last two, stepping as they do; +1, +2... when they aren't times
+func nextBuckets(before, last string, horizon int) []string {
+	out := make([]string, horizon)
+	for _, layout := range bucketLayouts {
+		a, errA := time.Parse(layout, strings.TrimSpace(before))
+		b, errB := time.Parse(layout, strings.TrimSpace(last))
+		if errA != nil || errB != nil || !b.After(a) {
+			continue
+		}
+		months := (b.Year()-a.Year())*12 + int(b.Month()-a.Month())
+		for h := 1; h <= horizon; h++ {
+			if a.Day() == b.Day() && months > 0 && a.AddDate(0, months, 0).Equal(b) {
+				out[h-1] = b.AddDate(0, h*months, 0).Format(layout)
+			} else {
+				out[h-1] = b.Add(time.Duration(h) * b.Sub(a)).Format(layout)
+			}
+		}
+		return out
+	}
+	for h := 1; h <= horizon; h++ {
+		out[h-1] = fmt.Sprintf("+%d", h)
+	}
+	return out
+}
+
+// forecastSeries forecasts each numeric column of a result with a bucket first
+func forecastSeries(t *resultTable, horizon, season int) []Forecast {
+	if t == nil || len(t.Columns) < 2 || len(t.Rows) < 4 {
+		return nil
+	}
+	var out []Forecast
+	for col := 1; col < len(t.Columns); col++ {
+		var buckets []string
+		var values []float64
+		for _, row := range t.Rows {
+			if row[col] == nil {
+				continue
+			}
+			v, err := strconv.ParseFloat(*row[col], 64)
+			if err != nil {
+				values = nil
+				break
+			}
+			bucket := ""
+			if row[0] != nil {
+				bucket = *row[0]
+			}
+			buckets = append(buckets, bucket)
+			values = append(values, v)
+		}
+		if len(values) < 4 {
+			continue
+		}
+		points, spread, model := fitForecast(values, horizon, season)
+		names := nextBuckets(buckets[len(buckets)-2], buckets[len(buckets)-1], horizon)
+		for h := range points {
+			out = append(out, Forecast{Bucket: names[h], Column: t.Columns[col], Value: points[h], Low: points[h] - spread[h], High: points[h] + spread[h], Model: model})
+		}
+	}
+	return out
+}
+
+// forecastPrompt is the question and the forecast, as the summary should take them
+func forecastPrompt(question string, forecasts []Forecast, t *resultTable) string {
+	var sb strings.Builder
+	sb.WriteString(question)
+	sb.WriteString("\n\n")
+	if len(forecasts) == 0 {
+		sb.WriteString(fmt.Sprintf("The %d rows are too little history of numbers to forecast from; say so, and don't guess.", len(t.Rows)))
+		return sb.String()
+	}
+	sb.WriteString(fmt.Sprintf(`The rows are the history. Fitted to those %d buckets, a simple model forecasts
+these, each with a rough 95%% interval. Explain the forecast as an estimate, not a
+fact: give the range as well as the point, say how sure it is and that it assumes
+the past pattern goes on, and don't give numbers that aren't here:
+`, len(t.Rows)))
+	for _, f := range forecasts {
+		sb.WriteString(fmt.Sprintf("- %s %s: %.4g, between %.4g and %.4g (%s)\n", f.Bucket, f.Column, f.Value, f.Low, f.High, f.Model))
+	}
+	return sb.String()
+}
@@ -0,0 +1,80 @@
+package gorag
+
+import (
+	"context"
+	"testing"
+)
+
+// answering is a provider that replies with one completion, counting the calls
+type answering struct {
+	reply string
+	calls *int
+}
+
+func (a answering) Complete(ctx context.Context, messages []Message, opts CompletionOptions) (string, error) {
+	*a.calls++
+	return a.reply, nil
+}
+
+// withAnswer has -llm reply with the given completion, and returns the number of calls made so far
+func withAnswer(t *testing.T, reply string) *int {
+	t.Helper()
+	calls := new(int)
+	RegisterLLMProvider("answering", answering{reply: reply, calls: calls})
+	old := *llmProvider
+	*llmProvider = "answering"
+	t.Cleanup(func() {
+		*llmProvider = old
+		delete(llmProviders, "answering")
+	})
+	return calls
+}
+
+func TestFutureWording(t *testing.T) {
+	cases := []struct {
+		question string
+		want     bool
+	}{
+		{"what will signups be next month?", true},
+		{"forecast revenue for the coming quarters", true},
+		{"how many orders are we going to reach?", true},
+		{"is churn expected to rise?", true},
+		{"show projected sales by region", true},
+
+		{"which employees are on each project?", false},
+		{"list orders expected to ship this week", false},
+		{"what projects is alice going to lead", false},
+		{"how many orders were placed last month?", false},
+	}
+	for _, tc := range cases {
+		if got := future.MatchString(tc.question); got != tc.want {
+			t.Errorf("future.MatchString(%q) = %v, want %v", tc.question, got, tc.want)
+		}
+	}
+}
+
+func TestWantsForecast(t *testing.T) {
+	cases := []struct {
+		horizon  int
+		question string
+		reply    string
+		want     bool
+		calls    int
+	}{
+		{3, "what will signups be next month?", `{"forecast": true}`, true, 1},
+		{3, "what will signups be next month?", `{"forecast": false}`, false, 1},
+		{3, "which employees are on each project?", `{"forecast": true}`, false, 0},
+		{0, "what will signups be next month?", `{"forecast": true}`, false, 0},
+	}
+	for _, tc := range cases {
+		calls := withAnswer(t, tc.reply)
+		c := &Client{ForecastHorizon: tc.horizon}
+		got, err := c.wantsForecast(tc.question)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want || *calls != tc.calls {
+			t.Errorf("wantsForecast(%q) with -forecast-horizon %d = %v after %d calls, want %v after %d", tc.question, tc.horizon, got, *calls, tc.want, tc.calls)
+		}
+	}
+}
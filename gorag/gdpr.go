@@ -0,0 +1,264 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+  gdpr mode answers "where does this person live in our database?".
+  Starting from the subject's row, we walk foreign keys outwards to
+  every table whose rows hang off it, then ask the model for columns
+  that hold the subject's data without a foreign key (emails copied
+  into a log table, and so on). Only counts are run; the result is an
+  export or deletion script for a human to review, never executed.
+*/
+type subjectTable struct {
+	Table     string
+	Condition string
+	Via       string
+	Depth     int
+	Rows      int64
+	Suggested bool
+}
+
+type gdprCandidate struct {
+	Table         string `json:"table"`
+	Column        string `json:"column"`
+	SubjectColumn string `json:"subject_column"`
+	Reason        string `json:"reason"`
+}
+
+func (s *DBMetadata) hasColumn(table, column string) bool {
+	for _, c := range s.Tables[table] {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// foreignKeyGroups puts the columns of each constraint together
+func (s *DBMetadata) foreignKeyGroups() [][]ForeignKey {
+	groups := make(map[string][]ForeignKey)
+	order := make([]string, 0)
+	for _, fk := range s.ForeignKeys {
+		k := fk.Table + "." + fk.Name
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], fk)
+	}
+	out := make([][]ForeignKey, 0, len(order))
+	for _, k := range order {
+		out = append(out, groups[k])
+	}
+	return out
+}
+
+// findSubjectTables walks foreign keys breadth first from the subject's row
+func findSubjectTables(schema *DBMetadata, table, condition string, maxDepth int) []*subjectTable {
+	root := &subjectTable{Table: table, Condition: condition, Via: "subject"}
+	found := map[string]*subjectTable{table: root}
+	out := []*subjectTable{root}
+	level := []*subjectTable{root}
+	for depth := 1; depth <= maxDepth && len(level) > 0; depth++ {
+		next := make(map[string]*subjectTable)
+		nextOrder := make([]string, 0)
+		for _, parent := range level {
+			for _, fk := range schema.foreignKeyGroups() {
+				if fk[0].RefTable != parent.Table || found[fk[0].Table] != nil {
+					continue
+				}
+				cols := make([]string, 0, len(fk))
+				refs := make([]string, 0, len(fk))
+				for _, k := range fk {
+					cols = append(cols, quoteIdent(k.Column))
+					refs = append(refs, quoteIdent(k.RefColumn))
+				}
+				lhs := strings.Join(cols, ", ")
+				if len(cols) > 1 {
+					lhs = "(" + lhs + ")"
+				}
+				cond := fmt.Sprintf("%s IN (SELECT %s FROM %s WHERE %s)",
+					lhs, strings.Join(refs, ", "), quoteIdent(parent.Table), parent.Condition)
+				via := fmt.Sprintf("%s.%s -> %s", fk[0].Table, fk[0].Name, parent.Table)
+				if t, ok := next[fk[0].Table]; ok {
+					t.Condition = "(" + t.Condition + ") OR (" + cond + ")"
+					t.Via += ", " + via
+					continue
+				}
+				next[fk[0].Table] = &subjectTable{Table: fk[0].Table, Condition: cond, Via: via, Depth: depth}
+				nextOrder = append(nextOrder, fk[0].Table)
+			}
+		}
+		level = make([]*subjectTable, 0, len(nextOrder))
+		for _, name := range nextOrder {
+			found[name] = next[name]
+			level = append(level, next[name])
+			out = append(out, next[name])
+		}
+	}
+	return out
+}
+
+// suggestSubjectColumns has the model point out subject data that no foreign key leads to
+func (c *Client) suggestSubjectColumns(table, column string, found []*subjectTable) ([]gdprCandidate, error) {
+	names := make([]string, 0, len(found))
+	for _, t := range found {
+		names = append(names, t.Table)
+	}
+	var out struct {
+		Candidates []gdprCandidate `json:"candidates"`
+	}
+	err := c.llmJSON(fmt.Sprintf(`
+We are handling a GDPR data subject request. The subject is identified by
+%s.%s in this PostgreSQL database:
+
+%s
+
+Foreign keys already lead us to these tables: %s
+
+List other columns that likely hold this subject's personal data without a
+foreign key, eg: an email or user id copied into a log table. For each one,
+give the column of %s whose value it would contain.
+Respond with json:
+{ "candidates": [ { "table": "", "column": "", "subject_column": "", "reason": "" } ] }
+`, table, column, formatSchema(c.Schema), strings.Join(names, ", "), table), &out)
+	if err != nil {
+		return nil, err
+	}
+	valid := make([]gdprCandidate, 0, len(out.Candidates))
+	for _, cand := range out.Candidates {
+		if !c.Schema.hasColumn(cand.Table, cand.Column) || !c.Schema.hasColumn(table, cand.SubjectColumn) {
+			log.Printf("Ignoring suggestion of %s.%s, no such column", cand.Table, cand.Column)
+			continue
+		}
+		valid = append(valid, cand)
+	}
+	return valid, nil
+}
+
+func gdprPlan(kind, table, column, value string, tables []*subjectTable) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("-- GDPR %s plan for %s.%s = %s\n", kind, table, column, sqlLiteral(value)))
+	sb.WriteString(fmt.Sprintf("-- generated %s; nothing has been executed, review before running\n\n",
+		time.Now().UTC().Format(time.RFC3339)))
+
+	ordered := append([]*subjectTable{}, tables...)
+	if kind == "delete" {
+		// children before parents, so foreign keys don't block the deletes
+		sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Depth > ordered[j].Depth })
+		sb.WriteString("BEGIN;\n\n")
+	}
+	for _, t := range ordered {
+		sb.WriteString(fmt.Sprintf("-- %s: %d rows, via %s\n", t.Table, t.Rows, t.Via))
+		if t.Suggested {
+			sb.WriteString("-- suggested by the model, no foreign key; check this one carefully\n")
+		}
+		if kind == "delete" {
+			sb.WriteString(fmt.Sprintf("DELETE FROM %s WHERE %s;\n\n", quoteIdent(t.Table), t.Condition))
+		} else {
+			sb.WriteString(fmt.Sprintf("SELECT * FROM %s WHERE %s;\n\n", quoteIdent(t.Table), t.Condition))
+		}
+	}
+	if kind == "delete" {
+		sb.WriteString("-- change this to COMMIT once the counts above are confirmed\nROLLBACK;\n")
+	}
+	return sb.String()
+}
+
+func runGDPR(args []string) {
+	fs := commandFlags("gdpr")
+	table := fs.String("subject-table", "", "table holding the data subject, eg: customer")
+	column := fs.String("subject-column", "", "column identifying the subject (default: the primary key)")
+	value := fs.String("subject", "", "the subject's identifier value")
+	kind := fs.String("plan", "export", "export or delete")
+	depth := fs.Int("depth", 5, "how many foreign key hops to follow")
+	useLLM := fs.Bool("suggest", true, "ask the model for subject data that has no foreign key")
+	out := fs.String("out", "", "write the plan here instead of stdout")
+	fs.Parse(args)
+	if err := requirePostgres("gorag gdpr"); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if *table == "" || *value == "" {
+		log.Fatalf("-subject-table and -subject are required")
+	}
+	if *kind != "export" && *kind != "delete" {
+		log.Fatalf("-plan must be export or delete")
+	}
+	client, done := setupClient()
+	defer done()
+
+	if _, ok := client.Schema.Tables[*table]; !ok {
+		log.Fatalf("No such table: %s", *table)
+	}
+	if *column == "" {
+		pk := client.Schema.PrimaryKeys[*table]
+		if len(pk) != 1 {
+			log.Fatalf("%s has no single column primary key, give -subject-column", *table)
+		}
+		*column = pk[0]
+	}
+	if !client.Schema.hasColumn(*table, *column) {
+		log.Fatalf("No such column: %s.%s", *table, *column)
+	}
+
+	condition := fmt.Sprintf("%s = %s", quoteIdent(*column), sqlLiteral(*value))
+	tables := findSubjectTables(client.Schema, *table, condition, *depth)
+	if *useLLM {
+		candidates, err := client.suggestSubjectColumns(*table, *column, tables)
+		if err != nil {
+			log.Printf("No suggestions from the model: %v", err)
+		}
+		seen := make(map[string]bool)
+		for _, t := range tables {
+			seen[t.Table] = true
+		}
+		for _, cand := range candidates {
+			if seen[cand.Table] {
+				continue
+			}
+			seen[cand.Table] = true
+			tables = append(tables, &subjectTable{
+				Table: cand.Table,
+				Condition: fmt.Sprintf("%s IN (SELECT %s FROM %s WHERE %s)",
+					quoteIdent(cand.Column), quoteIdent(cand.SubjectColumn), quoteIdent(*table), condition),
+				Via:       cand.Table + "." + cand.Column + ": " + cand.Reason,
+				Depth:     1,
+				Suggested: true,
+			})
+		}
+	}
+
+	for _, t := range tables {
+		q := fmt.Sprintf("SELECT count(*) FROM %s WHERE %s", quoteIdent(t.Table), t.Condition)
+		if err := client.DB.QueryRow(q).Scan(&t.Rows); err != nil {
+			log.Printf("Failed to count %s: %v", t.Table, err)
+			t.Rows = -1
+		}
+		log.Printf("%s: %d rows", t.Table, t.Rows)
+	}
+	client.Audit.Record(AuditEvent{
+		Event:   "gdpr",
+		RunID:   newRunID(),
+		User:    os.Getenv("USER"),
+		Profile: client.Profile,
+		Prompt:  fmt.Sprintf("%s plan for %s.%s", *kind, *table, *column),
+	})
+
+	plan := gdprPlan(*kind, *table, *column, *value, tables)
+	if *out == "" {
+		fmt.Print(plan)
+		return
+	}
+	if err := os.WriteFile(*out, []byte(plan), 0600); err != nil {
+		log.Fatalf("Failed to write plan: %v", err)
+	}
+	log.Printf("Wrote %s plan to %s", *kind, *out)
+}
@@ -0,0 +1,338 @@
+package gorag
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+  github:acme/warehouse/docs@main is the markdown and SQL files under
+  docs in that repository's main branch (the default branch without
+  @, the whole repository without a directory): schema docs, dbt
+  models, ADRs. GITHUB_TOKEN is needed for a private repository, and
+  GITHUB_API_URL points at GitHub Enterprise. A sync reads the files of
+  the commits since the last one, unless it is the first or -full.
+
+  With -ingest-on-push, the server syncs those sources when GitHub
+  says they were pushed to, at POST /webhooks/github. The webhook is
+  signed with GITHUB_WEBHOOK_SECRET, which it must be configured with:
+  content type application/json, push events.
+*/
+var ingestOnPush = Flags.String("ingest-on-push", "", "github:OWNER/REPO sources the server syncs into -docs when pushed to, comma separated")
+
+// ingestExtensions are the files that are documentation
+var ingestExtensions = map[string]bool{".md": true, ".markdown": true, ".mdx": true, ".sql": true}
+
+type githubRepo struct {
+	spec   string
+	owner  string
+	repo   string
+	dir    string
+	ref    string
+	api    string
+	header http.Header
+}
+
+func newGitHub(spec string) (*githubRepo, error) {
+	where, ref, _ := strings.Cut(spec, "@")
+	parts := strings.SplitN(strings.Trim(where, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("github needs a repository, eg: github:acme/warehouse/docs@main")
+	}
+	g := &githubRepo{
+		spec:   spec,
+		owner:  parts[0],
+		repo:   parts[1],
+		ref:    ref,
+		api:    strings.TrimSuffix(os.Getenv("GITHUB_API_URL"), "/"),
+		header: make(http.Header),
+	}
+	if len(parts) == 3 {
+		g.dir = strings.Trim(parts[2], "/")
+	}
+	if g.api == "" {
+		g.api = "https://api.github.com"
+	}
+	g.header.Set("Accept", "application/vnd.github+json")
+	g.header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		g.header.Set("Authorization", "Bearer "+token)
+	}
+	return g, nil
+}
+
+func (g *githubRepo) Source() string {
+	return "github:" + g.spec
+}
+
+func (g *githubRepo) get(p string, out interface{}) error {
+	u := g.api + "/repos/" + url.PathEscape(g.owner) + "/" + url.PathEscape(g.repo) + p
+	found, err := jsonRequest("GET", u, g.header, nil, out)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%s/%s%s is not found, or GITHUB_TOKEN can't see it", g.owner, g.repo, p)
+	}
+	return nil
+}
+
+// wanted is whether a file in the repository is documentation under the directory
+func (g *githubRepo) wanted(file string) bool {
+	if g.dir != "" && !strings.HasPrefix(file, g.dir+"/") {
+		return false
+	}
+	return ingestExtensions[strings.ToLower(path.Ext(file))]
+}
+
+func (g *githubRepo) Crawl(since time.Time, page func(Page) error) error {
+	ref := g.ref
+	if ref == "" {
+		var repo struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		if err := g.get("", &repo); err != nil {
+			return err
+		}
+		ref = repo.DefaultBranch
+	}
+	var head struct {
+		SHA    string `json:"sha"`
+		Commit struct {
+			Committer struct {
+				Date time.Time `json:"date"`
+			} `json:"committer"`
+		} `json:"commit"`
+	}
+	if err := g.get("/commits/"+url.PathEscape(ref), &head); err != nil {
+		return err
+	}
+	// every file is as new as the commit it was read at, which is what the next sync starts from
+	updated := head.Commit.Committer.Date
+	if !since.IsZero() && !updated.After(since) {
+		return nil
+	}
+	files, err := g.changed(head.SHA, since)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		var content struct {
+			Content  string `json:"content"`
+			Encoding string `json:"encoding"`
+			HTMLURL  string `json:"html_url"`
+		}
+		if err := g.get("/contents/"+escapePath(file)+"?ref="+head.SHA, &content); err != nil {
+			return err
+		}
+		// past a megabyte the contents api doesn't send the file
+		if content.Encoding != "base64" {
+			log.Printf("Skipping %s, which is too big", file)
+			continue
+		}
+		text, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(content.Content, "\n", ""))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		p := Page{ID: file, Title: file, URL: content.HTMLURL, Text: string(text), Updated: updated}
+		if err := page(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changed is the files to read at sha: all of them the first time, then those the commits since touched
+func (g *githubRepo) changed(sha string, since time.Time) ([]string, error) {
+	files := make([]string, 0)
+	if since.IsZero() {
+		var tree struct {
+			Tree []struct {
+				Path string `json:"path"`
+				Type string `json:"type"`
+			} `json:"tree"`
+			Truncated bool `json:"truncated"`
+		}
+		if err := g.get("/git/trees/"+sha+"?recursive=1", &tree); err != nil {
+			return nil, err
+		}
+		if tree.Truncated {
+			log.Printf("%s/%s has more files than GitHub lists at once, some are missed: name a directory", g.owner, g.repo)
+		}
+		for _, t := range tree.Tree {
+			if t.Type == "blob" && g.wanted(t.Path) {
+				files = append(files, t.Path)
+			}
+		}
+		return files, nil
+	}
+	seen := make(map[string]bool)
+	for n := 1; ; n++ {
+		q := url.Values{"sha": {sha}, "since": {since.UTC().Format(time.RFC3339)}, "per_page": {"100"}, "page": {fmt.Sprint(n)}}
+		if g.dir != "" {
+			q.Set("path", g.dir)
+		}
+		var commits []struct {
+			SHA string `json:"sha"`
+		}
+		if err := g.get("/commits?"+q.Encode(), &commits); err != nil {
+			return nil, err
+		}
+		for _, c := range commits {
+			var commit struct {
+				Files []struct {
+					Filename string `json:"filename"`
+					Status   string `json:"status"`
+				} `json:"files"`
+			}
+			if err := g.get("/commits/"+c.SHA, &commit); err != nil {
+				return nil, err
+			}
+			for _, f := range commit.Files {
+				if f.Status != "removed" && !seen[f.Filename] && g.wanted(f.Filename) {
+					seen[f.Filename] = true
+					files = append(files, f.Filename)
+				}
+			}
+		}
+		if len(commits) < 100 {
+			return files, nil
+		}
+	}
+}
+
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// pushIngest is what the server syncs on a push
+type pushIngest struct {
+	running sync.Mutex // one sync at a time, since they write the same index
+	mu      sync.Mutex
+	in      *ingester
+	repos   []*githubRepo
+	secret  []byte
+	pending map[*githubRepo]bool // waiting to sync, so another push needn't wait too
+}
+
+func newPushIngest(specs string) (*pushIngest, error) {
+	if specs == "" {
+		return nil, nil
+	}
+	secret := os.Getenv("GITHUB_WEBHOOK_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("-ingest-on-push needs GITHUB_WEBHOOK_SECRET, to know the pushes are from GitHub")
+	}
+	in, err := newIngester()
+	if err != nil {
+		return nil, err
+	}
+	p := &pushIngest{in: in, secret: []byte(secret), pending: make(map[*githubRepo]bool)}
+	for _, spec := range strings.Split(specs, ",") {
+		kind, rest, _ := strings.Cut(strings.TrimSpace(spec), ":")
+		if kind != "github" {
+			return nil, fmt.Errorf("-ingest-on-push only takes github:OWNER/REPO sources, not %s", spec)
+		}
+		g, err := newGitHub(rest)
+		if err != nil {
+			return nil, err
+		}
+		p.repos = append(p.repos, g)
+	}
+	return p, nil
+}
+
+// signed is whether body has the signature GitHub makes with the secret
+func (p *pushIngest) signed(body []byte, signature string) bool {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(want), []byte(signature))
+}
+
+// sync syncs a repository, unless a sync of it is already waiting to start
+func (p *pushIngest) sync(g *githubRepo) {
+	p.mu.Lock()
+	if p.pending[g] {
+		p.mu.Unlock()
+		return
+	}
+	p.pending[g] = true
+	p.mu.Unlock()
+
+	p.running.Lock()
+	defer p.running.Unlock()
+	p.mu.Lock()
+	delete(p.pending, g)
+	p.mu.Unlock()
+	pages, err := p.in.ingest(g, false)
+	if err != nil {
+		log.Printf("Failed to sync %s: %v", g.Source(), err)
+	}
+	log.Printf("Indexed %d pages from %s", pages, g.Source())
+}
+
+func (s *Server) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	p := s.pushIngest
+	if p == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no -ingest-on-push sources"))
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 25<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !p.signed(body, r.Header.Get("X-Hub-Signature-256")) {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("bad signature"))
+		return
+	}
+	if r.Header.Get("X-GitHub-Event") != "push" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var push struct {
+		Ref        string `json:"ref"`
+		Repository struct {
+			FullName      string `json:"full_name"`
+			DefaultBranch string `json:"default_branch"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &push); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	syncing := 0
+	for _, g := range p.repos {
+		branch := g.ref
+		if branch == "" {
+			branch = push.Repository.DefaultBranch
+		}
+		if strings.EqualFold(push.Repository.FullName, g.owner+"/"+g.repo) && push.Ref == "refs/heads/"+branch {
+			go p.sync(g)
+			syncing++
+		}
+	}
+	if syncing == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,399 @@
+package gorag
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+type DBMetadata struct {
+	Tables      map[string][]string     // Map of table names to column lists
+	ForeignKeys []ForeignKey            `json:",omitempty"`
+	PrimaryKeys map[string][]string     `json:",omitempty"`
+	Comments    map[string]string       `json:",omitempty"` // table or table.column -> COMMENT ON text
+	Stats       map[string]*ColumnStats `json:",omitempty"` // table.column
+}
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// pictures the message is about, which each provider sends its own way
+	Images [][]byte `json:"-"`
+	// the tools an assistant message calls, and the call a tool message answers
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+type OpenAIRequest struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Temperature float64   `json:"temperature"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
+	TopP        float64   `json:"top_p,omitempty"`
+	// json_schema or json_object, with -response-format
+	ResponseFormat interface{} `json:"response_format,omitempty"`
+	Tools          []Tool      `json:"tools,omitempty"`
+	// the reply as server-sent events, with its usage at the end
+	Stream        bool                 `json:"stream,omitempty"`
+	StreamOptions *openaiStreamOptions `json:"stream_options,omitempty"`
+}
+
+type OpenAIResponse struct {
+	Choices []struct {
+		Message struct {
+			Content   string     `json:"content"`
+			ToolCalls []ToolCall `json:"tool_calls"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Connect opens the -driver database the way gorag does, so -no-external-calls covers it
+func Connect(dsn string) (*sql.DB, error) {
+	if err := checkDriver(); err != nil {
+		return nil, err
+	}
+	if *dbCredentials != "" {
+		return connectAs(dsn, *dbCredentials)
+	}
+	db, err := dialect().Connect(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+/*
+  We get the schema explicitly so that chatgpt can study it to
+  plan SQL queries. This lets it not only understand questions
+  in terms of tables and columns, but in terms of joins and types.
+ */
+func getSchema(db *sql.DB) (*DBMetadata, error) {
+	query := `
+		SELECT table_name, column_name
+		FROM information_schema.columns
+		WHERE table_schema = 'public'
+		ORDER BY table_name, ordinal_position;
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metadata := DBMetadata{Tables: make(map[string][]string)}
+	var tableName, columnName string
+	for rows.Next() {
+		err := rows.Scan(&tableName, &columnName)
+		if err != nil {
+			return nil, err
+		}
+		metadata.Tables[tableName] = append(metadata.Tables[tableName], columnName)
+	}
+
+	// keys are nice to have, so a failure here is not fatal
+	if metadata.ForeignKeys, err = getForeignKeys(db); err != nil {
+		log.Printf("Failed to retrieve foreign keys: %v", err)
+	}
+	if metadata.PrimaryKeys, err = getPrimaryKeys(db); err != nil {
+		log.Printf("Failed to retrieve primary keys: %v", err)
+	}
+	if metadata.Comments, err = getComments(db); err != nil {
+		log.Printf("Failed to retrieve comments: %v", err)
+	}
+	if metadata.Stats, err = getColumnStats(db); err != nil {
+		log.Printf("Failed to retrieve column statistics: %v", err)
+	}
+	return &metadata, nil
+}
+
+func formatSchema(metadata *DBMetadata) string {
+	var sb strings.Builder
+	for table, columns := range metadata.Tables {
+		sb.WriteString(fmt.Sprintf("Table: %s\nColumns: %s\n", table, strings.Join(columns, ", ")))
+	}
+	return sb.String()
+}
+
+/*
+  If you want to pass in extra metadata to explain things that must be described outside the schema,
+  then put that here. It's basically just an extra bit of system prompting.
+ */
+func loadExtraMetadata(filename string) (map[string]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var extraMetadata map[string]string
+	if err := json.Unmarshal(data, &extraMetadata); err != nil {
+		return nil, err
+	}
+	return extraMetadata, nil
+}
+
+var chatModel = "gpt-4o"
+
+// modelTarget is where one call to a model goes
+type modelTarget struct {
+	CompletionOptions
+	OnCall func(prompt, response string, cached bool) // told each prompt and its answer, for -bundle-dir
+	ctx    context.Context                            // the run's, so a call stops with it; nil for none
+	json   bool                                       // the reply is read as json, so it isn't cached with prose
+}
+
+func (t modelTarget) context() context.Context {
+	if t.ctx == nil {
+		return context.Background()
+	}
+	return t.ctx
+}
+
+type openaiProvider struct{}
+
+// Complete sends the messages to an OpenAI compatible server's chat completions
+func (openaiProvider) Complete(ctx context.Context, messages []Message, opts CompletionOptions) (string, error) {
+	format := openaiResponseFormat(opts)
+	chat := OpenAIRequest{Model: opts.Model, Messages: messages, Temperature: opts.Temperature, MaxTokens: opts.MaxTokens, TopP: opts.TopP, ResponseFormat: format}
+	var request interface{} = chat
+	// pictures make the content a list of parts
+	for _, m := range messages {
+		if len(m.Images) > 0 {
+			request = visionRequestFor(messages, opts)
+			break
+		}
+	}
+	if _, vision := request.(visionRequest); !vision && opts.OnToken != nil && format == nil {
+		return openaiStream(ctx, opts, chat)
+	}
+	body, err := postCompletion(ctx, opts, request)
+	if err != nil {
+		return "", err
+	}
+	var openAIResponse OpenAIResponse
+	if err := json.Unmarshal(body, &openAIResponse); err != nil {
+		return "", err
+	}
+	if opts.OnUsage != nil {
+		opts.OnUsage(openAIResponse.Usage.PromptTokens, openAIResponse.Usage.CompletionTokens)
+	}
+	if len(openAIResponse.Choices) == 0 {
+		if e := openAIResponse.Error; e != nil && format != nil && refuseFormat(opts, e.Message) {
+			return openaiProvider{}.Complete(ctx, messages, opts)
+		}
+		return "", fmt.Errorf("no response from OpenAI")
+	}
+	return openAIResponse.Choices[0].Message.Content, nil
+}
+
+func (openaiProvider) CompleteTools(ctx context.Context, messages []Message, tools []Tool, opts CompletionOptions) (Message, error) {
+	request := OpenAIRequest{Model: opts.Model, Messages: messages, Temperature: opts.Temperature, MaxTokens: opts.MaxTokens, TopP: opts.TopP, Tools: tools}
+	body, err := postCompletion(ctx, opts, request)
+	if err != nil {
+		return Message{}, err
+	}
+	var openAIResponse OpenAIResponse
+	if err := json.Unmarshal(body, &openAIResponse); err != nil {
+		return Message{}, err
+	}
+	if opts.OnUsage != nil {
+		opts.OnUsage(openAIResponse.Usage.PromptTokens, openAIResponse.Usage.CompletionTokens)
+	}
+	if len(openAIResponse.Choices) == 0 {
+		if e := openAIResponse.Error; e != nil {
+			return Message{}, fmt.Errorf("no response from OpenAI: %s", e.Message)
+		}
+		return Message{}, fmt.Errorf("no response from OpenAI")
+	}
+	m := openAIResponse.Choices[0].Message
+	return Message{Role: "assistant", Content: m.Content, ToolCalls: m.ToolCalls}, nil
+}
+
+func (openaiProvider) Embed(ctx context.Context, texts []string, opts CompletionOptions) ([][]float64, error) {
+	return openaiEmbed(ctx, texts, opts)
+}
+
+func (openaiProvider) EmbeddingModel() string {
+	return embeddingModel
+}
+
+func (openaiProvider) Check(ctx context.Context, opts CompletionOptions) error {
+	return checkOpenAI(ctx, opts)
+}
+
+// postCompletion sends a chat completion request, whatever shape of messages it has
+func postCompletion(ctx context.Context, t CompletionOptions, request interface{}) ([]byte, error) {
+	resp, err := openCompletion(ctx, t, request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return body, err
+}
+
+// openCompletion sends the request, leaving the caller to read the response
+func openCompletion(ctx context.Context, t CompletionOptions, request interface{}) (*http.Response, error) {
+	url := strings.TrimRight(t.URL, "/") + "/chat/completions"
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	if t.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.APIKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.TraceParent != "" {
+		req.Header.Set("traceparent", t.TraceParent)
+		if t.TraceState != "" {
+			req.Header.Set("tracestate", t.TraceState)
+		}
+	}
+
+	client := &http.Client{}
+	return client.Do(req)
+}
+
+// callModelText returns just the content of the first choice
+func callModelText(t modelTarget, prompt string) (string, error) {
+	cache := *llmCacheTTL > 0 && t.Temperature == 0
+	key := ""
+	if cache {
+		key = completionKey(t, prompt)
+		if text, ok := completions.get(key); ok {
+			if t.OnCall != nil {
+				t.OnCall(prompt, text, true)
+			}
+			if t.OnToken != nil {
+				t.OnToken(text)
+			}
+			return text, nil
+		}
+	}
+	text, err := completeText(t, prompt)
+	if err != nil {
+		return "", err
+	}
+	if t.OnCall != nil {
+		t.OnCall(prompt, text, false)
+	}
+	if cache {
+		if err := completions.put(key, text); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	return text, nil
+}
+
+// callModelJSON parses the json in the model's reply into out
+func callModelJSON(t modelTarget, prompt string, out interface{}) error {
+	// unless -response-format held it to a schema, the json may be fenced in markdown
+	t.json = true
+	responseContentRaw, err := callModelText(t, prompt)
+	if err != nil {
+		return err
+	}
+	return parseJSONReply(responseContentRaw, out)
+}
+
+// Just assume that the json markdown fence is the only place with curlies
+func findJson(content string) string {
+	if strings.Index(content, "{") > 0 {
+		if strings.LastIndex(content, "}") > 0 {
+			content = content[strings.Index(content, "{") : strings.LastIndex(content, "}")+1]
+		}
+	}
+	return content
+}
+
+// connect to a postgres database
+var user = Flags.String("user", "llama", "user name")
+var password = Flags.String("password", "llama", "password")
+var dbname = Flags.String("dbname", "memory_agent", "database name")
+var host = Flags.String("host", "localhost", "host name")
+var prompt = Flags.String("prompt", "How many rows are in the conversation?", "user's request")
+var schemaCache = Flags.String("schema-cache", "", "load schema json from a file or s3://bucket/key instead of introspecting")
+var dumpSchema = Flags.String("dump-schema", "", "write the introspected schema json to this file and exit")
+var configFile = Flags.String("config", "gorag.json", "deployment config: profiles, deny rules, saved questions, api keys")
+var profileName = Flags.String("profile", "", "profile from the config to run against")
+var saved = Flags.String("saved", "", "run a saved question from the config instead of -prompt")
+var serve = Flags.String("serve", "", "serve the http api on this address, eg: :8080")
+var minGroupMode = Flags.String("min-group-mode", "rewrite", "for tables with min_group_size: rewrite (drop small groups) or reject")
+var purpose = Flags.String("purpose", "", "why you are asking, when the config defines purposes")
+var category = Flags.String("category", "", "the answer template, when the config defines them; empty lets the model pick")
+var override = Flags.String("override", "", "justification for reading data your purpose doesn't allow (audited)")
+var auditLog = Flags.String("audit-log", os.Getenv("GORAG_AUDIT_LOG"), "append audit events as json lines to this file")
+var joinCheck = Flags.String("join-check", "warn", "joins that don't follow a foreign key: warn, block or off")
+var deprecatedCheck = Flags.String("deprecated-check", "warn", "queries using deprecated tables or columns: warn, block or off")
+var maxComplexity = Flags.Int("max-complexity", 0, "decompose generated sql scoring above this (joins, subqueries, window functions) into stages, 0 never does")
+var scratchRows = Flags.Int("scratch-rows", 0, "build the stages of a decomposed query as temp tables of at most N rows, 0 chains them as CTEs")
+var bufferBytes = Flags.Int("buffer-bytes", 16<<20, "result bytes to hold in memory before spilling to a temp file")
+var maxLLMBytes = Flags.Int("max-llm-bytes", 64<<10, "result bytes passed to the model for the summary, 0 for all")
+var resultOut = Flags.String("result-out", "", "write the whole result here, however big")
+var retries = Flags.Int("retries", 1, "times to regenerate a query the database rejects, with the error")
+var judgeModel = Flags.String("judge", "", "a second model to check answers against the rows, eg: gpt-4o-mini")
+var judgeRetries = Flags.Int("judge-retries", 1, "times to rewrite an answer the judge rejects")
+var promptParts = Flags.String("prompt-parts", defaultPromptParts, "optional parts of the sql prompt: "+strings.Join(promptPartNames, ","))
+var pruneTables = Flags.Int("prune", 0, "only send the N tables most relevant to the question (plus their clusters), 0 sends all")
+var opaURL = Flags.String("opa", os.Getenv("GORAG_OPA_URL"), "OPA decision url consulted before executing, eg: http://localhost:8181/v1/data/gorag/decision")
+
+// lambdaMain is set when built with -tags lambda
+var lambdaMain func()
+
+func dsnFromFlags() string {
+	if dsn := os.Getenv("GORAG_DSN"); dsn != "" {
+		return dsn
+	}
+	return dialect().DSN(*user, *password, *dbname, *host)
+}
+
+func postgresDSN(user, password, dbname, host string) string {
+	return fmt.Sprintf(
+		"user=%s password=%s dbname=%s host=%s",
+		user, password, dbname, host,
+	)
+}
+
+// loadSchema prefers the cache when one is configured, and falls back to the database
+func loadSchema(db *sql.DB, cache string) (*DBMetadata, error) {
+	if cache != "" {
+		schema, err := loadSchemaCache(cache)
+		if err == nil {
+			return schema, nil
+		}
+		log.Printf("Schema cache %s unusable, introspecting: %v", cache, err)
+	}
+	return introspect(db)
+}
+
+func loadExtraMetadataOrEmpty(filename string) map[string]string {
+	extraMetadata, err := loadExtraMetadata(filename)
+	if err != nil {
+		fmt.Println("No extra metadata found, continuing without it.")
+		extraMetadata = make(map[string]string)
+	}
+	return extraMetadata
+}
@@ -0,0 +1,269 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+  The foreign keys make a graph of tables. We use it three ways: to
+  draw the schema (gorag schema graph -dot), to tell the model how to
+  get from one table to another with real relationships, and to flag
+  generated joins that don't follow any foreign key, which is usually
+  the model guessing.
+*/
+type fkEdge struct {
+	From       string
+	FromColumn string
+	To         string
+	ToColumn   string
+}
+
+type fkGraph struct {
+	adj map[string][]fkEdge
+}
+
+func newFKGraph(schema *DBMetadata) *fkGraph {
+	g := &fkGraph{adj: make(map[string][]fkEdge)}
+	for _, fk := range schema.ForeignKeys {
+		g.adj[fk.Table] = append(g.adj[fk.Table], fkEdge{fk.Table, fk.Column, fk.RefTable, fk.RefColumn})
+		g.adj[fk.RefTable] = append(g.adj[fk.RefTable], fkEdge{fk.RefTable, fk.RefColumn, fk.Table, fk.Column})
+	}
+	return g
+}
+
+// shortestPath is the fewest joins from a to b, or nil when they aren't connected
+func (g *fkGraph) shortestPath(a, b string) []fkEdge {
+	if a == b {
+		return nil
+	}
+	prev := map[string]fkEdge{}
+	seen := map[string]bool{a: true}
+	queue := []string{a}
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		for _, e := range g.adj[t] {
+			if seen[e.To] {
+				continue
+			}
+			seen[e.To] = true
+			prev[e.To] = e
+			if e.To == b {
+				path := make([]fkEdge, 0)
+				for at := b; at != a; at = prev[at].From {
+					path = append([]fkEdge{prev[at]}, path...)
+				}
+				return path
+			}
+			queue = append(queue, e.To)
+		}
+	}
+	return nil
+}
+
+// related is true when a.colA = b.colB is a foreign key, either way around
+func (g *fkGraph) related(a, colA, b, colB string) bool {
+	for _, e := range g.adj[a] {
+		if e.FromColumn == colA && e.To == b && e.ToColumn == colB {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *fkGraph) dot(schema *DBMetadata) string {
+	tables := make([]string, 0, len(schema.Tables))
+	for t := range schema.Tables {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	var sb strings.Builder
+	sb.WriteString("digraph schema {\n  rankdir=LR;\n  node [shape=box];\n")
+	for _, t := range tables {
+		sb.WriteString(fmt.Sprintf("  %q;\n", t))
+	}
+	for _, fk := range schema.ForeignKeys {
+		sb.WriteString(fmt.Sprintf("  %q -> %q [label=%q];\n", fk.Table, fk.RefTable, fk.Column+" = "+fk.RefColumn))
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func formatJoinPath(path []fkEdge) string {
+	parts := make([]string, 0, len(path))
+	for _, e := range path {
+		parts = append(parts, fmt.Sprintf("%s.%s = %s.%s", e.From, e.FromColumn, e.To, e.ToColumn))
+	}
+	return strings.Join(parts, ", then ")
+}
+
+func formatForeignKeys(schema *DBMetadata) string {
+	var sb strings.Builder
+	for _, fk := range schema.ForeignKeys {
+		sb.WriteString(fmt.Sprintf("%s.%s -> %s.%s\n", fk.Table, fk.Column, fk.RefTable, fk.RefColumn))
+	}
+	return sb.String()
+}
+
+// mentionedTables guesses which tables a question is about from the words in it
+func mentionedTables(schema *DBMetadata, question string) []string {
+	words := make(map[string]bool)
+	for _, w := range regexp.MustCompile(`[a-z0-9_]+`).FindAllString(strings.ToLower(question), -1) {
+		words[w] = true
+		words[strings.TrimSuffix(w, "s")] = true
+	}
+	out := make([]string, 0)
+	for t := range schema.Tables {
+		if words[t] || words[strings.TrimSuffix(t, "s")] {
+			out = append(out, t)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// suggestJoinPaths describes how to join each pair of tables the question mentions
+func suggestJoinPaths(schema *DBMetadata, question string) string {
+	g := newFKGraph(schema)
+	tables := mentionedTables(schema, question)
+	var sb strings.Builder
+	for i := 0; i < len(tables); i++ {
+		for j := i + 1; j < len(tables); j++ {
+			path := g.shortestPath(tables[i], tables[j])
+			if len(path) == 0 || len(path) > 4 {
+				continue
+			}
+			sb.WriteString(fmt.Sprintf("To join %s and %s: %s\n", tables[i], tables[j], formatJoinPath(path)))
+		}
+	}
+	return sb.String()
+}
+
+type joinCondition struct {
+	LeftTable, LeftColumn, RightTable, RightColumn string
+}
+
+// tableAliases maps each alias (and each bare table name) in FROM and JOIN to its table
+func tableAliases(tokens []sqlToken) map[string]string {
+	aliases := make(map[string]string)
+	inFrom := false
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i].upper() {
+		case "FROM", "JOIN":
+			inFrom = true
+		case "WHERE", "ON", "USING", "GROUP", "ORDER", "LIMIT", "HAVING", "SELECT":
+			inFrom = false
+			continue
+		default:
+			if !inFrom || tokens[i].Text != "," {
+				continue
+			}
+		}
+		name, next := qualifiedName(tokens, i+1)
+		if name == "" || isSQLKeyword(strings.ToUpper(name)) {
+			continue
+		}
+		bare := name
+		if k := strings.LastIndex(bare, "."); k >= 0 {
+			bare = bare[k+1:]
+		}
+		aliases[bare] = bare
+		if next < len(tokens) && tokens[next].upper() == "AS" {
+			next++
+		}
+		if next < len(tokens) && tokens[next].ident() != "" && !isSQLKeyword(tokens[next].upper()) {
+			aliases[tokens[next].ident()] = bare
+		}
+	}
+	return aliases
+}
+
+// joinConditions finds a.x = b.y comparisons in ON clauses, with aliases resolved
+func joinConditions(query string) []joinCondition {
+	tokens := lexSQL(query)
+	aliases := tableAliases(tokens)
+	out := make([]joinCondition, 0)
+	inOn := false
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i].upper() {
+		case "ON":
+			inOn = true
+			continue
+		case "JOIN", "WHERE", "GROUP", "ORDER", "LIMIT", "HAVING", "UNION", "SELECT":
+			inOn = false
+		}
+		if !inOn || i+6 >= len(tokens) {
+			continue
+		}
+		t := tokens[i : i+7]
+		if t[1].Text != "." || t[3].Text != "=" || t[5].Text != "." {
+			continue
+		}
+		left, lok := aliases[t[0].ident()]
+		right, rok := aliases[t[4].ident()]
+		if !lok || !rok || left == right {
+			continue
+		}
+		out = append(out, joinCondition{left, t[2].ident(), right, t[6].ident()})
+		i += 6
+	}
+	return out
+}
+
+/*
+  checkJoins looks at each join condition in the query, and complains
+  about the ones no foreign key backs up. Schemas without foreign keys
+  can't be checked, so they are left alone.
+*/
+func (c *Client) checkJoins(query string) error {
+	if c.JoinCheck == "off" || len(c.Schema.ForeignKeys) == 0 {
+		return nil
+	}
+	g := newFKGraph(c.Schema)
+	for _, j := range joinConditions(query) {
+		if g.related(j.LeftTable, j.LeftColumn, j.RightTable, j.RightColumn) {
+			continue
+		}
+		msg := fmt.Sprintf("join %s.%s = %s.%s does not follow a foreign key", j.LeftTable, j.LeftColumn, j.RightTable, j.RightColumn)
+		if path := g.shortestPath(j.LeftTable, j.RightTable); len(path) > 0 {
+			msg += "; the schema relates them by " + formatJoinPath(path)
+		}
+		if c.JoinCheck == "block" {
+			return fmt.Errorf("%s", msg)
+		}
+		log.Printf("Warning: %s", msg)
+	}
+	return nil
+}
+
+func runSchema(args []string) {
+	if len(args) == 0 || args[0] != "graph" {
+		log.Fatalf("usage: gorag schema graph [-dot]")
+	}
+	fs := commandFlags("schema graph")
+	dot := fs.Bool("dot", false, "render the foreign key graph as graphviz dot")
+	fs.Parse(args[1:])
+
+	client, done := setupClient()
+	defer done()
+	g := newFKGraph(client.Schema)
+	if *dot {
+		fmt.Fprint(os.Stdout, g.dot(client.Schema))
+		return
+	}
+	tables := make([]string, 0, len(g.adj))
+	for t := range g.adj {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	for _, t := range tables {
+		for _, e := range g.adj[t] {
+			fmt.Printf("%s.%s -- %s.%s\n", e.From, e.FromColumn, e.To, e.ToColumn)
+		}
+	}
+}
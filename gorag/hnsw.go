@@ -0,0 +1,234 @@
+package gorag
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+/*
+  -vector-store hnsw is a hierarchical navigable small world graph
+  (Malkov and Yashunin): every vector is linked to its nearest
+  neighbours on layer 0, and to fewer, farther ones on the sparser
+  layers above, so a search walks down from the top and only looks at
+  a few hundred vectors however many there are. It is approximate,
+  which only costs much past the efSearch nearest; for a few thousand
+  vectors memory is exact and about as fast. A replaced vector stays
+  in the graph for the links through it, but isn't found any more.
+*/
+const (
+	hnswM              = 16 // links per vector per layer, twice that on layer 0
+	hnswEfConstruction = 100
+	hnswEfSearch       = 64
+)
+
+type hnswNode struct {
+	id      string
+	vector  []float64 // normalized, so cosine distance is 1 - the dot product
+	friends [][]int   // per layer
+	deleted bool
+}
+
+type hnswIndex struct {
+	nodes []*hnswNode
+	byID  map[string]int
+	entry int
+	top   int
+	rng   *rand.Rand
+}
+
+type hnswStore struct {
+	mu          sync.RWMutex
+	collections map[string]*hnswIndex
+}
+
+func newHNSWStore() *hnswStore {
+	return &hnswStore{collections: make(map[string]*hnswIndex)}
+}
+
+func (s *hnswStore) Upsert(collection string, ids []string, vectors [][]float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.collections[collection]
+	if !ok {
+		h = &hnswIndex{byID: make(map[string]int), rng: rand.New(rand.NewSource(1))}
+		s.collections[collection] = h
+	}
+	for i, id := range ids {
+		h.insert(id, vectors[i])
+	}
+	return nil
+}
+
+func (s *hnswStore) Search(collection string, vector []float64, k int) ([]VectorMatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h, ok := s.collections[collection]
+	if !ok {
+		return nil, nil
+	}
+	return h.search(vector, k), nil
+}
+
+func normalize(v []float64) []float64 {
+	var n float64
+	for _, x := range v {
+		n += x * x
+	}
+	out := make([]float64, len(v))
+	if n == 0 {
+		return out
+	}
+	n = math.Sqrt(n)
+	for i, x := range v {
+		out[i] = x / n
+	}
+	return out
+}
+
+func (h *hnswIndex) distance(v []float64, node int) float64 {
+	var dot float64
+	w := h.nodes[node].vector
+	for i := range v {
+		if i >= len(w) {
+			break
+		}
+		dot += v[i] * w[i]
+	}
+	return 1 - dot
+}
+
+type hnswCandidate struct {
+	node int
+	dist float64
+}
+
+type nearestFirst []hnswCandidate
+
+func (q nearestFirst) Len() int            { return len(q) }
+func (q nearestFirst) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q nearestFirst) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *nearestFirst) Push(x interface{}) { *q = append(*q, x.(hnswCandidate)) }
+func (q *nearestFirst) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+type farthestFirst struct{ nearestFirst }
+
+func (q farthestFirst) Less(i, j int) bool { return q.nearestFirst[i].dist > q.nearestFirst[j].dist }
+
+// searchLayer is the ef nearest to v on one layer, nearest first, starting from entry
+func (h *hnswIndex) searchLayer(v []float64, entry []hnswCandidate, ef, layer int) []hnswCandidate {
+	visited := make(map[int]bool)
+	candidates := &nearestFirst{}
+	results := &farthestFirst{}
+	for _, e := range entry {
+		visited[e.node] = true
+		heap.Push(candidates, e)
+		heap.Push(results, e)
+	}
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(hnswCandidate)
+		if results.Len() >= ef && c.dist > results.nearestFirst[0].dist {
+			break
+		}
+		for _, f := range h.nodes[c.node].friends[layer] {
+			if visited[f] {
+				continue
+			}
+			visited[f] = true
+			d := h.distance(v, f)
+			if results.Len() < ef || d < results.nearestFirst[0].dist {
+				heap.Push(candidates, hnswCandidate{f, d})
+				heap.Push(results, hnswCandidate{f, d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+	out := append([]hnswCandidate(nil), results.nearestFirst...)
+	sort.Slice(out, func(i, j int) bool { return out[i].dist < out[j].dist })
+	return out
+}
+
+func (h *hnswIndex) insert(id string, vector []float64) {
+	v := normalize(vector)
+	if old, ok := h.byID[id]; ok {
+		h.nodes[old].deleted = true
+	}
+	// layer l has a 1/M^l share of the vectors
+	level := int(-math.Log(1-h.rng.Float64()) / math.Log(hnswM))
+	n := &hnswNode{id: id, vector: v, friends: make([][]int, level+1)}
+	h.nodes = append(h.nodes, n)
+	i := len(h.nodes) - 1
+	h.byID[id] = i
+	if i == 0 {
+		h.entry, h.top = 0, level
+		return
+	}
+	ep := []hnswCandidate{{h.entry, h.distance(v, h.entry)}}
+	for l := h.top; l > level; l-- {
+		ep = h.searchLayer(v, ep, 1, l)[:1]
+	}
+	for l := min(level, h.top); l >= 0; l-- {
+		found := h.searchLayer(v, ep, hnswEfConstruction, l)
+		neighbours := found
+		if len(neighbours) > hnswM {
+			neighbours = neighbours[:hnswM]
+		}
+		most := hnswM
+		if l == 0 {
+			most = 2 * hnswM
+		}
+		for _, nb := range neighbours {
+			n.friends[l] = append(n.friends[l], nb.node)
+			f := h.nodes[nb.node]
+			f.friends[l] = append(f.friends[l], i)
+			if len(f.friends[l]) > most {
+				h.prune(nb.node, l, most)
+			}
+		}
+		ep = found
+	}
+	if level > h.top {
+		h.entry, h.top = i, level
+	}
+}
+
+// prune keeps a node's nearest links on a layer
+func (h *hnswIndex) prune(node, layer, most int) {
+	v := h.nodes[node].vector
+	friends := h.nodes[node].friends[layer]
+	sort.Slice(friends, func(a, b int) bool { return h.distance(v, friends[a]) < h.distance(v, friends[b]) })
+	h.nodes[node].friends[layer] = friends[:most]
+}
+
+func (h *hnswIndex) search(vector []float64, k int) []VectorMatch {
+	if len(h.nodes) == 0 || k <= 0 {
+		return nil
+	}
+	v := normalize(vector)
+	ep := []hnswCandidate{{h.entry, h.distance(v, h.entry)}}
+	for l := h.top; l > 0; l-- {
+		ep = h.searchLayer(v, ep, 1, l)[:1]
+	}
+	// replaced vectors are still walked through, so ask for enough to have k after them
+	ef := max(k+len(h.nodes)-len(h.byID), hnswEfSearch)
+	out := make([]VectorMatch, 0, k)
+	for _, c := range h.searchLayer(v, ep, ef, 0) {
+		if h.nodes[c.node].deleted {
+			continue
+		}
+		out = append(out, VectorMatch{h.nodes[c.node].id, 1 - c.dist})
+		if len(out) == k {
+			break
+		}
+	}
+	return out
+}
@@ -0,0 +1,200 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+/*
+  Models write names the way they read them in the schema, but
+  without the quotes: CustomerId, which Postgres folds to customerid,
+  and user, which is a function there. After the query is generated,
+  -ident-quoting catalog puts quotes on each table and column name
+  that needs them, spelled as the catalog has it, and fixes the case
+  of quoted names that are spelled wrong; always quotes every table
+  and column name; off leaves the query as the model wrote it. The
+  names that need quotes are also in the prompt, as its quoting part.
+
+  A name is only rewritten when it is one table or column's name,
+  whatever its case, and not where it can't be one: a function call,
+  a type after ::, an alias after AS, or a keyword. Names spelled two
+  ways in the catalog, eg: Status and status, are left to the model.
+*/
+var identQuoting = Flags.String("ident-quoting", "catalog", "how names in generated SQL are quoted: catalog quotes the ones that need it, as the catalog spells them; always quotes every table and column; off leaves them")
+
+// reservedWords can't be bare names in Postgres, as well as the sqlKeywords and syntaxWords
+var reservedWords = map[string]bool{
+	"USER": true, "CHECK": true, "COLUMN": true, "CONSTRAINT": true, "CREATE": true,
+	"FOREIGN": true, "GRANT": true, "PRIMARY": true, "REFERENCES": true, "UNIQUE": true,
+	"ANALYSE": true, "ANALYZE": true, "PLACING": true, "VARIADIC": true, "AUTHORIZATION": true,
+	"COLLATION": true, "CONCURRENTLY": true, "FREEZE": true, "VERBOSE": true, "INITIALLY": true,
+	"DEFERRABLE": true, "SYMMETRIC": true, "ASYMMETRIC": true, "CAST": true,
+}
+
+// syntaxWords mean themselves when bare, even where the catalog has a name like them
+var syntaxWords = map[string]bool{
+	"TO": true, "DO": true, "ONLY": true, "END": true, "ASC": true, "DESC": true, "DISTINCT": true,
+	"NULL": true, "TRUE": true, "FALSE": true, "BOTH": true, "LEADING": true, "TRAILING": true,
+	"CURRENT_DATE": true, "CURRENT_TIME": true, "CURRENT_TIMESTAMP": true, "CURRENT_USER": true,
+	"CURRENT_ROLE": true, "CURRENT_CATALOG": true, "CURRENT_SCHEMA": true, "SESSION_USER": true,
+	"SYSTEM_USER": true, "LOCALTIME": true, "LOCALTIMESTAMP": true, "IS": true, "LIKE": true,
+	"ILIKE": true, "BETWEEN": true, "COLLATE": true, "ISNULL": true, "NOTNULL": true,
+}
+
+// isReserved is whether a bare word can't be a name
+func isReserved(upper string) bool {
+	return isSQLKeyword(upper) || reservedWords[upper] || syntaxWords[upper]
+}
+
+// caseFolds is whether quoted names ignore case too, so only spaces, symbols and keywords need quotes
+func caseFolds() bool {
+	switch *driver {
+	case "mysql", "sqlite", "sqlserver", "duckdb", "bigquery":
+		return true
+	}
+	return false
+}
+
+// needsQuotes is whether the catalog name can't be written bare
+func needsQuotes(name string) bool {
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		return true
+	}
+	if isReserved(strings.ToUpper(name)) {
+		return true
+	}
+	for _, r := range name {
+		if !(r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return true
+		}
+	}
+	return !caseFolds() && name != strings.ToLower(name)
+}
+
+// catalogNames is the spelling of each table and column name by its lower case, for those with only one
+func catalogNames(schema *DBMetadata) map[string]string {
+	spellings := make(map[string]map[string]bool)
+	add := func(name string) {
+		lower := strings.ToLower(name)
+		if spellings[lower] == nil {
+			spellings[lower] = make(map[string]bool)
+		}
+		spellings[lower][name] = true
+	}
+	for table, columns := range schema.Tables {
+		for _, part := range strings.Split(table, ".") {
+			add(part)
+		}
+		for _, col := range columns {
+			add(col)
+		}
+	}
+	names := make(map[string]string)
+	for lower, s := range spellings {
+		if len(s) != 1 {
+			continue
+		}
+		for name := range s {
+			names[lower] = name
+		}
+	}
+	return names
+}
+
+// resolves is whether the token already names the catalog name
+func resolves(t sqlToken, name string) bool {
+	id := t.ident()
+	if caseFolds() {
+		return strings.EqualFold(id, name)
+	}
+	if *driver == "snowflake" {
+		return id == snowflakeName(name)
+	}
+	return id == name
+}
+
+// quoteIdentifiers rewrites the names in query that don't say what the catalog has
+func quoteIdentifiers(query string, schema *DBMetadata, policy string) string {
+	if policy == "off" || schema == nil {
+		return query
+	}
+	names := catalogNames(schema)
+	tokens := lexSQL(query)
+	// aliases and CTE names are the query's own
+	own := make(map[string]bool)
+	for i, t := range tokens {
+		if t.upper() == "AS" {
+			if i+1 < len(tokens) {
+				own[strings.ToLower(tokens[i+1].ident())] = true
+			}
+			if i > 0 && i+1 < len(tokens) && tokens[i+1].Text == "(" {
+				own[strings.ToLower(tokens[i-1].ident())] = true
+			}
+		}
+	}
+
+	var sb strings.Builder
+	last := 0
+	for i, t := range tokens {
+		if t.Kind != sqlWord && t.Kind != sqlQuotedIdent {
+			continue
+		}
+		lower := strings.ToLower(t.ident())
+		name, ok := names[lower]
+		if !ok || own[lower] {
+			continue
+		}
+		if t.Kind == sqlWord {
+			upper := t.upper()
+			followedBy := func(s string) bool { return i+1 < len(tokens) && tokens[i+1].Text == s }
+			cast := i >= 2 && tokens[i-1].Text == ":" && tokens[i-2].Text == ":"
+			if isSQLKeyword(upper) || syntaxWords[upper] || followedBy("(") || cast {
+				continue
+			}
+		}
+		quote := !resolves(t, name) || t.Kind == sqlWord && (policy == "always" || needsQuotes(name))
+		if !quote {
+			continue
+		}
+		sb.WriteString(query[last:t.Pos])
+		sb.WriteString(dialect().QuoteIdentifier(name))
+		last = t.Pos + len(t.Text)
+	}
+	if last == 0 {
+		return query
+	}
+	sb.WriteString(query[last:])
+	return sb.String()
+}
+
+// fixIdentifiers is quoteIdentifiers with the client's schema, saying what it changed
+func (c *Client) fixIdentifiers(query string) string {
+	fixed := quoteIdentifiers(query, c.Schema, *identQuoting)
+	if fixed != query {
+		log.Printf("Quoted names as the catalog has them:\n%s", fixed)
+	}
+	return fixed
+}
+
+// quotingPrompt tells the model which names it has to quote
+func quotingPrompt(schema *DBMetadata) string {
+	quoted := make([]string, 0)
+	for _, name := range catalogNames(schema) {
+		if needsQuotes(name) {
+			quoted = append(quoted, dialect().QuoteIdentifier(name))
+		}
+	}
+	if len(quoted) == 0 {
+		return ""
+	}
+	sort.Strings(quoted)
+	more := ""
+	if len(quoted) > 50 {
+		more = fmt.Sprintf(", and %d more", len(quoted)-50)
+		quoted = quoted[:50]
+	}
+	return fmt.Sprintf("\nThese names have to be quoted, spelled exactly as here: %s%s.\n", strings.Join(quoted, ", "), more)
+}
@@ -0,0 +1,50 @@
+package gorag
+
+import "testing"
+
+func TestQuoteIdentifiers(t *testing.T) {
+	schema := &DBMetadata{Tables: map[string][]string{
+		"Orders":      {"CustomerId", "user", "total", "order"},
+		"public.item": {"name", "Status", "status"},
+	}}
+	cases := []struct {
+		driver string
+		policy string
+		query  string
+		want   string
+	}{
+		// mixed case names get the catalog's spelling, quoted
+		{"postgres", "catalog", `SELECT customerid FROM orders`, `SELECT "CustomerId" FROM "Orders"`},
+		{"postgres", "catalog", `SELECT CUSTOMERID FROM Orders`, `SELECT "CustomerId" FROM "Orders"`},
+		{"postgres", "catalog", `SELECT "customerid" FROM "ORDERS"`, `SELECT "CustomerId" FROM "Orders"`},
+		{"mysql", "catalog", `SELECT customerid FROM orders`, `SELECT customerid FROM orders`},
+
+		// reserved words as names are quoted, and keywords left alone
+		{"postgres", "catalog", `SELECT user, "order" FROM "Orders" ORDER BY total`, `SELECT "user", "order" FROM "Orders" ORDER BY total`},
+		{"postgres", "catalog", `SELECT current_user, count(total) FROM "Orders"`, `SELECT current_user, count(total) FROM "Orders"`},
+		{"mysql", "catalog", "SELECT user FROM orders", "SELECT `user` FROM orders"},
+
+		// names already quoted as the catalog has them stay as they are
+		{"postgres", "catalog", `SELECT "CustomerId", "user" FROM "Orders"`, `SELECT "CustomerId", "user" FROM "Orders"`},
+		{"mysql", "catalog", "SELECT `CustomerId` FROM `Orders`", "SELECT `CustomerId` FROM `Orders`"},
+		{"postgres", "always", `SELECT "total" FROM "Orders"`, `SELECT "total" FROM "Orders"`},
+		{"postgres", "always", `SELECT total FROM public.item`, `SELECT "total" FROM "public"."item"`},
+
+		// strings that spell a name aren't names
+		{"postgres", "catalog", `SELECT total FROM "Orders" WHERE note = 'customerid and user'`, `SELECT total FROM "Orders" WHERE note = 'customerid and user'`},
+		{"postgres", "catalog", `SELECT $$CustomerId$$, total FROM "Orders"`, `SELECT $$CustomerId$$, total FROM "Orders"`},
+
+		// nor are aliases, calls, casts, or names the catalog spells two ways
+		{"postgres", "catalog", `SELECT o.total AS customerid FROM "Orders" o ORDER BY customerid`, `SELECT o.total AS customerid FROM "Orders" o ORDER BY customerid`},
+		{"postgres", "catalog", `SELECT total::name FROM "Orders"`, `SELECT total::name FROM "Orders"`},
+		{"postgres", "catalog", `SELECT status FROM item`, `SELECT status FROM item`},
+
+		{"postgres", "off", `SELECT customerid FROM orders`, `SELECT customerid FROM orders`},
+	}
+	for _, tc := range cases {
+		withDriver(t, tc.driver)
+		if got := quoteIdentifiers(tc.query, schema, tc.policy); got != tc.want {
+			t.Errorf("%s, -ident-quoting %s: quoteIdentifiers(%q)\n got %s\nwant %s", tc.driver, tc.policy, tc.query, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package gorag
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+/*
+  A question can come with a picture: a screenshot of a spreadsheet, a
+  chart, a dashboard. Before anything else, a vision model (-vision-model,
+  the chat model unless that can't see) reads what it shows: the kind
+  of thing it is, the entities, periods and figures. That reading goes
+  into the question, so the query is written to check them and the
+  summary says whether the database agrees, which is what "is this
+  right?" is asking. The image goes to the data model's endpoint,
+  since it is data, and -anonymize can't hide what is in it.
+*/
+var imageFile = Flags.String("image", "", "a screenshot (png, jpeg, gif or webp) the question is about")
+var visionModel = Flags.String("vision-model", "", "the model that reads -image, when the chat model can't")
+
+// maxImageBytes is as big a picture as the vision models take
+const maxImageBytes = 20 << 20
+
+var imageTypes = map[string]bool{"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true}
+
+// imageType is the kind of picture it is, or an error when it isn't one the models read
+func imageType(image []byte) (string, error) {
+	if len(image) > maxImageBytes {
+		return "", fmt.Errorf("the image is over %d bytes", maxImageBytes)
+	}
+	kind := http.DetectContentType(image)
+	if !imageTypes[kind] {
+		return "", fmt.Errorf("the image must be png, jpeg, gif or webp, not %s", kind)
+	}
+	return kind, nil
+}
+
+func readImageFile(name string) ([]byte, error) {
+	image, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	if _, err := imageType(image); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return image, nil
+}
+
+// visionPart is text, or a picture as a data url
+type visionPart struct {
+	Type     string       `json:"type"`
+	Text     string       `json:"text,omitempty"`
+	ImageURL *visionImage `json:"image_url,omitempty"`
+}
+
+type visionImage struct {
+	URL string `json:"url"`
+}
+
+type visionMessage struct {
+	Role    string       `json:"role"`
+	Content []visionPart `json:"content"`
+}
+
+type visionRequest struct {
+	Model       string          `json:"model"`
+	Messages    []visionMessage `json:"messages"`
+	Temperature float64         `json:"temperature"`
+	MaxTokens   int             `json:"max_tokens,omitempty"`
+	TopP        float64         `json:"top_p,omitempty"`
+}
+
+// screenshotPrompt is what the vision model is asked about the picture
+const screenshotPrompt = `
+This picture was attached to a question about a database. Read it so the question
+can be checked against the database. Say what kind of thing it is (a chart,
+spreadsheet, dashboard, report), what it is about, then list every entity
+(customers, products, regions, ids), time period and metric it names, with the
+figures shown for each, exactly as shown. Only say what is in the picture.
+
+The question was: %s
+`
+
+// readScreenshot is what the vision model sees in the question's image
+func (c *Client) readScreenshot(q *Question) (string, error) {
+	kind, err := imageType(q.Image)
+	if err != nil {
+		return "", err
+	}
+	if *llmProvider == "mock" {
+		return "Mock screenshot: a " + kind + " of " + fmt.Sprint(len(q.Image)) + " bytes", nil
+	}
+	t, err := c.budgeted(c.target("data", *visionModel))
+	if err != nil {
+		return "", err
+	}
+	// schema names in the question are hidden from this model too, and put back in what it reads
+	text := c.Pseudonyms.hide(fmt.Sprintf(screenshotPrompt, q.Prompt))
+	screenshot, err := completeText(t, text, q.Image)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the image: %v", err)
+	}
+	if t.OnCall != nil {
+		t.OnCall(text, screenshot, false)
+	}
+	return strings.TrimSpace(c.Pseudonyms.reveal(screenshot)), nil
+}
+
+// visionRequestFor is an OpenAI chat request where each message's content is its text and pictures, as data urls
+func visionRequestFor(messages []Message, opts CompletionOptions) visionRequest {
+	req := visionRequest{Model: opts.Model, Temperature: opts.Temperature, MaxTokens: opts.MaxTokens, TopP: opts.TopP}
+	for _, m := range messages {
+		parts := []visionPart{{Type: "text", Text: m.Content}}
+		for _, image := range m.Images {
+			// imageType was checked when the picture came in
+			kind, _ := imageType(image)
+			parts = append(parts, visionPart{Type: "image_url", ImageURL: &visionImage{"data:" + kind + ";base64," + base64.StdEncoding.EncodeToString(image)}})
+		}
+		req.Messages = append(req.Messages, visionMessage{Role: m.Role, Content: parts})
+	}
+	return req
+}
+
+// withScreenshot is the question, with what its picture shows
+func withScreenshot(prompt, screenshot string) string {
+	return fmt.Sprintf(`%s
+
+The user attached a picture, which shows:
+%s
+Check what it shows against the database: where the question asks whether it is
+right, compare its figures with the rows, and say which agree and which don't.`, prompt, screenshot)
+}
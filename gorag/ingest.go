@@ -0,0 +1,313 @@
+package gorag
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+  gorag ingest copies pages from where the documentation is written
+  into the -docs index, so the docs prompt part can find them:
+
+    gorag ingest -docs elasticsearch:http://localhost:9200/wiki \
+      confluence:https://acme.atlassian.net/wiki/ENG notion:DATABASE_ID
+
+  A page is cut into chunks of at most -chunk-bytes, on line breaks,
+  and each chunk is a document with the page's title and link, embedded
+  into -docs-knn-field when there is one. Each run asks a source only
+  for the pages changed since the newest one the index has from it, and
+  replaces their chunks. Deleted pages stay until a -full run, which
+  reads everything and then drops what it didn't see. -every keeps
+  syncing, for a long running ingester.
+*/
+var chunkBytes = Flags.Int("chunk-bytes", 1500, "of page text in each ingested document")
+
+// Page is a page of documentation as a connector read it, as text
+type Page struct {
+	ID      string
+	Title   string
+	URL     string
+	Text    string
+	Updated time.Time
+}
+
+type Connector interface {
+	// Source names where the pages come from, eg: confluence:ENG
+	Source() string
+	// Crawl calls page for every page changed since then, or every page when since is zero
+	Crawl(since time.Time, page func(Page) error) error
+}
+
+func parseConnector(spec string) (Connector, error) {
+	kind, rest, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "confluence":
+		return newConfluence(rest)
+	case "notion":
+		return newNotion(rest)
+	case "github":
+		return newGitHub(rest)
+	}
+	return nil, fmt.Errorf("a source must be confluence:URL/SPACE, notion:DATABASE_ID or github:OWNER/REPO, not %s", spec)
+}
+
+// chunkText cuts text into pieces of at most max bytes, between lines when it can
+func chunkText(text string, max int) []string {
+	chunks := make([]string, 0)
+	var sb strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(sb.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		sb.Reset()
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if sb.Len() > 0 && sb.Len()+1+len(line) > max {
+			flush()
+		}
+		for len(line) > max {
+			cut := max
+			for cut > 1 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			sb.WriteString(line[:cut])
+			flush()
+			line = line[cut:]
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(line)
+	}
+	flush()
+	return chunks
+}
+
+type ingester struct {
+	index      *searchIndex
+	apiKey     string
+	chunkBytes int
+	created    bool
+}
+
+// newIngester writes to the -docs index
+func newIngester() (*ingester, error) {
+	if *chunkBytes <= 0 {
+		return nil, fmt.Errorf("-chunk-bytes must be positive")
+	}
+	r, err := parseRetriever(*docsSpec)
+	if err != nil {
+		return nil, err
+	}
+	index, ok := r.(*searchIndex)
+	if !ok {
+		return nil, fmt.Errorf("ingesting needs -docs, the index to write")
+	}
+	return &ingester{index: index, apiKey: os.Getenv("OPENAI_API_KEY"), chunkBytes: *chunkBytes}, nil
+}
+
+// ingest syncs one source into the index, and says how many pages it wrote
+func (in *ingester) ingest(c Connector, full bool) (int, error) {
+	var since time.Time
+	if !full {
+		newest, err := in.index.newest(c.Source())
+		if err != nil {
+			return 0, err
+		}
+		since = newest
+	}
+	// chunks written before this run, of the pages it writes, are stale
+	started := time.Now().UTC().Truncate(time.Millisecond)
+	pages := 0
+	err := c.Crawl(since, func(p Page) error {
+		if err := in.write(c.Source(), p, started); err != nil {
+			return fmt.Errorf("failed to index %s: %v", p.URL, err)
+		}
+		pages++
+		return nil
+	})
+	if err != nil {
+		return pages, err
+	}
+	if full {
+		if err := in.index.deleteStale(map[string]interface{}{"source": c.Source()}, started); err != nil {
+			return pages, err
+		}
+	}
+	return pages, nil
+}
+
+func (in *ingester) write(source string, p Page, synced time.Time) error {
+	chunks := chunkText(p.Text, in.chunkBytes)
+	var vectors [][]float64
+	if in.index.knnField != "" && len(chunks) > 0 {
+		texts := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			texts[i] = p.Title + "\n" + chunk
+		}
+		var err error
+		if vectors, err = embedTexts(context.Background(), in.apiKey, texts); err != nil {
+			return fmt.Errorf("failed to embed: %v", err)
+		}
+	}
+	if !in.created && len(chunks) > 0 {
+		dims := 0
+		if vectors != nil {
+			dims = len(vectors[0])
+		}
+		if err := in.index.create(dims); err != nil {
+			return err
+		}
+		in.created = true
+	}
+	page := source + ":" + p.ID
+	for i, chunk := range chunks {
+		doc := map[string]interface{}{
+			"url":     p.URL,
+			"source":  source,
+			"page":    page,
+			"updated": p.Updated.UTC().Format(time.RFC3339),
+			"synced":  synced.Format(time.RFC3339Nano),
+		}
+		if len(in.index.fields) > 1 {
+			doc[in.index.fields[0]] = p.Title
+			doc[in.index.fields[len(in.index.fields)-1]] = chunk
+		} else {
+			doc[in.index.fields[0]] = p.Title + "\n" + chunk
+		}
+		if vectors != nil {
+			doc[in.index.knnField] = vectors[i]
+		}
+		if err := in.index.put(fmt.Sprintf("%s:%d", page, i), doc); err != nil {
+			return err
+		}
+	}
+	return in.index.deleteStale(map[string]interface{}{"page": page}, synced)
+}
+
+func (s *searchIndex) indexURL() string {
+	return s.url + "/" + url.PathEscape(s.index)
+}
+
+// create makes the index when it isn't there, with a vector field of dims when there is one
+func (s *searchIndex) create(dims int) error {
+	found, err := jsonRequest("GET", s.indexURL(), s.header(), nil, nil)
+	if err != nil || found {
+		return err
+	}
+	properties := map[string]interface{}{
+		"url":     map[string]interface{}{"type": "keyword"},
+		"source":  map[string]interface{}{"type": "keyword"},
+		"page":    map[string]interface{}{"type": "keyword"},
+		"updated": map[string]interface{}{"type": "date"},
+		"synced":  map[string]interface{}{"type": "date"},
+	}
+	for _, f := range s.fields {
+		properties[f] = map[string]interface{}{"type": "text"}
+	}
+	body := map[string]interface{}{"mappings": map[string]interface{}{"properties": properties}}
+	if dims > 0 {
+		if s.openSearch {
+			properties[s.knnField] = map[string]interface{}{"type": "knn_vector", "dimension": dims}
+			body["settings"] = map[string]interface{}{"index": map[string]interface{}{"knn": true}}
+		} else {
+			properties[s.knnField] = map[string]interface{}{"type": "dense_vector", "dims": dims, "index": true, "similarity": "cosine"}
+		}
+	}
+	if _, err := jsonRequest("PUT", s.indexURL(), s.header(), body, nil); err != nil {
+		return fmt.Errorf("failed to create %s: %v", s.index, err)
+	}
+	log.Printf("Created %s", s.index)
+	return nil
+}
+
+func (s *searchIndex) put(id string, doc map[string]interface{}) error {
+	_, err := jsonRequest("PUT", s.indexURL()+"/_doc/"+url.PathEscape(id), s.header(), doc, nil)
+	return err
+}
+
+// newest is when the newest page from source there is was updated, zero for none
+func (s *searchIndex) newest(source string) (time.Time, error) {
+	var out struct {
+		Aggregations struct {
+			Newest struct {
+				Value *float64 `json:"value"`
+			} `json:"newest"`
+		} `json:"aggregations"`
+	}
+	q := map[string]interface{}{
+		"size":  0,
+		"query": map[string]interface{}{"term": map[string]interface{}{"source": source}},
+		"aggs":  map[string]interface{}{"newest": map[string]interface{}{"max": map[string]interface{}{"field": "updated"}}},
+	}
+	found, err := jsonRequest("POST", s.indexURL()+"/_search", s.header(), q, &out)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to find the newest page from %s: %v", source, err)
+	}
+	if !found || out.Aggregations.Newest.Value == nil {
+		return time.Time{}, nil
+	}
+	return time.UnixMilli(int64(*out.Aggregations.Newest.Value)).UTC(), nil
+}
+
+// deleteStale deletes the documents matching term that were synced before then
+func (s *searchIndex) deleteStale(term map[string]interface{}, before time.Time) error {
+	q := map[string]interface{}{
+		"query": map[string]interface{}{"bool": map[string]interface{}{"filter": []interface{}{
+			map[string]interface{}{"term": term},
+			map[string]interface{}{"range": map[string]interface{}{"synced": map[string]interface{}{"lt": before.Format(time.RFC3339Nano)}}},
+		}}},
+	}
+	if _, err := jsonRequest("POST", s.indexURL()+"/_delete_by_query?refresh=true", s.header(), q, nil); err != nil {
+		return fmt.Errorf("failed to delete stale chunks: %v", err)
+	}
+	return nil
+}
+
+func runIngest(args []string) {
+	fs := commandFlags("ingest")
+	full := fs.Bool("full", false, "read every page, and drop the ones that are gone")
+	every := fs.Duration("every", 0, "sync again at this interval, eg: 1h; 0 syncs once")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatalf("usage: gorag ingest -docs elasticsearch:URL/index [-full] [-every 1h] confluence:URL/SPACE|notion:DATABASE_ID|github:OWNER/REPO ...")
+	}
+
+	enforceNoExternalCalls()
+	in, err := newIngester()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	connectors := make([]Connector, 0, fs.NArg())
+	for _, spec := range fs.Args() {
+		c, err := parseConnector(spec)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		connectors = append(connectors, c)
+	}
+	for {
+		failed := false
+		for _, c := range connectors {
+			pages, err := in.ingest(c, *full)
+			if err != nil {
+				log.Printf("Failed to sync %s: %v", c.Source(), err)
+				failed = true
+			}
+			log.Printf("Indexed %d pages from %s", pages, c.Source())
+		}
+		if *every == 0 {
+			if failed {
+				os.Exit(1)
+			}
+			return
+		}
+		time.Sleep(*every)
+	}
+}
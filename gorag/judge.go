@@ -0,0 +1,82 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+/*
+  With -judge <model>, a second model checks every summary against
+  the rows it was written from, because the model that wrote it is
+  the worst placed to notice what it made up. If the judge finds
+  claims the rows don't support, the summary is written again with
+  the judge's complaints, up to -judge-retries times. The verdicts
+  are in the answer, so a monitor can watch how often it happens.
+*/
+type JudgeVerdict struct {
+	Model       string   `json:"model"`
+	Supported   bool     `json:"supported"`
+	Unsupported []string `json:"unsupported_claims,omitempty"`
+	Reason      string   `json:"reason,omitempty"`
+	// how many times the summary was rewritten before this verdict
+	Rewrites int `json:"rewrites"`
+}
+
+func (c *Client) judge(userInput, resultStr, summary string) (*JudgeVerdict, error) {
+	var v JudgeVerdict
+	err := c.completeJSON("data", c.JudgeModel, fmt.Sprintf(`
+You are checking an answer that another model wrote from database query results.
+
+The question was: %s
+
+The rows the answer was written from are:
+
+%s
+
+The answer is:
+
+%s
+
+Find every claim in the answer that the rows do not support: numbers that are
+not in them or computed wrongly, names that are not there, trends or causes
+that the rows don't show. Saying the data is incomplete is fine.
+http response must be application/json:
+{ "supported": true or false, "unsupported_claims": ["..."], "reason": "..." }
+`, userInput, resultStr, summary), &v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to judge answer: %v", err)
+	}
+	v.Model = c.JudgeModel
+	return &v, nil
+}
+
+// judgedSummary summarizes, then rewrites the summary until the judge is satisfied or we run out of tries
+func (c *Client) judgedSummary(userInput, resultStr string, t *AnswerTemplate) (string, *JudgeVerdict, error) {
+	summary, err := c.summarize(userInput, resultStr, t)
+	if err != nil || c.JudgeModel == "" {
+		return summary, nil, err
+	}
+	for rewrites := 0; ; rewrites++ {
+		v, err := c.judge(userInput, resultStr, summary)
+		if err != nil {
+			log.Printf("%v", err)
+			return summary, nil, nil
+		}
+		v.Rewrites = rewrites
+		if v.Supported || rewrites >= c.JudgeRetries {
+			if !v.Supported {
+				log.Printf("Judge %s still finds unsupported claims: %s", v.Model, strings.Join(v.Unsupported, "; "))
+			}
+			return summary, v, nil
+		}
+		log.Printf("Judge %s found unsupported claims, rewriting: %s", v.Model, strings.Join(v.Unsupported, "; "))
+		feedback := fmt.Sprintf("%s\n\nA reviewer rejected this answer:\n\n%s\n\nbecause these claims are not supported by the rows:\n\n%s\n\nWrite the answer again, using only what the rows show.\n",
+			c.summaryPrompt(userInput, resultStr, t), summary, strings.Join(v.Unsupported, "\n"))
+		rewritten, err := c.dataText(feedback)
+		if err != nil {
+			return summary, v, fmt.Errorf("failed to summarize: %v", err)
+		}
+		summary = rewritten
+	}
+}
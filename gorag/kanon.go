@@ -0,0 +1,261 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+/*
+  Tables with a min_group_size must only ever be seen in aggregate,
+  and never in groups small enough to pick out a person (eg: salary
+  by a filter that isolates one employee). We enforce that on the
+  outermost SELECT:
+
+  - row level results from a protected table are refused outright
+  - aggregates get HAVING count(DISTINCT <primary key>) >= k, for each
+    protected table in the outermost FROM, so small groups just vanish;
+    count(*) would let a join or generate_series make one person's row
+    into k of them
+  - a protected table with no primary key keeps count(*), and then the
+    FROM must be that table alone, with no set-returning functions
+  - a protected table anywhere but the outermost FROM (a subquery, a
+    CTE, a parenthesized join) is refused, since its groups aren't the
+    ones HAVING counts
+  - in reject mode we instead look for small groups first, and refuse
+    the query when there are any
+*/
+
+/*
+  minGroupSize is the largest k of any protected table the query
+  touches. When its tables can't all be told, and some table has a
+  min_group_size, the one it can't tell could be that table, so that
+  is an error.
+*/
+func (c *Client) minGroupSize(query string) (int, string, error) {
+	if c.Config == nil {
+		return 0, "", nil
+	}
+	tables, err := resolveTables(query)
+	if err != nil {
+		c.Config.mu.RLock()
+		defer c.Config.mu.RUnlock()
+		for name, tc := range c.Config.Tables {
+			if tc != nil && tc.MinGroupSize > 1 {
+				return 0, "", fmt.Errorf("table %s has a min_group_size, and %v", name, err)
+			}
+		}
+		return 0, "", nil
+	}
+	k := 0
+	table := ""
+	for _, t := range tables {
+		if tc := c.Config.tableConfig(t); tc != nil && tc.MinGroupSize > k {
+			k = tc.MinGroupSize
+			table = t
+		}
+	}
+	return k, table, nil
+}
+
+// setReturning are the functions that can make more rows out of one
+var setReturning = map[string]bool{
+	"generate_series": true, "generate_subscripts": true, "unnest": true,
+	"json_array_elements": true, "json_array_elements_text": true, "jsonb_array_elements": true,
+	"jsonb_array_elements_text": true, "json_each": true, "json_each_text": true, "jsonb_each": true,
+	"jsonb_each_text": true, "json_object_keys": true, "jsonb_object_keys": true,
+	"json_to_recordset": true, "jsonb_to_recordset": true, "json_populate_recordset": true,
+	"jsonb_populate_recordset": true, "jsonb_path_query": true, "regexp_matches": true,
+	"regexp_split_to_table": true, "string_to_table": true,
+}
+
+// primaryKey is the table's primary key columns, if the schema has them
+func (c *Client) primaryKey(table string) []string {
+	if c.Schema == nil {
+		return nil
+	}
+	if key := c.Schema.PrimaryKeys[table]; len(key) > 0 {
+		return key
+	}
+	if _, bare, ok := strings.Cut(table, "."); ok {
+		return c.Schema.PrimaryKeys[bare]
+	}
+	return nil
+}
+
+// isProtected is whether the table has a min_group_size
+func (c *Client) isProtected(table string) bool {
+	tc := c.Config.tableConfig(table)
+	return tc != nil && tc.MinGroupSize > 1
+}
+
+/*
+  groupCounts are what HAVING compares with k: count(DISTINCT key) of
+  each protected table in the outermost FROM, or count(*) when their
+  rows can't be multiplied. An error says why neither can be trusted.
+*/
+func (c *Client) groupCounts(query string, shape *selectShape) ([]string, error) {
+	tokens := shape.Tokens
+	from, _, err := fromItems(tokens, shape.Select)
+	if err != nil {
+		return nil, err
+	}
+	// a parenthesized join's tables are out of sight of the key, behind the join's alias
+	items := make([]tableRef, 0, len(from))
+	outer := make(map[string]bool)
+	for _, item := range from {
+		if !item.Parens {
+			items = append(items, item)
+			outer[item.Table] = true
+		}
+	}
+	for _, table := range referencedTables(query) {
+		if c.isProtected(table) && !outer[table] {
+			return nil, fmt.Errorf("table %s has a min_group_size, and may only be read in the outermost FROM", table)
+		}
+	}
+	for i := 0; i+1 < len(tokens); i++ {
+		if kw := tokens[i+1].upper(); tokens[i].Text != "(" || kw != "SELECT" && kw != "WITH" && kw != "TABLE" {
+			continue
+		}
+		// a subquery or CTE reading a protected table isn't counted by the outer HAVING
+		end := shape.offsetAt(matchingParen(tokens, i))
+		for _, table := range referencedTables(query[tokens[i+1].Pos:end]) {
+			if c.isProtected(table) {
+				return nil, fmt.Errorf("table %s has a min_group_size, and may only be read in the outermost FROM, not a subquery", table)
+			}
+		}
+	}
+
+	counts := make([]string, 0)
+	for _, item := range items {
+		if !c.isProtected(item.Table) {
+			continue
+		}
+		key := c.primaryKey(item.Table)
+		if len(key) == 0 {
+			if multiplies := rowMultiplier(shape); multiplies != "" {
+				return nil, fmt.Errorf("table %s has a min_group_size and no primary key, so its groups can't be counted through %s", item.Table, multiplies)
+			}
+			return []string{"count(*)"}, nil
+		}
+		// the key is qualified, since a joined table may have columns of the same name
+		parts := strings.Split(item.Table, ".")
+		if item.Alias != "" {
+			parts = []string{item.Alias}
+		}
+		for i := range parts {
+			parts[i] = quoteIdent(parts[i])
+		}
+		columns := make([]string, len(key))
+		for i, col := range key {
+			columns[i] = strings.Join(parts, ".") + "." + quoteIdent(col)
+		}
+		if len(columns) == 1 {
+			counts = append(counts, "count(DISTINCT "+columns[0]+")")
+		} else {
+			counts = append(counts, "count(DISTINCT ("+strings.Join(columns, ", ")+"))")
+		}
+	}
+	return counts, nil
+}
+
+// rowMultiplier is what can make more rows than the one protected table has, or ""
+func rowMultiplier(shape *selectShape) string {
+	tokens := shape.Tokens
+	for i, t := range tokens {
+		if i+1 < len(tokens) && tokens[i+1].Text == "(" && setReturning[t.ident()] {
+			return t.Text
+		}
+	}
+	if shape.From < 0 {
+		return ""
+	}
+	end := shape.Tail
+	for _, at := range []int{shape.Where, shape.GroupBy, shape.Having} {
+		if at > shape.From && at < end {
+			end = at
+		}
+	}
+	// FROM must be the table and maybe an alias, and nothing more
+	_, next := qualifiedName(tokens, shape.From+1)
+	if next < end && tokens[next].upper() == "AS" {
+		next++
+	}
+	if next < end && (tokens[next].Kind == sqlQuotedIdent || tokens[next].Kind == sqlWord && !isSQLKeyword(tokens[next].upper())) {
+		next++
+	}
+	if next < end {
+		return tokens[next].Text
+	}
+	return ""
+}
+
+// withGroupCondition adds the condition to the outermost HAVING
+func withGroupCondition(query string, shape *selectShape, condition string) string {
+	tail := shape.offsetAt(shape.Tail)
+	end := shape.offsetAt(len(shape.Tokens))
+	head := strings.TrimSpace(query[:tail])
+	rest := strings.TrimSpace(query[tail:end])
+	if shape.Having >= 0 {
+		at := shape.offsetAt(shape.Having + 1)
+		head = query[:at] + condition + " AND (" + strings.TrimSpace(query[at:tail]) + ")"
+	} else {
+		head += " HAVING " + condition
+	}
+	return strings.TrimSpace(head + " " + rest)
+}
+
+func (c *Client) enforceMinGroupSize(query string) (string, error) {
+	k, table, err := c.minGroupSize(query)
+	if err != nil {
+		return "", err
+	}
+	if k <= 1 {
+		return query, nil
+	}
+	if err := requirePostgres(fmt.Sprintf("table %s's min_group_size", table)); err != nil {
+		return "", err
+	}
+	shape, err := analyzeSelect(query)
+	if err != nil {
+		return "", fmt.Errorf("table %s requires groups of at least %d, and the query can't be checked: %v", table, k, err)
+	}
+	if shape.SetOp {
+		return "", fmt.Errorf("table %s requires groups of at least %d, which can't be enforced across UNION/INTERSECT/EXCEPT", table, k)
+	}
+	if !shape.Aggregate {
+		return "", fmt.Errorf("table %s may only be queried in aggregate (groups of at least %d rows)", table, k)
+	}
+	counts, err := c.groupCounts(query, shape)
+	if err != nil {
+		return "", err
+	}
+
+	if c.MinGroupMode == "reject" {
+		if c.DryRun {
+			log.Printf("Not checking groups of at least %d for %s, in a dry run", k, table)
+			return query, nil
+		}
+		under := make([]string, len(counts))
+		for i, count := range counts {
+			under[i] = fmt.Sprintf("%s < %d", count, k)
+		}
+		probe := "SELECT count(*) FROM (" + withGroupCondition(query, shape, "("+strings.Join(under, " OR ")+")") + ") AS small_groups"
+		var small int
+		if err := c.reader().QueryRow(c.annotate(probe)).Scan(&small); err != nil {
+			return "", fmt.Errorf("failed to check group sizes: %v", err)
+		}
+		if small > 0 {
+			return "", fmt.Errorf("query would expose %d groups smaller than %d from %s", small, k, table)
+		}
+		return query, nil
+	}
+	large := make([]string, len(counts))
+	for i, count := range counts {
+		large[i] = fmt.Sprintf("%s >= %d", count, k)
+	}
+	rewritten := withGroupCondition(query, shape, strings.Join(large, " AND "))
+	log.Printf("Enforcing groups of at least %d for %s", k, table)
+	return rewritten, nil
+}
@@ -0,0 +1,82 @@
+package gorag
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// kanonClient has salaries (keyed by id) and badges (no key) in groups of at least 5
+func kanonClient(t *testing.T) *Client {
+	t.Helper()
+	withDriver(t, "postgres")
+	config := newConfig("")
+	err := json.Unmarshal([]byte(`{"tables": {
+		"salaries": {"min_group_size": 5},
+		"badges": {"min_group_size": 5},
+		"visits": {"min_group_size": 5}
+	}}`), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := &DBMetadata{PrimaryKeys: map[string][]string{
+		"salaries": {"id"},
+		"visits":   {"person", "day"},
+	}}
+	return &Client{Config: config, Schema: schema}
+}
+
+func TestEnforceMinGroupSize(t *testing.T) {
+	c := kanonClient(t)
+	cases := []struct {
+		query string
+		want  string // the query that runs, or the error
+	}{
+		{`SELECT dept, avg(salary) FROM salaries GROUP BY dept`, `SELECT dept, avg(salary) FROM salaries GROUP BY dept HAVING count(DISTINCT salaries.id) >= 5`},
+		{`SELECT count(*) FROM orders`, `SELECT count(*) FROM orders`},
+		{`SELECT max(s.salary) FROM salaries s HAVING max(s.salary) > 1`, `SELECT max(s.salary) FROM salaries s HAVING count(DISTINCT s.id) >= 5 AND (max(s.salary) > 1)`},
+		{`SELECT count(*) FROM visits`, `HAVING count(DISTINCT (visits.person, visits.day)) >= 5`},
+
+		// multiplying rows doesn't make the distinct keys any more
+		{`SELECT max(salary) FROM salaries, generate_series(1,10) WHERE id = 5`, `HAVING count(DISTINCT salaries.id) >= 5`},
+		{`SELECT d.name, max(s.salary) FROM salaries s JOIN depts d ON d.id = s.dept GROUP BY d.name`, `HAVING count(DISTINCT s.id) >= 5`},
+		{`SELECT count(*) FROM salaries a JOIN salaries b ON true`, `HAVING count(DISTINCT a.id) >= 5 AND count(DISTINCT b.id) >= 5`},
+
+		// without a key, count(*) holds only when nothing can multiply the rows
+		{`SELECT kind, count(*) FROM badges b GROUP BY kind`, `SELECT kind, count(*) FROM badges b GROUP BY kind HAVING count(*) >= 5`},
+		{`SELECT max(level) FROM badges, generate_series(1,10)`, "can't be counted through generate_series"},
+		{`SELECT max(level) FROM badges, orders`, "can't be counted through ,"},
+		{`SELECT max(level) FROM badges JOIN orders ON true`, "can't be counted through JOIN"},
+		{`SELECT max(level) FROM badges CROSS JOIN LATERAL (SELECT 1) x`, "can't be counted through CROSS"},
+		{`SELECT max(x) FROM badges, unnest(array[1,2]) x`, "can't be counted through unnest"},
+		{`SELECT max(level) FROM badges WHERE level IN (SELECT * FROM generate_series(1,10))`, "can't be counted through generate_series"},
+
+		// anywhere but the outermost FROM, HAVING doesn't count it
+		{`SELECT count(*) FROM (SELECT * FROM salaries) t`, "only be read in the outermost FROM"},
+		{`WITH t AS (SELECT * FROM salaries) SELECT count(*) FROM t`, "only be read in the outermost FROM"},
+		{`SELECT count(*) FROM (salaries JOIN depts ON true)`, "only be read in the outermost FROM"},
+		{`SELECT count(*) FROM salaries WHERE id IN (SELECT id FROM salaries WHERE id = 5)`, "not a subquery"},
+		{`SELECT count(*), (SELECT max(salary) FROM salaries) FROM orders`, "only be read in the outermost FROM"},
+
+		{`SELECT salary FROM salaries`, "may only be queried in aggregate"},
+
+		// however the FROM list names the table
+		{`WITH salaries AS (SELECT * FROM salaries) SELECT * FROM salaries`, "may only be queried in aggregate"},
+		{`WITH salaries AS (SELECT * FROM salaries) SELECT max(salary) FROM salaries`, "only be read in the outermost FROM"},
+		{`SELECT * FROM generate_series(1,1) g, salaries`, "may only be queried in aggregate"},
+		{`SELECT salary FROM orders "o", salaries`, "may only be queried in aggregate"},
+		{`SELECT max(salary) FROM generate_series(1,1) g, salaries`, `HAVING count(DISTINCT salaries.id) >= 5`},
+		{`SELECT * FROM 'salaries.csv'`, "has a min_group_size, and can't tell which tables"},
+		{`SELECT max(salary) FROM orders, (salaries`, "has a min_group_size, and can't tell which tables"},
+		{`SELECT count(*) FROM salaries UNION SELECT 1`, "UNION/INTERSECT/EXCEPT"},
+	}
+	for _, tc := range cases {
+		got, err := c.enforceMinGroupSize(tc.query)
+		if err != nil {
+			got = err.Error()
+		}
+		if !strings.Contains(got, tc.want) {
+			t.Errorf("enforceMinGroupSize(%q)\n got %s\nwant %s", tc.query, got, tc.want)
+		}
+	}
+}
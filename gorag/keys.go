@@ -0,0 +1,74 @@
+package gorag
+
+import (
+	"database/sql"
+)
+
+// ForeignKey is one column of a foreign key constraint
+type ForeignKey struct {
+	Name      string `json:"name"`
+	Table     string `json:"table"`
+	Column    string `json:"column"`
+	RefTable  string `json:"ref_table"`
+	RefColumn string `json:"ref_column"`
+}
+
+/*
+  information_schema can't pair up the columns of a multi column
+  foreign key reliably, so this goes to pg_constraint directly.
+*/
+func getForeignKeys(db *sql.DB) ([]ForeignKey, error) {
+	query := `
+		SELECT c.conname, cl.relname, a.attname, fcl.relname, fa.attname
+		FROM pg_constraint c
+		JOIN pg_class cl ON cl.oid = c.conrelid
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		JOIN pg_class fcl ON fcl.oid = c.confrelid
+		CROSS JOIN LATERAL unnest(c.conkey, c.confkey) AS k(attnum, fattnum)
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum
+		JOIN pg_attribute fa ON fa.attrelid = c.confrelid AND fa.attnum = k.fattnum
+		WHERE c.contype = 'f' AND n.nspname = 'public'
+		ORDER BY cl.relname, c.conname;
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]ForeignKey, 0)
+	for rows.Next() {
+		var fk ForeignKey
+		if err := rows.Scan(&fk.Name, &fk.Table, &fk.Column, &fk.RefTable, &fk.RefColumn); err != nil {
+			return nil, err
+		}
+		keys = append(keys, fk)
+	}
+	return keys, rows.Err()
+}
+
+func getPrimaryKeys(db *sql.DB) (map[string][]string, error) {
+	query := `
+		SELECT tc.table_name, kcu.column_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+		  ON kcu.constraint_name = tc.constraint_name AND kcu.table_schema = tc.table_schema
+		WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = 'public'
+		ORDER BY tc.table_name, kcu.ordinal_position;
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string][]string)
+	var tableName, columnName string
+	for rows.Next() {
+		if err := rows.Scan(&tableName, &columnName); err != nil {
+			return nil, err
+		}
+		keys[tableName] = append(keys[tableName], columnName)
+	}
+	return keys, rows.Err()
+}
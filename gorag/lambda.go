@@ -0,0 +1,158 @@
+//go:build lambda
+
+package gorag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+)
+
+/*
+  Built with -tags lambda, the binary talks to the Lambda runtime API
+  directly, so it can be deployed as the `bootstrap` of a provided.al2023
+  function. The client (db connection, schema) is built once per cold
+  start and reused across invocations; the schema comes from
+  GORAG_SCHEMA_CACHE (usually s3://...) so we don't introspect every time.
+*/
+func init() {
+	lambdaMain = runLambda
+}
+
+// lambdaEvent accepts a direct invocation, or an API Gateway proxy event with a json body
+type lambdaEvent struct {
+	Prompt         string          `json:"prompt"`
+	User           string          `json:"user"`
+	Purpose        string          `json:"purpose"`
+	Category       string          `json:"category"`
+	Body           string          `json:"body"`
+	RequestContext json.RawMessage `json:"requestContext"`
+}
+
+type lambdaProxyResponse struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+}
+
+func newLambdaClient() (*Client, error) {
+	enforceNoExternalCalls()
+	db, err := Connect(dsnFromFlags())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	cache := os.Getenv("GORAG_SCHEMA_CACHE")
+	if cache == "" {
+		cache = *schemaCache
+	}
+	schema, err := loadSchema(db, cache)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve schema: %v", err)
+	}
+	metadataFile := os.Getenv("GORAG_METADATA")
+	if metadataFile == "" {
+		metadataFile = "metadata.json"
+	}
+	// there is no audit log to learn from here, so bandit example selection only ranks by relevance
+	c := &Client{
+		DB:            db,
+		APIKey:        os.Getenv("OPENAI_API_KEY"),
+		Schema:        schema,
+		ExtraMetadata: loadExtraMetadataOrEmpty(metadataFile),
+	}
+	if err := c.applyFlags(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func lambdaPost(url string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to marshal lambda response: %v", err)
+		return
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Failed to post lambda response: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func lambdaError(err error) map[string]string {
+	return map[string]string{
+		"errorMessage": err.Error(),
+		"errorType":    "GoragError",
+	}
+}
+
+func handleLambdaEvent(client *Client, payload []byte) (interface{}, error) {
+	var event lambdaEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	proxied := len(event.RequestContext) > 0
+	if event.Prompt == "" && event.Body != "" {
+		if err := json.Unmarshal([]byte(event.Body), &event); err != nil {
+			return nil, err
+		}
+	}
+	if event.Prompt == "" {
+		return nil, fmt.Errorf("prompt is required")
+	}
+
+	answer, err := client.Ask(Question{Prompt: event.Prompt, User: event.User, Purpose: event.Purpose, Category: event.Category})
+	if !proxied {
+		return answer, err
+	}
+	status := http.StatusOK
+	var body []byte
+	if err != nil {
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(map[string]string{"error": err.Error()})
+	} else {
+		body, _ = json.Marshal(answer)
+	}
+	return lambdaProxyResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
+
+func runLambda() {
+	api := "http://" + os.Getenv("AWS_LAMBDA_RUNTIME_API") + "/2018-06-01/runtime"
+	client, err := newLambdaClient()
+	if err != nil {
+		lambdaPost(api+"/init/error", lambdaError(err))
+		log.Fatalf("%v", err)
+	}
+	log.Println("Lambda client warmed up")
+
+	for {
+		resp, err := http.Get(api + "/invocation/next")
+		if err != nil {
+			log.Fatalf("Failed to get next invocation: %v", err)
+		}
+		requestID := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+		payload, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lambdaPost(api+"/invocation/"+requestID+"/error", lambdaError(err))
+			continue
+		}
+
+		result, err := handleLambdaEvent(client, payload)
+		if err != nil {
+			lambdaPost(api+"/invocation/"+requestID+"/error", lambdaError(err))
+			continue
+		}
+		lambdaPost(api+"/invocation/"+requestID+"/response", result)
+	}
+}
@@ -0,0 +1,170 @@
+package gorag
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+)
+
+/*
+  gorag is a library, and the gorag command is a thin wrapper around
+  Main. A program that embeds it connects, makes a client and asks:
+
+    db, err := gorag.Connect(dsn)
+    client, err := gorag.NewClient(db, os.Getenv("OPENAI_API_KEY"), nil)
+    answer, err := client.Ask(gorag.Question{Prompt: "...", User: "..."})
+
+  Most settings are fields of the Client, which NewClient fills with
+  the defaults the command has, and can be changed before the first
+  question. The settings are also flags, in their own set so that
+  importing gorag doesn't add them to a program's command line; a
+  program that wants them adds Flags to its own, or parses it.
+
+  Some are still only flags, read when they're used, and so the same
+  for every client in the program:
+
+  - the database: -driver, -db-credentials, -db-credentials-command,
+    -db-credentials-ttl, and -query-timeout as Connect opens it
+  - the model: -llm, -llm-url, -model, -max-tokens, -top-p,
+    -temperature, -data-temperature, -response-format, -vision-model,
+    -easy-model, -hard-model and -difficulty-threshold, with the
+    -ollama-* and -bedrock-* settings of those providers
+  - the model cache: -llm-cache and -llm-cache-ttl
+  - embeddings and documents: -embeddings-cache, -embed-values-max,
+    -value-match, -docs-count and -docs-max-bytes
+  - questions: -locale, -ident-quoting, -refine-rows and -usage
+*/
+var Flags = flag.NewFlagSet("gorag", flag.ExitOnError)
+
+// SchemaIntrospector reads the tables and columns the model gets to see
+type SchemaIntrospector interface {
+	Introspect(db *sql.DB) (*DBMetadata, error)
+}
+
+// CatalogIntrospector reads the catalogs of the -driver database, or Cache (a file or s3://bucket/key) when it has one
+type CatalogIntrospector struct {
+	Cache string
+}
+
+func (p CatalogIntrospector) Introspect(db *sql.DB) (*DBMetadata, error) {
+	return loadSchema(db, p.Cache)
+}
+
+// QueryGenerator writes the query for a question; feedback is about a previous attempt that failed, if any
+type QueryGenerator interface {
+	GenerateSQL(schema *DBMetadata, examples []*Example, question, feedback string) (string, error)
+}
+
+// NewClient asks questions of db, with the schema the introspector reads; nil introspects the database
+func NewClient(db *sql.DB, apiKey string, schema SchemaIntrospector) (*Client, error) {
+	if schema == nil {
+		schema = CatalogIntrospector{}
+	}
+	metadata, err := schema.Introspect(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve schema: %v", err)
+	}
+	c := &Client{
+		DB:            db,
+		APIKey:        apiKey,
+		Schema:        metadata,
+		ExtraMetadata: make(map[string]string),
+	}
+	if err := c.applyFlags(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// applyFlags sets the client's settings from Flags; the caller sets up the connection, the schema and the config
+func (c *Client) applyFlags() error {
+	parts, err := parsePromptParts(*promptParts)
+	if err != nil {
+		return err
+	}
+	// a typo here must not quietly send rows
+	if *summaryData != "rows" && *summaryData != "aggregates" {
+		return fmt.Errorf("-summary-data must be rows or aggregates")
+	}
+	if _, err := currentProvider(); err != nil {
+		return err
+	}
+	switch *responseFormat {
+	case "json_schema", "json_object", "none":
+	default:
+		return fmt.Errorf("-response-format must be json_schema, json_object or none")
+	}
+	var pipeline []*PipelineStage
+	if *pipelineFile != "" {
+		if pipeline, err = loadPipeline(*pipelineFile); err != nil {
+			return err
+		}
+	}
+	examples, err := newExampleSelector(*exampleSelection, c.Audit.Filename())
+	if err != nil {
+		return err
+	}
+	if err := checkPlanFlags(); err != nil {
+		return err
+	}
+	vectors, err := parseVectorStore(*vectorStoreSpec)
+	if err != nil {
+		return err
+	}
+	docs, err := parseRetriever(*docsSpec)
+	if err != nil {
+		return err
+	}
+	c.OPAURL = *opaURL
+	c.MinGroupMode = *minGroupMode
+	c.JoinCheck = *joinCheck
+	c.DeprecatedCheck = *deprecatedCheck
+	c.PruneTables = *pruneTables
+	c.MaxComplexity = *maxComplexity
+	c.ScratchRows = *scratchRows
+	c.Snapshot = *snapshotFlag
+	c.Retries = *retries
+	c.JudgeModel = *judgeModel
+	c.JudgeRetries = *judgeRetries
+	c.BufferBytes = *bufferBytes
+	c.MaxLLMBytes = *maxLLMBytes
+	c.MaxRows = *maxRows
+	c.QueryTimeout = *queryTimeout
+	c.FetchDeadline = *fetchDeadline
+	c.DryRun = *dryRun
+	c.MaxPlanCost = *maxPlanCost
+	c.MaxPlanRows = *maxPlanRows
+	c.PlanGate = *planGate
+	c.PromptParts = parts
+	c.Pseudonyms = pseudonymsFor(c.Schema)
+	c.SummaryData = *summaryData
+	c.MinLabelRows = *minLabelRows
+	c.WhatIf = *whatIfFlag
+	c.AnomalyZ = *anomalyZ
+	c.AgentSteps = *agentSteps
+	c.AgentBytes = *agentBytes
+	c.AnomalyWindow = *anomalyWindow
+	c.ForecastHorizon = *forecastHorizon
+	c.ForecastSeason = *forecastSeason
+	c.BundleDir = *bundleDir
+	c.Pipeline = pipeline
+	c.Examples = examples
+	c.Vectors = vectors
+	c.Docs = docs
+	terminal := terminalConfirmer{bufio.NewScanner(os.Stdin), os.Stderr}
+	if *confirmFlag {
+		if *serve != "" {
+			return fmt.Errorf("-confirm needs someone at the terminal, so it can't be served")
+		}
+		c.Confirm = terminal
+	}
+	if *allowWrites {
+		if *serve != "" {
+			return fmt.Errorf("-allow-writes needs someone at the terminal to commit, so it can't be served")
+		}
+		c.ConfirmWrites = terminal
+	}
+	return nil
+}
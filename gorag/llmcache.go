@@ -0,0 +1,119 @@
+package gorag
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+  -llm-cache-ttl keeps model completions for a while, keyed by a hash
+  of the endpoint, the model, the temperature, the exact prompt, and
+  for a reply read as json, the schema and format it was held to, so
+  the same question asked again (an eval run, a dashboard refreshing)
+  doesn't go to the provider again, or count against a budget. Only
+  calls at temperature 0 are cached, and with the cache on that is
+  what every call asks for unless -data-temperature says otherwise,
+  since a cached answer should be the one the model would give again.
+  -llm-cache keeps the completions in a file, for the next run; they
+  can hold what the model said about results, so it is only readable
+  by its owner.
+*/
+var llmCacheTTL = Flags.Duration("llm-cache-ttl", 0, "reuse a model's completion of an identical prompt for this long, eg: 24h")
+var llmCacheFile = Flags.String("llm-cache", "", "keep cached completions in this file across runs")
+
+type cachedCompletion struct {
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+type completionCache struct {
+	mu      sync.Mutex
+	loaded  bool
+	Entries map[string]*cachedCompletion `json:"entries"`
+}
+
+var completions = &completionCache{}
+
+func completionKey(t modelTarget, prompt string) string {
+	temperature := strconv.FormatFloat(t.Temperature, 'g', -1, 64)
+	// the limits only have a say in the key when they are set, so older entries still match
+	if t.MaxTokens > 0 || t.TopP > 0 {
+		temperature += fmt.Sprintf("/%d/%g", t.MaxTokens, t.TopP)
+	}
+	// and so is a json reply, with the schema and format it was held to, since it isn't the prose the same prompt gets
+	if t.json {
+		temperature += "/json"
+		if t.Schema != nil {
+			schema, _ := json.Marshal(t.Schema.Schema)
+			temperature += "/" + wantsFormat(t.CompletionOptions) + "/" + t.Schema.Name + "/" + string(schema)
+		}
+	}
+	sum := sha256.Sum256([]byte(t.URL + "\x00" + t.Model + "\x00" + temperature + "\x00" + prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// load reads -llm-cache the first time, leaving out what has expired
+func (cc *completionCache) load() {
+	if cc.loaded {
+		return
+	}
+	cc.loaded = true
+	cc.Entries = make(map[string]*cachedCompletion)
+	if *llmCacheFile == "" {
+		return
+	}
+	data, err := os.ReadFile(*llmCacheFile)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(data, cc)
+	}
+	if err != nil {
+		log.Printf("Starting with an empty completion cache, %s couldn't be read: %v", *llmCacheFile, err)
+		cc.Entries = make(map[string]*cachedCompletion)
+		return
+	}
+	for k, e := range cc.Entries {
+		if time.Since(e.Time) > *llmCacheTTL {
+			delete(cc.Entries, k)
+		}
+	}
+}
+
+func (cc *completionCache) get(key string) (string, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.load()
+	e, ok := cc.Entries[key]
+	if !ok || time.Since(e.Time) > *llmCacheTTL {
+		return "", false
+	}
+	return e.Text, true
+}
+
+func (cc *completionCache) put(key, text string) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.load()
+	cc.Entries[key] = &cachedCompletion{Text: text, Time: time.Now()}
+	if *llmCacheFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(cc)
+	if err != nil {
+		return err
+	}
+	tmp := *llmCacheFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save the completion cache: %v", err)
+	}
+	return os.Rename(tmp, *llmCacheFile)
+}
@@ -0,0 +1,25 @@
+package gorag
+
+import "testing"
+
+func TestCompletionKey(t *testing.T) {
+	prose := modelTarget{CompletionOptions: CompletionOptions{URL: "http://llm", Model: "m"}}
+	parsed := prose
+	parsed.json = true
+	query := parsed
+	query.Schema = querySchema
+	other := query
+	other.Schema = &ResponseSchema{Name: "plan", Schema: map[string]interface{}{"type": "object"}}
+
+	keys := map[string]string{}
+	for name, target := range map[string]modelTarget{"prose": prose, "json": parsed, "query": query, "other": other} {
+		key := completionKey(target, "the prompt")
+		if was, ok := keys[key]; ok {
+			t.Errorf("%s and %s completions of the same prompt share a key", was, name)
+		}
+		keys[key] = name
+		if again := completionKey(target, "the prompt"); again != key {
+			t.Errorf("a %s completion's key changed from %s to %s", name, key, again)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package gorag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+  gorag loadtest replays questions from the audit log against a
+  running server, at a fixed rate, and reports latency percentiles
+  and errors. Run the server with -llm mock (and -mock-latency) to
+  load the server and database without paying for a model, and
+  -replay query to send the audited SQL instead of the question, so
+  the mock runs the same queries people did.
+*/
+type loadResult struct {
+	status  int // 0 when the request never got an answer
+	latency time.Duration
+}
+
+func loadQuestions(filename, replay string, sample int) ([]askRequest, error) {
+	seen := make(map[string]bool)
+	questions := make([]askRequest, 0)
+	err := readAuditLog(filename, func(e AuditEvent) {
+		if e.Event != "ask" || e.Error != "" || e.Prompt == "" {
+			return
+		}
+		prompt := e.Prompt
+		if replay == "query" {
+			prompt = e.Query
+		}
+		key := e.Profile + "\x00" + prompt
+		if prompt == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		questions = append(questions, askRequest{Prompt: prompt, Profile: e.Profile, Purpose: e.Purpose})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	rand.Shuffle(len(questions), func(i, j int) { questions[i], questions[j] = questions[j], questions[i] })
+	if sample > 0 && len(questions) > sample {
+		questions = questions[:sample]
+	}
+	return questions, nil
+}
+
+func askOnce(client *http.Client, url, apiKey string, q askRequest) loadResult {
+	body, err := json.Marshal(q)
+	if err != nil {
+		return loadResult{}
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(url, "/")+"/ask", bytes.NewReader(body))
+	if err != nil {
+		return loadResult{}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return loadResult{latency: time.Since(start)}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return loadResult{status: resp.StatusCode, latency: time.Since(start)}
+}
+
+// percentile of sorted latencies, p in [0,100]
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p / 100)
+	return sorted[i]
+}
+
+func formatLoadReport(results []loadResult, elapsed time.Duration) string {
+	latencies := make([]time.Duration, 0, len(results))
+	statuses := make(map[int]int)
+	errors := 0
+	for _, r := range results {
+		latencies = append(latencies, r.latency)
+		statuses[r.status]++
+		if r.status < 200 || r.status > 299 {
+			errors++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("requests: %d in %s (%.1f/s)\n", len(results), elapsed.Round(time.Millisecond), float64(len(results))/elapsed.Seconds()))
+	if len(results) > 0 {
+		sb.WriteString(fmt.Sprintf("errors: %d (%.1f%%)\n", errors, 100*float64(errors)/float64(len(results))))
+	}
+	sb.WriteString(fmt.Sprintf("latency: p50 %s, p90 %s, p99 %s, max %s\n",
+		percentile(latencies, 50).Round(time.Millisecond),
+		percentile(latencies, 90).Round(time.Millisecond),
+		percentile(latencies, 99).Round(time.Millisecond),
+		percentile(latencies, 100).Round(time.Millisecond)))
+	codes := make([]int, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		name := "no response"
+		if code != 0 {
+			name = fmt.Sprintf("%d %s", code, http.StatusText(code))
+		}
+		sb.WriteString(fmt.Sprintf("  %s: %d\n", name, statuses[code]))
+	}
+	return sb.String()
+}
+
+func runLoadTest(args []string) {
+	fs := commandFlags("loadtest")
+	url := fs.String("url", "http://localhost:8080", "the server to load")
+	apiKey := fs.String("api-key", os.Getenv("GORAG_API_KEY"), "api key to ask with")
+	replay := fs.String("replay", "prompt", "send the audited prompt, or the audited query (for -llm mock servers)")
+	sample := fs.Int("sample", 100, "how many distinct questions to replay, 0 for all")
+	rate := fs.Float64("rate", 2, "requests per second")
+	duration := fs.Duration("duration", time.Minute, "how long to keep sending")
+	concurrency := fs.Int("concurrency", 50, "most requests in flight at once")
+	timeout := fs.Duration("timeout", 2*time.Minute, "per request timeout")
+	fs.Parse(args)
+	enforceNoExternalCalls()
+
+	if *auditLog == "" {
+		log.Fatalf("loadtest needs -audit-log")
+	}
+	if *replay != "prompt" && *replay != "query" {
+		log.Fatalf("-replay must be prompt or query")
+	}
+	if *rate <= 0 {
+		log.Fatalf("-rate must be positive")
+	}
+	questions, err := loadQuestions(*auditLog, *replay, *sample)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(questions) == 0 {
+		log.Fatalf("No answered questions in %s", *auditLog)
+	}
+	log.Printf("Replaying %d questions at %.1f/s for %s against %s", len(questions), *rate, *duration, *url)
+
+	client := &http.Client{Timeout: *timeout}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make([]loadResult, 0)
+	inFlight := make(chan struct{}, *concurrency)
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+	defer ticker.Stop()
+	start := time.Now()
+	for i := 0; time.Since(start) < *duration; i++ {
+		<-ticker.C
+		// when the server can't keep up we wait, and the achieved rate shows it
+		inFlight <- struct{}{}
+		wg.Add(1)
+		go func(q askRequest) {
+			defer wg.Done()
+			r := askOnce(client, *url, *apiKey, q)
+			<-inFlight
+			mu.Lock()
+			results = append(results, r)
+			mu.Unlock()
+		}(questions[i%len(questions)])
+	}
+	wg.Wait()
+	fmt.Print(formatLoadReport(results, time.Since(start)))
+}
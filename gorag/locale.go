@@ -0,0 +1,248 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+  People write numbers and dates the way they were taught: 1.000,50
+  and 31/12/2024 in Germany, 1,000.50 and 12/31/2024 in the US. The
+  model guesses, and a filter on the wrong day or a thousand times
+  the amount still runs. So before anything reads the question, its
+  numbers and dates are rewritten the one way SQL has them, 1000.50
+  and 2024-12-31, reading them as the question's locale writes them.
+
+  The locale is -locale, or what the asker sent: "locale" in the
+  request, or else its Accept-Language. Without one, the question is
+  left as it is. Two digit years are 1969 to 2068, as Go reads them.
+  Only what can be read one way is rewritten: 31/13/2024 isn't a
+  date anywhere, and 1.5 is the same in every locale.
+*/
+var localeFlag = Flags.String("locale", "", "read numbers and dates in questions as this locale writes them, eg: de-DE or en-US, and rewrite them as SQL has them")
+
+type localeFormat struct {
+	decimal rune
+	// thousands separators; numbers in locales that group with spaces are also written with no-break spaces
+	group []rune
+	// the order of day, month and year in a date without a four digit year first
+	order string
+}
+
+var (
+	usFormat      = localeFormat{'.', []rune{','}, "mdy"}
+	britishFormat = localeFormat{'.', []rune{','}, "dmy"}
+	commaFormat   = localeFormat{',', []rune{'.'}, "dmy"}
+	spaceFormat   = localeFormat{',', []rune{' ', '\u00a0', '\u202f'}, "dmy"}
+	swissFormat   = localeFormat{'.', []rune{'\'', '\u2019'}, "dmy"}
+	eastFormat    = localeFormat{'.', []rune{','}, "ymd"}
+)
+
+// localeFormats is by language, and language-region where that differs
+var localeFormats = map[string]localeFormat{
+	"en": usFormat, "en-us": usFormat, "en-ph": usFormat,
+	"en-gb": britishFormat, "en-au": britishFormat, "en-nz": britishFormat, "en-ie": britishFormat,
+	"en-in": britishFormat, "en-za": britishFormat, "en-ca": britishFormat, "ga": britishFormat,
+	"he": britishFormat, "th": britishFormat, "ar": britishFormat, "hi": britishFormat,
+	"de": commaFormat, "nl": commaFormat, "es": commaFormat, "it": commaFormat, "pt": commaFormat,
+	"da": commaFormat, "id": commaFormat, "tr": commaFormat, "el": commaFormat, "ro": commaFormat,
+	"hr": commaFormat, "sl": commaFormat, "sr": commaFormat, "vi": commaFormat,
+	"de-ch": swissFormat, "it-ch": swissFormat, "de-li": swissFormat,
+	"fr": spaceFormat, "ru": spaceFormat, "pl": spaceFormat, "sv": spaceFormat, "fi": spaceFormat,
+	"nb": spaceFormat, "no": spaceFormat, "nn": spaceFormat, "cs": spaceFormat, "sk": spaceFormat,
+	"uk": spaceFormat, "hu": spaceFormat, "bg": spaceFormat, "et": spaceFormat, "lt": spaceFormat,
+	"lv": spaceFormat, "pt-pt": spaceFormat, "es-mx": usFormat, "fr-ch": swissFormat,
+	"ja": eastFormat, "zh": eastFormat, "ko": eastFormat,
+}
+
+// lookupLocale finds the format of a locale like de-DE, de_DE.UTF-8, or an Accept-Language header
+func lookupLocale(locale string) (localeFormat, error) {
+	// the first, and most preferred, language in a header
+	locale = strings.Split(locale, ",")[0]
+	locale = strings.Split(locale, ";")[0]
+	locale = strings.Split(locale, ".")[0]
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	parts := strings.Split(locale, "-")
+	if len(parts) > 1 {
+		if f, ok := localeFormats[parts[0]+"-"+parts[len(parts)-1]]; ok {
+			return f, nil
+		}
+	}
+	if f, ok := localeFormats[parts[0]]; ok {
+		return f, nil
+	}
+	return localeFormat{}, fmt.Errorf("unknown locale %s", locale)
+}
+
+var localeDate = regexp.MustCompile(`\d{1,4}([./-])\d{1,2}([./-])\d{1,4}`)
+
+// localeNumber is digits with separators between them; which are grouping is up to the locale
+var localeNumber = regexp.MustCompile(`\d+(?:[.,'\x{2019} \x{00a0}\x{202f}]\d+)+`)
+
+const separators = ".,'\u2019 \u00a0\u202f"
+
+// standalone is whether text[from:to] isn't part of a longer word, number or date
+func standalone(text string, from, to int) bool {
+	if from > 0 {
+		r, _ := utf8.DecodeLastRuneInString(text[:from])
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("./-,_", r) {
+			return false
+		}
+	}
+	if to < len(text) {
+		r, n := utf8.DecodeRuneInString(text[to:])
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return false
+		}
+		// a sentence may end after it, but another part may not follow
+		if strings.ContainsRune("./-,", r) && to+n < len(text) {
+			next, _ := utf8.DecodeRuneInString(text[to+n:])
+			if unicode.IsDigit(next) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isoDate is the date d, read in order, or "" when it isn't one
+func isoDate(d string, order string) string {
+	fields := strings.FieldsFunc(d, func(r rune) bool { return r == '.' || r == '/' || r == '-' })
+	if len(fields) != 3 {
+		return ""
+	}
+	if len(fields[0]) == 4 {
+		order = "ymd"
+	} else if len(fields[2]) != 4 && len(fields[2]) != 2 {
+		return ""
+	}
+	var y, m, day string
+	for i, c := range order {
+		switch c {
+		case 'y':
+			y = fields[i]
+		case 'm':
+			m = fields[i]
+		case 'd':
+			day = fields[i]
+		}
+	}
+	layout := "2006"
+	if len(y) == 2 {
+		layout = "06"
+	} else if len(y) != 4 {
+		return ""
+	}
+	t, err := time.Parse(layout+"-1-2", y+"-"+m+"-"+day)
+	if err != nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// plainNumber is n as SQL writes it, or "" when the locale doesn't write numbers that way
+func plainNumber(n string, f localeFormat) string {
+	var whole, frac string
+	if i := strings.LastIndexFunc(n, func(r rune) bool { return r == f.decimal }); i >= 0 {
+		whole, frac = n[:i], n[i+utf8.RuneLen(f.decimal):]
+	} else {
+		whole = n
+	}
+	if strings.ContainsAny(frac, separators) {
+		return ""
+	}
+	groups := strings.FieldsFunc(whole, func(r rune) bool {
+		for _, g := range f.group {
+			if r == g {
+				return true
+			}
+		}
+		return false
+	})
+	for i, g := range groups {
+		if strings.ContainsAny(g, separators) {
+			return ""
+		}
+		if i > 0 && len(g) != 3 || i == 0 && len(groups) > 1 && len(g) > 3 {
+			return ""
+		}
+	}
+	out := strings.Join(groups, "")
+	if frac != "" {
+		out += "." + frac
+	}
+	if _, err := strconv.ParseFloat(out, 64); err != nil {
+		return ""
+	}
+	return out
+}
+
+// normalizeLiterals rewrites the dates and numbers in text the way SQL writes them
+func normalizeLiterals(text string, f localeFormat) string {
+	var sb strings.Builder
+	last := 0
+	for _, m := range localeDate.FindAllStringSubmatchIndex(text, -1) {
+		// 12.5-3 isn't a date
+		if text[m[2]:m[3]] != text[m[4]:m[5]] || !standalone(text, m[0], m[1]) {
+			continue
+		}
+		iso := isoDate(text[m[0]:m[1]], f.order)
+		if iso == "" {
+			continue
+		}
+		sb.WriteString(text[last:m[0]])
+		sb.WriteString(iso)
+		last = m[1]
+	}
+	sb.WriteString(text[last:])
+	text = sb.String()
+
+	sb.Reset()
+	last = 0
+	for _, m := range localeNumber.FindAllStringIndex(text, -1) {
+		n := text[m[0]:m[1]]
+		if !standalone(text, m[0], m[0]+len(n)) {
+			continue
+		}
+		// dates we wrote, and numbers already as SQL has them
+		if localeDate.MatchString(n) {
+			continue
+		}
+		out := plainNumber(n, f)
+		if out == "" || out == n {
+			continue
+		}
+		sb.WriteString(text[last:m[0]])
+		sb.WriteString(out)
+		last = m[0] + len(n)
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
+
+// normalizeQuestion rewrites q's prompt for its locale, or -locale
+func normalizeQuestion(q *Question) error {
+	locale := q.Locale
+	if locale == "" {
+		locale = *localeFlag
+	}
+	if locale == "" {
+		return nil
+	}
+	f, err := lookupLocale(locale)
+	if err != nil {
+		return err
+	}
+	normalized := normalizeLiterals(q.Prompt, f)
+	if normalized != q.Prompt {
+		log.Printf("Read the question in %s as: %s", locale, normalized)
+		q.Prompt = normalized
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package gorag
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+/*
+  A follow up that a refinement can't do (a pivot, a correlation, the
+  90th percentile by region) is asked of the result itself: the rows
+  we kept are loaded into an in-memory DuckDB table named result, the
+  model writes a DuckDB query over it, and that runs here, with no
+  round trip to the warehouse. As for refinements, the model only
+  sees the column names and types. Files and the network are off in
+  that database before the query runs, so all it can read is the
+  result. DuckDB needs cgo, so this is only in a binary built with
+  -tags duckdb.
+*/
+
+// duckdbType is the type a result column gets in DuckDB, from the type the database said it had
+func duckdbType(dbType string) string {
+	t := strings.ToUpper(dbType)
+	switch {
+	case t == "BOOL" || t == "BOOLEAN" || t == "BIT":
+		return "BOOLEAN"
+	case strings.Contains(t, "INT") || t == "SERIAL" || t == "BIGSERIAL":
+		return "BIGINT"
+	case strings.Contains(t, "NUMERIC") || strings.Contains(t, "DECIMAL") || strings.Contains(t, "FLOAT") ||
+		strings.Contains(t, "DOUBLE") || t == "REAL" || t == "MONEY" || t == "NUMBER":
+		return "DOUBLE"
+	case t == "DATE":
+		return "DATE"
+	case strings.Contains(t, "TIMESTAMP") || t == "DATETIME" || t == "DATETIME2":
+		return "TIMESTAMP"
+	}
+	return "VARCHAR"
+}
+
+// openResultDB makes a DuckDB database whose only table is t, as result
+func openResultDB(t *resultTable) (*sql.DB, error) {
+	if openDuckDB == nil {
+		return nil, fmt.Errorf("asking about a result needs a binary built with -tags duckdb")
+	}
+	// one connection, since each in-memory one is its own database
+	db, err := openDuckDB("", nil)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	quote := func(name string) string { return `"` + strings.ReplaceAll(name, `"`, `""`) + `"` }
+
+	raw := make([]string, len(t.Columns))
+	typed := make([]string, len(t.Columns))
+	marks := make([]string, len(t.Columns))
+	for i, name := range t.Columns {
+		raw[i] = fmt.Sprintf("c%d VARCHAR", i)
+		// a value that isn't what the column claims is NULL, not a failed load
+		typed[i] = fmt.Sprintf("TRY_CAST(c%d AS %s) AS %s", i, duckdbType(t.Types[i]), quote(name))
+		marks[i] = "?"
+	}
+	if _, err := db.Exec(fmt.Sprintf("CREATE TEMP TABLE raw_result (%s)", strings.Join(raw, ", "))); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load the result: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO raw_result VALUES (%s)", strings.Join(marks, ", ")))
+	if err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, fmt.Errorf("failed to load the result: %v", err)
+	}
+	for _, row := range t.Rows {
+		args := make([]interface{}, len(row))
+		for i, v := range row {
+			if v != nil {
+				args[i] = *v
+			}
+		}
+		if _, err := insert.Exec(args...); err != nil {
+			insert.Close()
+			tx.Rollback()
+			db.Close()
+			return nil, fmt.Errorf("failed to load the result: %v", err)
+		}
+	}
+	insert.Close()
+	if err := tx.Commit(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, s := range []string{
+		fmt.Sprintf("CREATE TABLE result AS SELECT %s FROM raw_result", strings.Join(typed, ", ")),
+		"DROP TABLE raw_result",
+		"SET enable_external_access = false",
+		"SET lock_configuration = true",
+	} {
+		if _, err := db.Exec(s); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to load the result: %v", err)
+		}
+	}
+	return db, nil
+}
+
+// resultColumns lists the columns for a prompt, with their DuckDB types
+func resultColumns(t *resultTable) string {
+	columns := make([]string, len(t.Columns))
+	for i, name := range t.Columns {
+		columns[i] = fmt.Sprintf("  %s %s", name, duckdbType(t.Types[i]))
+	}
+	return strings.Join(columns, "\n")
+}
+
+// AskResult answers a question about a result locally, returning the rows and the DuckDB query that made them
+func (c *Client) AskResult(t *resultTable, question string) (*resultTable, string, error) {
+	db, err := openResultDB(t)
+	if err != nil {
+		return nil, "", err
+	}
+	defer db.Close()
+	query, err := c.llmQuery(fmt.Sprintf(`
+A query result was loaded into a DuckDB table named result, with %d rows and these columns:
+
+%s
+%s
+Write one DuckDB SELECT over result that answers this question about it: %s
+
+Only the result table exists.
+http response must be application/json:
+{ "query": "SELECT ..." }
+`, len(t.Rows), resultColumns(t), duckdbPrompt, question))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to write a query over the result: %v", err)
+	}
+	if ops := sqlOperations(query); len(ops) != 1 || ops[0] != "SELECT" {
+		return nil, query, fmt.Errorf("only one SELECT over the result can run, not: %s", query)
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, query, fmt.Errorf("failed to run the query over the result: %v", err)
+	}
+	defer rows.Close()
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, query, err
+	}
+	out := &resultTable{Truncated: t.Truncated}
+	for _, col := range columns {
+		out.Columns = append(out.Columns, col.Name())
+		out.Types = append(out.Types, col.DatabaseTypeName())
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, query, err
+		}
+		out.keep(values, *refineRows)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, query, fmt.Errorf("failed to run the query over the result: %v", err)
+	}
+	return out, query, nil
+}
@@ -0,0 +1,397 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+/*
+  Sensitive columns can be masked in the database, so their raw values
+  never reach gorag, the model or whoever asked. A column gets a mask
+  of its own, or through a tag in mask_tags:
+
+    "mask_tags": {"pii": "hash"},
+    "tables": {
+      "people": {"columns": {
+        "email": {"tags": ["pii"], "mask": "email"},
+        "ssn": {"tags": ["pii"], "mask": "partial"},
+        "phone": {"tags": ["pii"]}
+      }}
+    }
+
+  Validate rewrites every select list that reads such a column to
+  read the mask of it instead, keeping the column's name, so
+  SELECT email FROM people runs as
+
+    SELECT '***@' || split_part(email::text, '@', 2) AS email FROM people
+
+  Filters, joins and sorting still use the real values, inside the
+  database. A hash is the same for equal values, so it can still be
+  grouped and joined on, but anyone who can guess a value can check
+  it, so short values like ssn are better off partial or redacted.
+  SELECT * and whole row references on a masked table are refused,
+  since every column would come back unmasked, and so is TABLE on
+  one, which is SELECT * by another name, as a statement, a subquery
+  or a CTE. So is an alias with a column list, people AS p (a), which
+  gives the masked columns other names, and, while any table has
+  masks, a query whose FROM lists can't all be told apart.
+*/
+var sqlMasks = map[string]string{
+	"hash":    "encode(sha256(convert_to(%s::text, 'UTF8')), 'hex')",
+	"partial": "'****' || right(%s::text, 4)",
+	"email":   "'***@' || split_part(%s::text, '@', 2)",
+	"redact":  "CASE WHEN %s IS NULL THEN NULL ELSE '[redacted]' END",
+}
+
+// masks maps the masked columns of a table to their masks
+func (c *Config) masks(table string) (map[string]string, error) {
+	tc := c.tableConfig(table)
+	if tc == nil {
+		return nil, nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make(map[string]string)
+	for column, cc := range tc.Columns {
+		if cc == nil {
+			continue
+		}
+		mask := cc.Mask
+		for _, tag := range cc.Tags {
+			if mask == "" {
+				mask = c.MaskTags[tag]
+			}
+		}
+		if mask == "" {
+			continue
+		}
+		if _, ok := sqlMasks[mask]; !ok {
+			return nil, fmt.Errorf("%s.%s has mask %q, which isn't one of hash, partial, email or redact", table, column, mask)
+		}
+		out[strings.ToLower(column)] = mask
+	}
+	return out, nil
+}
+
+// words ending a select list, at the level of its SELECT
+var selectListEnds = map[string]bool{
+	"FROM": true, "INTO": true, "WHERE": true, "GROUP": true, "HAVING": true,
+	"WINDOW": true, "ORDER": true, "LIMIT": true, "OFFSET": true, "FETCH": true,
+	"UNION": true, "INTERSECT": true, "EXCEPT": true, "FOR": true,
+}
+
+// fromItems are the tables in the FROM clause of the SELECT at i, and whether it has one
+func (s *tableScan) fromItems(i int) ([]tableRef, bool) {
+	items := make([]tableRef, 0)
+	for _, ref := range s.refs {
+		if ref.Select == i {
+			items = append(items, ref)
+		}
+	}
+	return items, s.from[i]
+}
+
+// fromItems is tableScan.fromItems, for tokens that haven't been scanned
+func fromItems(tokens []sqlToken, i int) ([]tableRef, bool, error) {
+	scan, err := scanTables(tokens)
+	if err != nil {
+		return nil, false, err
+	}
+	items, from := scan.fromItems(i)
+	return items, from, nil
+}
+
+// hasMasks is whether any table in the config has a masked column
+func (c *Config) hasMasks() (bool, error) {
+	c.mu.RLock()
+	names := make([]string, 0, len(c.Tables))
+	for name := range c.Tables {
+		names = append(names, name)
+	}
+	c.mu.RUnlock()
+	for _, name := range names {
+		m, err := c.masks(name)
+		if err != nil || len(m) > 0 {
+			return len(m) > 0, err
+		}
+	}
+	return false, nil
+}
+
+// maskLevel is a SELECT, or parentheses inside one, while masking
+type maskLevel struct {
+	query   bool                         // a SELECT opened it
+	list    bool                         // in a select list
+	columns map[string]string            // unqualified column -> mask
+	tables  map[string]map[string]string // table or alias -> its masked columns
+	owned   bool                         // the masks are this SELECT's own FROM
+}
+
+func (c *Client) selectMasks(scan *tableScan, i int, parent maskLevel) (maskLevel, error) {
+	level := maskLevel{query: true, list: true, columns: parent.columns, tables: make(map[string]map[string]string)}
+	// correlated references can name the tables of enclosing queries
+	for name, m := range parent.tables {
+		level.tables[name] = m
+	}
+	items, hasFrom := scan.fromItems(i)
+	if !hasFrom {
+		return level, nil
+	}
+	level.columns = make(map[string]string)
+	for _, item := range items {
+		m, err := c.Config.masks(item.Table)
+		if err != nil {
+			return level, err
+		}
+		if len(m) == 0 {
+			continue
+		}
+		if len(item.Columns) > 0 {
+			return level, fmt.Errorf("%s AS %s (%s) renames masked columns; name the columns instead", item.Table, item.Alias, strings.Join(item.Columns, ", "))
+		}
+		level.owned = true
+		for column, mask := range m {
+			if _, ok := level.columns[column]; !ok {
+				level.columns[column] = mask
+			}
+		}
+		// the table's name too, in case what was read as an alias isn't one
+		level.tables[item.Table] = m
+		if _, bare, ok := strings.Cut(item.Table, "."); ok {
+			level.tables[bare] = m
+		}
+		if item.Alias != "" {
+			level.tables[item.Alias] = m
+		}
+	}
+	return level, nil
+}
+
+// endsExpression is true of a token an alias can follow
+func endsExpression(t sqlToken) bool {
+	switch t.Kind {
+	case sqlQuotedIdent, sqlString, sqlNumber:
+		return true
+	case sqlWord:
+		return !isSQLKeyword(t.upper()) && t.upper() != "DISTINCT"
+	}
+	return t.Text == ")"
+}
+
+// distinctOn is true when the ) at i closes SELECT DISTINCT ON (...)
+func distinctOn(tokens []sqlToken, i int) bool {
+	depth := 0
+	for j := i; j >= 2; j-- {
+		switch tokens[j].Text {
+		case ")":
+			depth++
+		case "(":
+			depth--
+			if depth == 0 {
+				return tokens[j-1].upper() == "ON" && tokens[j-2].upper() == "DISTINCT"
+			}
+		}
+	}
+	return false
+}
+
+/*
+  applyMasks rewrites the query so that every select list reads masked
+  columns through their masks. Like the other checks it errs towards
+  masking: a column name that could be a masked column of a table its
+  SELECT reads is masked.
+*/
+func (c *Client) applyMasks(query string) (string, error) {
+	if c.Config == nil {
+		return query, nil
+	}
+	tokens := lexSQL(query)
+	scan, scanErr := scanTables(tokens)
+	masked := false
+	for _, ref := range scan.refs {
+		m, err := c.Config.masks(ref.Table)
+		if err != nil {
+			return "", err
+		}
+		if len(m) > 0 {
+			masked = true
+		}
+	}
+	if scanErr != nil {
+		// a table that couldn't be told could be a masked one
+		some, err := c.Config.hasMasks()
+		if err != nil {
+			return "", err
+		}
+		if masked || some {
+			return "", scanErr
+		}
+	}
+	if !masked {
+		return query, nil
+	}
+	if err := requirePostgres("masking"); err != nil {
+		return "", err
+	}
+
+	levels := []maskLevel{{}}
+	var out strings.Builder
+	copied := 0
+	columns := make(map[string]bool)
+	itemEnds := func(i int) bool {
+		if i >= len(tokens) {
+			return true
+		}
+		t := tokens[i]
+		return t.Text == "," || t.Text == ")" || t.Text == ";" || selectListEnds[t.upper()]
+	}
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		top := &levels[len(levels)-1]
+		switch {
+		case t.Kind == sqlPunct && t.Text == "(":
+			levels = append(levels, maskLevel{list: top.list, columns: top.columns, tables: top.tables})
+			continue
+		case t.Kind == sqlPunct && t.Text == ")":
+			if len(levels) > 1 {
+				levels = levels[:len(levels)-1]
+			}
+			continue
+		case t.Kind == sqlPunct && t.Text == ";":
+			levels = []maskLevel{{}}
+			continue
+		case t.upper() == "TABLE":
+			k := i + 1
+			for k < len(tokens) && sqlNotTables[tokens[k].upper()] {
+				k++
+			}
+			name, _ := qualifiedName(tokens, k)
+			m, err := c.Config.masks(name)
+			if err != nil {
+				return "", err
+			}
+			if len(m) > 0 {
+				return "", fmt.Errorf("TABLE %s would read masked columns; select the columns instead", name)
+			}
+			continue
+		case t.upper() == "SELECT":
+			level, err := c.selectMasks(scan, i, *top)
+			if err != nil {
+				return "", err
+			}
+			*top = level
+			continue
+		case top.query && selectListEnds[t.upper()]:
+			top.list = false
+			continue
+		}
+		if !top.list {
+			continue
+		}
+
+		// the start of a (possibly qualified) name ending at i
+		j := i
+		for j >= 2 && tokens[j-1].Text == "." && tokens[j-2].ident() != "" {
+			j -= 2
+		}
+		var prev sqlToken
+		if j > 0 {
+			prev = tokens[j-1]
+		}
+		if t.Text == "*" {
+			if j < i {
+				name := strings.ToLower(query[tokens[j].Pos:tokens[i-1].Pos])
+				if len(top.tables[tokens[i-2].ident()]) > 0 || len(top.tables[name]) > 0 {
+					return "", fmt.Errorf("%s* would read masked columns; name the columns instead", query[tokens[j].Pos:tokens[i-1].Pos+1])
+				}
+			} else if top.owned && (prev.upper() == "SELECT" || prev.upper() == "DISTINCT" || prev.upper() == "ALL" || prev.Text == ",") {
+				return "", fmt.Errorf("SELECT * would read masked columns; name the columns instead")
+			}
+			continue
+		}
+		name := t.ident()
+		if name == "" || i+1 < len(tokens) && (tokens[i+1].Text == "." || tokens[i+1].Text == "(") {
+			continue
+		}
+		// an alias, or a type in a cast
+		if prev.upper() == "AS" || prev.Text == ":" {
+			continue
+		}
+		if j == i && endsExpression(prev) && itemEnds(i+1) && !(prev.Text == ")" && distinctOn(tokens, j-1)) {
+			continue
+		}
+		mask := ""
+		if j < i {
+			qualifier := strings.ToLower(query[tokens[j].Pos:tokens[i-1].Pos])
+			m := top.tables[qualifier]
+			if m == nil {
+				m = top.tables[tokens[i-2].ident()]
+			}
+			mask = m[name]
+		} else {
+			mask = top.columns[name]
+			if mask == "" && len(top.tables[name]) > 0 {
+				return "", fmt.Errorf("%s is a whole row of a table with masked columns; name the columns instead", t.Text)
+			}
+		}
+		if mask == "" {
+			continue
+		}
+		start, end := tokens[j].Pos, t.Pos+len(t.Text)
+		expr := fmt.Sprintf(sqlMasks[mask], query[start:end])
+		// a bare column keeps its name in the result
+		bare := prev.upper() == "SELECT" || prev.upper() == "DISTINCT" || prev.upper() == "ALL" || prev.Text == "," ||
+			prev.Text == ")" && distinctOn(tokens, j-1)
+		if top.query && bare && itemEnds(i+1) {
+			expr += " AS " + t.Text
+		} else {
+			expr = "(" + expr + ")"
+		}
+		out.WriteString(query[copied:start])
+		out.WriteString(expr)
+		copied = end
+		columns[name] = true
+	}
+	if len(columns) == 0 {
+		return query, nil
+	}
+	out.WriteString(query[copied:])
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	log.Printf("Masking %s", strings.Join(names, ", "))
+	return out.String(), nil
+}
+
+// masksPrompt tells the model which columns come back masked, so it doesn't write SELECT * on them
+func (c *Client) masksPrompt(schema *DBMetadata) string {
+	if c.Config == nil {
+		return ""
+	}
+	tables := make([]string, 0)
+	for table := range schema.Tables {
+		if m, _ := c.Config.masks(table); len(m) > 0 {
+			tables = append(tables, table)
+		}
+	}
+	if len(tables) == 0 {
+		return ""
+	}
+	sort.Strings(tables)
+	var sb strings.Builder
+	sb.WriteString("\nThese columns are masked. They can be selected, but come back masked, and SELECT * on their tables isn't allowed:\n\n")
+	for _, table := range tables {
+		m, _ := c.Config.masks(table)
+		columns := make([]string, 0, len(m))
+		for column, mask := range m {
+			columns = append(columns, fmt.Sprintf("%s.%s (%s)", table, column, mask))
+		}
+		sort.Strings(columns)
+		sb.WriteString(strings.Join(columns, ", ") + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,84 @@
+package gorag
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// maskClient masks people.ssn (partial) and people.email (email)
+func maskClient(t *testing.T) *Client {
+	t.Helper()
+	withDriver(t, "postgres")
+	config := newConfig("")
+	err := json.Unmarshal([]byte(`{"tables": {"people": {"columns": {
+		"ssn": {"mask": "partial"},
+		"email": {"tags": ["pii"]}
+	}}}, "mask_tags": {"pii": "email"}}`), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Client{Config: config}
+}
+
+func TestApplyMasks(t *testing.T) {
+	c := maskClient(t)
+	ssn := `'****' || right(ssn::text, 4)`
+	cases := []struct {
+		query string
+		want  string // the query that runs, or the error
+	}{
+		{`SELECT name FROM people`, `SELECT name FROM people`},
+		{`SELECT ssn FROM orders`, `SELECT ssn FROM orders`},
+		{`SELECT ssn FROM people`, `SELECT ` + ssn + ` AS ssn FROM people`},
+		{`SELECT p.ssn FROM people p`, `SELECT '****' || right(p.ssn::text, 4) AS ssn FROM people p`},
+		{`SELECT ssn AS s FROM people`, `SELECT (` + ssn + `) AS s FROM people`},
+		{`SELECT count(*) FROM people WHERE ssn = '1'`, `SELECT count(*) FROM people WHERE ssn = '1'`},
+
+		// subqueries and CTEs mask where the column is selected
+		{`SELECT ssn FROM (SELECT ssn FROM people) t`, `SELECT ssn FROM (SELECT ` + ssn + ` AS ssn FROM people) t`},
+		{`WITH t AS (SELECT ssn FROM people) SELECT ssn FROM t`, `WITH t AS (SELECT ` + ssn + ` AS ssn FROM people) SELECT ssn FROM t`},
+		{`SELECT name FROM people WHERE id IN (SELECT id FROM people WHERE ssn LIKE '1%')`, `SELECT name FROM people WHERE id IN (SELECT id FROM people WHERE ssn LIKE '1%')`},
+
+		// what would read every column is refused
+		{`SELECT * FROM people`, "SELECT * would read masked columns"},
+		{`SELECT p.* FROM people p`, "p.* would read masked columns"},
+		{`SELECT people FROM people`, "whole row"},
+		{`SELECT ssn FROM (SELECT * FROM people) t`, "SELECT * would read masked columns"},
+		{`WITH t AS (SELECT * FROM people) SELECT ssn FROM t`, "SELECT * would read masked columns"},
+
+		// and so is TABLE, wherever it is
+		{`TABLE people`, "TABLE people would read masked columns"},
+		{`TABLE ONLY people`, "TABLE people would read masked columns"},
+		{`TABLE public.people`, "TABLE public.people would read masked columns"},
+		{`SELECT ssn FROM (TABLE people) t`, "TABLE people would read masked columns"},
+		{`WITH t AS (TABLE people) SELECT ssn FROM t`, "TABLE people would read masked columns"},
+		{`SELECT 1 UNION ALL TABLE people`, "TABLE people would read masked columns"},
+		{`TABLE orders`, `TABLE orders`},
+
+		// the table is found however the FROM list names it
+		{`WITH people AS (SELECT ssn FROM people) SELECT ssn FROM people`, `WITH people AS (SELECT ` + ssn + ` AS ssn FROM people) SELECT`},
+		{`SELECT ssn FROM generate_series(1,1) g, people`, `SELECT ` + ssn + ` AS ssn FROM generate_series(1,1) g, people`},
+		{`SELECT ssn FROM orders "o", people`, `SELECT ` + ssn + ` AS ssn FROM orders "o", people`},
+		{`SELECT ssn FROM (people)`, `SELECT ` + ssn + ` AS ssn FROM (people)`},
+		{`SELECT people.ssn FROM people FINAL`, `SELECT '****' || right(people.ssn::text, 4) AS ssn FROM people FINAL`},
+
+		// an alias' column list would rename the masked columns
+		{`SELECT a FROM people AS p(a)`, "renames masked columns"},
+		{`SELECT a FROM people p (a, b)`, "renames masked columns"},
+		{`SELECT a FROM orders AS o(a)`, `SELECT a FROM orders AS o(a)`},
+
+		// and a FROM list that can't be told apart is refused
+		{`SELECT ssn FROM 'people.csv'`, "can't tell which tables"},
+		{`SELECT ssn FROM orders, (people`, "can't tell which tables"},
+	}
+	for _, tc := range cases {
+		got, err := c.applyMasks(tc.query)
+		if err != nil {
+			got = err.Error()
+		}
+		if !strings.Contains(got, tc.want) {
+			t.Errorf("applyMasks(%q)\n got %s\nwant %s", tc.query, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+  gorag migrate draft "add a nullable archived_at to orders" has the
+  model write a migration and its down migration, and writes them to
+  files for review. Nothing is ever run. The draft is checked against
+  the schema we know: tables it alters or drops must exist, columns it
+  adds must not. If the check finds problems, the model gets one try
+  at fixing them, and anything left is written at the top of the file.
+  A deployment has to turn this on with "allow_migration_drafts".
+*/
+type migrationDraft struct {
+	Up   string `json:"up"`
+	Down string `json:"down"`
+}
+
+// addNotColumn is what can follow ADD in ALTER TABLE that isn't a column name
+var addNotColumn = map[string]bool{"CONSTRAINT": true, "PRIMARY": true, "UNIQUE": true, "FOREIGN": true, "CHECK": true, "EXCLUDE": true}
+
+// migrationProblems checks statements against the schema, without running anything
+func migrationProblems(schema *DBMetadata, script string) []string {
+	problems := make([]string, 0)
+	bare := func(t string) string {
+		if i := strings.LastIndex(t, "."); i >= 0 {
+			return t[i+1:]
+		}
+		return t
+	}
+	exists := func(t string) bool {
+		_, ok := schema.Tables[bare(t)]
+		return ok
+	}
+	for _, stmt := range splitStatements(lexSQL(script)) {
+		words := func(i int) string {
+			if i < len(stmt) {
+				return stmt[i].upper()
+			}
+			return ""
+		}
+		// skip IF [NOT] EXISTS, which makes the statement safe either way
+		skipIf := func(i int) (int, bool) {
+			if words(i) == "IF" && words(i+1) == "NOT" && words(i+2) == "EXISTS" {
+				return i + 3, true
+			}
+			if words(i) == "IF" && words(i+1) == "EXISTS" {
+				return i + 2, true
+			}
+			return i, false
+		}
+		switch {
+		case words(0) == "CREATE" && words(1) == "TABLE":
+			i, guarded := skipIf(2)
+			if name, _ := qualifiedName(stmt, i); name != "" && exists(name) && !guarded {
+				problems = append(problems, fmt.Sprintf("table %s already exists", name))
+			}
+		case words(0) == "DROP" && words(1) == "TABLE":
+			i, guarded := skipIf(2)
+			if name, _ := qualifiedName(stmt, i); name != "" && !exists(name) && !guarded {
+				problems = append(problems, fmt.Sprintf("there is no table %s to drop", name))
+			}
+		case words(0) == "ALTER" && words(1) == "TABLE":
+			i, _ := skipIf(2)
+			if words(i) == "ONLY" {
+				i++
+			}
+			name, next := qualifiedName(stmt, i)
+			if name == "" {
+				continue
+			}
+			if !exists(name) {
+				problems = append(problems, fmt.Sprintf("there is no table %s to alter", name))
+				continue
+			}
+			for j := next; j < len(stmt); j++ {
+				action := words(j)
+				if action != "ADD" && action != "DROP" && action != "RENAME" && action != "ALTER" {
+					continue
+				}
+				k := j + 1
+				if words(k) == "COLUMN" {
+					k++
+				} else if action != "ADD" || addNotColumn[words(k)] {
+					// ADD CONSTRAINT, DROP CONSTRAINT, RENAME TO and so on
+					continue
+				}
+				k, guarded := skipIf(k)
+				column := ""
+				if k < len(stmt) {
+					column = stmt[k].ident()
+				}
+				if column == "" || guarded {
+					continue
+				}
+				has := schema.hasColumn(bare(name), column)
+				if action == "ADD" && has {
+					problems = append(problems, fmt.Sprintf("column %s.%s already exists", name, column))
+				}
+				if action != "ADD" && !has {
+					problems = append(problems, fmt.Sprintf("there is no column %s.%s", name, column))
+				}
+			}
+		case words(0) == "CREATE" && (words(1) == "INDEX" || words(1) == "UNIQUE"):
+			for j := 2; j < len(stmt); j++ {
+				if words(j) == "ON" {
+					k := j + 1
+					if words(k) == "ONLY" {
+						k++
+					}
+					if name, _ := qualifiedName(stmt, k); name != "" && !exists(name) {
+						problems = append(problems, fmt.Sprintf("there is no table %s to index", name))
+					}
+					break
+				}
+			}
+		}
+	}
+	return problems
+}
+
+func (c *Client) draftMigration(description string) (*migrationDraft, []string, error) {
+	prompt := fmt.Sprintf(`
+You are writing a PostgreSQL schema migration. The current schema is:
+
+%s
+%s
+Write the migration for: %s
+
+Also write the down migration that undoes it. Prefer changes that are safe
+on a live database (nullable columns, CREATE INDEX CONCURRENTLY outside a
+transaction), and say so in a SQL comment when something isn't.
+http response must be application/json:
+{ "up": "SQL ...", "down": "SQL ..." }
+`, formatSchema(c.Schema), relationshipsPrompt(c.Schema, description), description)
+	var d migrationDraft
+	if err := c.llmJSON(prompt, &d); err != nil {
+		return nil, nil, fmt.Errorf("failed to draft migration: %v", err)
+	}
+	problems := migrationProblems(c.Schema, d.Up)
+	if len(problems) > 0 {
+		log.Printf("Draft has problems, asking for a fix: %s", strings.Join(problems, "; "))
+		fix := prompt + fmt.Sprintf("\nA previous draft was\n\n%s\n\nwhich doesn't fit the schema: %s\n", d.Up, strings.Join(problems, "; "))
+		var fixed migrationDraft
+		if err := c.llmJSON(fix, &fixed); err == nil {
+			d = fixed
+			problems = migrationProblems(c.Schema, d.Up)
+		}
+	}
+	return &d, problems, nil
+}
+
+var slugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+func migrationSlug(description string) string {
+	slug := strings.Trim(slugChars.ReplaceAllString(strings.ToLower(description), "_"), "_")
+	if len(slug) > 40 {
+		slug = strings.TrimRight(slug[:40], "_")
+	}
+	return slug
+}
+
+func runMigrate(args []string) {
+	if len(args) == 0 || args[0] != "draft" {
+		log.Fatalf("usage: gorag migrate draft [-dir migrations] \"what to change\"")
+	}
+	fs := commandFlags("migrate draft")
+	dir := fs.String("dir", "migrations", "where to write the .up.sql and .down.sql files")
+	fs.Parse(args[1:])
+	if err := requirePostgres("gorag migrate"); err != nil {
+		log.Fatalf("%v", err)
+	}
+	description := strings.Join(fs.Args(), " ")
+	if description == "" {
+		log.Fatalf("Say what the migration should do")
+	}
+
+	client, done := setupClient()
+	defer done()
+	client.Config.mu.RLock()
+	allowed := client.Config.AllowMigrationDrafts
+	client.Config.mu.RUnlock()
+	if !allowed {
+		log.Fatalf("Migration drafts are off; set \"allow_migration_drafts\": true in %s", *configFile)
+	}
+
+	d, problems, err := client.draftMigration(description)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	header := fmt.Sprintf("-- Drafted by gorag migrate from: %s\n-- Review before running; nothing has been run.\n", description)
+	for _, p := range problems {
+		header += "-- PROBLEM: " + p + "\n"
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		log.Fatalf("Failed to create %s: %v", *dir, err)
+	}
+	base := filepath.Join(*dir, time.Now().UTC().Format("20060102150405")+"_"+migrationSlug(description))
+	for suffix, sql := range map[string]string{".up.sql": d.Up, ".down.sql": d.Down} {
+		if err := os.WriteFile(base+suffix, []byte(header+"\n"+strings.TrimSpace(sql)+"\n"), 0644); err != nil {
+			log.Fatalf("Failed to write migration: %v", err)
+		}
+	}
+	log.Printf("Wrote %s.up.sql and %s.down.sql", base, base)
+	if len(problems) > 0 {
+		log.Printf("The draft still has problems: %s", strings.Join(problems, "; "))
+	}
+}
@@ -0,0 +1,66 @@
+package gorag
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+/*
+  -llm mock answers without calling anyone, for load tests and for
+  running the plumbing offline. A request that is already SQL gets
+  itself back as the query, as the real model is told to do, so
+  replaying audited queries puts real load on the database. Anything
+  else gets a trivial query. -mock-latency stands in for the time a
+  model takes.
+*/
+var llmProvider = Flags.String("llm", "openai", "openai, ollama for a local server (see -ollama-host), bedrock for AWS Bedrock (see -bedrock-model), one the program registered, or mock to answer without a model (load tests, offline runs)")
+var mockLatency = Flags.Duration("mock-latency", 0, "how long the mock model takes to answer, eg: 800ms")
+
+type mockProvider struct{}
+
+// Complete answers the last message with a query
+func (mockProvider) Complete(ctx context.Context, messages []Message, opts CompletionOptions) (string, error) {
+	time.Sleep(*mockLatency)
+	prompt := ""
+	if len(messages) > 0 {
+		prompt = messages[len(messages)-1].Content
+	}
+	query := "SELECT 1 AS mock"
+	if i := strings.LastIndex(prompt, "User's request:"); i >= 0 {
+		request := strings.TrimSpace(prompt[i+len("User's request:"):])
+		if ops := sqlOperations(request); len(ops) == 1 && ops[0] == "SELECT" {
+			query = request
+		}
+	}
+	content, err := json.Marshal(map[string]string{"query": query})
+	if err != nil {
+		return "", err
+	}
+	return "Mock answer: " + string(content), nil
+}
+
+func (mockProvider) Embed(ctx context.Context, texts []string, opts CompletionOptions) ([][]float64, error) {
+	return mockEmbeddings(texts), nil
+}
+
+func (mockProvider) EmbeddingModel() string {
+	return "mock"
+}
+
+// mockEmbeddings are stable per text, so pruning still picks something
+func mockEmbeddings(texts []string) [][]float64 {
+	vectors := make([][]float64, len(texts))
+	for i, t := range texts {
+		v := make([]float64, 64)
+		for _, word := range strings.Fields(strings.ToLower(t)) {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			v[h.Sum32()%64]++
+		}
+		vectors[i] = v
+	}
+	return vectors
+}
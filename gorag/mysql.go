@@ -0,0 +1,149 @@
+package gorag
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+/*
+  With -driver mysql (or MariaDB), the schema is the current database's
+  information_schema: columns, keys and comments. There are no pg_stats
+  to describe values with, so that prompt part is empty. The dsn is the
+  driver's, user:password@tcp(host:3306)/dbname, which GORAG_DSN can
+  give whole.
+*/
+const mysqlPrompt = `
+This is MySQL, not PostgreSQL. Quote identifiers with backticks, never double
+quotes, which are strings. There is no ILIKE (LIKE ignores case in most
+collations), no :: casts (use CAST(x AS CHAR), CAST(x AS DECIMAL(20,2)) or
+CAST(x AS DATE)), no FULL OUTER JOIN, no FILTER or DISTINCT ON, and booleans
+are 0 and 1. Use DATE_FORMAT, DATE_SUB(CURDATE(), INTERVAL 7 DAY), TIMESTAMPDIFF
+and CONCAT for dates and strings.
+`
+
+func init() {
+	RegisterDialect("mysql", builtinDialect{
+		name:       "MySQL",
+		hints:      mysqlPrompt,
+		dsn:        mysqlDSN,
+		connect:    openMySQL,
+		introspect: getMySQLSchema,
+		quote:      func(name string) string { return "`" + strings.ReplaceAll(name, "`", "``") + "`" },
+	})
+}
+
+func mysqlDSN(user, password, dbname, host string) string {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "3306")
+	}
+	cfg := mysql.NewConfig()
+	cfg.User = user
+	cfg.Passwd = password
+	cfg.Net = "tcp"
+	cfg.Addr = host
+	cfg.DBName = dbname
+	return cfg.FormatDSN()
+}
+
+// openMySQL is sql.Open, with the egress guard on the connections when there is one
+func openMySQL(dsn string) (*sql.DB, error) {
+	if egress != nil {
+		mysql.RegisterDialContext("tcp", func(ctx context.Context, addr string) (net.Conn, error) {
+			return egress.DialContext(ctx, "tcp", addr)
+		})
+	}
+	return sql.Open("mysql", dsn)
+}
+
+func getMySQLSchema(db *sql.DB) (*DBMetadata, error) {
+	rows, err := db.Query(`
+		SELECT table_name, column_name, column_comment
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE()
+		ORDER BY table_name, ordinal_position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metadata := DBMetadata{Tables: make(map[string][]string), Comments: make(map[string]string)}
+	for rows.Next() {
+		var table, column, comment string
+		if err := rows.Scan(&table, &column, &comment); err != nil {
+			return nil, err
+		}
+		metadata.Tables[table] = append(metadata.Tables[table], column)
+		if comment != "" {
+			metadata.Comments[table+"."+column] = comment
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// keys and table comments are nice to have, so a failure here is not fatal
+	if metadata.ForeignKeys, metadata.PrimaryKeys, err = getMySQLKeys(db); err != nil {
+		log.Printf("Failed to retrieve keys: %v", err)
+	}
+	if err := getMySQLTableComments(db, metadata.Comments); err != nil {
+		log.Printf("Failed to retrieve comments: %v", err)
+	}
+	return &metadata, nil
+}
+
+func getMySQLKeys(db *sql.DB) ([]ForeignKey, map[string][]string, error) {
+	rows, err := db.Query(`
+		SELECT constraint_name, table_name, column_name,
+			COALESCE(referenced_table_name, ''), COALESCE(referenced_column_name, '')
+		FROM information_schema.key_column_usage
+		WHERE table_schema = DATABASE()
+		ORDER BY table_name, constraint_name, ordinal_position`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	// one row per column, and a foreign key's columns pair up by position
+	primary := make(map[string][]string)
+	fks := make([]ForeignKey, 0)
+	for rows.Next() {
+		var fk ForeignKey
+		if err := rows.Scan(&fk.Name, &fk.Table, &fk.Column, &fk.RefTable, &fk.RefColumn); err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case fk.Name == "PRIMARY":
+			primary[fk.Table] = append(primary[fk.Table], fk.Column)
+		case fk.RefTable != "":
+			fks = append(fks, fk)
+		}
+	}
+	return fks, primary, rows.Err()
+}
+
+func getMySQLTableComments(db *sql.DB, comments map[string]string) error {
+	rows, err := db.Query(`
+		SELECT table_name, table_comment
+		FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_comment <> ''`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, comment string
+		if err := rows.Scan(&table, &comment); err != nil {
+			return err
+		}
+		// views say VIEW, which isn't a description
+		if strings.EqualFold(comment, "VIEW") {
+			continue
+		}
+		comments[table] = comment
+	}
+	return rows.Err()
+}
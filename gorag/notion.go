@@ -0,0 +1,177 @@
+package gorag
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+  notion:DATABASE_ID is the pages of a Notion database, as the
+  integration whose NOTION_TOKEN this is can see them (the database has
+  to be shared with it). A page's text is its blocks, nested ones
+  included, but not the pages and databases inside it. Notion allows
+  about three requests a second, so they are paced to that.
+*/
+const (
+	notionAPI     = "https://api.notion.com/v1"
+	notionVersion = "2022-06-28"
+	notionPace    = 350 * time.Millisecond
+	notionDepth   = 5 // of nested blocks
+)
+
+type notion struct {
+	database string
+	header   http.Header
+	last     time.Time
+}
+
+func newNotion(database string) (*notion, error) {
+	database = strings.ReplaceAll(database, "-", "")
+	if len(database) != 32 {
+		return nil, fmt.Errorf("notion needs a database id, the 32 hex digits in its url, eg: notion:0123456789abcdef0123456789abcdef")
+	}
+	token := os.Getenv("NOTION_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("notion needs NOTION_TOKEN, an integration's secret")
+	}
+	h := make(http.Header)
+	h.Set("Authorization", "Bearer "+token)
+	h.Set("Notion-Version", notionVersion)
+	return &notion{database: database, header: h}, nil
+}
+
+func (n *notion) Source() string {
+	return "notion:" + n.database
+}
+
+func (n *notion) request(method, u string, in, out interface{}) error {
+	if wait := notionPace - time.Since(n.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	n.last = time.Now()
+	found, err := jsonRequest(method, u, n.header, in, out)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%s is not found, or not shared with the integration", u)
+	}
+	return nil
+}
+
+type notionText []struct {
+	PlainText string `json:"plain_text"`
+}
+
+func (t notionText) String() string {
+	var sb strings.Builder
+	for _, r := range t {
+		sb.WriteString(r.PlainText)
+	}
+	return sb.String()
+}
+
+func (n *notion) Crawl(since time.Time, page func(Page) error) error {
+	body := map[string]interface{}{
+		"page_size": 100,
+		"sorts":     []interface{}{map[string]interface{}{"timestamp": "last_edited_time", "direction": "ascending"}},
+	}
+	if !since.IsZero() {
+		body["filter"] = map[string]interface{}{
+			"timestamp":        "last_edited_time",
+			"last_edited_time": map[string]interface{}{"on_or_after": since.Format(time.RFC3339)},
+		}
+	}
+	for {
+		var out struct {
+			Results []struct {
+				ID             string    `json:"id"`
+				URL            string    `json:"url"`
+				LastEditedTime time.Time `json:"last_edited_time"`
+				Properties     map[string]struct {
+					Type  string     `json:"type"`
+					Title notionText `json:"title"`
+				} `json:"properties"`
+			} `json:"results"`
+			HasMore    bool   `json:"has_more"`
+			NextCursor string `json:"next_cursor"`
+		}
+		if err := n.request("POST", notionAPI+"/databases/"+n.database+"/query", body, &out); err != nil {
+			return fmt.Errorf("failed to query notion: %v", err)
+		}
+		for _, r := range out.Results {
+			p := Page{ID: r.ID, URL: r.URL, Updated: r.LastEditedTime}
+			for _, prop := range r.Properties {
+				if prop.Type == "title" {
+					p.Title = prop.Title.String()
+				}
+			}
+			lines := make([]string, 0)
+			if err := n.blocks(r.ID, 0, &lines); err != nil {
+				return fmt.Errorf("failed to read %s: %v", p.URL, err)
+			}
+			p.Text = strings.Join(lines, "\n")
+			if err := page(p); err != nil {
+				return err
+			}
+		}
+		if !out.HasMore || out.NextCursor == "" {
+			return nil
+		}
+		body["start_cursor"] = out.NextCursor
+	}
+}
+
+// notionPrefixes mark the blocks that aren't paragraphs the way markdown would
+var notionPrefixes = map[string]string{
+	"heading_1": "# ", "heading_2": "## ", "heading_3": "### ",
+	"bulleted_list_item": "- ", "numbered_list_item": "- ", "to_do": "- ",
+	"quote": "> ", "callout": "> ",
+}
+
+// blocks appends the text of a block's children, and theirs, a line each
+func (n *notion) blocks(id string, depth int, lines *[]string) error {
+	cursor := ""
+	for {
+		q := url.Values{"page_size": {"100"}}
+		if cursor != "" {
+			q.Set("start_cursor", cursor)
+		}
+		var out struct {
+			Results    []map[string]json.RawMessage `json:"results"`
+			HasMore    bool                         `json:"has_more"`
+			NextCursor string                       `json:"next_cursor"`
+		}
+		if err := n.request("GET", notionAPI+"/blocks/"+id+"/children?"+q.Encode(), nil, &out); err != nil {
+			return err
+		}
+		for _, b := range out.Results {
+			var kind, child string
+			var hasChildren bool
+			json.Unmarshal(b["type"], &kind)
+			json.Unmarshal(b["id"], &child)
+			json.Unmarshal(b["has_children"], &hasChildren)
+			var content struct {
+				RichText notionText `json:"rich_text"`
+			}
+			json.Unmarshal(b[kind], &content)
+			if text := strings.TrimSpace(content.RichText.String()); text != "" {
+				*lines = append(*lines, notionPrefixes[kind]+text)
+			}
+			if hasChildren && depth < notionDepth && kind != "child_page" && kind != "child_database" {
+				if err := n.blocks(child, depth+1, lines); err != nil {
+					return err
+				}
+			}
+		}
+		if !out.HasMore || out.NextCursor == "" {
+			return nil
+		}
+		cursor = out.NextCursor
+	}
+}
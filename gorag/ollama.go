@@ -0,0 +1,218 @@
+package gorag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+/*
+  -llm ollama talks to a local Ollama server's own api instead of an
+  OpenAI one, so nothing leaves the host: chat (and pictures) go to
+  /api/chat, embeddings to /api/embed, and -warm checks /api/tags has
+  the models pulled. With -no-external-calls and -allow-hosts
+  localhost, the whole pipeline runs air-gapped:
+
+    ollama pull llama3 && ollama pull nomic-embed-text
+    ./gorag -llm ollama -ollama-model sqlcoder -no-external-calls -allow-hosts localhost
+
+  A profile's sql_model or data_model url is then another Ollama
+  server, and its model one pulled there. No api key is sent.
+*/
+var ollamaHost = Flags.String("ollama-host", ollamaHostDefault(), "with -llm ollama, the ollama server")
+var ollamaModel = Flags.String("ollama-model", "llama3", "with -llm ollama, the chat model, eg: sqlcoder")
+var ollamaEmbedModel = Flags.String("ollama-embed-model", "nomic-embed-text", "with -llm ollama, the embedding model")
+
+func ollamaHostDefault() string {
+	if h := os.Getenv("OLLAMA_HOST"); h != "" {
+		if !strings.Contains(h, "://") {
+			h = "http://" + h
+		}
+		return h
+	}
+	return "http://localhost:11434"
+}
+
+type ollamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"` // base64, without the data url prefix
+}
+
+type ollamaChatRequest struct {
+	Model    string                 `json:"model"`
+	Messages []ollamaMessage        `json:"messages"`
+	Stream   bool                   `json:"stream"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+	Format   interface{}            `json:"format,omitempty"` // "json", or a json schema
+}
+
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+	Done            bool          `json:"done"` // the last piece of a streamed reply
+}
+
+// ollamaMessages are the messages as Ollama has them, with the pictures in base64
+func ollamaMessages(messages []Message) []ollamaMessage {
+	out := make([]ollamaMessage, 0, len(messages))
+	for _, m := range messages {
+		msg := ollamaMessage{Role: m.Role, Content: m.Content}
+		if len(m.Images) > 0 {
+			msg.Images = base64Images(m.Images)
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+// ollamaPost posts json to the server's api, and returns the body of a 200
+func ollamaPost(ctx context.Context, t CompletionOptions, path string, v interface{}) ([]byte, error) {
+	resp, err := ollamaOpen(ctx, t, path, v)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// ollamaOpen posts json to the server's api, leaving the caller to read a 200
+func ollamaOpen(ctx context.Context, t CompletionOptions, path string, v interface{}) (*http.Response, error) {
+	requestBody, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(t.URL, "/")+path, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.TraceParent != "" {
+		req.Header.Set("traceparent", t.TraceParent)
+		if t.TraceState != "" {
+			req.Header.Set("tracestate", t.TraceState)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("ollama: %s", e.Error)
+		}
+		return nil, fmt.Errorf("ollama: %s: %s", resp.Status, body)
+	}
+	return resp, nil
+}
+
+type ollamaProvider struct{}
+
+func (ollamaProvider) Endpoint() (string, string) {
+	return *ollamaHost, *ollamaModel
+}
+
+// Complete sends a chat request to /api/chat
+func (ollamaProvider) Complete(ctx context.Context, messages []Message, opts CompletionOptions) (string, error) {
+	options := map[string]interface{}{"temperature": opts.Temperature}
+	if opts.MaxTokens > 0 {
+		options["num_predict"] = opts.MaxTokens
+	}
+	if opts.TopP > 0 {
+		options["top_p"] = opts.TopP
+	}
+	format := ollamaFormat(opts)
+	request := ollamaChatRequest{
+		Model:    opts.Model,
+		Messages: ollamaMessages(messages),
+		Options:  options,
+		Format:   format,
+	}
+	if opts.OnToken != nil && format == nil {
+		return ollamaStream(ctx, opts, request)
+	}
+	body, err := ollamaPost(ctx, opts, "/api/chat", request)
+	// older servers only know "json"
+	if err != nil && format != nil && refuseFormat(opts, err.Error()) {
+		return ollamaProvider{}.Complete(ctx, messages, opts)
+	}
+	if err != nil {
+		return "", err
+	}
+	var out ollamaChatResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return "", fmt.Errorf("ollama: %v", err)
+	}
+	if opts.OnUsage != nil {
+		opts.OnUsage(out.PromptEvalCount, out.EvalCount)
+	}
+	return out.Message.Content, nil
+}
+
+func (ollamaProvider) EmbeddingModel() string {
+	return *ollamaEmbedModel
+}
+
+// Embed gets one embedding per text from /api/embed
+func (ollamaProvider) Embed(ctx context.Context, texts []string, opts CompletionOptions) ([][]float64, error) {
+	body, err := ollamaPost(ctx, CompletionOptions{URL: *ollamaHost}, "/api/embed", map[string]interface{}{
+		"model": *ollamaEmbedModel,
+		"input": texts,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("ollama: %v", err)
+	}
+	if len(out.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("embeddings: asked for %d, got %d", len(texts), len(out.Embeddings))
+	}
+	return out.Embeddings, nil
+}
+
+// Check is that the server answers, and has the model pulled
+func (ollamaProvider) Check(ctx context.Context, t CompletionOptions) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimRight(t.URL, "/")+"/api/tags", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("can't reach %s: %v", t.URL, err)
+	}
+	defer resp.Body.Close()
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return fmt.Errorf("%s/api/tags: %v", t.URL, err)
+	}
+	for _, m := range tags.Models {
+		// llama3 is llama3:latest
+		if m.Name == t.Model || m.Name == t.Model+":latest" {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s doesn't have %s, run: ollama pull %s", t.URL, t.Model, t.Model)
+}
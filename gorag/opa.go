@@ -0,0 +1,152 @@
+package gorag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+/*
+  When -opa is set, every generated query is put in front of an Open
+  Policy Agent decision before it runs, so the security team can keep
+  its policies in a rego bundle instead of in our config. The url is a
+  data api path, eg: http://localhost:8181/v1/data/gorag/decision
+
+  The decision can be a bare boolean, or an object:
+
+    { "allow": false, "reason": "..." }
+    { "allow": true, "query": "<rewritten sql>", "reason": "..." }
+
+  Anything we can't get a clear answer for is a deny. Each decision
+  is an opa audit event, tagged allow, deny or rewrite, with the
+  rewritten query when there is one. A rewritten
+  query gets no more trust than a generated one: Validate checks it
+  again from the start, read-only or write rules, table access, masks
+  and group sizes included.
+*/
+type OPAInput struct {
+	User          string   `json:"user"`
+	Profile       string   `json:"profile,omitempty"`
+	Prompt        string   `json:"prompt"`
+	Query         string   `json:"query"`
+	Tables        []string `json:"tables"`
+	Operations    []string `json:"operations"`
+	EstimatedCost float64  `json:"estimated_cost"`
+	EstimatedRows float64  `json:"estimated_rows"`
+}
+
+type OPADecision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+	Query  string `json:"query,omitempty"`
+}
+
+func (d *OPADecision) UnmarshalJSON(data []byte) error {
+	var allow bool
+	if err := json.Unmarshal(data, &allow); err == nil {
+		d.Allow = allow
+		return nil
+	}
+	type decision OPADecision
+	return json.Unmarshal(data, (*decision)(d))
+}
+
+var opaClient = &http.Client{Timeout: 10 * time.Second}
+
+func queryOPA(ctx context.Context, url string, input OPAInput) (*OPADecision, error) {
+	requestBody, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := opaClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("opa: %s: %s", resp.Status, body)
+	}
+	var out struct {
+		Result *OPADecision `json:"result"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, err
+	}
+	if out.Result == nil {
+		return nil, fmt.Errorf("opa returned no decision, is the policy loaded?")
+	}
+	return out.Result, nil
+}
+
+// checkOPA returns the query to run, which the policy may have rewritten
+func (c *Client) checkOPA(q Question, query string) (string, error) {
+	if c.OPAURL == "" {
+		return query, nil
+	}
+	input := OPAInput{
+		User:          q.User,
+		Profile:       c.Profile,
+		Prompt:        q.Prompt,
+		Query:         query,
+		Tables:        referencedTables(query),
+		Operations:    sqlOperations(query),
+		EstimatedCost: -1,
+		EstimatedRows: -1,
+	}
+	ctx, cancel := c.queryContext()
+	defer cancel()
+	if plan, err := explainQuery(ctx, c.DB, query); err == nil {
+		input.EstimatedCost = plan.TotalCost
+		input.EstimatedRows = plan.PlanRows
+	} else {
+		log.Printf("No cost estimate for opa: %v", err)
+	}
+
+	event := AuditEvent{
+		Event:   "opa",
+		RunID:   q.RunID,
+		User:    q.User,
+		Profile: c.Profile,
+		Prompt:  q.Prompt,
+		Query:   query,
+		Tables:  input.Tables,
+	}
+	decision, err := queryOPA(c.runContext(), c.OPAURL, input)
+	if err != nil {
+		log.Printf("OPA deny user=%s tables=%v: %v", q.User, input.Tables, err)
+		event.Tags, event.Error = []string{"deny"}, err.Error()
+		c.Audit.Record(event)
+		return "", fmt.Errorf("policy check failed: %v", err)
+	}
+	event.Reason = decision.Reason
+	if !decision.Allow {
+		log.Printf("OPA deny user=%s tables=%v: %s", q.User, input.Tables, decision.Reason)
+		event.Tags = []string{"deny"}
+		c.Audit.Record(event)
+		return "", fmt.Errorf("query denied by policy: %s", decision.Reason)
+	}
+	if decision.Query != "" && decision.Query != query {
+		log.Printf("OPA modify user=%s tables=%v: %s", q.User, input.Tables, decision.Reason)
+		event.Tags, event.Rewrite = []string{"rewrite"}, decision.Query
+		c.Audit.Record(event)
+		return decision.Query, nil
+	}
+	log.Printf("OPA allow user=%s tables=%v", q.User, input.Tables)
+	event.Tags = []string{"allow"}
+	c.Audit.Record(event)
+	return query, nil
+}
@@ -0,0 +1,78 @@
+package gorag
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// opaRewriting is a policy that allows every query, rewritten to the one given
+func opaRewriting(t *testing.T, query string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"result": {"allow": true, "query": %q}}`, query)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestValidateOPARewrite(t *testing.T) {
+	withDriver(t, "sqlite")
+	cases := []struct {
+		rewrite string
+		want    string // the query that runs, or the error
+	}{
+		{`SELECT id FROM orders WHERE owner = 'me'`, `SELECT id FROM orders WHERE owner = 'me'`},
+		{`DELETE FROM orders`, "the policy's rewrite was refused"},
+		{`SELECT 1; DROP TABLE orders`, "the policy's rewrite was refused"},
+		{`SELECT 'abc`, "the policy's rewrite was refused"},
+	}
+	for _, tc := range cases {
+		c := &Client{OPAURL: opaRewriting(t, tc.rewrite), Schema: &DBMetadata{}}
+		got, err := c.Validate(&Question{User: "u"}, `SELECT id FROM orders`)
+		if err != nil {
+			got = err.Error()
+		}
+		if !strings.Contains(got, tc.want) {
+			t.Errorf("Validate with a rewrite to %q\n got %s\nwant %s", tc.rewrite, got, tc.want)
+		}
+	}
+}
+
+func TestOPAAudit(t *testing.T) {
+	withDriver(t, "sqlite")
+	cases := []struct {
+		decision string
+		want     string // in the audit event
+	}{
+		{`{"result": true}`, `"tags":["allow"]`},
+		{`{"result": {"allow": false, "reason": "no"}}`, `"tags":["deny"],"reason":"no"`},
+		{`{"result": {"allow": true, "query": "SELECT id FROM orders LIMIT 1"}}`, `"tags":["rewrite"],"rewrite":"SELECT id FROM orders LIMIT 1"`},
+		{`{}`, `"tags":["deny"],"error":"opa returned no decision`},
+	}
+	for _, tc := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, tc.decision)
+		}))
+		filename := filepath.Join(t.TempDir(), "audit.jsonl")
+		audit, err := openAuditLog(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &Client{OPAURL: server.URL, Audit: audit}
+		c.checkOPA(Question{User: "u"}, `SELECT id FROM orders`)
+		server.Close()
+		audit.Close()
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(data); !strings.Contains(got, `"event":"opa"`) || !strings.Contains(got, tc.want) {
+			t.Errorf("the audit event for %s\n got %s\nwant %s", tc.decision, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+/*
+  gorag optimize looks at one slow query: its plan, the indexes on the
+  tables it reads, and the schema, and has the model suggest rewrites
+  and indexes. The rewritten query it proposes is planned with EXPLAIN
+  before it is shown, so a candidate that doesn't even parse is never
+  offered, and the two estimated costs can be compared. -analyze uses
+  EXPLAIN ANALYZE, which runs the query, so it is opt in, only for a
+  plain SELECT, and inside a transaction that is rolled back.
+*/
+type optimizeAdvice struct {
+	Suggestions []string `json:"suggestions"`
+	Indexes     []string `json:"indexes"`
+	Query       string   `json:"query"`
+}
+
+// indexDefinitions is the CREATE INDEX text for every index on these tables
+func (c *Client) indexDefinitions(tables []string) ([]string, error) {
+	rows, err := c.DB.Query(`
+		SELECT indexdef FROM pg_indexes
+		WHERE schemaname = 'public' AND tablename = ANY($1)
+		ORDER BY tablename, indexname
+	`, pq.Array(tables))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	defs := make([]string, 0)
+	for rows.Next() {
+		var def string
+		if err := rows.Scan(&def); err != nil {
+			return nil, err
+		}
+		defs = append(defs, def)
+	}
+	return defs, rows.Err()
+}
+
+// textPlan is EXPLAIN's text output, with ANALYZE if asked for
+func (c *Client) textPlan(query string, analyze bool) (string, error) {
+	explain := "EXPLAIN "
+	if analyze {
+		explain = "EXPLAIN (ANALYZE, BUFFERS) "
+	}
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(explain + query)
+	if err != nil {
+		return "", fmt.Errorf("failed to explain query: %v", err)
+	}
+	defer rows.Close()
+	lines := make([]string, 0)
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
+
+func (c *Client) optimize(query string, analyze bool) (string, error) {
+	if ops := sqlOperations(query); analyze && (len(ops) != 1 || ops[0] != "SELECT") {
+		return "", fmt.Errorf("-analyze runs the query, so it is only allowed for a single SELECT")
+	}
+	plan, err := c.textPlan(query, analyze)
+	if err != nil {
+		return "", err
+	}
+	before, err := explainQuery(c.runContext(), c.DB, query)
+	if err != nil {
+		return "", err
+	}
+	tables := referencedTables(query)
+	indexes, err := c.indexDefinitions(tables)
+	if err != nil {
+		log.Printf("Failed to read indexes: %v", err)
+	}
+	schema := c.Schema
+	if len(tables) > 0 {
+		schema = c.Schema.subset(tables)
+	}
+
+	var advice optimizeAdvice
+	err = c.llmJSON(fmt.Sprintf(`
+You are a PostgreSQL performance expert. This query is slow:
+
+%s
+
+Its plan is:
+
+%s
+
+The tables it reads are:
+
+%s
+%s
+with these indexes:
+
+%s
+
+Suggest concrete rewrites and indexes that would make it faster, and give a
+rewritten query that returns exactly the same result. Leave query empty if a
+rewrite wouldn't help.
+http response must be application/json:
+{ "suggestions": ["..."], "indexes": ["CREATE INDEX ..."], "query": "SELECT ..." }
+`, query, plan, formatSchema(schema), statsPrompt(schema), strings.Join(indexes, "\n")), &advice)
+	if err != nil {
+		return "", fmt.Errorf("failed to get suggestions: %v", err)
+	}
+
+	var sb strings.Builder
+	kind := "Plan"
+	if analyze {
+		kind = "Plan (analyzed)"
+	}
+	sb.WriteString(fmt.Sprintf("%s:\n\n%s\n\nSuggestions:\n\n", kind, plan))
+	for _, s := range advice.Suggestions {
+		sb.WriteString("- " + s + "\n")
+	}
+	if len(advice.Indexes) > 0 {
+		sb.WriteString("\nIndexes:\n\n")
+		for _, ix := range advice.Indexes {
+			sb.WriteString(strings.TrimRight(strings.TrimSpace(ix), ";") + ";\n")
+		}
+	}
+	if advice.Query != "" {
+		after, err := explainQuery(c.runContext(), c.DB, advice.Query)
+		if err != nil {
+			sb.WriteString(fmt.Sprintf("\nThe suggested rewrite doesn't plan, so it is left out: %v\n", err))
+		} else {
+			sb.WriteString(fmt.Sprintf("\nRewritten query (estimated cost %.0f, was %.0f):\n\n%s\n", after.TotalCost, before.TotalCost, advice.Query))
+		}
+	}
+	return sb.String(), nil
+}
+
+func runOptimize(args []string) {
+	fs := commandFlags("optimize")
+	file := fs.String("file", "", "file with the slow query, - or empty for stdin")
+	analyze := fs.Bool("analyze", false, "use EXPLAIN ANALYZE, which runs the query (in a transaction that is rolled back)")
+	fs.Parse(args)
+	if err := requirePostgres("gorag optimize"); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	query := trimStatement(readQueryFile(*file))
+	if query == "" {
+		log.Fatalf("No query to optimize")
+	}
+	client, done := setupClient()
+	defer done()
+	report, err := client.optimize(query, *analyze)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	fmt.Print(report)
+}
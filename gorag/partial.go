@@ -0,0 +1,35 @@
+package gorag
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+  A question over more rows than the database sends in good time fails
+  at -query-timeout with nothing to show for the wait. With
+  -fetch-deadline, reading the rows of a question's query stops once
+  it has run that long, the query is cancelled, and the rows read so far
+  are summarized as they are: the answer is marked partial, the summary
+  is told the rows are only the first ones, and it ends with a note
+  saying so, and how to ask a narrower question that answers in time.
+  A query that hasn't sent a row by then fails, as at the timeout, and
+  is written again from that. With -summary-data aggregates the
+  summary is over every row, so only the result is partial. The agent's
+  queries and evals always read every row, since a partial result
+  there would be taken for the whole.
+*/
+var fetchDeadline = Flags.Duration("fetch-deadline", 0, "stop reading a question's rows after this long, and summarize those read as partial; 0 reads them all")
+
+// partialPrompt is the question, with what the summary of rows read up to the deadline must say
+func partialPrompt(question string) string {
+	return question + "\n\nThe rows are partial results, only those read before the fetch deadline. Say so first, " +
+		"describe only these rows, and don't total or rank them as if they were all of them. " +
+		"End by suggesting how to narrow the question so it answers in time, eg: a shorter time range, a filter, or totals rather than rows."
+}
+
+// withPartialNote is the summary with the disclaimer a partial result has
+func withPartialNote(summary string, rows int, deadline time.Duration) string {
+	return summary + fmt.Sprintf("\n\nPartial results: only the first %d rows came back within the fetch deadline of %s. "+
+		"A narrower question, over a shorter time range, with a filter, or asking for totals rather than rows, answers in full.", rows, deadline)
+}
@@ -0,0 +1,558 @@
+package gorag
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+  Ask runs a question through stages, which -pipeline pipeline.yaml
+  can reorder, leave out, or add http hooks between:
+
+    stages:
+      - route        # prompt safety rules, and the answer template
+      - retrieve     # -prune picks the tables the model sees
+      - generate     # the model writes the query
+      - hook: https://review.internal/sql
+        name: review
+        api_key_env: REVIEW_KEY
+      - validate     # policies: opa, purposes, joins, deny rules, masks, group sizes
+      - execute
+      - verify       # explain an empty result, instead of summarizing it
+      - summarize    # the summary, and the judge
+
+  That list is the default. generate, validate and execute can't be
+  left out, and the order has to make sense: route and retrieve come
+  before generate, generate before validate before execute, and verify
+  and summarize after execute. When execute fails in the database, the
+  pipeline goes back to generate with the error, -retries times.
+
+  A hook is POSTed what the run knows so far (its name, the run id,
+  profile, user, prompt, query, result, rows and summary), and can
+  answer {"error": "..."} to refuse the question, {"query": "..."} to
+  replace the query before it runs, which is validated again if
+  validate already ran, or {"summary": "..."} to replace the summary.
+  A hook that fails or can't be reached fails the question, and hooks
+  are subject to -allow-hosts like any other outgoing call.
+*/
+var pipelineFile = Flags.String("pipeline", "", "a YAML file with the stages questions go through, see gorag/pipeline.go")
+
+type PipelineStage struct {
+	Stage     string `yaml:"stage,omitempty"` // a built in stage, which can also be given as just its name
+	Hook      string `yaml:"hook,omitempty"`  // a url to POST to
+	Name      string `yaml:"name,omitempty"`
+	APIKeyEnv string `yaml:"api_key_env,omitempty"` // sent to the hook as a bearer token
+}
+
+func (s *PipelineStage) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		s.Stage = value.Value
+		return nil
+	}
+	type plain PipelineStage
+	return value.Decode((*plain)(s))
+}
+
+func (s *PipelineStage) String() string {
+	if s.Hook == "" {
+		return s.Stage
+	}
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Hook
+}
+
+var builtinStages = []string{"route", "retrieve", "generate", "validate", "execute", "verify", "summarize"}
+
+// stageOrder is what must come before each built in stage, when both are there
+var stageOrder = map[string][]string{
+	"generate":  {"route", "retrieve"},
+	"validate":  {"generate"},
+	"execute":   {"validate"},
+	"verify":    {"execute"},
+	"summarize": {"execute"},
+}
+
+func defaultPipeline() []*PipelineStage {
+	out := make([]*PipelineStage, len(builtinStages))
+	for i, name := range builtinStages {
+		out[i] = &PipelineStage{Stage: name}
+	}
+	return out
+}
+
+func loadPipeline(filename string) ([]*PipelineStage, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var p struct {
+		Stages []*PipelineStage `yaml:"stages"`
+	}
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", filename, err)
+	}
+	if err := checkPipeline(p.Stages); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return p.Stages, nil
+}
+
+func checkPipeline(stages []*PipelineStage) error {
+	at := make(map[string]int)
+	for i, s := range stages {
+		if s.Hook != "" {
+			if s.Stage != "" {
+				return fmt.Errorf("stage %d is both %s and a hook", i+1, s.Stage)
+			}
+			if u, err := url.Parse(s.Hook); err != nil || u.Host == "" {
+				return fmt.Errorf("hook %s is not a url", s.Hook)
+			}
+			continue
+		}
+		known := false
+		for _, name := range builtinStages {
+			known = known || s.Stage == name
+		}
+		if !known {
+			return fmt.Errorf("unknown stage %q, expected one of %v or a hook", s.Stage, builtinStages)
+		}
+		if _, ok := at[s.Stage]; ok {
+			return fmt.Errorf("stage %s is in the pipeline twice", s.Stage)
+		}
+		at[s.Stage] = i
+	}
+	for _, required := range []string{"generate", "validate", "execute"} {
+		if _, ok := at[required]; !ok {
+			return fmt.Errorf("the pipeline needs a %s stage", required)
+		}
+	}
+	for _, stage := range builtinStages {
+		j, ok := at[stage]
+		if !ok {
+			continue
+		}
+		for _, before := range stageOrder[stage] {
+			if i, ok := at[before]; ok && i > j {
+				return fmt.Errorf("%s has to come before %s", before, stage)
+			}
+		}
+	}
+	return nil
+}
+
+// askRun is what the stages of one Ask share
+type askRun struct {
+	q         *Question
+	answer    *Answer
+	schema    *DBMetadata // what generate shows the model
+	feedback  string      // about the attempt that failed, when regenerating
+	query     string      // what execute runs
+	validated bool
+	db        queryer
+	scratch   *scratchSpace
+	buf       *resultBuffer
+	result    string // what the model sees of the rows
+	attempts  int
+	done      bool // a stage answered, so the built in stages after it are skipped
+	whatIf    *WhatIf
+	planned   bool // whether the question was looked at for a what-if
+	anomalies bool // the question asks what is unusual in a series
+	forecast  bool // the question asks what a series will do
+	wrote     bool // the query changed rows, and they were committed
+	route     *modelRoute
+
+	// for gorag analytics
+	stage             string
+	generations       int
+	failedGenerations int
+	executions        int
+	failedExecutions  int
+}
+
+func (r *askRun) routeName() string {
+	if r.route == nil {
+		return ""
+	}
+	return r.route.name()
+}
+
+func (r *askRun) difficulty() float64 {
+	if r.route == nil {
+		return 0
+	}
+	return r.route.Difficulty
+}
+
+func (r *askRun) close() {
+	if r.scratch != nil {
+		r.scratch.Close()
+		r.scratch = nil
+	}
+	if r.buf != nil {
+		r.buf.Close()
+	}
+}
+
+// errRegenerate sends the pipeline back to generate
+var errRegenerate = errors.New("regenerate")
+
+func (c *Client) runPipeline(r *askRun) error {
+	stages := c.Pipeline
+	if stages == nil {
+		stages = defaultPipeline()
+	}
+	for i := 0; i < len(stages); i++ {
+		s := stages[i]
+		r.stage = s.String()
+		if s.Hook != "" {
+			if err := c.runHook(s, r); err != nil {
+				return err
+			}
+			continue
+		}
+		if r.done {
+			continue
+		}
+		err := c.runStage(s.Stage, r)
+		if err == errRegenerate {
+			for j, g := range stages {
+				if g.Stage == "generate" {
+					i = j - 1
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) runStage(stage string, r *askRun) error {
+	q, answer := r.q, r.answer
+	switch stage {
+	case "route":
+		if err := c.checkPrompt(q); err != nil {
+			return err
+		}
+		if routing() && r.route == nil {
+			r.route = c.routeQuestion(q.Prompt)
+			c.route = r.route
+		}
+		return c.categorize(q, answer)
+	case "retrieve":
+		r.schema = c.promptSchema(q.Prompt)
+	case "generate":
+		schema := r.schema
+		if schema == nil {
+			schema = c.accessibleSchema(c.Schema)
+		}
+		if !r.planned {
+			w, err := c.planWhatIf(q.Prompt)
+			if err != nil {
+				return err
+			}
+			r.whatIf = w
+			if w == nil {
+				if r.anomalies, err = c.wantsAnomalies(q.Prompt); err != nil {
+					return err
+				}
+			}
+			if w == nil && !r.anomalies {
+				if r.forecast, err = c.wantsForecast(q.Prompt); err != nil {
+					return err
+				}
+			}
+			r.planned = true
+		}
+		prompt := q.Prompt
+		if r.whatIf != nil {
+			prompt = r.whatIf.Baseline
+		} else if r.anomalies {
+			prompt = anomalyQuestion(q.Prompt)
+		} else if r.forecast {
+			prompt = forecastQuestion(q.Prompt)
+		}
+		examples := c.promptExamples(schema, prompt)
+		r.generations++
+		query, err := c.generator().GenerateSQL(schema, examples, prompt, r.feedback)
+		if err != nil {
+			r.failedGenerations++
+			return err
+		}
+		if c.DryRun {
+			answer.SQLPrompt = c.recording.lastPrompt()
+		}
+		query = c.fixIdentifiers(query)
+		if err := c.checkGeneratedTables(r, query); err != nil {
+			return err
+		}
+		if err := c.checkGeneratedPlan(r, query); err != nil {
+			return err
+		}
+		if err := c.checkRecipe(r, schema, prompt, query); err != nil {
+			return err
+		}
+		if r.whatIf != nil {
+			r.whatIf.BaselineQuery = query
+			if r.whatIf.ScenarioQuery, err = c.scenarioQuery(r.whatIf); err != nil {
+				r.failedGenerations++
+				return err
+			}
+			query = whatIfQuery(r.whatIf)
+			answer.WhatIf = r.whatIf
+		}
+		answer.Examples = nil
+		for _, ex := range examples {
+			answer.Examples = append(answer.Examples, exampleKey(ex))
+		}
+		answer.Documents = c.retrieveDocs(q.Prompt)
+		answer.Query = query
+		answer.Stages = nil
+		r.query = query
+		r.validated = false
+	case "validate":
+		return c.validateStage(r)
+	case "execute":
+		if c.DryRun {
+			log.Printf("Not running the query, in a dry run")
+			r.done = true
+			return nil
+		}
+		if c.Confirm != nil && !c.Confirm.Confirm(r.query) {
+			return fmt.Errorf("the query wasn't confirmed, so it didn't run")
+		}
+		if c.ConfirmWrites != nil && isWrite(r.query) {
+			return c.executeWrite(r)
+		}
+		r.executions++
+		keep := q.Keep
+		if (r.anomalies || r.forecast) && keep < anomalyBuckets {
+			keep = anomalyBuckets
+		}
+		buf, err := c.runQuery(r.db, r.query, keep, c.FetchDeadline)
+		if err != nil {
+			return c.retryExecution(r, err)
+		}
+		r.buf = buf
+		if q.Export != nil {
+			if _, err := buf.WriteTo(q.Export); err != nil {
+				return fmt.Errorf("failed to export result: %v", err)
+			}
+		}
+		r.result = c.resultForLLM(buf)
+		answer.Result = r.result
+		answer.Rows = buf.rows
+		answer.Truncated = buf.cut
+		answer.Partial = buf.partial
+		answer.Table = buf.table
+		if r.anomalies {
+			answer.Anomalies = findAnomalies(buf.table, c.AnomalyWindow, c.AnomalyZ)
+		}
+		if r.forecast {
+			answer.Forecast = forecastSeries(buf.table, c.ForecastHorizon, c.ForecastSeason)
+		}
+	case "verify":
+		if r.buf.rows > 0 || r.wrote {
+			return nil
+		}
+		d, err := c.diagnoseEmpty(q.Prompt, answer.Query)
+		if err != nil {
+			log.Printf("Couldn't diagnose the empty result: %v", err)
+			return nil
+		}
+		answer.Summary = d.Explanation
+		answer.SuggestedQuery = d.Query
+		r.done = true
+	case "summarize":
+		result := r.result
+		if c.SummaryData == "aggregates" {
+			aggregates, err := c.aggregates(answer.Query)
+			if err != nil {
+				return err
+			}
+			answer.Aggregates = aggregates
+			result = aggregates
+		}
+		if q.OnToken != nil {
+			c.streamTo = func(text string) { q.OnToken(answer, text) }
+			defer func() { c.streamTo = nil }()
+		}
+		prompt := q.Prompt
+		if r.whatIf != nil {
+			prompt = whatIfPrompt(q.Prompt, r.whatIf)
+		} else if r.anomalies {
+			prompt = anomalyPrompt(q.Prompt, answer.Anomalies, r.buf.table)
+		} else if r.forecast {
+			prompt = forecastPrompt(q.Prompt, answer.Forecast, r.buf.table)
+		}
+		// aggregates are over every row, so only the rows are partial
+		partial := r.buf.partial && c.SummaryData != "aggregates"
+		if partial {
+			prompt = partialPrompt(prompt)
+		}
+		summary, verdict, err := c.judgedSummary(prompt, result, c.answerTemplate(q))
+		answer.Judge = verdict
+		if err != nil {
+			return err
+		}
+		if r.whatIf != nil {
+			full := withAssumptions(summary, r.whatIf)
+			if stream := c.summaryStream(); stream != nil {
+				stream(full[len(summary):])
+			}
+			summary = full
+		}
+		if partial {
+			full := withPartialNote(summary, r.buf.rows, c.FetchDeadline)
+			if stream := c.summaryStream(); stream != nil {
+				stream(full[len(summary):])
+			}
+			summary = full
+		}
+		answer.Summary = summary
+	}
+	return nil
+}
+
+// retryExecution has the query written again after it failed to run, until the retries run out
+func (c *Client) retryExecution(r *askRun, err error) error {
+	r.failedExecutions++
+	if r.attempts >= c.Retries {
+		return err
+	}
+	r.attempts++
+	log.Printf("Query failed, generating it again: %v", err)
+	r.feedback = c.retryFeedback(r.answer.Query, err)
+	return errRegenerate
+}
+
+// validateStage checks the query, and decomposes it when it is too complex
+func (c *Client) validateStage(r *askRun) error {
+	if r.scratch != nil {
+		r.scratch.Close()
+		r.scratch = nil
+	}
+	run, err := c.stageQuery(r.q, r.answer, r.query)
+	if err != nil {
+		return err
+	}
+	r.db = c.reader()
+	if run == nil {
+		query, err := c.Validate(r.q, r.query)
+		if err != nil {
+			return err
+		}
+		r.query = query
+		r.answer.Query = query
+	} else {
+		r.query = run.Query
+		r.answer.Query = run.Chain
+		if run.scratch != nil {
+			r.scratch = run.scratch
+			r.db = run.scratch.tx
+		}
+	}
+	r.validated = true
+	return nil
+}
+
+type hookRequest struct {
+	Hook    string `json:"hook"`
+	RunID   string `json:"run_id"`
+	Profile string `json:"profile,omitempty"`
+	User    string `json:"user,omitempty"`
+	Prompt  string `json:"prompt"`
+	Query   string `json:"query,omitempty"`
+	Result  string `json:"result,omitempty"`
+	Rows    int    `json:"rows"`
+	Summary string `json:"summary,omitempty"`
+}
+
+type hookResponse struct {
+	Error   string `json:"error,omitempty"`
+	Query   string `json:"query,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+var hookClient = &http.Client{Timeout: 30 * time.Second}
+
+func (c *Client) runHook(s *PipelineStage, r *askRun) error {
+	if err := checkSinkHost(s.Hook); err != nil {
+		return fmt.Errorf("pipeline hook %s: %v", s, err)
+	}
+	body, err := json.Marshal(hookRequest{
+		Hook:    s.String(),
+		RunID:   r.q.RunID,
+		Profile: c.Profile,
+		User:    r.q.User,
+		Prompt:  r.q.Prompt,
+		Query:   r.answer.Query,
+		Result:  r.result,
+		Rows:    r.answer.Rows,
+		Summary: r.answer.Summary,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.Hook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.APIKeyEnv != "" {
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(s.APIKeyEnv))
+	}
+	resp, err := hookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("pipeline hook %s failed: %v", s, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("pipeline hook %s failed: %v", s, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("pipeline hook %s failed: %s", s, resp.Status)
+	}
+	var out hookResponse
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &out); err != nil {
+			return fmt.Errorf("pipeline hook %s answered something other than json: %v", s, err)
+		}
+	}
+	if out.Error != "" {
+		return fmt.Errorf("refused by pipeline hook %s: %s", s, out.Error)
+	}
+	if out.Query != "" && out.Query != r.answer.Query {
+		if r.buf != nil {
+			log.Printf("Pipeline hook %s changed the query after it ran, ignoring that", s)
+		} else {
+			log.Printf("Pipeline hook %s changed the query", s)
+			r.query = out.Query
+			r.answer.Query = out.Query
+			if r.validated {
+				if err := c.validateStage(r); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if out.Summary != "" {
+		r.answer.Summary = out.Summary
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+)
+
+/*
+  A query that reads every row of a billion row table plans as one,
+  so the planner's estimate is asked first: with -max-plan-cost or
+  -max-plan-rows, a query whose EXPLAIN (FORMAT JSON) says it costs
+  more, or returns more rows, is written again, cheaper, with the
+  estimate as the reason, up to -retries times, and then refused. With
+  -plan-gate refuse it is refused straight away. Validate checks the
+  query as it will run, LIMIT from -max-rows in, so one from anywhere
+  else is held to it too. EXPLAIN only plans, so nothing runs; a query
+  the planner can't plan is let through, to fail when it runs and be
+  written again from its error. Costs are in the planner's own units,
+  which gorag advise reports for the slow queries, to pick a limit from.
+*/
+var maxPlanCost = Flags.Float64("max-plan-cost", 0, "refuse a query the postgres planner estimates costs more than this; 0 doesn't ask it")
+var maxPlanRows = Flags.Float64("max-plan-rows", 0, "refuse a query the postgres planner estimates returns more rows than this; 0 doesn't ask it")
+var planGate = Flags.String("plan-gate", "simplify", "over -max-plan-cost or -max-plan-rows, simplify to have the query written again first, or refuse")
+
+func checkPlanFlags() error {
+	if *maxPlanCost <= 0 && *maxPlanRows <= 0 {
+		return nil
+	}
+	if *planGate != "simplify" && *planGate != "refuse" {
+		return fmt.Errorf("-plan-gate must be simplify or refuse")
+	}
+	return requirePostgres("-max-plan-cost and -max-plan-rows")
+}
+
+// planProblem is why the planner's estimate is over the limits, or ""
+func (c *Client) planProblem(query string) string {
+	if c.MaxPlanCost <= 0 && c.MaxPlanRows <= 0 {
+		return ""
+	}
+	if c.MaxRows > 0 {
+		query = limitQuery(query, c.MaxRows+1)
+	}
+	plan, err := explainQuery(c.runContext(), c.reader(), query)
+	if err != nil {
+		log.Printf("No plan estimate, so not checking it: %v", err)
+		return ""
+	}
+	switch {
+	case c.MaxPlanCost > 0 && plan.TotalCost > c.MaxPlanCost:
+		return fmt.Sprintf("the planner estimates it costs %.0f, more than the %.0f allowed", plan.TotalCost, c.MaxPlanCost)
+	case c.MaxPlanRows > 0 && plan.PlanRows > c.MaxPlanRows:
+		return fmt.Sprintf("the planner estimates it returns %.0f rows, more than the %.0f allowed", plan.PlanRows, c.MaxPlanRows)
+	}
+	return ""
+}
+
+func (c *Client) checkPlan(query string) error {
+	if problem := c.planProblem(query); problem != "" {
+		return fmt.Errorf("query refused, %s", problem)
+	}
+	return nil
+}
+
+// checkGeneratedPlan asks for a cheaper query when the planner thinks this one is too expensive
+func (c *Client) checkGeneratedPlan(r *askRun, query string) error {
+	if c.PlanGate == "refuse" {
+		return nil
+	}
+	problem := c.planProblem(query)
+	if problem == "" {
+		return nil
+	}
+	if r.attempts >= c.Retries {
+		return fmt.Errorf("query refused, %s", problem)
+	}
+	r.attempts++
+	log.Printf("The query is too expensive, since %s; generating it again", problem)
+	r.feedback = fmt.Sprintf("\nA previous attempt at this request was\n\n%s\n\nwhich was refused because %s. "+
+		"Write a cheaper query: filter as early as possible, on indexed columns where there are any, aggregate in the "+
+		"query rather than returning raw rows, and avoid joins that multiply rows.\n", query, problem)
+	return errRegenerate
+}
@@ -0,0 +1,192 @@
+package gorag
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+  The SQL prompt is built from parts, and -prompt-parts picks which,
+  so we can find out what actually helps on a given schema and stop
+  paying tokens for what doesn't. The schema itself always goes in.
+
+    metadata      the extra metadata file
+    comments      COMMENT ON text from the database
+    stats         value ranges and distinct counts, from pg_stats
+    fk            foreign keys and suggested join paths
+    glossary      business terms from the config
+    deprecations  deprecated tables and columns, and their replacements
+    examples      example questions and queries from the config
+    hints         query_hints from the config, on how to write SQL here
+    values        the embed_values column values nearest to what the question names
+    quoting       the table and column names that have to be quoted
+    recipes       how to write cohort and funnel queries, for questions about them
+    samples       a few rows from each table (off by default: it sends data)
+*/
+var promptPartNames = []string{"metadata", "comments", "stats", "fk", "glossary", "deprecations", "examples", "docs", "hints", "values", "quoting", "recipes", "samples"}
+
+const defaultPromptParts = "metadata,comments,stats,fk,glossary,deprecations,examples,docs,hints,values,quoting,recipes"
+
+func parsePromptParts(s string) (map[string]bool, error) {
+	parts := make(map[string]bool)
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		known := false
+		for _, name := range promptPartNames {
+			known = known || name == p
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown prompt part %s, expected some of %s", p, strings.Join(promptPartNames, ","))
+		}
+		parts[p] = true
+	}
+	return parts, nil
+}
+
+// usePart is whether a prompt part is on, and the defaults when nobody said
+func (c *Client) usePart(name string) bool {
+	if c.PromptParts == nil {
+		return name != "samples"
+	}
+	return c.PromptParts[name]
+}
+
+// Example is a question and the query that answers it, to show the model what we expect
+type Example struct {
+	Prompt string `json:"prompt"`
+	Query  string `json:"query"`
+	// why it is left out of prompts, set when the schema dropped what it uses
+	Broken string `json:"broken,omitempty"`
+}
+
+// promptExamples has the configured examples whose tables are all in this schema, as the selector picks them
+func (c *Client) promptExamples(schema *DBMetadata, question string) []*Example {
+	if c.Config == nil || !c.usePart("examples") {
+		return nil
+	}
+	c.Config.mu.RLock()
+	examples := c.Config.Examples
+	c.Config.mu.RUnlock()
+	var fit []*Example
+	for _, ex := range examples {
+		fits := ex.Broken == ""
+		for _, t := range referencedTables(ex.Query) {
+			if _, ok := schema.Tables[t]; !ok {
+				fits = false
+			}
+		}
+		if fits {
+			fit = append(fit, ex)
+		}
+	}
+	if c.Examples == nil || len(fit) == 0 {
+		return fit
+	}
+	return c.Examples.Select(question, fit)
+}
+
+func examplesPrompt(examples []*Example) string {
+	var sb strings.Builder
+	for _, ex := range examples {
+		sb.WriteString(fmt.Sprintf("Request: %s\nQuery: %s\n\n", ex.Prompt, strings.TrimSpace(ex.Query)))
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return "\nExamples of requests and the queries that answer them:\n\n" + sb.String()
+}
+
+// sample rows are read once per database and table
+var sampleRows = struct {
+	sync.Mutex
+	m map[*sql.DB]map[string]string
+}{m: make(map[*sql.DB]map[string]string)}
+
+/*
+  samplesPrompt shows the model what values look like, which helps
+  with codes and formats. Tables with tagged columns or group sizes
+  are left out, since the point of that config is to keep their rows
+  from being read.
+*/
+func (c *Client) samplesPrompt(schema *DBMetadata) string {
+	tables := make([]string, 0, len(schema.Tables))
+	for t := range schema.Tables {
+		if c.Config == nil || c.Config.tableConfig(t) == nil || !c.Config.tableConfig(t).restricted() {
+			tables = append(tables, t)
+		}
+	}
+	sort.Strings(tables)
+	var sb strings.Builder
+	for _, t := range tables {
+		if s := c.sampleTable(t); s != "" {
+			sb.WriteString(fmt.Sprintf("Table: %s\n%s\n", t, s))
+		}
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return "\nSample rows:\n\n" + sb.String()
+}
+
+func (c *Client) sampleTable(table string) string {
+	sampleRows.Lock()
+	defer sampleRows.Unlock()
+	byTable, ok := sampleRows.m[c.DB]
+	if !ok {
+		byTable = make(map[string]string)
+		sampleRows.m[c.DB] = byTable
+	}
+	if s, ok := byTable[table]; ok {
+		return s
+	}
+	s := ""
+	rows, err := c.DB.Query(sampleQuery(table, 3))
+	if err == nil {
+		s = formatSampleRows(rows)
+		rows.Close()
+	}
+	byTable[table] = s
+	return s
+}
+
+func formatSampleRows(rows *sql.Rows) string {
+	columns, err := rows.Columns()
+	if err != nil {
+		return ""
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return ""
+	}
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	var sb strings.Builder
+	for rows.Next() {
+		if rows.Scan(ptrs...) != nil {
+			return ""
+		}
+		fields := make([]string, len(columns))
+		for i, col := range columns {
+			v := values[i]
+			if b, ok := v.([]byte); ok {
+				v = promptValue(types[i].DatabaseTypeName(), b)
+			}
+			s := fmt.Sprint(v)
+			if r := []rune(s); len(r) > 40 {
+				s = string(r[:40]) + "..."
+			}
+			fields[i] = col + "=" + s
+		}
+		sb.WriteString(strings.Join(fields, ", ") + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,145 @@
+package gorag
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+  An LLMProvider is what -llm names: it turns messages into the
+  model's reply, however its server wants to be asked. openai, ollama,
+  bedrock and mock are built in, and a program using gorag as a
+  library can RegisterLLMProvider its own, to be picked with -llm like
+  them, without touching the rest of the pipeline.
+
+  A provider may also have any of:
+
+    Endpoint() (url, model string)
+      where it is and its default model, instead of -llm-url and gpt-4o;
+      a provider with one isn't sent OPENAI_API_KEY
+    Embed(ctx, texts []string, opts CompletionOptions) ([][]float64, error)
+    EmbeddingModel() string
+      embeddings, for -prune, -docs and embed_values
+    Check(ctx, opts CompletionOptions) error
+      that the credentials and model are good, for -warm
+    CompleteTools(ctx, messages []Message, tools []Tool, opts CompletionOptions) (Message, error)
+      a reply that may call tools instead, for -agent-steps
+
+  A profile's sql_model and data_model go to the same provider, with
+  their own url and model in the options.
+*/
+type LLMProvider interface {
+	// Complete is the model's reply to the messages
+	Complete(ctx context.Context, messages []Message, opts CompletionOptions) (string, error)
+}
+
+// CompletionOptions says which model a call goes to, and how
+type CompletionOptions struct {
+	URL         string
+	APIKey      string
+	Model       string
+	Temperature float64
+	MaxTokens   int     // 0 is the provider's default
+	TopP        float64 // 0 is the provider's default
+	// the reply is json matching this, which the prompt also asks for, for providers that can't hold it to one
+	Schema      *ResponseSchema
+	TraceParent string // W3C trace headers to send along, if any
+	TraceState  string
+	// told what each call used, by providers that know
+	OnUsage func(promptTokens, completionTokens int)
+	// told the reply a piece at a time as it is written, by providers that stream; nil waits for all of it
+	OnToken func(text string)
+}
+
+type endpointProvider interface {
+	Endpoint() (url, model string)
+}
+
+type embeddingProvider interface {
+	Embed(ctx context.Context, texts []string, opts CompletionOptions) ([][]float64, error)
+	EmbeddingModel() string
+}
+
+type checkingProvider interface {
+	Check(ctx context.Context, opts CompletionOptions) error
+}
+
+var llmProviders = map[string]LLMProvider{
+	"openai":  openaiProvider{},
+	"ollama":  ollamaProvider{},
+	"bedrock": bedrockProvider{},
+	"mock":    mockProvider{},
+}
+
+// RegisterLLMProvider makes a provider available as -llm name
+func RegisterLLMProvider(name string, p LLMProvider) {
+	llmProviders[name] = p
+}
+
+// currentProvider is the one -llm names
+func currentProvider() (LLMProvider, error) {
+	p, ok := llmProviders[*llmProvider]
+	if !ok {
+		names := make([]string, 0, len(llmProviders))
+		for name := range llmProviders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown -llm %s, expected one of %s", *llmProvider, strings.Join(names, ","))
+	}
+	return p, nil
+}
+
+// defaultOptions is where -llm sends calls, and its model, unless a profile says otherwise
+func defaultOptions(apiKey string) CompletionOptions {
+	if p, err := currentProvider(); err == nil {
+		if e, ok := p.(endpointProvider); ok {
+			url, model := e.Endpoint()
+			return CompletionOptions{URL: url, Model: model}
+		}
+	}
+	return CompletionOptions{URL: *llmURL, APIKey: apiKey, Model: chatModel}
+}
+
+// providerEmbeddingModel names the model embeddings come from, so cached ones are kept apart
+func providerEmbeddingModel() string {
+	if p, err := currentProvider(); err == nil {
+		if e, ok := p.(embeddingProvider); ok {
+			return e.EmbeddingModel()
+		}
+	}
+	return embeddingModel
+}
+
+// completeText asks the -llm provider for t's reply to one user message, with pictures if any
+func completeText(t modelTarget, prompt string, images ...[]byte) (string, error) {
+	p, err := currentProvider()
+	if err != nil {
+		return "", err
+	}
+	// a provider that doesn't stream is still told the reply, in one piece
+	onToken, streamed := t.OnToken, false
+	if onToken != nil {
+		t.OnToken = func(text string) {
+			streamed = true
+			onToken(text)
+		}
+	}
+	text, err := p.Complete(t.context(), []Message{{Role: "user", Content: prompt, Images: images}}, t.CompletionOptions)
+	if err == nil && onToken != nil && !streamed {
+		onToken(text)
+	}
+	return text, err
+}
+
+// base64Images is how most servers want pictures sent
+func base64Images(images [][]byte) []string {
+	out := make([]string, 0, len(images))
+	for _, image := range images {
+		out = append(out, base64.StdEncoding.EncodeToString(image))
+	}
+	return out
+}
@@ -0,0 +1,356 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+  With -prune N, only the N tables most similar to the question (by
+  embedding) go into the prompt, which matters once a schema has
+  hundreds of tables. Similarity alone misses the tables a query
+  needs to get between the ones it picked, so each pick also brings
+  its cluster: junction tables hanging off it and the tables on their
+  other side, plus foreign key neighbours with related names, like
+  order and order_line.
+
+  The table embeddings go in the -vector-store, and a question gets
+  the tables nearest to it from there: four times N of them, which the
+  tables' weights rank again before N are picked.
+*/
+type tableIndex struct {
+	mu         sync.Mutex
+	vectors    map[string][]float64
+	collection string            // in the vector store, named for the tables' descriptions
+	tables     map[string]string // vector store id -> table
+	stored     bool
+}
+
+// one index per schema, so profiles don't share embeddings
+var tableIndexes = struct {
+	sync.Mutex
+	m map[*DBMetadata]*tableIndex
+}{m: make(map[*DBMetadata]*tableIndex)}
+
+func tableDescription(schema *DBMetadata, table string, extraMetadata map[string]string) string {
+	s := fmt.Sprintf("Table: %s\nColumns: %s\n", table, strings.Join(schema.Tables[table], ", "))
+	if note, ok := extraMetadata[table]; ok {
+		s += note + "\n"
+	}
+	return s
+}
+
+// tableVectors embeds every table once, and puts them in the vector store
+func (c *Client) tableVectors() (*tableIndex, error) {
+	tableIndexes.Lock()
+	idx, ok := tableIndexes.m[c.Schema]
+	if !ok {
+		idx = &tableIndex{}
+		tableIndexes.m[c.Schema] = idx
+	}
+	tableIndexes.Unlock()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.vectors != nil && idx.stored {
+		return idx, nil
+	}
+	if idx.vectors == nil {
+		if err := c.embedTables(idx); err != nil {
+			return nil, err
+		}
+	}
+	ids := make([]string, 0, len(idx.tables))
+	for id := range idx.tables {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	vectors := make([][]float64, len(ids))
+	for i, id := range ids {
+		vectors[i] = idx.vectors[idx.tables[id]]
+	}
+	if err := c.vectorStore().Upsert(idx.collection, ids, vectors); err != nil {
+		return nil, fmt.Errorf("failed to store table embeddings: %v", err)
+	}
+	idx.stored = true
+	return idx, nil
+}
+
+func (c *Client) embedTables(idx *tableIndex) error {
+	names := make([]string, 0, len(c.Schema.Tables))
+	for t := range c.Schema.Tables {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	texts := make([]string, len(names))
+	for i, t := range names {
+		texts[i] = tableDescription(c.Schema, t, c.ExtraMetadata)
+	}
+	keys := make([]string, len(texts))
+	for i, t := range texts {
+		keys[i] = c.Pseudonyms.hide(t)
+	}
+	vectors, err := cachedEmbed(*embeddingsCache, keys, func(missing []int) ([][]float64, error) {
+		some := make([]string, len(missing))
+		for j, i := range missing {
+			some[j] = texts[i]
+		}
+		return c.embed(some)
+	})
+	if err != nil {
+		return err
+	}
+	idx.vectors = make(map[string][]float64, len(names))
+	idx.tables = make(map[string]string, len(names))
+	for i, t := range names {
+		idx.vectors[t] = vectors[i]
+		idx.tables[sha256Hex([]byte(keys[i]))[:16]] = t
+	}
+	idx.collection = "tables_" + embeddingKey(strings.Join(keys, "\x00"))[:16]
+	return nil
+}
+
+// subset is the schema restricted to some tables, keeping keys between them
+func (s *DBMetadata) subset(tables []string) *DBMetadata {
+	keep := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		keep[t] = true
+	}
+	out := &DBMetadata{Tables: make(map[string][]string), PrimaryKeys: make(map[string][]string)}
+	for t, cols := range s.Tables {
+		if keep[t] {
+			out.Tables[t] = cols
+		}
+	}
+	for t, cols := range s.PrimaryKeys {
+		if keep[t] {
+			out.PrimaryKeys[t] = cols
+		}
+	}
+	for key, comment := range s.Comments {
+		if table, _, _ := strings.Cut(key, "."); keep[table] {
+			if out.Comments == nil {
+				out.Comments = make(map[string]string)
+			}
+			out.Comments[key] = comment
+		}
+	}
+	for key, stats := range s.Stats {
+		if table, _, _ := strings.Cut(key, "."); keep[table] {
+			if out.Stats == nil {
+				out.Stats = make(map[string]*ColumnStats)
+			}
+			out.Stats[key] = stats
+		}
+	}
+	for _, fk := range s.ForeignKeys {
+		if keep[fk.Table] && keep[fk.RefTable] {
+			out.ForeignKeys = append(out.ForeignKeys, fk)
+		}
+	}
+	return out
+}
+
+/*
+  isJunctionTable is true for the bridge of a many to many: it points
+  at two or more tables, and nearly all of its columns are keys.
+*/
+func isJunctionTable(schema *DBMetadata, table string) bool {
+	refs := make(map[string]bool)
+	keyCols := make(map[string]bool)
+	for _, fk := range schema.ForeignKeys {
+		if fk.Table == table {
+			refs[fk.RefTable] = true
+			keyCols[fk.Column] = true
+		}
+	}
+	for _, c := range schema.PrimaryKeys[table] {
+		keyCols[c] = true
+	}
+	if len(refs) < 2 {
+		return false
+	}
+	others := 0
+	for _, c := range schema.Tables[table] {
+		if !keyCols[c] {
+			others++
+		}
+	}
+	return others <= 2
+}
+
+func singular(word string) string {
+	switch {
+	case strings.HasSuffix(word, "ies"):
+		return strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "ses"):
+		return strings.TrimSuffix(word, "es")
+	default:
+		return strings.TrimSuffix(word, "s")
+	}
+}
+
+// namesRelated is true for order/order_items, or customer/customer_address
+func namesRelated(a, b string) bool {
+	ha := singular(strings.Split(a, "_")[0])
+	hb := singular(strings.Split(b, "_")[0])
+	return ha != "" && ha == hb
+}
+
+// tableCluster is what a table brings along with it when pruning picks it
+func tableCluster(schema *DBMetadata, table string) []string {
+	cluster := make(map[string]bool)
+	for _, fk := range schema.ForeignKeys {
+		var other string
+		switch table {
+		case fk.Table:
+			other = fk.RefTable
+		case fk.RefTable:
+			other = fk.Table
+		default:
+			continue
+		}
+		if other == table {
+			continue
+		}
+		if namesRelated(table, other) {
+			cluster[other] = true
+		}
+		// a junction pointing at us brings itself and whatever is on its other side
+		if other == fk.Table && isJunctionTable(schema, other) {
+			cluster[other] = true
+			for _, jfk := range schema.ForeignKeys {
+				if jfk.Table == other && jfk.RefTable != table {
+					cluster[jfk.RefTable] = true
+				}
+			}
+		}
+	}
+	out := make([]string, 0, len(cluster))
+	for t := range cluster {
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func expandClusters(schema *DBMetadata, selected []string) []string {
+	seen := make(map[string]bool)
+	out := make([]string, 0, len(selected))
+	add := func(t string) {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	for _, t := range selected {
+		add(t)
+	}
+	for _, t := range selected {
+		for _, related := range tableCluster(schema, t) {
+			add(related)
+		}
+	}
+	return out
+}
+
+// pruneSetting is a table's weight, and its prune setting from the config
+func (c *Client) pruneSetting(table string) (float64, string) {
+	if c.Config == nil {
+		return 1, ""
+	}
+	tc := c.Config.tableConfig(table)
+	if tc == nil {
+		return 1, ""
+	}
+	weight := tc.Weight
+	if weight == 0 {
+		weight = 1
+	}
+	return weight, tc.Prune
+}
+
+// withoutNever drops the tables configured to never be in a pruned prompt
+func (c *Client) withoutNever(tables []string) []string {
+	out := make([]string, 0, len(tables))
+	for _, t := range tables {
+		if _, prune := c.pruneSetting(t); prune != "never" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// promptSchema is the part of the schema this question's prompt should carry, of the tables it may use
+func (c *Client) promptSchema(question string) *DBMetadata {
+	return c.accessibleSchema(c.prunedSchema(question))
+}
+
+/*
+  prunedSchema is the part of the schema relevant to the question.
+  Weights from the config scale similarity, so a fact table can
+  win over a lookup table that happens to sound like the question;
+  "always" tables go in on top of the N picked, and "never" tables stay
+  out even when a cluster would bring them along.
+*/
+func (c *Client) prunedSchema(question string) *DBMetadata {
+	if c.PruneTables <= 0 {
+		return c.Schema
+	}
+	if len(c.Schema.Tables) <= c.PruneTables {
+		all := make([]string, 0, len(c.Schema.Tables))
+		for t := range c.Schema.Tables {
+			all = append(all, t)
+		}
+		if kept := c.withoutNever(all); len(kept) < len(all) {
+			return c.Schema.subset(kept)
+		}
+		return c.Schema
+	}
+	idx, err := c.tableVectors()
+	if err != nil {
+		log.Printf("Not pruning schema, embeddings failed: %v", err)
+		return c.Schema
+	}
+	qv, err := c.embed([]string{question})
+	if err != nil {
+		log.Printf("Not pruning schema, embeddings failed: %v", err)
+		return c.Schema
+	}
+	selected := make([]string, 0, c.PruneTables)
+	for t := range c.Schema.Tables {
+		if _, prune := c.pruneSetting(t); prune == "always" {
+			selected = append(selected, t)
+		}
+	}
+	sort.Strings(selected)
+	matches, err := c.vectorStore().Search(idx.collection, qv[0], 4*c.PruneTables+len(selected))
+	if err != nil {
+		log.Printf("Not pruning schema, the vector store failed: %v", err)
+		return c.Schema
+	}
+	type scored struct {
+		table string
+		score float64
+	}
+	ranked := make([]scored, 0, len(matches))
+	for _, m := range matches {
+		t, ok := idx.tables[m.ID]
+		if !ok {
+			continue
+		}
+		if weight, prune := c.pruneSetting(t); prune != "never" && prune != "always" {
+			ranked = append(ranked, scored{t, weight * m.Score})
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	for i := 0; i < len(ranked) && i < c.PruneTables; i++ {
+		selected = append(selected, ranked[i].table)
+	}
+	tables := c.withoutNever(expandClusters(c.Schema, selected))
+	log.Printf("Pruned schema to %d tables: %s", len(tables), strings.Join(tables, ", "))
+	return c.Schema.subset(tables)
+}
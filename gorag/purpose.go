@@ -0,0 +1,189 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+/*
+  Columns can be tagged (pii, financial, internal, ...) in the tables
+  section of the config, and each purpose says which tags it may see:
+
+    "purposes": {
+      "support": { "description": "helping a customer", "allowed_tags": ["pii"] },
+      "reporting": { "description": "aggregate business metrics", "allowed_tags": ["financial"] }
+    }
+
+  Once purposes are configured, every question has one: either the
+  caller declares it, or the model classifies the question into one.
+  A query touching a tag its purpose doesn't allow is blocked, unless
+  the caller is allowed to override and gives a justification, which
+  goes in the audit log.
+*/
+type Purpose struct {
+	Description string   `json:"description,omitempty"`
+	AllowedTags []string `json:"allowed_tags,omitempty"`
+}
+
+type ColumnConfig struct {
+	Tags []string `json:"tags,omitempty"`
+	// what to use instead, when the column is deprecated
+	Deprecated string `json:"deprecated,omitempty"`
+	// hash, partial, email or redact: see mask.go
+	Mask string `json:"mask,omitempty"`
+	// embed the distinct values, so questions find them however they spell them: see values.go
+	EmbedValues bool `json:"embed_values,omitempty"`
+}
+
+func (c *Config) purposes() map[string]*Purpose {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make(map[string]*Purpose, len(c.Purposes))
+	for k, v := range c.Purposes {
+		out[k] = v
+	}
+	return out
+}
+
+// classifyPurpose has the model pick one of the configured purposes for a question
+func (c *Client) classifyPurpose(prompt string, purposes map[string]*Purpose) (string, error) {
+	names := make([]string, 0, len(purposes))
+	for name := range purposes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(fmt.Sprintf("- %s: %s\n", name, purposes[name].Description))
+	}
+	var out struct {
+		Purpose string `json:"purpose"`
+	}
+	err := c.llmJSON(fmt.Sprintf(`
+Classify the purpose of this database question into exactly one of these purposes:
+
+%s
+Respond with json: { "purpose": "<name>" }
+
+Question: %s
+`, sb.String(), prompt), &out)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := purposes[out.Purpose]; !ok {
+		return "", fmt.Errorf("model picked unknown purpose %q", out.Purpose)
+	}
+	return out.Purpose, nil
+}
+
+/*
+  touchedTags finds the tagged columns a query could read. Like the
+  deny rules this errs on the side of blocking: a tagged column counts
+  if its name shows up anywhere in a query on its table, and a * on
+  such a table reads every column.
+*/
+func (c *Client) touchedTags(query string) (map[string][]string, []string) {
+	tokens := lexSQL(query)
+	idents := make(map[string]bool)
+	star := false
+	for i, t := range tokens {
+		if id := t.ident(); id != "" {
+			idents[strings.ToLower(id)] = true
+		}
+		if t.Text == "*" && i > 0 {
+			prev := tokens[i-1]
+			if prev.upper() == "SELECT" || prev.Text == "." || prev.Text == "," {
+				star = true
+			}
+		}
+	}
+	byTag := make(map[string][]string)
+	tables := referencedTables(query)
+	for _, table := range tables {
+		tc := c.Config.tableConfig(table)
+		if tc == nil {
+			continue
+		}
+		for column, cc := range tc.Columns {
+			if !star && !idents[strings.ToLower(column)] {
+				continue
+			}
+			for _, tag := range cc.Tags {
+				byTag[tag] = append(byTag[tag], table+"."+column)
+			}
+		}
+	}
+	return byTag, tables
+}
+
+func (c *Client) checkPurpose(q *Question, query string) error {
+	if c.Config == nil {
+		return nil
+	}
+	purposes := c.Config.purposes()
+	if len(purposes) == 0 {
+		return nil
+	}
+	if q.Purpose == "" {
+		purpose, err := c.classifyPurpose(q.Prompt, purposes)
+		if err != nil {
+			log.Printf("Could not classify purpose: %v", err)
+		}
+		q.Purpose = purpose
+	}
+	p, ok := purposes[q.Purpose]
+	if !ok && q.Purpose != "" {
+		return fmt.Errorf("unknown purpose: %s", q.Purpose)
+	}
+	allowed := make(map[string]bool)
+	if p != nil {
+		for _, tag := range p.AllowedTags {
+			allowed[tag] = true
+		}
+	}
+
+	byTag, tables := c.touchedTags(query)
+	blocked := make([]string, 0)
+	columns := make([]string, 0)
+	for tag, cols := range byTag {
+		if !allowed[tag] {
+			blocked = append(blocked, tag)
+			columns = append(columns, cols...)
+		}
+	}
+	if len(blocked) == 0 {
+		return nil
+	}
+	sort.Strings(blocked)
+	sort.Strings(columns)
+
+	if q.Override != "" && q.CanOverride {
+		log.Printf("Purpose override by %s for %v: %s", q.User, blocked, q.Override)
+		c.Audit.Record(AuditEvent{
+			Event:   "override",
+			RunID:   q.RunID,
+			User:    q.User,
+			Profile: c.Profile,
+			Prompt:  q.Prompt,
+			Purpose: q.Purpose,
+			Query:   query,
+			Tables:  tables,
+			Tags:    blocked,
+			Reason:  q.Override,
+		})
+		return nil
+	}
+	if q.Override != "" {
+		return fmt.Errorf("%s may not override purpose restrictions", q.User)
+	}
+	purpose := q.Purpose
+	if purpose == "" {
+		purpose = "(unclassified)"
+	}
+	return fmt.Errorf(
+		"purpose %s may not read %s data (%s); declare another purpose, or override with a justification",
+		purpose, strings.Join(blocked, ", "), strings.Join(columns, ", "),
+	)
+}
@@ -0,0 +1,390 @@
+package gorag
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+  gorag quality probes a table for the usual problems in its data,
+  with checks generated from the schema rather than written by hand:
+
+    gorag quality -table orders
+
+  null_rate      each column's share of nulls, over -max-null-rate
+                 (any null at all, for the primary key)
+  duplicate_key  rows sharing a primary key, or an id column when
+                 none is declared, which warehouses don't enforce
+  orphans        rows whose foreign key finds no parent row
+  date_range     dates before -min-date, or over -future-days ahead
+
+  Each check is one read-only query, and the model summarizes what
+  failed for whoever owns the table. -register keeps the failing ones
+  in the config's monitors, and -monitors runs those instead, from a
+  scheduler or with -every; a monitor that fails goes to the -sink
+  sinks, and the command fails when it runs once, so a scheduler
+  notices. A monitor keeps the check, not its SQL, so it is written
+  again for the schema and date of each run.
+*/
+
+// QualityCheck is one generated check of a table's data
+type QualityCheck struct {
+	Name       string   `json:"name"`
+	Profile    string   `json:"profile,omitempty"`
+	Kind       string   `json:"kind"` // null_rate, duplicate_key, orphans or date_range
+	Table      string   `json:"table"`
+	Columns    []string `json:"columns"`
+	RefTable   string   `json:"ref_table,omitempty"` // for orphans
+	RefColumns []string `json:"ref_columns,omitempty"`
+	MinDate    string   `json:"min_date,omitempty"` // for date_range
+	FutureDays int      `json:"future_days,omitempty"`
+	// the most it finds and passes: a share of the rows for null_rate, rows for the others
+	Max float64 `json:"max"`
+}
+
+// qualityResult is what a check found
+type qualityResult struct {
+	Check *QualityCheck
+	Bad   int64 // rows with the problem
+	Rows  int64
+	Err   error
+}
+
+func (r *qualityResult) value() float64 {
+	if r.Check.Kind == "null_rate" {
+		return rate(int(r.Bad), int(r.Rows))
+	}
+	return float64(r.Bad)
+}
+
+func (r *qualityResult) passed() bool {
+	return r.Err == nil && r.value() <= r.Check.Max
+}
+
+func (r *qualityResult) String() string {
+	status := "ok  "
+	if !r.passed() {
+		status = "FAIL"
+	}
+	target := r.Check.Table + "." + strings.Join(r.Check.Columns, ",")
+	if r.Err != nil {
+		return fmt.Sprintf("%s %s %s: %v", status, target, r.Check.Kind, r.Err)
+	}
+	found := fmt.Sprintf("%d of %d rows (%.2f%%)", r.Bad, r.Rows, 100*rate(int(r.Bad), int(r.Rows)))
+	switch r.Check.Kind {
+	case "null_rate":
+		return fmt.Sprintf("%s %s %s: %s are null, at most %.0f%% may be", status, target, r.Check.Kind, found, 100*r.Check.Max)
+	case "duplicate_key":
+		return fmt.Sprintf("%s %s %s: %s share their key with another row", status, target, r.Check.Kind, found)
+	case "orphans":
+		return fmt.Sprintf("%s %s %s: %s have no %s row", status, target, r.Check.Kind, found, r.Check.RefTable)
+	}
+	return fmt.Sprintf("%s %s %s: %s are before %s or over %d days ahead", status, target, r.Check.Kind, found, r.Check.MinDate, r.Check.FutureDays)
+}
+
+// isTimeType is whether a database type name holds dates
+func isTimeType(dbType string) bool {
+	t := strings.ToUpper(dbType)
+	return strings.Contains(t, "DATE") || strings.Contains(t, "TIMESTAMP")
+}
+
+// columnTypes is the database type of each of the table's columns, read from a query for no rows
+func columnTypes(db queryer, table string) (map[string]string, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s WHERE 1 = 0", quoteIdent(table)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the columns of %s: %v", table, err)
+	}
+	out := make(map[string]string, len(types))
+	for _, t := range types {
+		out[t.Name()] = t.DatabaseTypeName()
+	}
+	return out, nil
+}
+
+// qualityLimits are what the generated checks allow
+type qualityLimits struct {
+	MaxNullRate float64
+	MinDate     string
+	FutureDays  int
+}
+
+// qualityChecks generates the checks for a table from the schema
+func qualityChecks(schema *DBMetadata, table string, types map[string]string, limits qualityLimits) []*QualityCheck {
+	out := make([]*QualityCheck, 0)
+	add := func(c *QualityCheck) {
+		c.Table = table
+		c.Name = table + "." + strings.Join(c.Columns, ",") + ":" + c.Kind
+		out = append(out, c)
+	}
+	key := schema.PrimaryKeys[table]
+	if len(key) == 0 && schema.hasColumn(table, "id") {
+		key = []string{"id"}
+	}
+	inKey := make(map[string]bool)
+	for _, k := range key {
+		inKey[k] = true
+	}
+	for _, col := range schema.Tables[table] {
+		max := limits.MaxNullRate
+		if inKey[col] {
+			max = 0
+		}
+		add(&QualityCheck{Kind: "null_rate", Columns: []string{col}, Max: max})
+	}
+	if len(key) > 0 {
+		add(&QualityCheck{Kind: "duplicate_key", Columns: key})
+	}
+	for _, fk := range schema.foreignKeyGroups() {
+		if fk[0].Table != table {
+			continue
+		}
+		c := &QualityCheck{Kind: "orphans", RefTable: fk[0].RefTable}
+		for _, k := range fk {
+			c.Columns = append(c.Columns, k.Column)
+			c.RefColumns = append(c.RefColumns, k.RefColumn)
+		}
+		add(c)
+	}
+	for _, col := range schema.Tables[table] {
+		t := types[col]
+		if s := schema.Stats[table+"."+col]; t == "" && s != nil {
+			t = s.Type
+		}
+		if isTimeType(t) {
+			add(&QualityCheck{Kind: "date_range", Columns: []string{col}, MinDate: limits.MinDate, FutureDays: limits.FutureDays})
+		}
+	}
+	return out
+}
+
+// qualityQuery counts the rows with the check's problem, and all the rows
+func qualityQuery(c *QualityCheck, now time.Time) string {
+	table := quoteIdent(c.Table)
+	cols := make([]string, len(c.Columns))
+	for i, col := range c.Columns {
+		cols[i] = quoteIdent(col)
+	}
+	switch c.Kind {
+	case "null_rate":
+		return fmt.Sprintf("SELECT COUNT(*) - COUNT(%s), COUNT(*) FROM %s", cols[0], table)
+	case "duplicate_key":
+		return fmt.Sprintf("SELECT SUM(n), (SELECT COUNT(*) FROM %s) FROM (SELECT COUNT(*) AS n FROM %s GROUP BY %s HAVING COUNT(*) > 1) d",
+			table, table, strings.Join(cols, ", "))
+	case "orphans":
+		present := make([]string, len(cols))
+		matched := make([]string, len(cols))
+		for i, col := range cols {
+			present[i] = "c." + col + " IS NOT NULL"
+			matched[i] = "p." + quoteIdent(c.RefColumns[i]) + " = c." + col
+		}
+		return fmt.Sprintf("SELECT SUM(CASE WHEN %s AND NOT EXISTS (SELECT 1 FROM %s p WHERE %s) THEN 1 ELSE 0 END), COUNT(*) FROM %s c",
+			strings.Join(present, " AND "), quoteIdent(c.RefTable), strings.Join(matched, " AND "), table)
+	}
+	latest := now.AddDate(0, 0, c.FutureDays).Format("2006-01-02")
+	return fmt.Sprintf("SELECT SUM(CASE WHEN %s < %s OR %s > %s THEN 1 ELSE 0 END), COUNT(*) FROM %s",
+		cols[0], sqlLiteral(c.MinDate), cols[0], sqlLiteral(latest), table)
+}
+
+// runQualityCheck runs one check; a problem no row has sums to null
+func runQualityCheck(db queryer, c *QualityCheck, now time.Time) *qualityResult {
+	r := &qualityResult{Check: c}
+	var bad, rows sql.NullFloat64
+	if err := db.QueryRow(qualityQuery(c, now)).Scan(&bad, &rows); err != nil {
+		r.Err = fmt.Errorf("failed to run the check: %v", err)
+		return r
+	}
+	r.Bad, r.Rows = int64(bad.Float64), int64(rows.Float64)
+	return r
+}
+
+// qualitySummary has the model explain what the checks found
+func (c *Client) qualitySummary(results []*qualityResult) (string, error) {
+	var sb strings.Builder
+	for _, r := range results {
+		sb.WriteString(r.String() + "\n")
+	}
+	return c.dataText(fmt.Sprintf(`
+These data quality checks were run on a database, whose schema is:
+
+%s
+The results, one per line, FAIL for a check that found more than it allows:
+
+%s
+Summarize the findings for whoever owns these tables, in a few short paragraphs:
+what looks wrong and how much of the data it touches, what the likely causes are,
+and what to look at first. Group related failures, don't list the checks that
+passed one by one, and don't give numbers that aren't here.
+`, formatSchema(c.Schema), sb.String()))
+}
+
+// registerMonitors keeps the failing checks in the config's monitors, and says how many were new
+func (c *Client) registerMonitors(results []*qualityResult) (int, error) {
+	c.Config.mu.Lock()
+	defer c.Config.mu.Unlock()
+	if c.Config.Monitors == nil {
+		c.Config.Monitors = make(map[string]*QualityCheck)
+	}
+	added := 0
+	for _, r := range results {
+		if r.passed() || r.Err != nil {
+			continue
+		}
+		r.Check.Profile = c.Profile
+		key := r.Check.Name
+		if c.Profile != "" {
+			key = c.Profile + "/" + key
+		}
+		if _, ok := c.Config.Monitors[key]; !ok {
+			added++
+		}
+		c.Config.Monitors[key] = r.Check
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, c.Config.save()
+}
+
+// profileMonitors are the registered checks of the profile, on the tables if any are given
+func (c *Client) profileMonitors(tables map[string]bool) []*QualityCheck {
+	c.Config.mu.RLock()
+	defer c.Config.mu.RUnlock()
+	out := make([]*QualityCheck, 0)
+	for _, m := range c.Config.Monitors {
+		if m.Profile == c.Profile && (len(tables) == 0 || tables[m.Table]) {
+			out = append(out, m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func runQuality(args []string) {
+	fs := commandFlags("quality")
+	tableList := fs.String("table", "", "the tables to check, comma separated; every table when empty")
+	maxNullRate := fs.Float64("max-null-rate", 0.5, "the share of a column's rows that may be null")
+	minDate := fs.String("min-date", "1900-01-01", "dates before this are out of range")
+	futureDays := fs.Int("future-days", 1, "dates more than this many days ahead are out of range")
+	summarize := fs.Bool("summarize", true, "have the model summarize the findings")
+	register := fs.Bool("register", false, "keep the failing checks in the config's monitors")
+	monitors := fs.Bool("monitors", false, "run the registered monitors instead of generating checks")
+	every := fs.Duration("every", 0, "with -monitors, run them again at this interval, eg: 1h; 0 runs them once")
+	fs.Parse(args)
+
+	client, done := setupClient()
+	defer done()
+	tables := make(map[string]bool)
+	for _, t := range strings.Split(*tableList, ",") {
+		if t = strings.TrimSpace(t); t == "" {
+			continue
+		}
+		if _, ok := client.Schema.Tables[t]; !ok {
+			log.Fatalf("No such table: %s", t)
+		}
+		tables[t] = true
+	}
+	if *monitors {
+		sinks, err := parseSinks(strings.Split(*sinkSpecs, ","))
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for {
+			failed, err := client.runMonitors(tables, sinks, *summarize)
+			if err != nil {
+				log.Printf("%v", err)
+			}
+			if *every == 0 {
+				if failed || err != nil {
+					os.Exit(1)
+				}
+				return
+			}
+			time.Sleep(*every)
+		}
+	}
+
+	names := make([]string, 0)
+	for t := range client.Schema.Tables {
+		if len(tables) == 0 || tables[t] {
+			names = append(names, t)
+		}
+	}
+	sort.Strings(names)
+	limits := qualityLimits{MaxNullRate: *maxNullRate, MinDate: *minDate, FutureDays: *futureDays}
+	results := make([]*qualityResult, 0)
+	now := time.Now()
+	for _, table := range names {
+		types, err := columnTypes(client.reader(), table)
+		if err != nil {
+			log.Printf("%v", err)
+		}
+		for _, check := range qualityChecks(client.Schema, table, types, limits) {
+			r := runQualityCheck(client.reader(), check, now)
+			fmt.Println(r)
+			results = append(results, r)
+		}
+	}
+	if *summarize && len(results) > 0 {
+		summary, err := client.qualitySummary(results)
+		if err != nil {
+			log.Fatalf("Failed to summarize the findings: %v", err)
+		}
+		fmt.Printf("\n%s\n", strings.TrimSpace(summary))
+	}
+	if *register {
+		added, err := client.registerMonitors(results)
+		if err != nil {
+			log.Fatalf("Failed to register monitors: %v", err)
+		}
+		log.Printf("Registered %d new monitors in %s", added, client.Config.filename)
+	}
+}
+
+// runMonitors runs the registered checks, sending the failures to the sinks, and says whether any failed
+func (c *Client) runMonitors(tables map[string]bool, sinks []Sink, summarize bool) (bool, error) {
+	checks := c.profileMonitors(tables)
+	if len(checks) == 0 {
+		log.Printf("No monitors are registered; gorag quality -register adds the checks that fail")
+		return false, nil
+	}
+	now := time.Now()
+	failures := make([]*qualityResult, 0)
+	for _, check := range checks {
+		r := runQualityCheck(c.reader(), check, now)
+		fmt.Println(r)
+		if !r.passed() {
+			failures = append(failures, r)
+		}
+	}
+	if len(failures) == 0 {
+		return false, nil
+	}
+	lines := make([]string, len(failures))
+	for i, r := range failures {
+		lines[i] = r.String()
+	}
+	answer := &Answer{
+		RunID:   newRunID(),
+		Prompt:  "Data quality monitors failed",
+		Summary: strings.Join(lines, "\n"),
+	}
+	if summarize {
+		summary, err := c.qualitySummary(failures)
+		if err != nil {
+			log.Printf("Failed to summarize the findings: %v", err)
+		} else {
+			answer.Summary = strings.TrimSpace(summary) + "\n\n" + answer.Summary
+		}
+	}
+	return true, sendAll(sinks, answer, "")
+}
@@ -0,0 +1,178 @@
+package gorag
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+  Nothing gorag asks the model for should change the database, so a
+  query runs only if it can't: one statement, a SELECT (or WITH ...
+  SELECT, VALUES, TABLE), read from the tokens the way the database
+  would read them, so a DROP in a string or a comment is no reason to
+  refuse, and one after a semicolon is. Inside it, there may be no
+  INSERT, UPDATE, DELETE or MERGE in a CTE or subquery (WITH d AS
+  (DELETE ... RETURNING *) SELECT ...), no SELECT ... INTO, which
+  makes a table or writes a file, no FOR UPDATE or FOR SHARE, which
+  lock rows (or mysql's LOCK IN SHARE MODE), and no call to the
+  functions that do something besides returning a value: end
+  sessions, change settings, read or write files, or sleep, named
+  plainly or "quoted", nor on clickhouse to the table functions that
+  reach outside it, url(), file(), remote() and the like. Sql server
+  needs no semicolon between statements, so SELECT 1 DROP TABLE t is
+  two of them there, and any word that starts a statement is refused
+  wherever it is. A quote that doesn't end,
+  or that a server could end somewhere else, eg: postgres' '\' with
+  standard_conforming_strings off, is refused too, since the
+  statements the database sees could be others than the ones read
+  here. The connection should still be one that can only
+  read; this is so a bad query is refused with a reason, before the
+  database sees it, on every database.
+*/
+
+// readOnlyKinds are the statements that only read
+var readOnlyKinds = map[string]bool{"SELECT": true, "VALUES": true, "TABLE": true}
+
+// writeKeywords start a statement that writes, where a subquery or CTE would have a SELECT
+var writeKeywords = map[string]bool{"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true}
+
+/*
+  tsqlStatements start a statement in a sql server batch, and none of
+  them can be part of a SELECT. OPEN, CLOSE and PRINT are left out, as
+  columns are named that, and they do nothing without a DECLARE.
+*/
+var tsqlStatements = map[string]bool{
+	"ALTER": true, "BACKUP": true, "BEGIN": true, "BULK": true, "CHECKPOINT": true, "COMMIT": true,
+	"CREATE": true, "DBCC": true, "DEALLOCATE": true, "DECLARE": true, "DELETE": true, "DENY": true,
+	"DISABLE": true, "DROP": true, "ENABLE": true, "EXEC": true, "EXECUTE": true, "GO": true,
+	"GOTO": true, "GRANT": true, "IF": true, "INSERT": true, "KILL": true, "MERGE": true,
+	"RAISERROR": true, "READTEXT": true, "RECONFIGURE": true, "RESTORE": true, "REVERT": true,
+	"REVOKE": true, "ROLLBACK": true, "SET": true, "SETUSER": true, "SHUTDOWN": true, "THROW": true,
+	"TRUNCATE": true, "UPDATE": true, "UPDATETEXT": true, "USE": true, "WAITFOR": true, "WHILE": true,
+	"WRITETEXT": true,
+}
+
+// tsqlBatchProblem is why the sql server statement has another after its first word, or ""
+func tsqlBatchProblem(stmt []sqlToken) string {
+	if *driver != "sqlserver" || len(stmt) == 0 {
+		return ""
+	}
+	// UPDATE t SET ... has one SET of its own
+	set := stmt[0].upper() == "UPDATE"
+	for _, t := range stmt[1:] {
+		if set && t.upper() == "SET" {
+			set = false
+			continue
+		}
+		if t.Kind == sqlWord && tsqlStatements[t.upper()] {
+			return fmt.Sprintf("%s starts another statement, which sql server runs without a semicolon", t.Text)
+		}
+	}
+	return ""
+}
+
+// clickhouseExternal are clickhouse's table functions that read files, urls or other servers
+var clickhouseExternal = map[string]bool{
+	"url": true, "urlcluster": true, "file": true, "filecluster": true, "remote": true, "remotesecure": true,
+	"cluster": true, "clusterallreplicas": true, "s3": true, "s3cluster": true, "gcs": true, "azureblobstorage": true,
+	"hdfs": true, "hdfscluster": true, "mysql": true, "postgresql": true, "mongodb": true, "redis": true,
+	"jdbc": true, "odbc": true, "sqlite": true, "executable": true, "input": true, "dictionary": true,
+	"iceberg": true, "deltalake": true, "hudi": true,
+}
+
+// sideEffectFunctions do something besides returning a value, by lower case name
+var sideEffectFunctions = map[string]bool{
+	// postgres
+	"pg_terminate_backend": true, "pg_cancel_backend": true, "pg_reload_conf": true, "pg_rotate_logfile": true,
+	"set_config": true, "nextval": true, "setval": true, "pg_sleep": true, "pg_sleep_for": true, "pg_sleep_until": true,
+	"pg_file_write": true, "pg_file_rename": true, "pg_file_unlink": true, "pg_file_sync": true, "pg_logdir_ls": true,
+	"lo_import": true, "lo_export": true, "lo_unlink": true, "lo_create": true, "lo_from_bytea": true, "lo_put": true,
+	"pg_read_file": true, "pg_read_binary_file": true, "pg_ls_dir": true, "pg_stat_file": true,
+	"dblink": true, "dblink_exec": true, "dblink_connect": true, "dblink_send_query": true,
+	"pg_advisory_lock": true, "pg_advisory_xact_lock": true, "pg_try_advisory_lock": true,
+	"pg_switch_wal": true, "pg_create_restore_point": true, "pg_stat_reset": true, "pg_notify": true,
+	"pg_logical_emit_message": true, "pg_create_logical_replication_slot": true, "pg_drop_replication_slot": true,
+	// mysql
+	"sleep": true, "benchmark": true, "load_file": true, "get_lock": true, "release_lock": true,
+	// sqlite
+	"load_extension": true, "writefile": true, "readfile": true,
+	// sql server
+	"xp_cmdshell": true, "openrowset": true, "opendatasource": true,
+	// snowflake
+	"system$cancel_query": true, "system$abort_session": true, "system$wait": true,
+}
+
+// readOnlyProblem is why the query might change something, or "" when it can only read
+func readOnlyProblem(query string) string {
+	tokens := lexSQL(query)
+	if problem := unclearQuote(tokens); problem != "" {
+		return problem
+	}
+	statements := splitStatements(tokens)
+	if len(statements) == 0 {
+		return "there is no statement"
+	}
+	if len(statements) > 1 {
+		return fmt.Sprintf("only one statement can run, not %d", len(statements))
+	}
+	stmt := statements[0]
+	if kind := statementKind(stmt); !readOnlyKinds[kind] {
+		return fmt.Sprintf("only a SELECT can run, not %s", kind)
+	}
+	if problem := tsqlBatchProblem(stmt); problem != "" {
+		return problem
+	}
+	for i, t := range stmt {
+		word := t.upper()
+		var next, after string
+		if i+1 < len(stmt) {
+			next = stmt[i+1].upper()
+			if stmt[i+1].Kind == sqlPunct {
+				next = stmt[i+1].Text
+			}
+		}
+		if i+2 < len(stmt) {
+			after = stmt[i+2].upper()
+		}
+		switch {
+		case t.Kind == sqlPunct && t.Text == "(" && writeKeywords[next]:
+			return fmt.Sprintf("it has a %s inside it", next)
+		case word == "INTO":
+			return "SELECT ... INTO writes a table or a file"
+		case word == "FOR" && (next == "UPDATE" || next == "SHARE" || next == "KEY" || next == "NO" && after == "KEY"):
+			return "FOR UPDATE and FOR SHARE lock the rows"
+		case word == "LOCK" && next == "IN":
+			return "LOCK IN SHARE MODE locks the rows"
+		case next == "(" && isSideEffect(t):
+			return fmt.Sprintf("%s does more than return a value", t.Text)
+		}
+	}
+	return ""
+}
+
+// unclearQuote is why the tokens can't be trusted to be what the database reads, or ""
+func unclearQuote(tokens []sqlToken) string {
+	for _, t := range tokens {
+		if t.Unclear {
+			return fmt.Sprintf("the quote at %s doesn't end, or doesn't end in the same place on every server", t.Text)
+		}
+	}
+	return ""
+}
+
+// isSideEffect is whether the name, quoted or not, is a function that does more than return a value
+func isSideEffect(t sqlToken) bool {
+	if t.Kind != sqlWord && t.Kind != sqlQuotedIdent {
+		return false
+	}
+	name := strings.ToLower(t.ident())
+	return sideEffectFunctions[name] || *driver == "clickhouse" && clickhouseExternal[name]
+}
+
+// checkReadOnly refuses a query that could change the database
+func checkReadOnly(query string) error {
+	if problem := readOnlyProblem(query); problem != "" {
+		return fmt.Errorf("refused a query that isn't read only, since %s: %s", problem, query)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package gorag
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadOnlyProblem(t *testing.T) {
+	cases := []struct {
+		driver string
+		query  string
+		want   string // in the problem, or "" when the query may run
+	}{
+		// what may run
+		{"postgres", `SELECT * FROM t`, ""},
+		{"postgres", `SELECT 1;`, ""},
+		{"postgres", `WITH w AS (SELECT 1) SELECT * FROM w`, ""},
+		{"postgres", `(SELECT 1) UNION (SELECT 2)`, ""},
+		{"postgres", `VALUES (1), (2)`, ""},
+		{"postgres", `TABLE t`, ""},
+		{"postgres", `SELECT 'DROP TABLE t; DELETE FROM t'`, ""},
+		{"postgres", "SELECT 1 -- ; DROP TABLE t\n", ""},
+		{"postgres", `SELECT 1 /* ; DROP TABLE t */`, ""},
+		{"postgres", `SELECT $$; DROP TABLE t$$`, ""},
+		{"postgres", `SELECT E'a\\b', 'a\b'`, ""},
+		{"postgres", `SELECT "sleep" FROM t`, ""},
+		{"postgres", `SELECT * FROM t FOR_x`, ""},
+
+		// one statement
+		{"postgres", ``, "there is no statement"},
+		{"postgres", `;`, "there is no statement"},
+		{"postgres", `SELECT 1; SELECT 2`, "only one statement"},
+		{"postgres", `SELECT 1; DROP TABLE t`, "only one statement"},
+
+		// that only reads
+		{"postgres", `DROP TABLE t`, "not DROP"},
+		{"postgres", `DELETE FROM t`, "not DELETE"},
+		{"postgres", `UPDATE t SET a = 1`, "not UPDATE"},
+		{"postgres", `INSERT INTO t VALUES (1)`, "not INSERT"},
+		{"postgres", `WITH w AS (SELECT 1) DELETE FROM t`, "not DELETE"},
+		{"postgres", `COPY t TO '/tmp/x'`, "not COPY"},
+		{"postgres", `SET statement_timeout = 0`, "not SET"},
+
+		// with no write inside it
+		{"postgres", `WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d`, "DELETE inside it"},
+		{"postgres", `WITH u AS (UPDATE t SET a = 1 RETURNING *) SELECT 1`, "UPDATE inside it"},
+		{"postgres", `WITH i AS (INSERT INTO t VALUES (1) RETURNING *) SELECT 1`, "INSERT inside it"},
+		{"postgres", `SELECT * FROM (MERGE INTO t USING s ON true WHEN MATCHED THEN DELETE) m`, "MERGE inside it"},
+
+		// no SELECT ... INTO
+		{"postgres", `SELECT * INTO copy FROM t`, "INTO"},
+
+		// no row locks
+		{"postgres", `SELECT * FROM t FOR UPDATE`, "lock the rows"},
+		{"postgres", `SELECT * FROM t FOR SHARE`, "lock the rows"},
+		{"postgres", `SELECT * FROM t FOR NO KEY UPDATE`, "lock the rows"},
+		{"postgres", `SELECT * FROM t FOR KEY SHARE`, "lock the rows"},
+		{"mysql", `SELECT * FROM t LOCK IN SHARE MODE`, "LOCK IN SHARE MODE"},
+		{"mysql", `SELECT * FROM t WHERE a = 1 lock in share mode`, "LOCK IN SHARE MODE"},
+
+		// no functions with side effects, however they are named
+		{"postgres", `SELECT pg_sleep(10)`, "pg_sleep does more"},
+		{"postgres", `SELECT PG_SLEEP(10)`, "does more"},
+		{"postgres", `SELECT pg_catalog.pg_sleep(10)`, "does more"},
+		{"postgres", `SELECT "pg_sleep"(1)`, `"pg_sleep" does more`},
+		{"postgres", `SELECT "pg_advisory_lock"(1)`, "does more"},
+		{"postgres", `SELECT pg_terminate_backend(pid) FROM pg_stat_activity`, "does more"},
+		{"postgres", `SELECT set_config('x', 'y', false)`, "does more"},
+		{"postgres", `SELECT * FROM dblink('host=x', 'DROP TABLE t') AS r(a int)`, "does more"},
+		{"mysql", `SELECT sleep(10)`, "does more"},
+		{"mysql", "SELECT `sleep`(10)", "does more"},
+		{"sqlite", `SELECT load_extension('x')`, "does more"},
+		{"sqlserver", `SELECT * FROM [openrowset]('x', 'y', 'z')`, "does more"},
+		{"postgres", `SELECT pg_file_write('/tmp/x', 'y', false)`, "pg_file_write does more"},
+		{"postgres", `SELECT lo_export(16385, '/tmp/x')`, "lo_export does more"},
+		{"clickhouse", `SELECT * FROM url('http://x/y', CSV)`, "url does more"},
+		{"clickhouse", `SELECT * FROM file('/etc/passwd', 'LineAsString')`, "file does more"},
+		{"clickhouse", `SELECT * FROM remote('db:9000', system.users)`, "remote does more"},
+		{"clickhouse", `SELECT * FROM remoteSecure('db:9440', default.t)`, "does more"},
+		{"clickhouse", `SELECT file FROM t`, ""},
+		{"postgres", `SELECT url(1)`, ""},
+
+		// a sql server batch needs no semicolon between its statements
+		{"sqlserver", `SELECT 1 DROP TABLE t`, "DROP starts another statement"},
+		{"sqlserver", "SELECT * FROM t\nEXEC xp_cmdshell 'dir'", "EXEC starts another statement"},
+		{"sqlserver", `SELECT * FROM t EXECUTE('DROP TABLE t')`, "EXECUTE starts another statement"},
+		{"sqlserver", `SELECT 1 INSERT INTO t VALUES (1)`, "INSERT starts another statement"},
+		{"sqlserver", `SELECT 1 UPDATE t SET a = 1`, "UPDATE starts another statement"},
+		{"sqlserver", `SELECT 1 DELETE t`, "DELETE starts another statement"},
+		{"sqlserver", `SELECT 1 MERGE t USING s ON 1 = 1 WHEN MATCHED THEN DELETE;`, "MERGE starts another statement"},
+		{"sqlserver", `SELECT 1 CREATE TABLE x (a int)`, "CREATE starts another statement"},
+		{"sqlserver", `SELECT 1 ALTER TABLE t ADD b int`, "ALTER starts another statement"},
+		{"sqlserver", `SELECT 1 TRUNCATE TABLE t`, "TRUNCATE starts another statement"},
+		{"sqlserver", `SELECT 1 GRANT SELECT ON t TO u`, "GRANT starts another statement"},
+		{"sqlserver", `SELECT 1 DECLARE @x int`, "DECLARE starts another statement"},
+		{"sqlserver", `SELECT 1 WAITFOR DELAY '00:01'`, "WAITFOR starts another statement"},
+		{"sqlserver", `SELECT 1 SET NOCOUNT ON`, "SET starts another statement"},
+		{"sqlserver", `SELECT 'DROP TABLE t', [exec] FROM t`, ""},
+		{"sqlserver", `SELECT TOP 10 open, close FROM prices ORDER BY day OFFSET 0 ROWS FETCH NEXT 10 ROWS ONLY`, ""},
+		{"postgres", `SELECT 1 WHERE 'x' = 'drop'`, ""},
+
+		// quotes that end where the database would end them
+		{"postgres", `SELECT E'\''; DROP TABLE t; --'`, "only one statement"},
+		{"postgres", `SELECT e'\'' FROM t`, ""},
+		{"postgres", `SELECT '\'; DROP TABLE t; --'`, "doesn't end"},
+		{"postgres", `SELECT 'abc`, "doesn't end"},
+		{"postgres", `SELECT "abc`, "doesn't end"},
+		{"postgres", `SELECT $x$abc`, "doesn't end"},
+		{"sqlite", `SELECT [abc`, "doesn't end"},
+		{"bigquery", `SELECT '''abc`, "doesn't end"},
+
+		// and comments the database would run
+		{"mysql", `SELECT 1 /*! ; DROP TABLE t */`, "only one statement"},
+		{"mysql", `SELECT 'a\'; DROP TABLE t; --'`, ""},
+		{"mysql", "SELECT 1 # ; DROP TABLE t\n", ""},
+	}
+	for _, c := range cases {
+		withDriver(t, c.driver)
+		got := readOnlyProblem(c.query)
+		switch {
+		case c.want == "" && got != "":
+			t.Errorf("%s: %q was refused, since %s", c.driver, c.query, got)
+		case c.want != "" && !strings.Contains(got, c.want):
+			t.Errorf("%s: %q: got problem %q, want one with %q", c.driver, c.query, got, c.want)
+		}
+	}
+}
+
+func TestCheckReadOnly(t *testing.T) {
+	withDriver(t, "postgres")
+	if err := checkReadOnly(`SELECT 1`); err != nil {
+		t.Errorf("SELECT 1 was refused: %v", err)
+	}
+	err := checkReadOnly(`DROP TABLE t`)
+	if err == nil || !strings.Contains(err.Error(), "DROP TABLE t") {
+		t.Errorf("DROP TABLE t: got %v, want a refusal that shows the query", err)
+	}
+}
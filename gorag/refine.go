@@ -0,0 +1,247 @@
+package gorag
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+  A refinement ("drop the rows where region is unknown", "sort by the
+  second column") works on the result we already have, rather than
+  asking the database again. The model only turns the instruction into
+  a few operations (filter, sort, limit, columns), seeing the column
+  names and types but none of the values, and they are applied here.
+  The table is the head of the result, the first -refine-rows rows, so
+  refining a bigger result says so.
+*/
+var refineRows = Flags.Int("refine-rows", 10000, "how many rows of a result are kept to refine")
+
+type resultTable struct {
+	Columns   []string
+	Types     []string
+	Rows      [][]*string // nil is NULL
+	Truncated bool        // there were more rows than were kept
+}
+
+type refineOp struct {
+	Op      string   `json:"op"`                // filter, sort, limit or columns
+	Column  string   `json:"column,omitempty"`  // a name, or a position from 1
+	Compare string   `json:"compare,omitempty"` // =, !=, <, <=, >, >=, contains, not contains, empty, not empty
+	Value   string   `json:"value,omitempty"`
+	Desc    bool     `json:"desc,omitempty"`
+	N       int      `json:"n,omitempty"`
+	Columns []string `json:"columns,omitempty"`
+}
+
+// keep adds a scanned row, or notes that it didn't fit
+func (t *resultTable) keep(values []interface{}, max int) {
+	if len(t.Rows) >= max {
+		t.Truncated = true
+		return
+	}
+	row := make([]*string, len(values))
+	for i, v := range values {
+		var s string
+		switch x := v.(type) {
+		case nil:
+			continue
+		case []byte:
+			s = promptValue(t.Types[i], x)
+		default:
+			s = fmt.Sprint(x)
+		}
+		row[i] = &s
+	}
+	t.Rows = append(t.Rows, row)
+}
+
+func (t *resultTable) column(name string) (int, error) {
+	name = strings.Trim(name, `"`)
+	if n, err := strconv.Atoi(name); err == nil && n >= 1 && n <= len(t.Columns) {
+		return n - 1, nil
+	}
+	for i, c := range t.Columns {
+		if strings.EqualFold(c, name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no column %s in the result", name)
+}
+
+// compareValues compares as numbers when both are, and as text otherwise
+func compareValues(a, b string) int {
+	x, errx := strconv.ParseFloat(a, 64)
+	y, erry := strconv.ParseFloat(b, 64)
+	if errx == nil && erry == nil {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+func matches(v *string, compare, value string) (bool, error) {
+	switch compare {
+	case "empty":
+		return v == nil || strings.TrimSpace(*v) == "", nil
+	case "not empty":
+		return v != nil && strings.TrimSpace(*v) != "", nil
+	}
+	if v == nil {
+		// as in SQL, NULL is neither equal nor unequal to anything
+		return false, nil
+	}
+	switch compare {
+	case "=":
+		return compareValues(*v, value) == 0 || strings.EqualFold(*v, value), nil
+	case "!=":
+		return compareValues(*v, value) != 0 && !strings.EqualFold(*v, value), nil
+	case "<":
+		return compareValues(*v, value) < 0, nil
+	case "<=":
+		return compareValues(*v, value) <= 0, nil
+	case ">":
+		return compareValues(*v, value) > 0, nil
+	case ">=":
+		return compareValues(*v, value) >= 0, nil
+	case "contains":
+		return strings.Contains(strings.ToLower(*v), strings.ToLower(value)), nil
+	case "not contains":
+		return !strings.Contains(strings.ToLower(*v), strings.ToLower(value)), nil
+	}
+	return false, fmt.Errorf("unknown comparison %s", compare)
+}
+
+// apply returns a new table; t is left as it was
+func (t *resultTable) apply(ops []refineOp) (*resultTable, error) {
+	out := &resultTable{Columns: t.Columns, Types: t.Types, Rows: t.Rows, Truncated: t.Truncated}
+	for _, op := range ops {
+		switch op.Op {
+		case "filter":
+			i, err := out.column(op.Column)
+			if err != nil {
+				return nil, err
+			}
+			kept := make([][]*string, 0, len(out.Rows))
+			for _, row := range out.Rows {
+				ok, err := matches(row[i], op.Compare, op.Value)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					kept = append(kept, row)
+				}
+			}
+			out.Rows = kept
+		case "sort":
+			i, err := out.column(op.Column)
+			if err != nil {
+				return nil, err
+			}
+			rows := append([][]*string{}, out.Rows...)
+			sort.SliceStable(rows, func(a, b int) bool {
+				x, y := rows[a][i], rows[b][i]
+				// NULLs last either way, as ORDER BY does ascending
+				if x == nil || y == nil {
+					return x != nil
+				}
+				if op.Desc {
+					return compareValues(*x, *y) > 0
+				}
+				return compareValues(*x, *y) < 0
+			})
+			out.Rows = rows
+		case "limit":
+			if op.N >= 0 && op.N < len(out.Rows) {
+				out.Rows = out.Rows[:op.N]
+			}
+		case "columns":
+			idx := make([]int, 0, len(op.Columns))
+			for _, name := range op.Columns {
+				i, err := out.column(name)
+				if err != nil {
+					return nil, err
+				}
+				idx = append(idx, i)
+			}
+			next := &resultTable{Truncated: out.Truncated}
+			for _, i := range idx {
+				next.Columns = append(next.Columns, out.Columns[i])
+				next.Types = append(next.Types, out.Types[i])
+			}
+			for _, row := range out.Rows {
+				picked := make([]*string, len(idx))
+				for k, i := range idx {
+					picked[k] = row[i]
+				}
+				next.Rows = append(next.Rows, picked)
+			}
+			out = next
+		default:
+			return nil, fmt.Errorf("unknown operation %s", op.Op)
+		}
+	}
+	return out, nil
+}
+
+// String renders rows the way query results are, one col: value line per column
+func (t *resultTable) String() string {
+	var sb strings.Builder
+	for _, row := range t.Rows {
+		for i, v := range row {
+			s := "<nil>"
+			if v != nil {
+				s = *v
+			}
+			sb.WriteString(fmt.Sprintf("%s: %s\n", t.Columns[i], s))
+		}
+	}
+	sb.WriteString(fmt.Sprintf("(%d rows", len(t.Rows)))
+	if t.Truncated {
+		sb.WriteString(", refined from the first rows of the result only")
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+// Refine turns an instruction into operations on the table, and applies them
+func (c *Client) Refine(t *resultTable, instruction string) (*resultTable, []refineOp, error) {
+	columns := make([]string, len(t.Columns))
+	for i, name := range t.Columns {
+		columns[i] = fmt.Sprintf("%d. %s (%s)", i+1, name, strings.ToLower(t.Types[i]))
+	}
+	var out struct {
+		Ops []refineOp `json:"ops"`
+	}
+	err := c.llmJSON(fmt.Sprintf(`
+A query result has these columns:
+
+%s
+
+Turn this instruction about the result into operations on it: %s
+
+The operations, applied in order, are
+{"op": "filter", "column": "...", "compare": "...", "value": "..."}, which keeps the rows that match,
+  where compare is =, !=, <, <=, >, >=, contains, not contains, empty (null or blank) or not empty;
+{"op": "sort", "column": "...", "desc": true or false};
+{"op": "limit", "n": 10};
+{"op": "columns", "columns": ["...", "..."]}, which keeps those columns in that order.
+A column is its name or its number. To drop rows, filter for the rows to keep.
+http response must be application/json:
+{ "ops": [ ... ] }
+`, strings.Join(columns, "\n"), instruction), &out)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to understand the refinement: %v", err)
+	}
+	refined, err := t.apply(out.Ops)
+	if err != nil {
+		return nil, out.Ops, err
+	}
+	return refined, out.Ops, nil
+}
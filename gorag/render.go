@@ -0,0 +1,310 @@
+package gorag
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+  On a terminal, the command line prints the answer rendered: the query
+  with its keywords, strings and numbers in color, and the summary's
+  markdown as it would look, headings and bold, lists, code, and tables
+  drawn with their columns lined up. A summary that streams is
+  rendered a line at a time, and a table once it ends. With -plain, or
+  NO_COLOR set, or when the output isn't a terminal, eg: piped to a
+  file, the answer is printed as it came, with no escape codes. Either
+  way it goes to stdout, and the log to stderr.
+*/
+var plainFlag = Flags.Bool("plain", false, "print the answer as it is, without colors or markdown rendering")
+
+const (
+	ansiReset     = "\x1b[0m"
+	ansiBold      = "\x1b[1m"
+	ansiDim       = "\x1b[2m"
+	ansiItalic    = "\x1b[3m"
+	ansiUnderline = "\x1b[4m"
+	ansiGreen     = "\x1b[32m"
+	ansiYellow    = "\x1b[33m"
+	ansiBlue      = "\x1b[34m"
+	ansiMagenta   = "\x1b[35m"
+	ansiCyan      = "\x1b[36m"
+)
+
+// answerRenderer writes answers to a terminal, or as they are when plain
+type answerRenderer struct {
+	w        io.Writer
+	plain    bool
+	line     strings.Builder // what has streamed of a line that hasn't ended
+	table    []string        // the lines of a markdown table, drawn once it ends
+	fence    bool            // in a ``` block
+	fenceSQL bool            // the block is sql
+}
+
+func newAnswerRenderer(f *os.File) *answerRenderer {
+	return &answerRenderer{w: f, plain: *plainFlag || os.Getenv("NO_COLOR") != "" || !isTerminal(f)}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (r *answerRenderer) style(s, codes string) string {
+	if r.plain || s == "" {
+		return s
+	}
+	return codes + s + ansiReset
+}
+
+// label starts a part of the answer
+func (r *answerRenderer) label(name string) {
+	fmt.Fprintf(r.w, "%s\n", r.style(name+":", ansiBold+ansiBlue))
+}
+
+// sql writes a query, in color
+func (r *answerRenderer) sql(query string) {
+	fmt.Fprintf(r.w, "%s\n", r.highlightSQL(strings.TrimSpace(query)))
+}
+
+// text writes lines that aren't markdown, eg: the result
+func (r *answerRenderer) text(s string) {
+	fmt.Fprintf(r.w, "%s\n", strings.TrimRight(s, "\n"))
+}
+
+// markdown writes a whole summary
+func (r *answerRenderer) markdown(s string) {
+	r.Write(s)
+	r.Flush()
+}
+
+// Write renders the lines of streamed markdown as they end
+func (r *answerRenderer) Write(text string) {
+	if r.plain {
+		fmt.Fprint(r.w, text)
+		return
+	}
+	for {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			r.line.WriteString(text)
+			return
+		}
+		r.line.WriteString(text[:i])
+		r.renderLine(r.line.String())
+		r.line.Reset()
+		text = text[i+1:]
+	}
+}
+
+// Flush renders what is left of the markdown, ending it with a newline
+func (r *answerRenderer) Flush() {
+	if r.plain {
+		fmt.Fprintln(r.w)
+		return
+	}
+	if r.line.Len() > 0 {
+		r.renderLine(r.line.String())
+		r.line.Reset()
+	}
+	r.drawTable()
+	r.fence = false
+}
+
+var (
+	headingLine = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	listLine    = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	ruleLine    = regexp.MustCompile(`^\s*([-*_]\s*){3,}$`)
+	tableRule   = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+)
+
+func (r *answerRenderer) renderLine(line string) {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "|") && !r.fence {
+		r.table = append(r.table, trimmed)
+		return
+	}
+	r.drawTable()
+	if strings.HasPrefix(trimmed, "```") {
+		r.fence = !r.fence
+		lang := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(trimmed, "```")))
+		r.fenceSQL = r.fence && (lang == "sql" || lang == "")
+		return
+	}
+	if r.fence {
+		if r.fenceSQL {
+			fmt.Fprintf(r.w, "    %s\n", r.highlightSQL(line))
+		} else {
+			fmt.Fprintf(r.w, "    %s\n", r.style(line, ansiCyan))
+		}
+		return
+	}
+	if m := headingLine.FindStringSubmatch(trimmed); m != nil {
+		codes := ansiBold + ansiMagenta
+		if len(m[1]) == 1 {
+			codes += ansiUnderline
+		}
+		fmt.Fprintf(r.w, "%s\n", r.style(stripInline(m[2]), codes))
+		return
+	}
+	if ruleLine.MatchString(trimmed) {
+		fmt.Fprintf(r.w, "%s\n", r.style(strings.Repeat("─", 40), ansiDim))
+		return
+	}
+	if strings.HasPrefix(trimmed, ">") {
+		fmt.Fprintf(r.w, "%s %s\n", r.style("│", ansiDim), r.style(stripInline(strings.TrimSpace(trimmed[1:])), ansiItalic))
+		return
+	}
+	if m := listLine.FindStringSubmatch(line); m != nil {
+		bullet := "•"
+		if m[2][0] >= '0' && m[2][0] <= '9' {
+			bullet = m[2]
+		}
+		fmt.Fprintf(r.w, "%s%s %s\n", m[1], r.style(bullet, ansiYellow), r.inline(m[3]))
+		return
+	}
+	fmt.Fprintf(r.w, "%s\n", r.inline(line))
+}
+
+var inlineMarkup = regexp.MustCompile("`[^`]+`|\\*\\*[^*]+\\*\\*|__[^_]+__|\\*[^*\\s][^*]*\\*|\\[[^\\]]+\\]\\([^)]+\\)")
+
+// inline styles bold, italic, code and links
+func (r *answerRenderer) inline(s string) string {
+	return inlineMarkup.ReplaceAllStringFunc(s, func(m string) string {
+		switch {
+		case strings.HasPrefix(m, "`"):
+			return r.style(m[1:len(m)-1], ansiCyan)
+		case strings.HasPrefix(m, "**"), strings.HasPrefix(m, "__"):
+			return r.style(m[2:len(m)-2], ansiBold)
+		case strings.HasPrefix(m, "["):
+			i := strings.Index(m, "](")
+			return m[1:i] + " " + r.style("("+m[i+2:len(m)-1]+")", ansiDim)
+		}
+		return r.style(m[1:len(m)-1], ansiItalic)
+	})
+}
+
+// stripInline is the text without its inline markup, for what is styled whole
+func stripInline(s string) string {
+	return (&answerRenderer{plain: true}).inline(s)
+}
+
+// drawTable writes the markdown table so far with its columns lined up
+func (r *answerRenderer) drawTable() {
+	if len(r.table) == 0 {
+		return
+	}
+	var rows [][]string
+	header := -1
+	for i, line := range r.table {
+		if tableRule.MatchString(line) {
+			if i == 1 {
+				header = 0
+			}
+			continue
+		}
+		line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+		cells := strings.Split(line, "|")
+		for j := range cells {
+			cells[j] = r.inline(strings.TrimSpace(cells[j]))
+		}
+		rows = append(rows, cells)
+	}
+	r.table = nil
+	var widths []int
+	for _, row := range rows {
+		for j, cell := range row {
+			if j >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := visibleLen(cell); n > widths[j] {
+				widths[j] = n
+			}
+		}
+	}
+	border := func(left, mid, right string) {
+		parts := make([]string, len(widths))
+		for j, w := range widths {
+			parts[j] = strings.Repeat("─", w+2)
+		}
+		fmt.Fprintf(r.w, "%s\n", r.style(left+strings.Join(parts, mid)+right, ansiDim))
+	}
+	bar := r.style("│", ansiDim)
+	border("┌", "┬", "┐")
+	for i, row := range rows {
+		var sb strings.Builder
+		sb.WriteString(bar)
+		for j, w := range widths {
+			cell := ""
+			if j < len(row) {
+				cell = row[j]
+			}
+			if i == header {
+				cell = r.style(cell, ansiBold)
+			}
+			sb.WriteString(" " + cell + strings.Repeat(" ", w-visibleLen(cell)) + " " + bar)
+		}
+		fmt.Fprintf(r.w, "%s\n", sb.String())
+		if i == header {
+			border("├", "┼", "┤")
+		}
+	}
+	border("└", "┴", "┘")
+}
+
+var ansiCode = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// visibleLen is how many columns the text takes on a terminal
+func visibleLen(s string) int {
+	return utf8.RuneCountInString(ansiCode.ReplaceAllString(s, ""))
+}
+
+// sqlHighlighted are the words colored as keywords that sqlKeywords doesn't have
+var sqlHighlighted = map[string]bool{
+	"NULL": true, "IS": true, "LIKE": true, "ILIKE": true, "BETWEEN": true, "ASC": true,
+	"DESC": true, "DISTINCT": true, "END": true, "TRUE": true, "FALSE": true, "INTERVAL": true,
+	"TOP": true, "ROWS": true, "ONLY": true, "NEXT": true, "CAST": true, "FILTER": true,
+	"PARTITION": true, "NULLS": true, "FIRST": true, "LAST": true, "RECURSIVE": true,
+}
+
+// highlightSQL colors the query's keywords, functions, strings and numbers, and dims its comments
+func (r *answerRenderer) highlightSQL(query string) string {
+	if r.plain {
+		return query
+	}
+	tokens := lexSQL(query)
+	var sb strings.Builder
+	at := 0
+	for i, t := range tokens {
+		if gap := query[at:t.Pos]; strings.TrimSpace(gap) != "" {
+			// comments are all the lexer skips
+			sb.WriteString(r.style(gap, ansiDim))
+		} else {
+			sb.WriteString(gap)
+		}
+		switch {
+		case t.Kind == sqlString:
+			sb.WriteString(r.style(t.Text, ansiGreen))
+		case t.Kind == sqlNumber:
+			sb.WriteString(r.style(t.Text, ansiYellow))
+		case t.Kind == sqlWord && (isSQLKeyword(t.upper()) || sqlHighlighted[t.upper()]):
+			sb.WriteString(r.style(t.Text, ansiBold+ansiBlue))
+		case t.Kind == sqlWord && i+1 < len(tokens) && tokens[i+1].Text == "(":
+			sb.WriteString(r.style(t.Text, ansiMagenta))
+		case t.Kind == sqlQuotedIdent:
+			sb.WriteString(r.style(t.Text, ansiCyan))
+		default:
+			sb.WriteString(t.Text)
+		}
+		at = t.Pos + len(t.Text)
+	}
+	if rest := query[at:]; strings.TrimSpace(rest) != "" {
+		sb.WriteString(r.style(rest, ansiDim))
+	} else {
+		sb.WriteString(rest)
+	}
+	return sb.String()
+}
@@ -0,0 +1,436 @@
+package gorag
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+  gorag repl asks one question per line, so an analyst can keep going
+  without restarting. Lines that start with \ are meta-commands, named
+  after psql's so the same habits work:
+
+    \dt [pattern]        tables
+    \d [table]           columns, keys and comments of a table
+    \timing [on|off]     how long each question took
+    \copy (query) TO 'file' [CSV [HEADER]]
+    \copy table TO 'file' ...
+    \sql                 the last generated query
+    \local question      ask DuckDB about the last result, eg: \local median total by region
+    \q                   quit
+
+  A line starting with | refines the last result instead of asking the
+  database again: "| drop the rows where region is unknown", "| sort
+  by the second column". Refinements build on each other until the next
+  question.
+
+  They answer from the schema gorag already loaded, which is what the
+  model sees, not from the catalogs. \copy only goes out, and the query
+  is held to the same policies as a generated one.
+*/
+type repl struct {
+	client *Client
+	q      Question
+	out    io.Writer
+	timing bool
+	last   string
+	table  *resultTable
+}
+
+func (r *repl) meta(line string) (quit bool, err error) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case `\q`, `\quit`:
+		return true, nil
+	case `\?`:
+		fmt.Fprintln(r.out, `\dt [pattern], \d [table], \timing [on|off], \copy (query) TO 'file' [CSV [HEADER]], \sql, \local question, \q, | refinement`)
+	case `\dt`:
+		r.listTables(arg)
+	case `\d`:
+		if arg == "" {
+			r.listTables("")
+			return false, nil
+		}
+		return false, r.describe(arg)
+	case `\timing`:
+		switch strings.ToLower(arg) {
+		case "":
+			r.timing = !r.timing
+		case "on":
+			r.timing = true
+		case "off":
+			r.timing = false
+		default:
+			return false, fmt.Errorf(`\timing: unrecognized value "%s": Boolean expected`, arg)
+		}
+		state := "off"
+		if r.timing {
+			state = "on"
+		}
+		fmt.Fprintf(r.out, "Timing is %s.\n", state)
+	case `\copy`:
+		return false, r.copy(arg)
+	case `\local`:
+		return false, r.local(arg)
+	case `\sql`:
+		if r.last == "" {
+			fmt.Fprintln(r.out, "No query yet.")
+		} else {
+			fmt.Fprintln(r.out, r.last)
+		}
+	default:
+		return false, fmt.Errorf(`invalid command %s. Try \? for help.`, name)
+	}
+	return false, nil
+}
+
+// listTables takes psql's * wildcard, and matches anywhere without one
+func (r *repl) listTables(pattern string) {
+	tables := make([]string, 0, len(r.client.Schema.Tables))
+	for t := range r.client.Schema.Tables {
+		if pattern == "" || globMatch(strings.ToLower(pattern), t) {
+			tables = append(tables, t)
+		}
+	}
+	sort.Strings(tables)
+	if len(tables) == 0 {
+		fmt.Fprintln(r.out, "Did not find any relations.")
+		return
+	}
+	for _, t := range tables {
+		line := fmt.Sprintf("%-30s %3d columns", t, len(r.client.Schema.Tables[t]))
+		if comment := r.client.Schema.Comments[t]; comment != "" {
+			line += "  " + comment
+		}
+		fmt.Fprintln(r.out, line)
+	}
+}
+
+func globMatch(pattern, s string) bool {
+	if !strings.Contains(pattern, "*") {
+		return strings.Contains(s, pattern)
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for i, p := range parts[1:] {
+		if i == len(parts)-2 {
+			return strings.HasSuffix(s, p)
+		}
+		k := strings.Index(s, p)
+		if k < 0 {
+			return false
+		}
+		s = s[k+len(p):]
+	}
+	return true
+}
+
+func (r *repl) describe(table string) error {
+	schema := r.client.Schema
+	columns, ok := schema.Tables[table]
+	if !ok {
+		return fmt.Errorf(`did not find any relation named "%s"`, table)
+	}
+	fmt.Fprintf(r.out, "Table %s\n", table)
+	if comment := schema.Comments[table]; comment != "" {
+		fmt.Fprintf(r.out, "%s\n", comment)
+	}
+	pk := make(map[string]bool)
+	for _, col := range schema.PrimaryKeys[table] {
+		pk[col] = true
+	}
+	for _, col := range columns {
+		typ := ""
+		if s := schema.Stats[table+"."+col]; s != nil {
+			typ = s.Type
+		}
+		line := fmt.Sprintf("  %-30s %-20s", col, typ)
+		if pk[col] {
+			line += " primary key"
+		}
+		if comment := schema.Comments[table+"."+col]; comment != "" {
+			line += "  " + comment
+		}
+		fmt.Fprintln(r.out, strings.TrimRight(line, " "))
+	}
+	refs, referenced := make([]string, 0), make([]string, 0)
+	for _, fk := range schema.foreignKeyGroups() {
+		cols, refCols := make([]string, len(fk)), make([]string, len(fk))
+		for i, k := range fk {
+			cols[i], refCols[i] = k.Column, k.RefColumn
+		}
+		if fk[0].Table == table {
+			refs = append(refs, fmt.Sprintf("  (%s) -> %s(%s)", strings.Join(cols, ", "), fk[0].RefTable, strings.Join(refCols, ", ")))
+		}
+		if fk[0].RefTable == table {
+			referenced = append(referenced, fmt.Sprintf("  %s(%s) -> (%s)", fk[0].Table, strings.Join(cols, ", "), strings.Join(refCols, ", ")))
+		}
+	}
+	if len(refs) > 0 {
+		fmt.Fprintf(r.out, "Foreign-key constraints:\n%s\n", strings.Join(refs, "\n"))
+	}
+	if len(referenced) > 0 {
+		fmt.Fprintf(r.out, "Referenced by:\n%s\n", strings.Join(referenced, "\n"))
+	}
+	return nil
+}
+
+/*
+  copy takes psql's \copy ... TO syntax: a query in parentheses or a
+  table, a quoted file name or STDOUT, and CSV and HEADER either bare or
+  as WITH (FORMAT csv, HEADER). Without CSV it writes psql's text
+  format, tab separated.
+*/
+func (r *repl) copy(arg string) error {
+	tokens := lexSQL(arg)
+	if len(tokens) == 0 {
+		return fmt.Errorf(`\copy: arguments required`)
+	}
+	var query string
+	i := 0
+	if tokens[0].Text == "(" {
+		depth := 0
+		for ; i < len(tokens); i++ {
+			if tokens[i].Kind == sqlPunct && tokens[i].Text == "(" {
+				depth++
+			}
+			if tokens[i].Kind == sqlPunct && tokens[i].Text == ")" {
+				if depth--; depth == 0 {
+					break
+				}
+			}
+		}
+		if i == len(tokens) {
+			return fmt.Errorf(`\copy: unbalanced parentheses`)
+		}
+		query = arg[tokens[0].Pos+1 : tokens[i].Pos]
+		i++
+	} else {
+		name, next := qualifiedName(tokens, 0)
+		if name == "" {
+			return fmt.Errorf(`\copy: expected a table or a (query)`)
+		}
+		query = "SELECT * FROM " + quoteIdent(name)
+		i = next
+	}
+	if i >= len(tokens) || tokens[i].upper() != "TO" {
+		return fmt.Errorf(`\copy: only TO is supported, gorag doesn't write to the database`)
+	}
+	i++
+	if i >= len(tokens) {
+		return fmt.Errorf(`\copy: a file name or STDOUT is required`)
+	}
+	file := ""
+	switch {
+	case tokens[i].Kind == sqlString:
+		file = strings.ReplaceAll(tokens[i].Text[1:len(tokens[i].Text)-1], "''", "'")
+	case tokens[i].upper() == "STDOUT":
+	default:
+		return fmt.Errorf(`\copy: the file name must be quoted`)
+	}
+	csvFormat, header := false, false
+	for _, t := range tokens[i+1:] {
+		switch strings.ToUpper(strings.Trim(t.Text, `'"`)) {
+		case "CSV":
+			csvFormat = true
+		case "HEADER":
+			header = true
+		}
+	}
+
+	query, err := r.client.Validate(&r.q, query)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = r.out
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	rows, err := copyOut(r.client.forRun(&r.q), query, w, csvFormat, header)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(r.out, "COPY %d\n", rows)
+	return nil
+}
+
+func copyOut(c *Client, query string, w io.Writer, csvFormat, header bool) (int, error) {
+	rows, err := c.DB.Query(c.annotate(query))
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute query: %v", err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	cw := csv.NewWriter(w)
+	if !csvFormat {
+		cw.Comma = '\t'
+	}
+	if csvFormat && header {
+		cw.Write(columns)
+	}
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	n := 0
+	record := make([]string, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return n, err
+		}
+		for i, v := range values {
+			switch x := v.(type) {
+			case nil:
+				// psql writes NULL as an empty field in csv, and \N in text
+				record[i] = ""
+				if !csvFormat {
+					record[i] = `\N`
+				}
+			case []byte:
+				record[i] = string(x)
+			case time.Time:
+				record[i] = x.Format("2006-01-02 15:04:05.999999-07")
+			default:
+				record[i] = fmt.Sprint(x)
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return n, err
+		}
+		n++
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return n, err
+	}
+	return n, rows.Err()
+}
+
+func (r *repl) ask(line string) {
+	q := r.q
+	q.Prompt = line
+	start := time.Now()
+	answer, err := r.client.Ask(q)
+	r.table = answer.Table
+	if answer.Query != "" {
+		r.last = answer.Query
+		fmt.Fprintf(r.out, "%s\n\n", answer.Query)
+	}
+	if err != nil {
+		fmt.Fprintf(r.out, "ERROR: %v\n", err)
+	} else {
+		fmt.Fprintf(r.out, "%s\n\n%s\n", answer.Result, answer.Summary)
+		if answer.SuggestedQuery != "" {
+			fmt.Fprintf(r.out, "Try instead: %s\n", answer.SuggestedQuery)
+		}
+	}
+	if r.timing {
+		fmt.Fprintf(r.out, "Time: %.3f ms\n", float64(time.Since(start).Microseconds())/1000)
+	}
+}
+
+func (r *repl) refine(instruction string) error {
+	if r.table == nil {
+		return fmt.Errorf("no result to refine yet")
+	}
+	if instruction == "" {
+		return fmt.Errorf("say how to refine the result, eg: | sort by the second column")
+	}
+	refined, _, err := r.client.Refine(r.table, instruction)
+	if err != nil {
+		return err
+	}
+	r.table = refined
+	fmt.Fprintln(r.out, refined)
+	return nil
+}
+
+func (r *repl) local(question string) error {
+	if r.table == nil {
+		return fmt.Errorf("no result to ask about yet")
+	}
+	if question == "" {
+		return fmt.Errorf(`say what to work out from the result, eg: \local the 90th percentile of total`)
+	}
+	answer, query, err := r.client.AskResult(r.table, question)
+	if query != "" {
+		fmt.Fprintf(r.out, "%s\n\n", query)
+	}
+	if err != nil {
+		return err
+	}
+	r.table = answer
+	fmt.Fprintln(r.out, answer)
+	return nil
+}
+
+func runREPL(args []string) {
+	fs := commandFlags("repl")
+	fs.Parse(args)
+	client, done := setupClient()
+	defer done()
+
+	r := &repl{
+		client: client,
+		out:    os.Stdout,
+		q:      Question{User: os.Getenv("USER"), Purpose: *purpose, Override: *override, CanOverride: true, Keep: *refineRows},
+	}
+	fmt.Fprintln(r.out, `Ask a question, or \? for meta-commands.`)
+	in := bufio.NewScanner(os.Stdin)
+	in.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	// one reader, or each would take lines meant for the other
+	if client.Confirm != nil {
+		client.Confirm = terminalConfirmer{in, r.out}
+	}
+	if client.ConfirmWrites != nil {
+		client.ConfirmWrites = terminalConfirmer{in, r.out}
+	}
+	for {
+		fmt.Fprint(r.out, "gorag> ")
+		if !in.Scan() {
+			break
+		}
+		line := strings.TrimSpace(in.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, `\`):
+			quit, err := r.meta(line)
+			if err != nil {
+				fmt.Fprintf(r.out, "ERROR: %v\n", err)
+			}
+			if quit {
+				return
+			}
+		case strings.HasPrefix(line, "|"):
+			if err := r.refine(strings.TrimSpace(line[1:])); err != nil {
+				fmt.Fprintf(r.out, "ERROR: %v\n", err)
+			}
+		default:
+			r.ask(line)
+		}
+	}
+	if err := in.Err(); err != nil {
+		log.Fatalf("Failed to read input: %v", err)
+	}
+	fmt.Fprintln(r.out)
+}
@@ -0,0 +1,131 @@
+package gorag
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+/*
+  A resultBuffer holds the rendered rows of one result. It keeps them
+  in memory up to a limit and then spills everything to a temp file,
+  so a huge result costs disk instead of memory. Only a bounded head
+  of it is ever handed to the model; all of it can be streamed out as
+  an export.
+*/
+type resultBuffer struct {
+	memLimit int
+	mem      bytes.Buffer
+	spill    *os.File
+	size     int64
+	rows     int
+	cut      bool          // the query had rows after these, which weren't read
+	partial  bool          // reading stopped at the fetch deadline, with rows left
+	table    *resultTable  // the first rows, when the caller wants them
+	export   *resultBuffer // the rows as exports have them, once one differs from what the model sees
+}
+
+func newResultBuffer(memLimit int) *resultBuffer {
+	return &resultBuffer{memLimit: memLimit}
+}
+
+// writeRow adds a row, and exported is how exports have it when that isn't the same, or nil
+func (b *resultBuffer) writeRow(lines, exported []string) error {
+	if exported != nil && b.export == nil {
+		// the rows so far are the same in both
+		export := newResultBuffer(b.memLimit)
+		if _, err := b.WriteTo(export); err != nil {
+			export.Close()
+			return err
+		}
+		b.export = export
+	}
+	if b.export != nil {
+		if exported == nil {
+			exported = lines
+		}
+		if err := b.export.writeRow(exported, nil); err != nil {
+			return err
+		}
+	}
+	for _, line := range lines {
+		if err := b.write(line + "\n"); err != nil {
+			return err
+		}
+	}
+	b.rows++
+	return nil
+}
+
+func (b *resultBuffer) Write(p []byte) (int, error) {
+	if err := b.write(string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (b *resultBuffer) write(s string) error {
+	if b.spill == nil && b.memLimit > 0 && b.mem.Len()+len(s) > b.memLimit {
+		f, err := os.CreateTemp("", "gorag-result-*")
+		if err != nil {
+			return fmt.Errorf("failed to spill result to disk: %v", err)
+		}
+		b.spill = f
+		if _, err := b.mem.WriteTo(f); err != nil {
+			return fmt.Errorf("failed to spill result to disk: %v", err)
+		}
+	}
+	b.size += int64(len(s))
+	if b.spill != nil {
+		_, err := io.WriteString(b.spill, s)
+		return err
+	}
+	b.mem.WriteString(s)
+	return nil
+}
+
+// head is at most max bytes from the start, cut at a line, and whether anything was left out
+func (b *resultBuffer) head(max int) (string, bool) {
+	if max <= 0 || b.size <= int64(max) {
+		max = int(b.size)
+	}
+	data := make([]byte, max)
+	var n int
+	if b.spill != nil {
+		n, _ = b.spill.ReadAt(data, 0)
+	} else {
+		n = copy(data, b.mem.Bytes())
+	}
+	data = data[:n]
+	truncated := int64(n) < b.size
+	if truncated {
+		if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+			data = data[:i+1]
+		}
+	}
+	return string(bytes.TrimRight(data, "\n")), truncated
+}
+
+// WriteTo streams the whole result, as exports have it
+func (b *resultBuffer) WriteTo(w io.Writer) (int64, error) {
+	if b.export != nil {
+		return b.export.WriteTo(w)
+	}
+	if b.spill == nil {
+		n, err := w.Write(b.mem.Bytes())
+		return int64(n), err
+	}
+	return io.Copy(w, io.NewSectionReader(b.spill, 0, b.size))
+}
+
+func (b *resultBuffer) Close() error {
+	if b.export != nil {
+		b.export.Close()
+	}
+	if b.spill == nil {
+		return nil
+	}
+	b.spill.Close()
+	return os.Remove(b.spill.Name())
+}
@@ -0,0 +1,111 @@
+package gorag
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+  When the database rejects a generated query, we ask again with the
+  error. Most failures are a misspelled or invented name, and the raw
+  "column does not exist" doesn't tell the model what the right name
+  is, so we add the schema names closest to the missing one. Policy
+  refusals are never retried; only queries the database choked on.
+*/
+var missingIdentifier = regexp.MustCompile(`(column|relation) "?([^" ]+?)"? does not exist`)
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// closeNames are the names within a few edits of name, closest first
+func closeNames(name string, names []string, limit int) []string {
+	name = strings.ToLower(name)
+	maxDist := max(2, len(name)/3)
+	type scored struct {
+		name string
+		dist int
+	}
+	found := make([]scored, 0)
+	for _, n := range names {
+		d := levenshtein(name, strings.ToLower(n))
+		// a name containing the missing one (or the other way) is close too: cust vs customer_id
+		if d > maxDist && (strings.Contains(strings.ToLower(n), name) || strings.Contains(name, strings.ToLower(n))) {
+			d = maxDist
+		}
+		if d <= maxDist {
+			found = append(found, scored{n, d})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].dist < found[j].dist })
+	out := make([]string, 0, limit)
+	for _, f := range found {
+		if len(out) == limit {
+			break
+		}
+		out = append(out, f.name)
+	}
+	return out
+}
+
+// identifierCandidates are real tables (for a relation) or table.columns (for a column) like name
+func identifierCandidates(schema *DBMetadata, kind, name string) []string {
+	names := make([]string, 0)
+	byName := make(map[string][]string)
+	if kind == "relation" {
+		for t := range schema.Tables {
+			names = append(names, t)
+		}
+		sort.Strings(names)
+		return closeNames(name, names, 5)
+	}
+	// a column may come qualified, as alias.column
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	for t, cols := range schema.Tables {
+		for _, col := range cols {
+			if _, ok := byName[col]; !ok {
+				names = append(names, col)
+			}
+			byName[col] = append(byName[col], t+"."+col)
+		}
+	}
+	sort.Strings(names)
+	out := make([]string, 0)
+	for _, col := range closeNames(name, names, 5) {
+		sort.Strings(byName[col])
+		out = append(out, byName[col]...)
+	}
+	return out
+}
+
+// retryFeedback is what the retry prompt learns from the failure
+func (c *Client) retryFeedback(query string, err error) string {
+	s := fmt.Sprintf("\nA previous attempt at this request was\n\n%s\n\nwhich failed with: %v\n", query, err)
+	if m := missingIdentifier.FindStringSubmatch(err.Error()); m != nil {
+		if candidates := identifierCandidates(c.Schema, m[1], m[2]); len(candidates) > 0 {
+			s += fmt.Sprintf("There is no %s %s. The closest names in the schema are: %s\n", m[1], m[2], strings.Join(candidates, ", "))
+		}
+	}
+	return s
+}
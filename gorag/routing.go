@@ -0,0 +1,189 @@
+package gorag
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+  Most questions are a count or a total from one table, and a small
+  model writes those as well as a big one, for a fraction of the price.
+  With -easy-model and -hard-model, each question is given a difficulty
+  from 0 to 1 before anything is written, and its SQL goes to the hard
+  model at -difficulty-threshold or over, and to the easy one under it;
+  either can be left out, to mean the model it would have had anyway.
+  Summaries still go wherever the data goes.
+
+  The difficulty is half what the question looks like (how long it
+  is, how many tables it names, and words like "compare", "per" or
+  "year over year" that take joins, windows or subqueries) and half how
+  hard the queries were for the config's examples that embed nearest
+  to it, by the complexity of their SQL. Without examples, or when
+  embedding fails, it is only the first half. The difficulty and the
+  route go in the log and the question's ask event, so gorag analytics
+  -by route, or a -usage question, can say what each route costs and
+  how often it works, and the threshold be moved.
+*/
+var easyModel = Flags.String("easy-model", "", "the model easy questions' SQL is written by, eg: gpt-4o-mini")
+var hardModel = Flags.String("hard-model", "", "the model hard questions' SQL is written by, eg: gpt-4o")
+var difficultyThreshold = Flags.Float64("difficulty-threshold", 0.5, "with -easy-model or -hard-model, the difficulty from 0 to 1 at which a question is hard")
+
+// hardTerms are words in a question that usually take more than a filter and a group by
+var hardTerms = []string{
+	"compare", "compared", "versus", "vs", "ratio", "rank", "ranked", "ranking", "percentile", "median",
+	"per", "each", "trend", "growth", "cohort", "retention", "churn", "cumulative", "running", "rolling",
+	"moving", "share", "percentage", "correlation", "between", "without", "never", "except", "first",
+	"previous", "prior", "change", "year over year", "month over month", "week over week", "top",
+}
+
+// how many of the nearest examples a question's difficulty is judged by
+const routingNeighbours = 3
+
+type modelRoute struct {
+	Difficulty float64
+	Hard       bool
+	Model      string // "" for the model the sql stage has anyway
+}
+
+func (m *modelRoute) name() string {
+	if m.Hard {
+		return "hard"
+	}
+	return "easy"
+}
+
+// routing is whether questions are routed by difficulty
+func routing() bool {
+	return *easyModel != "" || *hardModel != ""
+}
+
+// routeQuestion picks the model the question's SQL is written by
+func (c *Client) routeQuestion(question string) *modelRoute {
+	difficulty, how := c.questionDifficulty(question)
+	route := &modelRoute{Difficulty: difficulty, Hard: difficulty >= *difficultyThreshold, Model: *easyModel}
+	if route.Hard {
+		route.Model = *hardModel
+	}
+	model := route.Model
+	if model == "" {
+		model = c.target("sql", "").Model
+	}
+	log.Printf("Question difficulty %.2f by %s, so it is %s and goes to %s", difficulty, how, route.name(), model)
+	return route
+}
+
+// questionDifficulty is from 0 to 1, and what it was judged by
+func (c *Client) questionDifficulty(question string) (float64, string) {
+	looks := c.wordDifficulty(question)
+	near, err := c.exampleDifficulty(question)
+	if err != nil {
+		log.Printf("Judging difficulty by the question's words only: %v", err)
+	}
+	if err != nil || near < 0 {
+		return looks, "words"
+	}
+	return (looks + near) / 2, "words and examples"
+}
+
+// wordDifficulty is how hard the question looks, from its length, hard terms and the tables it names
+func (c *Client) wordDifficulty(question string) float64 {
+	lower := " " + strings.Join(strings.FieldsFunc(strings.ToLower(question), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_')
+	}), " ") + " "
+	length := math.Min(1, float64(len(strings.Fields(lower)))/40)
+	terms := 0
+	for _, t := range hardTerms {
+		if strings.Contains(lower, " "+t+" ") {
+			terms++
+		}
+	}
+	tables := 0
+	if c.Schema != nil {
+		for table := range c.Schema.Tables {
+			_, bare, ok := strings.Cut(table, ".")
+			if !ok {
+				bare = table
+			}
+			bare = strings.ToLower(bare)
+			if strings.Contains(lower, " "+bare+" ") || strings.Contains(lower, " "+strings.TrimSuffix(bare, "s")+" ") {
+				tables++
+			}
+		}
+	}
+	named := 0.0
+	if tables > 1 {
+		named = math.Min(1, float64(tables-1)/3)
+	}
+	return 0.3*length + 0.4*math.Min(1, float64(terms)/3) + 0.3*named
+}
+
+// example prompts are embedded once, by embedding model and prompt
+var exampleEmbeddings = struct {
+	sync.Mutex
+	m map[string][]float64
+}{m: make(map[string][]float64)}
+
+/*
+  exampleDifficulty is how complex the SQL of the examples nearest the
+  question is, weighted by how near they are, or -1 with no examples.
+*/
+func (c *Client) exampleDifficulty(question string) (float64, error) {
+	if c.Config == nil {
+		return -1, nil
+	}
+	c.Config.mu.RLock()
+	examples := make([]*Example, 0, len(c.Config.Examples))
+	for _, ex := range c.Config.Examples {
+		if ex.Broken == "" && ex.Query != "" {
+			examples = append(examples, ex)
+		}
+	}
+	c.Config.mu.RUnlock()
+	if len(examples) == 0 {
+		return -1, nil
+	}
+	model := providerEmbeddingModel()
+	exampleEmbeddings.Lock()
+	missing := make([]string, 0)
+	for _, ex := range examples {
+		if _, ok := exampleEmbeddings.m[model+"\x00"+ex.Prompt]; !ok {
+			missing = append(missing, ex.Prompt)
+		}
+	}
+	exampleEmbeddings.Unlock()
+	vectors, err := c.embed(append(missing, question))
+	if err != nil {
+		return -1, err
+	}
+	exampleEmbeddings.Lock()
+	for i, prompt := range missing {
+		exampleEmbeddings.m[model+"\x00"+prompt] = vectors[i]
+	}
+	type scored struct {
+		ex         *Example
+		similarity float64
+	}
+	near := make([]scored, len(examples))
+	for i, ex := range examples {
+		near[i] = scored{ex, cosineSimilarity(vectors[len(missing)], exampleEmbeddings.m[model+"\x00"+ex.Prompt])}
+	}
+	exampleEmbeddings.Unlock()
+	sort.Slice(near, func(i, j int) bool { return near[i].similarity > near[j].similarity })
+	if len(near) > routingNeighbours {
+		near = near[:routingNeighbours]
+	}
+	weighted, weights := 0.0, 0.0
+	for _, n := range near {
+		w := math.Max(n.similarity, 0)
+		score := float64(sqlComplexity(n.ex.Query).Score)
+		weighted += w * score / (score + 3)
+		weights += w
+	}
+	if weights == 0 {
+		return -1, nil
+	}
+	return weighted / weights, nil
+}
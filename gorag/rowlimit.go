@@ -0,0 +1,87 @@
+package gorag
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/*
+  A generated query with no LIMIT can return millions of rows, which
+  are read, buffered and, as much as -max-llm-bytes allows, put in the
+  summary prompt. With -max-rows, every query gorag runs for a question
+  is limited to one row more than that: a LIMIT it already has is
+  lowered, and one is added where it has none (TOP, or FETCH NEXT
+  after an ORDER BY, for sqlserver), or the query is wrapped when its
+  tail can't take one. Reading stops at -max-rows, and the extra row
+  says whether any were left out; when they were, the answer is marked
+  truncated and the summary is told it only has the first rows. The
+  cap goes for -result exports too.
+*/
+var maxRows = Flags.Int("max-rows", 0, "the most rows a query returns, with a LIMIT added where it has none; 0 runs it as written")
+
+// limitQuery is the query returning at most n rows, or the query itself when it can't be made to
+func limitQuery(query string, n int) string {
+	shape, err := analyzeSelect(query)
+	if err != nil || len(shape.Tokens) == 0 {
+		// Validate only lets a SELECT through; the rows are still cut when read
+		return query
+	}
+	tokens := shape.Tokens
+	// without the semicolon or a comment after the last token, which would swallow a LIMIT
+	body := query[:shape.offsetAt(len(tokens))]
+	fetch := false
+	for _, t := range tokens[shape.Tail:] {
+		if u := t.upper(); u == "FETCH" || u == "OFFSET" {
+			fetch = true
+		}
+	}
+	if *driver == "sqlserver" {
+		return sqlServerLimit(shape, body, n, fetch)
+	}
+	if shape.Limit >= 0 {
+		return lowerLimit(tokens, shape.Limit, body, n)
+	}
+	if fetch {
+		return wrapLimit(body, n)
+	}
+	return fmt.Sprintf("%s\nLIMIT %d", body, n)
+}
+
+// lowerLimit sets the number after LIMIT or TOP at i to n when it is more, and wraps what isn't a plain number
+func lowerLimit(tokens []sqlToken, i int, body string, n int) string {
+	if i+1 >= len(tokens) || tokens[i+1].Kind != sqlNumber || (i+2 < len(tokens) && tokens[i+2].Text == ",") {
+		// LIMIT $1, LIMIT ALL, or mysql's LIMIT offset, count
+		return wrapLimit(body, n)
+	}
+	t := tokens[i+1]
+	if k, err := strconv.Atoi(t.Text); err == nil && k <= n {
+		return body
+	}
+	return body[:t.Pos] + strconv.Itoa(n) + body[t.Pos+len(t.Text):]
+}
+
+func wrapLimit(body string, n int) string {
+	if *driver == "sqlserver" {
+		return fmt.Sprintf("SELECT TOP %d * FROM (\n%s\n) AS limited", n, body)
+	}
+	return fmt.Sprintf("SELECT * FROM (\n%s\n) AS limited\nLIMIT %d", body, n)
+}
+
+// sqlServerLimit is limitQuery for a database without LIMIT
+func sqlServerLimit(shape *selectShape, body string, n int, fetch bool) string {
+	tokens := shape.Tokens
+	at := shape.Select + 1
+	if at < len(tokens) && (tokens[at].upper() == "DISTINCT" || tokens[at].upper() == "ALL") {
+		at++
+	}
+	switch {
+	case at < len(tokens) && tokens[at].upper() == "TOP":
+		return lowerLimit(tokens, at, body, n)
+	case fetch || shape.SetOp:
+		return wrapLimit(body, n)
+	case shape.OrderBy >= 0:
+		return fmt.Sprintf("%s\nOFFSET 0 ROWS FETCH NEXT %d ROWS ONLY", body, n)
+	}
+	pos := shape.offsetAt(at)
+	return body[:pos] + fmt.Sprintf("TOP %d ", n) + body[pos:]
+}
@@ -0,0 +1,59 @@
+package gorag
+
+import "testing"
+
+func TestLimitQuery(t *testing.T) {
+	cases := []struct {
+		driver string
+		query  string
+		want   string
+	}{
+		// postgres
+		{"postgres", `SELECT id FROM t`, "SELECT id FROM t\nLIMIT 11"},
+		{"postgres", `SELECT id FROM t;`, "SELECT id FROM t\nLIMIT 11"},
+		{"postgres", `SELECT id FROM t -- the ids`, "SELECT id FROM t\nLIMIT 11"},
+		{"postgres", `SELECT id FROM t LIMIT 5`, `SELECT id FROM t LIMIT 5`},
+		{"postgres", `SELECT id FROM t LIMIT 500`, `SELECT id FROM t LIMIT 11`},
+		{"postgres", `SELECT id FROM t LIMIT ALL`, "SELECT * FROM (\nSELECT id FROM t LIMIT ALL\n) AS limited\nLIMIT 11"},
+		{"postgres", `SELECT id FROM t ORDER BY id DESC`, "SELECT id FROM t ORDER BY id DESC\nLIMIT 11"},
+		{"postgres", `SELECT id FROM t ORDER BY id FETCH FIRST 500 ROWS ONLY`, "SELECT * FROM (\nSELECT id FROM t ORDER BY id FETCH FIRST 500 ROWS ONLY\n) AS limited\nLIMIT 11"},
+		{"postgres", `SELECT id FROM t OFFSET 5`, "SELECT * FROM (\nSELECT id FROM t OFFSET 5\n) AS limited\nLIMIT 11"},
+		{"postgres", `SELECT id FROM a UNION SELECT id FROM b`, "SELECT id FROM a UNION SELECT id FROM b\nLIMIT 11"},
+		{"postgres", `SELECT id FROM a UNION SELECT id FROM b LIMIT 500`, `SELECT id FROM a UNION SELECT id FROM b LIMIT 11`},
+		{"postgres", `SELECT id FROM (SELECT id FROM t LIMIT 500) s`, "SELECT id FROM (SELECT id FROM t LIMIT 500) s\nLIMIT 11"},
+		{"postgres", `SELECT id FROM t WHERE id IN (SELECT id FROM u LIMIT 3) LIMIT 50`, `SELECT id FROM t WHERE id IN (SELECT id FROM u LIMIT 3) LIMIT 11`},
+		{"postgres", `WITH s AS (SELECT id FROM t LIMIT 500) SELECT id FROM s`, "WITH s AS (SELECT id FROM t LIMIT 500) SELECT id FROM s\nLIMIT 11"},
+
+		// mysql
+		{"mysql", `SELECT id FROM t`, "SELECT id FROM t\nLIMIT 11"},
+		{"mysql", `SELECT id FROM t LIMIT 500`, `SELECT id FROM t LIMIT 11`},
+		{"mysql", `SELECT id FROM t LIMIT 10, 500`, "SELECT * FROM (\nSELECT id FROM t LIMIT 10, 500\n) AS limited\nLIMIT 11"},
+		{"mysql", `SELECT id FROM t ORDER BY id`, "SELECT id FROM t ORDER BY id\nLIMIT 11"},
+		{"mysql", `SELECT id FROM a UNION ALL SELECT id FROM b`, "SELECT id FROM a UNION ALL SELECT id FROM b\nLIMIT 11"},
+		{"mysql", `SELECT id FROM (SELECT id FROM t LIMIT 500) s`, "SELECT id FROM (SELECT id FROM t LIMIT 500) s\nLIMIT 11"},
+
+		// sqlite
+		{"sqlite", `SELECT id FROM t`, "SELECT id FROM t\nLIMIT 11"},
+		{"sqlite", `SELECT id FROM t LIMIT 500 OFFSET 10`, `SELECT id FROM t LIMIT 11 OFFSET 10`},
+		{"sqlite", `SELECT id FROM t ORDER BY id`, "SELECT id FROM t ORDER BY id\nLIMIT 11"},
+		{"sqlite", `SELECT id FROM a EXCEPT SELECT id FROM b`, "SELECT id FROM a EXCEPT SELECT id FROM b\nLIMIT 11"},
+		{"sqlite", `SELECT id FROM t WHERE id IN (SELECT id FROM u LIMIT 3)`, "SELECT id FROM t WHERE id IN (SELECT id FROM u LIMIT 3)\nLIMIT 11"},
+
+		// sqlserver
+		{"sqlserver", `SELECT id FROM t`, `SELECT TOP 11 id FROM t`},
+		{"sqlserver", `SELECT DISTINCT id FROM t`, `SELECT DISTINCT TOP 11 id FROM t`},
+		{"sqlserver", `SELECT TOP 5 id FROM t`, `SELECT TOP 5 id FROM t`},
+		{"sqlserver", `SELECT TOP 500 id FROM t`, `SELECT TOP 11 id FROM t`},
+		{"sqlserver", `SELECT TOP (500) id FROM t`, "SELECT TOP 11 * FROM (\nSELECT TOP (500) id FROM t\n) AS limited"},
+		{"sqlserver", `SELECT id FROM t ORDER BY id`, "SELECT id FROM t ORDER BY id\nOFFSET 0 ROWS FETCH NEXT 11 ROWS ONLY"},
+		{"sqlserver", `SELECT id FROM t ORDER BY id OFFSET 5 ROWS FETCH NEXT 500 ROWS ONLY`, "SELECT TOP 11 * FROM (\nSELECT id FROM t ORDER BY id OFFSET 5 ROWS FETCH NEXT 500 ROWS ONLY\n) AS limited"},
+		{"sqlserver", `SELECT id FROM a UNION SELECT id FROM b`, "SELECT TOP 11 * FROM (\nSELECT id FROM a UNION SELECT id FROM b\n) AS limited"},
+		{"sqlserver", `SELECT id FROM (SELECT TOP 500 id FROM t) s`, `SELECT TOP 11 id FROM (SELECT TOP 500 id FROM t) s`},
+	}
+	for _, tc := range cases {
+		withDriver(t, tc.driver)
+		if got := limitQuery(tc.query, 11); got != tc.want {
+			t.Errorf("%s: limitQuery(%q, 11)\n got %q\nwant %q", tc.driver, tc.query, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package gorag
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+  We only need a couple of AWS calls, so rather than pulling in the
+  whole SDK we sign requests ourselves with SigV4. Credentials come
+  from the standard environment variables, which is also what the
+  Lambda runtime hands us.
+*/
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+}
+
+func awsCredentialsFromEnv() (awsCredentials, error) {
+	creds := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Region:          os.Getenv("AWS_REGION"),
+	}
+	if creds.Region == "" {
+		creds.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if creds.Region == "" {
+		creds.Region = "us-east-1"
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return creds, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return creds, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// signV4 adds the AWS Signature Version 4 headers to req
+func signV4(req *http.Request, body []byte, service string, creds awsCredentials, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := now.UTC().Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-type" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalURI := req.URL.EscapedPath()
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+	// everything but s3 signs the path escaped twice
+	if service != "s3" {
+		canonicalURI = awsURIEncode(canonicalURI, false)
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + creds.Region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), day)
+	key = hmacSHA256(key, creds.Region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+// awsURIEncode escapes all but the unreserved characters, and slashes unless told to
+func awsURIEncode(s string, slash bool) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~', b == '/' && !slash:
+			sb.WriteByte(b)
+		default:
+			sb.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+	return sb.String()
+}
+
+// getS3Object fetches s3://bucket/key
+func getS3Object(location string) ([]byte, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+	if !ok || bucket == "" || key == "" {
+		return nil, fmt.Errorf("bad s3 location: %s", location)
+	}
+	creds, err := awsCredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, creds.Region, key)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	signV4(req, nil, "s3", creds, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("s3 get %s: %s: %s", location, resp.Status, body)
+	}
+	return body, nil
+}
+
+/*
+  A schema cache is just the DBMetadata as json, either in a local
+  file or in S3. Serverless cold starts are much cheaper when they
+  don't have to walk information_schema every time.
+*/
+func loadSchemaCache(location string) (*DBMetadata, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(location, "s3://") {
+		data, err = getS3Object(location)
+	} else {
+		data, err = os.ReadFile(location)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var metadata DBMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	if len(metadata.Tables) == 0 {
+		return nil, fmt.Errorf("schema cache %s has no tables", location)
+	}
+	return &metadata, nil
+}
+
+func saveSchemaCache(filename string, metadata *DBMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// putS3Object writes s3://bucket/key
+func putS3Object(location string, body []byte, contentType string) error {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+	if !ok || bucket == "" || key == "" {
+		return fmt.Errorf("bad s3 location: %s", location)
+	}
+	creds, err := awsCredentialsFromEnv()
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, creds.Region, key)
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	signV4(req, body, "s3", creds, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("s3 put %s: %s: %s", location, resp.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package gorag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+  The safety config screens prompts before any SQL is generated.
+  Regexp rules, built in or the deployment's own, catch requests to
+  dump whole columns of personal data and attempts to talk the model
+  out of its instructions; the OpenAI moderation endpoint can catch
+  plain abuse. Each rule either rejects the prompt or flags it, which
+  lets it through with an audit event, so a deployment can watch a
+  rule for a while before enforcing it.
+
+    "safety": {
+      "builtin": ["exfiltration", "injection"],
+      "rules": [{"name": "salaries", "pattern": "(?i)everyone'?s salar", "action": "reject"}],
+      "moderation": true
+    }
+*/
+type SafetyRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Action  string `json:"action,omitempty"` // reject (the default) or flag
+	Reason  string `json:"reason,omitempty"`
+}
+
+type SafetyConfig struct {
+	Builtin    []string     `json:"builtin,omitempty"`
+	Rules      []SafetyRule `json:"rules,omitempty"`
+	Moderation bool         `json:"moderation,omitempty"`
+	// what a moderation hit does: reject (the default) or flag
+	ModerationAction string `json:"moderation_action,omitempty"`
+}
+
+var builtinSafetyRules = map[string]SafetyRule{
+	"exfiltration": {
+		Name:    "exfiltration",
+		Pattern: `(?i)\b(dump|export|download|list|give me|show( me)?|get|extract)\b.{0,40}\b(all|every|entire|whole|complete)\b.{0,40}\b(e-?mails?|passwords?|password hashes|ssns?|social security|credit cards?|card numbers|phone numbers|home addresses|dates? of birth|api keys|tokens|secrets)\b`,
+		Reason:  "bulk requests for personal or secret data are not allowed",
+	},
+	"injection": {
+		Name:    "injection",
+		Pattern: `(?i)(ignore|disregard|forget)\s+(all\s+|any\s+)?(the\s+)?(previous|prior|above|your)\s+(instructions|rules|prompt)|\b(drop|truncate|alter)\s+(table|database|schema)\b|\bgrant\s+all\b`,
+		Reason:  "the prompt tries to override instructions or change the database",
+	},
+}
+
+func (c *Config) safety() *SafetyConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Safety
+}
+
+func (s *SafetyConfig) rules() ([]SafetyRule, error) {
+	rules := make([]SafetyRule, 0, len(s.Builtin)+len(s.Rules))
+	for _, name := range s.Builtin {
+		r, ok := builtinSafetyRules[name]
+		if !ok {
+			return nil, fmt.Errorf("no builtin safety rule %s", name)
+		}
+		rules = append(rules, r)
+	}
+	return append(rules, s.Rules...), nil
+}
+
+// moderate asks the OpenAI moderation endpoint, returning the categories it flags
+func moderate(apiKey, prompt string) ([]string, error) {
+	if *llmProvider == "mock" {
+		return nil, nil
+	}
+	body, err := json.Marshal(map[string]string{"input": prompt})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(*llmURL, "/")+"/moderations", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	if out.Error != nil {
+		return nil, fmt.Errorf("moderation: %s", out.Error.Message)
+	}
+	flagged := make([]string, 0)
+	for _, r := range out.Results {
+		for category, hit := range r.Categories {
+			if hit {
+				flagged = append(flagged, category)
+			}
+		}
+		if r.Flagged && len(flagged) == 0 {
+			flagged = append(flagged, "flagged")
+		}
+	}
+	sort.Strings(flagged)
+	return flagged, nil
+}
+
+/*
+  checkPrompt screens the question. A broken rule or an unreachable
+  moderation endpoint refuses the prompt, like an unreachable OPA,
+  since the deployment asked for the check.
+*/
+func (c *Client) checkPrompt(q *Question) error {
+	if c.Config == nil {
+		return nil
+	}
+	s := c.Config.safety()
+	if s == nil {
+		return nil
+	}
+	rules, err := s.rules()
+	if err != nil {
+		return err
+	}
+	hit := func(name, action, reason string) error {
+		c.Audit.Record(AuditEvent{
+			Event:   "safety",
+			RunID:   q.RunID,
+			User:    q.User,
+			Profile: c.Profile,
+			Prompt:  q.Prompt,
+			Tags:    []string{name},
+			Reason:  reason,
+		})
+		if action == "flag" {
+			return nil
+		}
+		return fmt.Errorf("prompt refused by safety rule %s: %s", name, reason)
+	}
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("bad safety rule %s: %v", r.Name, err)
+		}
+		if !re.MatchString(q.Prompt) {
+			continue
+		}
+		reason := r.Reason
+		if reason == "" {
+			reason = "the prompt matches " + r.Name
+		}
+		if err := hit(r.Name, r.Action, reason); err != nil {
+			return err
+		}
+	}
+	if s.Moderation {
+		categories, err := moderate(c.APIKey, q.Prompt)
+		if err != nil {
+			return fmt.Errorf("prompt not checked, moderation failed: %v", err)
+		}
+		if len(categories) > 0 {
+			return hit("moderation", s.ModerationAction, "moderation flagged "+strings.Join(categories, ", "))
+		}
+	}
+	return nil
+}
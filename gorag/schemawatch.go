@@ -0,0 +1,298 @@
+package gorag
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+  With -serve and -schema-watch, every connected profile's schema is
+  read again that often, and compared with the one being asked of by
+  its fingerprint: the tables, columns, keys and comments. When it
+  changed, the profile gets the new schema (and the prune, values and
+  samples caches, the capabilities summary and its -schema-cache with
+  it), and the diff goes to the -schema-sink sinks, as an answer
+  whose schema_change is the structured diff and whose summary says it
+  in words, for slack and mail. What was removed is looked for in the
+  configured examples and in the query each of the profile's saved
+  questions last ran, per the audit log; those are marked broken, so
+  examples stay out of prompts and saved questions refuse to run until
+  someone fixes them and puts them again. Examples are shared by the
+  profiles, so one is set aside when any profile drops what it uses.
+*/
+var schemaWatch = Flags.Duration("schema-watch", 0, "with -serve, read each connected profile's schema this often and act on changes, 0 never does")
+var schemaSinks = Flags.String("schema-sink", "", "where schema changes go, as -sink takes them, comma separated")
+
+// SchemaDiff is what changed in a profile's schema
+type SchemaDiff struct {
+	Profile         string   `json:"profile"`
+	Fingerprint     string   `json:"fingerprint"` // of the new schema
+	AddedTables     []string `json:"added_tables,omitempty"`
+	RemovedTables   []string `json:"removed_tables,omitempty"`
+	AddedColumns    []string `json:"added_columns,omitempty"` // table.column
+	RemovedColumns  []string `json:"removed_columns,omitempty"`
+	KeysChanged     bool     `json:"keys_changed,omitempty"`
+	CommentsChanged bool     `json:"comments_changed,omitempty"`
+	// saved questions and examples that used what was removed
+	Broken []string `json:"broken,omitempty"`
+}
+
+// schemaFingerprint is a hash of the schema's structure, without the statistics, which change all the time
+func schemaFingerprint(s *DBMetadata) string {
+	data, err := json.Marshal(DBMetadata{Tables: s.Tables, ForeignKeys: s.ForeignKeys, PrimaryKeys: s.PrimaryKeys, Comments: s.Comments})
+	if err != nil {
+		return ""
+	}
+	return sha256Hex(data)[:16]
+}
+
+func diffSchemas(before, after *DBMetadata) *SchemaDiff {
+	d := &SchemaDiff{Fingerprint: schemaFingerprint(after)}
+	for table, columns := range after.Tables {
+		if _, ok := before.Tables[table]; !ok {
+			d.AddedTables = append(d.AddedTables, table)
+			continue
+		}
+		for _, col := range columns {
+			if !before.hasColumn(table, col) {
+				d.AddedColumns = append(d.AddedColumns, table+"."+col)
+			}
+		}
+	}
+	for table, columns := range before.Tables {
+		if _, ok := after.Tables[table]; !ok {
+			d.RemovedTables = append(d.RemovedTables, table)
+			continue
+		}
+		for _, col := range columns {
+			if !after.hasColumn(table, col) {
+				d.RemovedColumns = append(d.RemovedColumns, table+"."+col)
+			}
+		}
+	}
+	for _, list := range [][]string{d.AddedTables, d.RemovedTables, d.AddedColumns, d.RemovedColumns} {
+		sort.Strings(list)
+	}
+	keys := func(s *DBMetadata) string {
+		data, _ := json.Marshal(DBMetadata{ForeignKeys: s.ForeignKeys, PrimaryKeys: s.PrimaryKeys})
+		return string(data)
+	}
+	d.KeysChanged = keys(before) != keys(after)
+	beforeComments, _ := json.Marshal(before.Comments)
+	afterComments, _ := json.Marshal(after.Comments)
+	d.CommentsChanged = string(beforeComments) != string(afterComments)
+	return d
+}
+
+// usedBy is what the query uses that the diff removed
+func (d *SchemaDiff) usedBy(query string) []string {
+	idents := make(map[string]bool)
+	for _, t := range lexSQL(query) {
+		if id := t.ident(); id != "" {
+			idents[id] = true
+		}
+	}
+	removed := make(map[string]bool)
+	for _, table := range d.RemovedTables {
+		removed[table] = true
+	}
+	uses := make([]string, 0)
+	for _, table := range referencedTables(query) {
+		if removed[table] {
+			uses = append(uses, "table "+table)
+		}
+		for _, key := range d.RemovedColumns {
+			t, column, _ := strings.Cut(key, ".")
+			if t == table && idents[strings.ToLower(column)] {
+				uses = append(uses, "column "+key)
+			}
+		}
+	}
+	sort.Strings(uses)
+	return uses
+}
+
+func (d *SchemaDiff) label() string {
+	if d.Profile == "" {
+		return "default"
+	}
+	return d.Profile
+}
+
+func (d *SchemaDiff) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("The schema of profile %s changed:\n", d.label()))
+	lines := []struct {
+		what  string
+		names []string
+	}{
+		{"Added tables", d.AddedTables},
+		{"Removed tables", d.RemovedTables},
+		{"Added columns", d.AddedColumns},
+		{"Removed columns", d.RemovedColumns},
+		{"Broken by this", d.Broken},
+	}
+	for _, l := range lines {
+		if len(l.names) > 0 {
+			sb.WriteString(fmt.Sprintf("%s: %s\n", l.what, strings.Join(l.names, ", ")))
+		}
+	}
+	if d.KeysChanged {
+		sb.WriteString("Keys changed\n")
+	}
+	if d.CommentsChanged {
+		sb.WriteString("Comments changed\n")
+	}
+	return sb.String()
+}
+
+func (s *Server) watchSchemas(every time.Duration, sinks []Sink) {
+	for range time.Tick(every) {
+		s.mu.Lock()
+		profiles := []string{""}
+		for name := range s.clients {
+			profiles = append(profiles, name)
+		}
+		s.mu.Unlock()
+		sort.Strings(profiles)
+		for _, profile := range profiles {
+			if err := s.checkSchema(profile, sinks); err != nil {
+				log.Printf("Schema watch: profile %q: %v", profile, err)
+			}
+		}
+	}
+}
+
+// checkSchema reads the profile's schema again, and when it changed, switches to it and says so
+func (s *Server) checkSchema(profile string, sinks []Sink) error {
+	old, err := s.clientFor(profile)
+	if err != nil {
+		return err
+	}
+	schema, err := introspect(old.DB)
+	if err != nil {
+		return fmt.Errorf("failed to read the schema: %v", err)
+	}
+	if schemaFingerprint(schema) == schemaFingerprint(old.Schema) {
+		return nil
+	}
+	diff := diffSchemas(old.Schema, schema)
+	diff.Profile = profile
+
+	c := old.forProfile(profile, old.DB, schema, old.ExtraMetadata)
+	s.mu.Lock()
+	if profile == "" {
+		s.Default = c
+	} else if s.clients[profile] == old {
+		s.clients[profile] = c
+	}
+	s.mu.Unlock()
+	s.forgetSchema(old)
+	if err := s.storeSchemaCache(profile, schema); err != nil {
+		log.Printf("Schema watch: failed to update the schema cache: %v", err)
+	}
+	diff.Broken = s.markBroken(c, diff)
+
+	log.Printf("%s", diff)
+	c.Audit.Record(AuditEvent{
+		Event:   "schema_change",
+		Profile: profile,
+		Tables:  append(append([]string{}, diff.AddedTables...), diff.RemovedTables...),
+		Reason:  diff.String(),
+	})
+	answer := &Answer{
+		RunID:        newRunID(),
+		Prompt:       "Schema change in profile " + diff.label(),
+		Summary:      diff.String(),
+		SchemaChange: diff,
+	}
+	return sendAll(sinks, answer, "")
+}
+
+// forgetSchema drops what was cached for the old schema
+func (s *Server) forgetSchema(old *Client) {
+	tableIndexes.Lock()
+	delete(tableIndexes.m, old.Schema)
+	tableIndexes.Unlock()
+	valueIndexes.Lock()
+	delete(valueIndexes.m, old.Schema)
+	valueIndexes.Unlock()
+	sampleRows.Lock()
+	delete(sampleRows.m, old.DB)
+	sampleRows.Unlock()
+	s.capabilities.mu.Lock()
+	delete(s.capabilities.byClient, old)
+	s.capabilities.mu.Unlock()
+}
+
+// storeSchemaCache rewrites the profile's -schema-cache, so a restart doesn't load the old schema
+func (s *Server) storeSchemaCache(profile string, schema *DBMetadata) error {
+	location := *schemaCache
+	if profile != "" {
+		s.Config.mu.RLock()
+		if p, ok := s.Config.Profiles[profile]; ok {
+			location = p.SchemaCache
+		}
+		s.Config.mu.RUnlock()
+	}
+	if location == "" {
+		return nil
+	}
+	if !strings.HasPrefix(location, "s3://") {
+		return saveSchemaCache(location, schema)
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	return putS3Object(location, data, "application/json")
+}
+
+// markBroken marks the examples and saved questions that used what the diff removed
+func (s *Server) markBroken(c *Client, diff *SchemaDiff) []string {
+	if len(diff.RemovedTables) == 0 && len(diff.RemovedColumns) == 0 {
+		return nil
+	}
+	// what each of the profile's prompts last ran
+	ran := make(map[string]string)
+	if filename := c.Audit.Filename(); filename != "" {
+		err := readAuditLog(filename, func(e AuditEvent) {
+			if e.Event == "ask" && e.Error == "" && e.Query != "" && e.Profile == diff.Profile {
+				ran[strings.TrimSpace(e.Prompt)] = e.Query
+			}
+		})
+		if err != nil {
+			log.Printf("Schema watch: failed to read the audit log: %v", err)
+		}
+	}
+
+	s.Config.mu.Lock()
+	defer s.Config.mu.Unlock()
+	broken := make([]string, 0)
+	for _, ex := range s.Config.Examples {
+		if uses := diff.usedBy(ex.Query); len(uses) > 0 && ex.Broken == "" {
+			ex.Broken = "uses the removed " + strings.Join(uses, ", ")
+			broken = append(broken, fmt.Sprintf("example %q", ex.Prompt))
+		}
+	}
+	for name, q := range s.Config.SavedQuestions {
+		if q.Profile != diff.Profile || q.Broken != "" {
+			continue
+		}
+		if uses := diff.usedBy(ran[strings.TrimSpace(q.Prompt)]); len(uses) > 0 {
+			q.Broken = "its query used the removed " + strings.Join(uses, ", ")
+			broken = append(broken, "saved question "+name)
+		}
+	}
+	sort.Strings(broken)
+	if len(broken) > 0 {
+		if err := s.Config.save(); err != nil {
+			log.Printf("Schema watch: failed to save the config: %v", err)
+		}
+	}
+	return broken
+}
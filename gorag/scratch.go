@@ -0,0 +1,132 @@
+package gorag
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+/*
+  With -scratch-rows N, the stages of a decomposed query are
+  materialized as TEMP tables instead of being chained as CTEs, so
+  each is computed once and the final query reads small tables.
+  The scratch space is one transaction on one connection: temp
+  tables live in that session's own pg_temp schema, are created
+  ON COMMIT DROP, and the transaction is always rolled back, so
+  nothing outlives the question. A stage over N rows is an error,
+  to keep the workspace from becoming a copy of the database.
+*/
+type scratchSpace struct {
+	tx      *sql.Tx
+	maxRows int
+	tables  []string
+	// the comment the client puts on its queries
+	annotate func(string) string
+}
+
+// queryer is the *sql.DB or *sql.Tx a query runs on
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (c *Client) openScratch() (*scratchSpace, error) {
+	if err := requirePostgres("-scratch-rows"); err != nil {
+		return nil, err
+	}
+	opts := &sql.TxOptions{}
+	if c.snapshot != nil {
+		opts.Isolation = sql.LevelRepeatableRead
+	}
+	tx, err := c.DB.BeginTx(c.runContext(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open scratch space: %v", err)
+	}
+	// the stages see the rows the answer's other queries do
+	if c.snapshot != nil {
+		if _, err := tx.Exec("SET TRANSACTION SNAPSHOT " + sqlLiteral(c.snapshot.id)); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to share the snapshot with the scratch space: %v", err)
+		}
+	}
+	return &scratchSpace{tx: tx, maxRows: c.ScratchRows, annotate: c.annotate}, nil
+}
+
+// create materializes one stage, and is undone by itself if it fails
+func (s *scratchSpace) create(st queryStage) (int64, error) {
+	if _, err := s.tx.Exec("SAVEPOINT stage"); err != nil {
+		return 0, err
+	}
+	n, err := s.createTable(st)
+	if err != nil {
+		if _, rerr := s.tx.Exec("ROLLBACK TO SAVEPOINT stage"); rerr != nil {
+			return 0, fmt.Errorf("%v (and rollback failed: %v)", err, rerr)
+		}
+		return 0, err
+	}
+	_, err = s.tx.Exec("RELEASE SAVEPOINT stage")
+	return n, err
+}
+
+func (s *scratchSpace) createTable(st queryStage) (int64, error) {
+	name := quoteIdent(st.Name)
+	_, err := s.tx.Exec(s.annotate(fmt.Sprintf("CREATE TEMP TABLE %s ON COMMIT DROP AS SELECT * FROM (%s) AS stage LIMIT %d",
+		name, trimStatement(st.Query), s.maxRows+1)))
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if err := s.tx.QueryRow("SELECT count(*) FROM " + name).Scan(&n); err != nil {
+		return 0, err
+	}
+	if n > int64(s.maxRows) {
+		return n, fmt.Errorf("stage %s has more than %d rows, the scratch limit", st.Name, s.maxRows)
+	}
+	s.tables = append(s.tables, st.Name)
+	return n, nil
+}
+
+func (s *scratchSpace) Close() error {
+	return s.tx.Rollback()
+}
+
+/*
+  runScratch builds the stages in a scratch space, with one repair
+  of the first stage that fails, as runStages does for CTEs. The
+  caller has validated the chain; a repair means validating again,
+  which is what validate is for.
+*/
+func (c *Client) runScratch(userInput string, sq *stagedQuery, validate func() error) (*scratchSpace, error) {
+	s, err := c.openScratch()
+	if err != nil {
+		return nil, err
+	}
+	repaired := false
+	for i := 0; i < len(sq.Stages); i++ {
+		st := sq.Stages[i]
+		n, err := s.create(st)
+		if err != nil && !repaired {
+			log.Printf("Stage %s failed, asking for a fix: %v", st.Name, err)
+			if rerr := c.repairStage(userInput, sq, i, err); rerr != nil {
+				s.Close()
+				return nil, fmt.Errorf("stage %s failed: %v (and repair failed: %v)", st.Name, err, rerr)
+			}
+			if verr := validate(); verr != nil {
+				s.Close()
+				return nil, verr
+			}
+			repaired = true
+			i--
+			continue
+		}
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("stage %s failed: %v", st.Name, err)
+		}
+		log.Printf("Stage %s: %d rows in scratch (%s)", st.Name, n, st.Description)
+	}
+	return s, nil
+}
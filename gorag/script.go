@@ -0,0 +1,269 @@
+package gorag
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+  gorag script "backfill orders.region from customers for last month"
+  has the model write the change as steps, one statement each, or
+  -file runs the statements in a file as the steps. The script runs
+  in one transaction, with a savepoint before each step: a step that
+  fails is rolled back to its savepoint, and the script stops there,
+  or carries on with -on-error continue. Unless -commit is given, the
+  transaction is rolled back at the end anyway, so the default is a
+  rehearsal whose report says what each step would change. With
+  -commit, it is only committed when no step failed, or with -on-error
+  continue, when some step worked.
+
+  Steps can only be INSERT, UPDATE, DELETE, MERGE or SELECT (to check
+  something along the way), an UPDATE or DELETE needs a WHERE, the
+  transaction is ours to control, and the deny rules apply to each
+  step; a script that breaks any of that isn't run at all. The report
+  is json on stdout (or -report), and every run is audited. A
+  deployment has to turn this on with "allow_scripts".
+*/
+type scriptStep struct {
+	Description string `json:"description,omitempty"`
+	SQL         string `json:"sql"`
+}
+
+// scriptStepReport is what happened to one step
+type scriptStepReport struct {
+	scriptStep
+	Status     string `json:"status"` // ok, failed (and rolled back to its savepoint), or skipped
+	Rows       int64  `json:"rows"`   // affected, or returned by a SELECT
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type scriptReport struct {
+	Description string              `json:"description,omitempty"`
+	Steps       []*scriptStepReport `json:"steps"`
+	Committed   bool                `json:"committed"`
+	Outcome     string              `json:"outcome"`
+}
+
+var scriptKinds = map[string]bool{"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "SELECT": true}
+
+// scriptSteps are the statements of a file, as steps
+func scriptSteps(script string) []scriptStep {
+	steps := make([]scriptStep, 0)
+	for _, stmt := range splitStatements(lexSQL(script)) {
+		last := stmt[len(stmt)-1]
+		steps = append(steps, scriptStep{SQL: script[stmt[0].Pos : last.Pos+len(last.Text)]})
+	}
+	return steps
+}
+
+// scriptProblems is why steps can't run, without running anything
+func (c *Client) scriptProblems(steps []scriptStep) []string {
+	problems := make([]string, 0)
+	for i, step := range steps {
+		statements := splitStatements(lexSQL(step.SQL))
+		if len(statements) != 1 {
+			problems = append(problems, fmt.Sprintf("step %d has %d statements, not one", i+1, len(statements)))
+			continue
+		}
+		stmt := statements[0]
+		kind := statementKind(stmt)
+		if !scriptKinds[kind] {
+			problems = append(problems, fmt.Sprintf("step %d is a %s, and steps can only be INSERT, UPDATE, DELETE, MERGE or SELECT", i+1, kind))
+			continue
+		}
+		if kind == "UPDATE" || kind == "DELETE" {
+			where := false
+			for _, t := range stmt {
+				where = where || t.upper() == "WHERE"
+			}
+			if !where {
+				problems = append(problems, fmt.Sprintf("step %d is a %s without a WHERE", i+1, kind))
+			}
+		}
+		if c.Config != nil {
+			if err := checkDenyRules(c.Config.denyRulesFor(c.Profile), step.SQL); err != nil {
+				problems = append(problems, fmt.Sprintf("step %d: %v", i+1, err))
+			}
+		}
+	}
+	return problems
+}
+
+func (c *Client) draftScript(description string) ([]scriptStep, error) {
+	prompt := fmt.Sprintf(`
+You are writing a PostgreSQL data change, to run in one transaction. The schema is:
+
+%s
+%s%s%s
+Write the change for: %s
+
+Break it into steps, each one statement: INSERT, UPDATE, DELETE or MERGE, and
+SELECT steps that check the data before or after, eg: how many rows are left to
+change. Every UPDATE and DELETE needs a WHERE that limits it to what was asked.
+Don't write BEGIN, COMMIT or SAVEPOINT; each step gets a savepoint already.
+http response must be application/json:
+{ "steps": [{ "description": "what this step does", "sql": "..." }, ...] }
+`, formatSchema(c.Schema), relationshipsPrompt(c.Schema, description), commentsPrompt(c.Schema), c.glossaryPrompt(), description)
+	var out struct {
+		Steps []scriptStep `json:"steps"`
+	}
+	if err := c.llmJSON(prompt, &out); err != nil {
+		return nil, fmt.Errorf("failed to draft the script: %v", err)
+	}
+	if len(out.Steps) == 0 {
+		return nil, fmt.Errorf("the model wrote no steps")
+	}
+	return out.Steps, nil
+}
+
+// runScript runs the steps in a transaction with a savepoint before each
+func (c *Client) runScript(steps []scriptStep, continueOnError, commit bool) (*scriptReport, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	report := &scriptReport{}
+	failed, worked := 0, 0
+	for i, step := range steps {
+		r := &scriptStepReport{scriptStep: step, Status: "skipped"}
+		report.Steps = append(report.Steps, r)
+		if failed > 0 && !continueOnError {
+			continue
+		}
+		savepoint := fmt.Sprintf("gorag_step_%d", i+1)
+		if _, err := tx.Exec("SAVEPOINT " + savepoint); err != nil {
+			return nil, fmt.Errorf("failed to make a savepoint: %v", err)
+		}
+		start := time.Now()
+		res, err := tx.Exec(step.SQL)
+		r.DurationMs = time.Since(start).Milliseconds()
+		if err != nil {
+			r.Status, r.Error = "failed", err.Error()
+			failed++
+			if _, err := tx.Exec("ROLLBACK TO SAVEPOINT " + savepoint); err != nil {
+				return nil, fmt.Errorf("failed to roll back step %d: %v", i+1, err)
+			}
+			continue
+		}
+		if _, err := tx.Exec("RELEASE SAVEPOINT " + savepoint); err != nil {
+			return nil, fmt.Errorf("failed to release a savepoint: %v", err)
+		}
+		r.Status = "ok"
+		r.Rows, _ = res.RowsAffected()
+		worked++
+	}
+
+	switch {
+	case !commit:
+		report.Outcome = "rolled back: a rehearsal, run with -commit to keep it"
+	case failed > 0 && !continueOnError:
+		report.Outcome = fmt.Sprintf("rolled back: step %d failed", failedStep(report))
+	case worked == 0:
+		report.Outcome = "rolled back: no step worked"
+	default:
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit: %v", err)
+		}
+		report.Committed = true
+		report.Outcome = fmt.Sprintf("committed %d of %d steps", worked, len(steps))
+	}
+	return report, nil
+}
+
+func failedStep(report *scriptReport) int {
+	for i, r := range report.Steps {
+		if r.Status == "failed" {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func runScriptCommand(args []string) {
+	fs := commandFlags("script")
+	file := fs.String("file", "", "run the statements in this file as the steps, instead of having the model write them")
+	commit := fs.Bool("commit", false, "commit the transaction, instead of rolling back a rehearsal")
+	onError := fs.String("on-error", "stop", "when a step fails: stop, or continue with the next one")
+	reportFile := fs.String("report", "", "write the json report here instead of stdout")
+	fs.Parse(args)
+	if err := requirePostgres("gorag script"); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *onError != "stop" && *onError != "continue" {
+		log.Fatalf("-on-error must be stop or continue")
+	}
+	description := strings.Join(fs.Args(), " ")
+	if description == "" && *file == "" {
+		log.Fatalf("usage: gorag script [-commit] \"what to change\", or gorag script -file change.sql")
+	}
+
+	client, done := setupClient()
+	defer done()
+	client.Config.mu.RLock()
+	allowed := client.Config.AllowScripts
+	client.Config.mu.RUnlock()
+	if !allowed {
+		log.Fatalf("Scripts are off; set \"allow_scripts\": true in %s", *configFile)
+	}
+
+	var steps []scriptStep
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", *file, err)
+		}
+		steps = scriptSteps(string(data))
+	} else {
+		var err error
+		if steps, err = client.draftScript(description); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if len(steps) == 0 {
+		log.Fatalf("There are no steps to run")
+	}
+	for i, step := range steps {
+		log.Printf("Step %d: %s\n%s", i+1, step.Description, step.SQL)
+	}
+	if problems := client.scriptProblems(steps); len(problems) > 0 {
+		log.Fatalf("Not running the script: %s", strings.Join(problems, "; "))
+	}
+
+	queries := make([]string, len(steps))
+	for i, step := range steps {
+		queries[i] = step.SQL
+	}
+	script := strings.Join(queries, ";\n")
+	report, err := client.runScript(steps, *onError == "continue", *commit)
+	event := AuditEvent{Event: "script", User: os.Getenv("USER"), Profile: client.Profile, Prompt: description, Query: script, Tables: referencedTables(script)}
+	if err != nil {
+		event.Error = err.Error()
+	} else {
+		event.Reason = report.Outcome
+	}
+	client.Audit.Record(event)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	report.Description = description
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *reportFile != "" {
+		if err := os.WriteFile(*reportFile, append(data, '\n'), 0644); err != nil {
+			log.Fatalf("Failed to write %s: %v", *reportFile, err)
+		}
+	} else {
+		fmt.Println(string(data))
+	}
+	log.Printf("%s", report.Outcome)
+}
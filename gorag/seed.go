@@ -0,0 +1,399 @@
+package gorag
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+/*
+  gorag seed -table customers -rows 1000 fills a demo or eval database
+  with made up rows. The model writes the values, in batches, from the
+  column types, check constraints and comments. Foreign keys are not
+  left to it: they are filled from rows that really exist in the
+  parent table, and an empty parent is seeded first (-parent-rows), so
+  tables are loaded in dependency order. Everything goes in one
+  transaction. Seeding writes to the database, so a deployment has to
+  turn it on with "allow_seed".
+*/
+type seedColumn struct {
+	Name      string
+	Type      string
+	Nullable  bool
+	Generated bool // serial, identity or generated, so the database fills it
+	Unique    bool
+}
+
+type seedTable struct {
+	Name        string
+	Columns     []seedColumn
+	Checks      []string
+	ForeignKeys [][]ForeignKey
+}
+
+func loadSeedTable(db queryer, schema *DBMetadata, table string) (*seedTable, error) {
+	t := &seedTable{Name: table}
+	rows, err := db.Query(`
+		SELECT c.column_name, c.data_type, c.is_nullable = 'YES',
+			c.is_identity = 'YES' OR c.is_generated = 'ALWAYS' OR coalesce(c.column_default, '') LIKE 'nextval(%',
+			EXISTS (
+				SELECT 1 FROM pg_constraint k
+				JOIN pg_class cl ON cl.oid = k.conrelid
+				JOIN pg_attribute a ON a.attrelid = cl.oid AND a.attnum = k.conkey[1]
+				WHERE k.contype IN ('p', 'u') AND array_length(k.conkey, 1) = 1
+					AND cl.relname = c.table_name AND a.attname = c.column_name
+			)
+		FROM information_schema.columns c
+		WHERE c.table_schema = 'public' AND c.table_name = $1
+		ORDER BY c.ordinal_position
+	`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var col seedColumn
+		if err := rows.Scan(&col.Name, &col.Type, &col.Nullable, &col.Generated, &col.Unique); err != nil {
+			return nil, err
+		}
+		t.Columns = append(t.Columns, col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(t.Columns) == 0 {
+		return nil, fmt.Errorf("no such table: %s", table)
+	}
+
+	checks, err := db.Query(`
+		SELECT pg_get_constraintdef(k.oid)
+		FROM pg_constraint k
+		JOIN pg_class cl ON cl.oid = k.conrelid
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		WHERE k.contype = 'c' AND n.nspname = 'public' AND cl.relname = $1
+	`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read constraints of %s: %v", table, err)
+	}
+	defer checks.Close()
+	for checks.Next() {
+		var def string
+		if err := checks.Scan(&def); err != nil {
+			return nil, err
+		}
+		t.Checks = append(t.Checks, def)
+	}
+
+	for _, fk := range schema.foreignKeyGroups() {
+		if fk[0].Table == table {
+			t.ForeignKeys = append(t.ForeignKeys, fk)
+		}
+	}
+	return t, checks.Err()
+}
+
+// seedOrder is the table and the parents its foreign keys need, parents first
+func seedOrder(schema *DBMetadata, table string) []string {
+	order := make([]string, 0)
+	seen := make(map[string]bool)
+	var visit func(t string)
+	visit = func(t string) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		for _, fk := range schema.foreignKeyGroups() {
+			if fk[0].Table == t && fk[0].RefTable != t {
+				visit(fk[0].RefTable)
+			}
+		}
+		order = append(order, t)
+	}
+	visit(table)
+	return order
+}
+
+// foreignKeyColumns are the columns filled from parent rows, not by the model
+func (t *seedTable) foreignKeyColumns() map[string]bool {
+	cols := make(map[string]bool)
+	for _, fk := range t.ForeignKeys {
+		for _, k := range fk {
+			cols[k.Column] = true
+		}
+	}
+	return cols
+}
+
+// modelColumns are the ones the model writes values for
+func (t *seedTable) modelColumns() []seedColumn {
+	fks := t.foreignKeyColumns()
+	cols := make([]seedColumn, 0, len(t.Columns))
+	for _, col := range t.Columns {
+		if !col.Generated && !fks[col.Name] {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+func (c *Client) seedBatch(t *seedTable, n int, avoid []string) ([]map[string]interface{}, error) {
+	var sb strings.Builder
+	for _, col := range t.modelColumns() {
+		null := "not null"
+		if col.Nullable {
+			null = "nullable"
+		}
+		unique := ""
+		if col.Unique {
+			unique = ", unique"
+		}
+		sb.WriteString(fmt.Sprintf("%s %s (%s%s)\n", col.Name, col.Type, null, unique))
+	}
+	checks := ""
+	if len(t.Checks) > 0 {
+		checks = "\nEvery row must satisfy:\n\n" + strings.Join(t.Checks, "\n") + "\n"
+	}
+	avoiding := ""
+	if len(avoid) > 0 {
+		avoiding = "\nDon't reuse these values of unique columns: " + strings.Join(avoid, ", ") + "\n"
+	}
+	var out struct {
+		Rows []map[string]interface{} `json:"rows"`
+	}
+	err := c.llmJSON(fmt.Sprintf(`
+You are making up realistic test data for the PostgreSQL table %s, which has these columns:
+
+%s%s%s%s
+Write %d rows. Make them varied and believable, the way real data in this table
+would look, with an occasional null in nullable columns. Dates and timestamps
+are ISO 8601.
+http response must be application/json:
+{ "rows": [ { "column": value, ... } ] }
+`, t.Name, sb.String(), checks, commentsPrompt(c.Schema.subset([]string{t.Name})), avoiding, n), &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate rows for %s: %v", t.Name, err)
+	}
+	return out.Rows, nil
+}
+
+// seedValue turns a JSON value into something the driver can send
+func seedValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	default:
+		data, _ := json.Marshal(x)
+		return string(data)
+	}
+}
+
+// parentKeys are up to 1000 existing key tuples for each foreign key of the table
+func parentKeys(db queryer, t *seedTable) ([][][]interface{}, error) {
+	keys := make([][][]interface{}, len(t.ForeignKeys))
+	for i, fk := range t.ForeignKeys {
+		refs := make([]string, len(fk))
+		for j, k := range fk {
+			refs[j] = quoteIdent(k.RefColumn) + "::text"
+		}
+		rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s ORDER BY random() LIMIT 1000",
+			strings.Join(refs, ", "), quoteIdent(fk[0].RefTable)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keys of %s: %v", fk[0].RefTable, err)
+		}
+		for rows.Next() {
+			values := make([]sql.NullString, len(fk))
+			dest := make([]interface{}, len(fk))
+			for j := range values {
+				dest[j] = &values[j]
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			tuple := make([]interface{}, len(fk))
+			for j, v := range values {
+				if v.Valid {
+					tuple[j] = v.String
+				}
+			}
+			keys[i] = append(keys[i], tuple)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// seedRows makes n rows of insert values for t, in the order of its columns
+func (c *Client) seedRows(db queryer, t *seedTable, n int) ([]string, [][]interface{}, error) {
+	keys, err := parentKeys(db, t)
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := make([]string, 0, len(t.Columns))
+	for _, col := range t.Columns {
+		if !col.Generated {
+			columns = append(columns, col.Name)
+		}
+	}
+	for i, fk := range t.ForeignKeys {
+		if len(keys[i]) == 0 && fk[0].RefTable != t.Name {
+			return nil, nil, fmt.Errorf("%s has no rows for %s to refer to", fk[0].RefTable, t.Name)
+		}
+	}
+
+	seen := make(map[string]map[string]bool)
+	for _, col := range t.modelColumns() {
+		if col.Unique {
+			seen[col.Name] = make(map[string]bool)
+		}
+	}
+	out := make([][]interface{}, 0, n)
+	const batch = 50
+	for tries := 0; len(out) < n && tries < 2*(n/batch+1); tries++ {
+		avoid := make([]string, 0)
+		for _, values := range seen {
+			for v := range values {
+				if len(avoid) < 100 {
+					avoid = append(avoid, v)
+				}
+			}
+		}
+		want := n - len(out)
+		if want > batch {
+			want = batch
+		}
+		generated, err := c.seedBatch(t, want, avoid)
+		if err != nil {
+			return nil, nil, err
+		}
+	rows:
+		for _, g := range generated {
+			byColumn := make(map[string]interface{})
+			for _, col := range t.modelColumns() {
+				v := seedValue(g[col.Name])
+				if s, ok := v.(string); ok && seen[col.Name] != nil {
+					if seen[col.Name][s] {
+						continue rows
+					}
+					seen[col.Name][s] = true
+				}
+				byColumn[col.Name] = v
+			}
+			// self references are left null, the other keys point at a random parent
+			for i, fk := range t.ForeignKeys {
+				if len(keys[i]) == 0 {
+					continue
+				}
+				tuple := keys[i][rand.Intn(len(keys[i]))]
+				for j, k := range fk {
+					byColumn[k.Column] = tuple[j]
+				}
+			}
+			row := make([]interface{}, len(columns))
+			for i, col := range columns {
+				row[i] = byColumn[col]
+			}
+			out = append(out, row)
+			if len(out) == n {
+				break
+			}
+		}
+	}
+	if len(out) < n {
+		log.Printf("Only got %d of %d rows for %s", len(out), n, t.Name)
+	}
+	return columns, out, nil
+}
+
+func insertStatement(table string, columns []string) string {
+	cols := make([]string, len(columns))
+	vals := make([]string, len(columns))
+	for i, col := range columns {
+		cols[i] = quoteIdent(col)
+		vals[i] = "$" + strconv.Itoa(i+1)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING",
+		quoteIdent(table), strings.Join(cols, ", "), strings.Join(vals, ", "))
+}
+
+func runSeed(args []string) {
+	fs := commandFlags("seed")
+	table := fs.String("table", "", "table to fill")
+	n := fs.Int("rows", 100, "how many rows to add")
+	parentRows := fs.Int("parent-rows", 100, "how many rows to add to empty parent tables")
+	fs.Parse(args)
+	if err := requirePostgres("gorag seed"); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *table == "" {
+		log.Fatalf("usage: gorag seed -table customers -rows 1000")
+	}
+
+	client, done := setupClient()
+	defer done()
+	client.Config.mu.RLock()
+	allowed := client.Config.AllowSeed
+	client.Config.mu.RUnlock()
+	if !allowed {
+		log.Fatalf("Seeding is off; set \"allow_seed\": true in %s", *configFile)
+	}
+	if _, ok := client.Schema.Tables[*table]; !ok {
+		log.Fatalf("No such table: %s", *table)
+	}
+
+	// children find the keys of parents seeded before them, because it is all one transaction
+	tx, err := client.DB.Begin()
+	if err != nil {
+		log.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	for _, name := range seedOrder(client.Schema, *table) {
+		want := *n
+		if name != *table {
+			var existing int
+			row := tx.QueryRow(fmt.Sprintf("SELECT count(*) FROM (SELECT 1 FROM %s LIMIT 1) t", quoteIdent(name)))
+			if err := row.Scan(&existing); err != nil {
+				log.Fatalf("Failed to count %s: %v", name, err)
+			}
+			if existing > 0 {
+				continue
+			}
+			want = *parentRows
+		}
+		t, err := loadSeedTable(tx, client.Schema, name)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		columns, rows, err := client.seedRows(tx, t, want)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		added := int64(0)
+		insert := insertStatement(name, columns)
+		for _, values := range rows {
+			result, err := tx.Exec(insert, values...)
+			if err != nil {
+				log.Fatalf("Failed to insert into %s: %v", name, err)
+			}
+			affected, _ := result.RowsAffected()
+			added += affected
+		}
+		log.Printf("Seeded %s with %d rows", name, added)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("Failed to commit: %v", err)
+	}
+}
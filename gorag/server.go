@@ -0,0 +1,462 @@
+package gorag
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+  Server exposes the client over http. Questions go to /ask, and the
+  deployment itself (profiles, deny rules, saved questions, api keys)
+  is managed under /admin, so it can be driven declaratively instead
+  of by editing the config file on the host.
+*/
+type Server struct {
+	Config   *Config
+	APIKey   string
+	AdminKey string
+	Default  *Client
+
+	mu      sync.Mutex
+	clients map[string]*Client
+	popular *popularQuestions
+
+	capabilities capabilitiesCache
+	pushIngest   *pushIngest
+}
+
+func newServer(config *Config, apiKey, adminKey string, defaultClient *Client) *Server {
+	return &Server{
+		Config:   config,
+		APIKey:   apiKey,
+		AdminKey: adminKey,
+		Default:  defaultClient,
+		clients:  make(map[string]*Client),
+		popular:  &popularQuestions{filename: defaultClient.Audit.Filename()},
+		capabilities: capabilitiesCache{
+			byClient: make(map[*Client]string),
+		},
+	}
+}
+
+type askRequest struct {
+	Prompt  string `json:"prompt"`
+	Profile string `json:"profile,omitempty"`
+	Saved   string `json:"saved,omitempty"`
+	Purpose string `json:"purpose,omitempty"`
+	// the answer template, instead of the one the router picks
+	Category string `json:"category,omitempty"`
+	// justification for reading data the purpose doesn't allow
+	Override string `json:"override,omitempty"`
+	// how many rows come back as values, up to maxAskRows; 0 is defaultAskRows, and -1 none
+	Keep int `json:"keep,omitempty"`
+	// a screenshot the question is about, base64
+	Image []byte `json:"image,omitempty"`
+	// how the prompt writes numbers and dates, eg: de-DE; the Accept-Language by default
+	Locale string `json:"locale,omitempty"`
+	// the answer as server-sent events, the summary as it is written; also for Accept: text/event-stream
+	Stream bool `json:"stream,omitempty"`
+}
+
+const (
+	defaultAskRows = 100
+	maxAskRows     = 10000
+)
+
+// askResponse is the answer, with the rows a front end can render
+type askResponse struct {
+	*Answer
+	Columns   []string    `json:"columns,omitempty"`
+	Data      [][]*string `json:"data,omitempty"` // null is NULL
+	Truncated bool        `json:"truncated,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+func newAskResponse(answer *Answer, err error) askResponse {
+	out := askResponse{Answer: answer}
+	if t := answer.Table; t != nil {
+		out.Columns, out.Data, out.Truncated = t.Columns, t.Rows, t.Truncated
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func bearerToken(r *http.Request) string {
+	if k := r.Header.Get("X-API-Key"); k != "" {
+		return k
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// authenticate returns the caller's key, or nil when the deployment has no keys at all
+func (s *Server) authenticate(r *http.Request) (*APIKey, error) {
+	secret := bearerToken(r)
+	if s.AdminKey != "" && secret == s.AdminKey {
+		return &APIKey{Name: "admin", Admin: true}, nil
+	}
+	if k := s.Config.lookupAPIKey(secret); k != nil {
+		return k, nil
+	}
+	s.Config.mu.RLock()
+	open := len(s.Config.APIKeys) == 0 && s.AdminKey == ""
+	s.Config.mu.RUnlock()
+	if open {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("a valid api key is required")
+}
+
+// clientFor connects to a profile once, and reuses it after that
+func (s *Server) clientFor(profile string) (*Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if profile == "" {
+		return s.Default, nil
+	}
+	if c, ok := s.clients[profile]; ok {
+		return c, nil
+	}
+	s.Config.mu.RLock()
+	p, ok := s.Config.Profiles[profile]
+	var pc Profile
+	if ok {
+		pc = *p
+	}
+	s.Config.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no such profile: %s", profile)
+	}
+
+	db, err := connectAs(pc.DSN, pc.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", profile, err)
+	}
+	schema, err := loadSchema(db, pc.SchemaCache)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to retrieve schema for %s: %v", profile, err)
+	}
+	metadataFile := pc.Metadata
+	if metadataFile == "" {
+		metadataFile = "metadata.json"
+	}
+	c := s.Default.forProfile(profile, db, schema, loadExtraMetadataOrEmpty(metadataFile))
+	c.SQLModel, c.DataModel = pc.SQLModel, pc.DataModel
+	s.clients[profile] = c
+	return c, nil
+}
+
+// defaultClient is the client without a profile, which -schema-watch can replace
+func (s *Server) defaultClient() *Client {
+	c, _ := s.clientFor("")
+	return c
+}
+
+// forgetClient drops a cached connection after its profile changed
+func (s *Server) forgetClient(profile string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.clients[profile]; ok {
+		c.DB.Close()
+		delete(s.clients, profile)
+	}
+}
+
+func (s *Server) handleAsk(w http.ResponseWriter, r *http.Request) {
+	key, err := s.authenticate(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	var req askRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if wantsStream(r, req) {
+		s.streamAsk(w, r, key, req)
+		return
+	}
+	answer, status, err := s.ask(r, key, req, nil)
+	if answer == nil {
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, status, newAskResponse(answer, err))
+}
+
+// ask answers a request for the caller with key; without an answer, the status says what was wrong with it
+func (s *Server) ask(r *http.Request, key *APIKey, req askRequest, onToken func(*Answer, string)) (*Answer, int, error) {
+	if req.Saved != "" {
+		s.Config.mu.RLock()
+		q, ok := s.Config.SavedQuestions[req.Saved]
+		broken := ""
+		if ok {
+			req.Prompt = q.Prompt
+			if req.Profile == "" {
+				req.Profile = q.Profile
+			}
+			broken = q.Broken
+		}
+		s.Config.mu.RUnlock()
+		if !ok {
+			return nil, http.StatusNotFound, fmt.Errorf("no such saved question: %s", req.Saved)
+		}
+		if broken != "" {
+			return nil, http.StatusConflict, fmt.Errorf("saved question %s is broken: %s", req.Saved, broken)
+		}
+	}
+	if req.Prompt == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("prompt is required")
+	}
+	if key != nil && !allowsProfile(key.Profiles, req.Profile) {
+		return nil, http.StatusForbidden, fmt.Errorf("key %s may not use profile %s", key.Name, req.Profile)
+	}
+
+	client, err := s.clientFor(req.Profile)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	client = client.withTrace(traceFromRequest(r))
+	keep := req.Keep
+	switch {
+	case keep == 0:
+		keep = defaultAskRows
+	case keep < 0:
+		keep = 0
+	case keep > maxAskRows:
+		keep = maxAskRows
+	}
+	if len(req.Image) > 0 {
+		if _, err := imageType(req.Image); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+	question := Question{Prompt: req.Prompt, Purpose: req.Purpose, Category: req.Category, Override: req.Override, Keep: keep, Image: req.Image, Locale: req.Locale, OnToken: onToken}
+	// browsers send one for every request, so one we don't know is ignored
+	if _, err := lookupLocale(r.Header.Get("Accept-Language")); question.Locale == "" && err == nil {
+		question.Locale = r.Header.Get("Accept-Language")
+	}
+	if key != nil {
+		question.User = key.Name
+		question.CanOverride = key.CanOverride || key.Admin
+	}
+	answer, err := client.AskContext(r.Context(), question)
+	if err != nil {
+		return answer, http.StatusInternalServerError, err
+	}
+	return answer, http.StatusOK, nil
+}
+
+/*
+  registerAdmin wires up list/get/put/delete for one kind of config
+  object. prepare gets a chance to check (and fix up) an object before
+  it's stored, and its result is what the caller sees.
+*/
+func registerAdmin[T any](
+	s *Server,
+	mux *http.ServeMux,
+	kind string,
+	table func() map[string]*T,
+	setName func(*T, string),
+	prepare func(name string, v *T) (*T, error),
+	changed func(name string),
+) {
+	requireAdmin := func(w http.ResponseWriter, r *http.Request) bool {
+		key, err := s.authenticate(r)
+		if err == nil && key != nil && !key.Admin {
+			err = fmt.Errorf("key %s is not an admin key", key.Name)
+		}
+		if err == nil && key == nil && s.AdminKey == "" {
+			err = fmt.Errorf("admin api is disabled until GORAG_ADMIN_KEY or an admin api key is set")
+		}
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return false
+		}
+		return true
+	}
+
+	mux.HandleFunc("GET /admin/"+kind, func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		s.Config.mu.RLock()
+		names := make([]string, 0)
+		for name := range table() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		out := make([]*T, 0, len(names))
+		for _, name := range names {
+			out = append(out, table()[name])
+		}
+		s.Config.mu.RUnlock()
+		writeJSON(w, http.StatusOK, out)
+	})
+
+	mux.HandleFunc("GET /admin/"+kind+"/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		name := r.PathValue("name")
+		s.Config.mu.RLock()
+		v, ok := table()[name]
+		s.Config.mu.RUnlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no such %s: %s", kind, name))
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	})
+
+	mux.HandleFunc("PUT /admin/"+kind+"/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		name := r.PathValue("name")
+		v := new(T)
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		setName(v, name)
+		out, err := prepare(name, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		s.Config.mu.Lock()
+		table()[name] = v
+		err = s.Config.save()
+		s.Config.mu.Unlock()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		changed(name)
+		writeJSON(w, http.StatusOK, out)
+	})
+
+	mux.HandleFunc("DELETE /admin/"+kind+"/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		name := r.PathValue("name")
+		s.Config.mu.Lock()
+		_, ok := table()[name]
+		delete(table(), name)
+		err := s.Config.save()
+		s.Config.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no such %s: %s", kind, name))
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		changed(name)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /ask", s.handleAsk)
+	mux.HandleFunc("POST /query/audio", s.handleQueryAudio)
+	mux.HandleFunc("GET /suggest", s.handleSuggest)
+	mux.HandleFunc("POST /feedback", s.handleFeedback)
+	mux.HandleFunc("GET /capabilities", s.handleCapabilities)
+	mux.HandleFunc("POST /editor/edit", s.handleEditorEdit)
+	mux.HandleFunc("POST /editor/explain", s.handleEditorExplain)
+	mux.HandleFunc("POST /webhooks/github", s.handleGitHubWebhook)
+
+	nothing := func(string) {}
+	registerAdmin(s, mux, "profiles",
+		func() map[string]*Profile { return s.Config.Profiles },
+		func(v *Profile, name string) { v.Name = name },
+		func(name string, v *Profile) (*Profile, error) {
+			if v.DSN == "" {
+				return nil, fmt.Errorf("dsn is required")
+			}
+			return v, nil
+		},
+		s.forgetClient,
+	)
+	registerAdmin(s, mux, "deny-rules",
+		func() map[string]*DenyRule { return s.Config.DenyRules },
+		func(v *DenyRule, name string) { v.Name = name },
+		func(name string, v *DenyRule) (*DenyRule, error) {
+			if len(v.Tables) == 0 && v.Pattern == "" {
+				return nil, fmt.Errorf("a deny rule needs tables or a pattern")
+			}
+			if _, err := regexp.Compile(v.Pattern); err != nil {
+				return nil, fmt.Errorf("bad pattern: %v", err)
+			}
+			return v, nil
+		},
+		nothing,
+	)
+	registerAdmin(s, mux, "saved-questions",
+		func() map[string]*SavedQuestion { return s.Config.SavedQuestions },
+		func(v *SavedQuestion, name string) { v.Name = name },
+		func(name string, v *SavedQuestion) (*SavedQuestion, error) {
+			if v.Prompt == "" {
+				return nil, fmt.Errorf("prompt is required")
+			}
+			v.Broken = ""
+			return v, nil
+		},
+		nothing,
+	)
+	registerAdmin(s, mux, "api-keys",
+		func() map[string]*APIKey { return s.Config.APIKeys },
+		func(v *APIKey, name string) { v.Name = name },
+		func(name string, v *APIKey) (*APIKey, error) {
+			// only the hash is stored, the secret is returned once
+			shown := *v
+			if v.Key == "" && v.KeyHash == "" {
+				key, err := generateAPIKey()
+				if err != nil {
+					return nil, err
+				}
+				v.Key = key
+				shown.Key = key
+			}
+			if v.Key != "" {
+				v.KeyHash = hashAPIKey(v.Key)
+				v.Key = ""
+			}
+			shown.KeyHash = v.KeyHash
+			return &shown, nil
+		},
+		nothing,
+	)
+	return mux
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	log.Printf("Serving on %s", addr)
+	return http.ListenAndServe(addr, s.routes())
+}
@@ -0,0 +1,285 @@
+package gorag
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+)
+
+/*
+  A Sink is somewhere an answer goes after a run, so a scheduled
+  question can post to Slack and archive to S3 at once without glue
+  around gorag. -sink takes a comma separated list, and a saved
+  question can carry its own:
+
+    stdout                  the answer as json
+    file:answer.json        the same, to a file
+    https://host/hook       POSTed as json
+    slack:https://hooks...  a Slack incoming webhook
+    s3://bucket/prefix      <prefix>/<run id>.json and the whole result as <run id>.txt
+    mailto:me@example.com   through GORAG_SMTP_ADDR, from GORAG_SMTP_FROM
+
+  Every sink is tried even when one fails, and the run fails after if
+  any did, so a scheduler notices.
+*/
+var sinkSpecs = Flags.String("sink", "", "where answers go: stdout, file:path, https://webhook, slack:https://hook, s3://bucket/prefix, mailto:address, comma separated")
+
+type Sink interface {
+	// Send delivers one answer; result is a file with the whole result, or ""
+	Send(answer *Answer, result string) error
+	String() string
+}
+
+func parseSinks(specs []string) ([]Sink, error) {
+	sinks := make([]Sink, 0, len(specs))
+	for _, spec := range specs {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		s, err := parseSink(spec)
+		if err != nil {
+			return nil, fmt.Errorf("bad sink %s: %v", spec, err)
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+func parseSink(spec string) (Sink, error) {
+	scheme, rest, _ := strings.Cut(spec, ":")
+	switch scheme {
+	case "stdout":
+		return &writerSink{name: "stdout", w: os.Stdout}, nil
+	case "file":
+		if rest == "" {
+			return nil, fmt.Errorf("a file name is required")
+		}
+		return &fileSink{name: rest}, nil
+	case "http", "https":
+		return &webhookSink{url: spec}, checkSinkHost(spec)
+	case "slack":
+		return &slackSink{url: rest}, checkSinkHost(rest)
+	case "s3":
+		location := strings.TrimSuffix(spec, "/")
+		if strings.TrimPrefix(location, "s3://") == "" {
+			return nil, fmt.Errorf("a bucket is required")
+		}
+		if egress != nil {
+			return nil, fmt.Errorf("S3 is blocked by -no-external-calls")
+		}
+		return &s3Sink{location: location}, nil
+	case "mailto":
+		if !strings.Contains(rest, "@") {
+			return nil, fmt.Errorf("an address is required")
+		}
+		addr := os.Getenv("GORAG_SMTP_ADDR")
+		if addr == "" {
+			return nil, fmt.Errorf("GORAG_SMTP_ADDR must be set, eg: smtp.example.com:587")
+		}
+		if egress != nil && !egress.allow.allows(addr) {
+			return nil, fmt.Errorf("%s is not in -allow-hosts", addr)
+		}
+		return &mailSink{to: rest, addr: addr, from: os.Getenv("GORAG_SMTP_FROM")}, nil
+	}
+	return nil, fmt.Errorf("unknown kind of sink")
+}
+
+// checkSinkHost refuses at startup what -no-external-calls would refuse after the question
+func checkSinkHost(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("bad url")
+	}
+	if egress != nil && !egress.allow.allows(u.Host) {
+		return fmt.Errorf("%s is not in -allow-hosts", u.Host)
+	}
+	return nil
+}
+
+// sendAll tries every sink, and fails if any did
+func sendAll(sinks []Sink, answer *Answer, result string) error {
+	failed := 0
+	for _, s := range sinks {
+		if err := s.Send(answer, result); err != nil {
+			log.Printf("Failed to send the answer to %s: %v", s, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sinks failed", failed, len(sinks))
+	}
+	return nil
+}
+
+type writerSink struct {
+	name string
+	w    io.Writer
+}
+
+func (s *writerSink) Send(answer *Answer, result string) error {
+	enc := json.NewEncoder(s.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(answer)
+}
+
+func (s *writerSink) String() string { return s.name }
+
+type fileSink struct {
+	name string
+}
+
+func (s *fileSink) Send(answer *Answer, result string) error {
+	data, err := json.MarshalIndent(answer, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.name, append(data, '\n'), 0644)
+}
+
+func (s *fileSink) String() string { return "file:" + s.name }
+
+func postJSON(url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+type webhookSink struct {
+	url string
+}
+
+func (s *webhookSink) Send(answer *Answer, result string) error {
+	return postJSON(s.url, answer)
+}
+
+func (s *webhookSink) String() string { return s.url }
+
+type slackSink struct {
+	url string
+}
+
+func (s *slackSink) Send(answer *Answer, result string) error {
+	text := fmt.Sprintf("*%s*\n%s", answer.Prompt, answer.Summary)
+	if answer.Query != "" {
+		text += fmt.Sprintf("\n```%s```", answer.Query)
+	}
+	if answer.SuggestedQuery != "" {
+		text += fmt.Sprintf("\nTry instead: ```%s```", answer.SuggestedQuery)
+	}
+	return postJSON(s.url, map[string]string{"text": text})
+}
+
+// the webhook url is a secret, so it isn't logged
+func (s *slackSink) String() string { return "slack" }
+
+type s3Sink struct {
+	location string
+}
+
+func (s *s3Sink) Send(answer *Answer, result string) error {
+	data, err := json.MarshalIndent(answer, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := putS3Object(s.location+"/"+answer.RunID+".json", data, "application/json"); err != nil {
+		return err
+	}
+	if result == "" {
+		return nil
+	}
+	rows, err := os.ReadFile(result)
+	if err != nil {
+		return err
+	}
+	return putS3Object(s.location+"/"+answer.RunID+".txt", rows, "text/plain")
+}
+
+func (s *s3Sink) String() string { return s.location }
+
+type mailSink struct {
+	to, addr, from string
+}
+
+func (s *mailSink) Send(answer *Answer, result string) error {
+	from := s.from
+	if from == "" {
+		from = "gorag@localhost"
+	}
+	subject := answer.Prompt
+	if len(subject) > 80 {
+		subject = subject[:77] + "..."
+	}
+	subject = strings.ReplaceAll(strings.ReplaceAll(subject, "\r", " "), "\n", " ")
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: gorag: %s\r\n", from, s.to, subject))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(fmt.Sprintf("%s\r\n\r\n%s\r\n\r\n%s\r\n", answer.Summary, answer.Query, answer.Result))
+
+	// dialed ourselves, so -no-external-calls applies as it does to everything else
+	var conn net.Conn
+	var err error
+	if egress != nil {
+		conn, err = egress.Dial("tcp", s.addr)
+	} else {
+		conn, err = net.Dial("tcp", s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	host, _, _ := net.SplitHostPort(s.addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if user := os.Getenv("GORAG_SMTP_USER"); user != "" {
+		if err := c.Auth(smtp.PlainAuth("", user, os.Getenv("GORAG_SMTP_PASSWORD"), host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(s.to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, msg.String()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (s *mailSink) String() string { return "mailto:" + s.to }
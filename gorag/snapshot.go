@@ -0,0 +1,88 @@
+package gorag
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+var snapshotFlag = Flags.Bool("snapshot", false, "read all of an answer's queries from one REPEATABLE READ snapshot")
+
+/*
+  One answer can run several queries: the probes of validate, the
+  query and its regenerations, the diagnosis of an empty result, and
+  the aggregates of the summary. With -snapshot they all read one
+  read only REPEATABLE READ transaction, so their numbers agree even
+  while other sessions write. A query that fails would abort the
+  transaction, so each one after the first starts by rolling back to
+  a savepoint taken at the start, which keeps the snapshot. A scratch
+  space is a transaction of its own, since it writes temp tables; it
+  imports the snapshot, so its stages see the same rows. The
+  transaction holds a connection for the whole answer, summary
+  included.
+*/
+type readSnapshot struct {
+	tx    *sql.Tx
+	id    string // pg_export_snapshot, for a scratch space to share
+	dirty bool
+}
+
+func (c *Client) openSnapshot() (*readSnapshot, error) {
+	if err := requirePostgres("-snapshot"); err != nil {
+		return nil, err
+	}
+	tx, err := c.DB.BeginTx(c.runContext(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %v", err)
+	}
+	s := &readSnapshot{tx: tx}
+	if err := tx.QueryRow("SELECT pg_export_snapshot()").Scan(&s.id); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to export snapshot: %v", err)
+	}
+	if _, err := tx.Exec("SAVEPOINT snapshot"); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to open snapshot: %v", err)
+	}
+	return s, nil
+}
+
+// reset undoes whatever the last query did, an error included
+func (s *readSnapshot) reset() {
+	if s.dirty {
+		s.tx.Exec("ROLLBACK TO SAVEPOINT snapshot")
+	}
+	s.dirty = true
+}
+
+func (s *readSnapshot) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	s.reset()
+	return s.tx.Query(query, args...)
+}
+
+func (s *readSnapshot) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	s.reset()
+	return s.tx.QueryContext(ctx, query, args...)
+}
+
+func (s *readSnapshot) QueryRow(query string, args ...interface{}) *sql.Row {
+	s.reset()
+	return s.tx.QueryRow(query, args...)
+}
+
+func (s *readSnapshot) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	s.reset()
+	return s.tx.QueryRowContext(ctx, query, args...)
+}
+
+func (s *readSnapshot) Close() error {
+	return s.tx.Rollback()
+}
+
+// reader is what the run's queries read: its snapshot, or the pool
+func (c *Client) reader() queryer {
+	if c.snapshot != nil {
+		return c.snapshot
+	}
+	return c.DB
+}
@@ -0,0 +1,195 @@
+package gorag
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/snowflakedb/gosnowflake"
+)
+
+/*
+  With -driver snowflake, -host is the account identifier (eg:
+  myorg-myaccount), -dbname the database, and the schema explored is
+  -snowflake-schema in it, from its information_schema, with the keys
+  from SHOW, since Snowflake has no key_column_usage. Snowflake folds
+  unquoted names to upper case, where Postgres folds them to lower,
+  so names the catalog has in upper case are kept in lower, which is
+  how the checks compare unquoted names, and quoteIdent puts them back.
+  The dsn is the driver's, which GORAG_DSN can give whole:
+  user:password@account.snowflakecomputing.com:443?database=db&schema=s.
+*/
+var snowflakeWarehouse = Flags.String("snowflake-warehouse", "", "with -driver snowflake, the warehouse queries run on (the user's default if empty)")
+var snowflakeRole = Flags.String("snowflake-role", "", "with -driver snowflake, the role to use (the user's default if empty)")
+var snowflakeSchema = Flags.String("snowflake-schema", "PUBLIC", "with -driver snowflake, the schema of -dbname to explore")
+
+const snowflakePrompt = `
+This is Snowflake, so write Snowflake SQL, not PostgreSQL. Leave identifiers
+unquoted: Snowflake folds them to upper case, and a quoted lower case name is a
+different one. Use QUALIFY to filter on window functions, IFF(cond, a, b),
+DATEADD(day, -7, CURRENT_DATE()), DATEDIFF(day, a, b), DATE_TRUNC('month', x),
+TO_CHAR(x, 'YYYY-MM') and LISTAGG. VARIANT columns are read with col:field::string
+and LATERAL FLATTEN(input => col). ILIKE and :: casts work; prefer TRY_CAST when
+the text might not parse.
+`
+
+func init() {
+	RegisterDialect("snowflake", builtinDialect{
+		name:       "Snowflake",
+		hints:      snowflakePrompt,
+		dsn:        snowflakeDSN,
+		connect:    openSnowflake,
+		introspect: getSnowflakeSchema,
+		quote:      quoteSnowflake,
+	})
+}
+
+func snowflakeDSN(user, password, dbname, account string) string {
+	dsn, err := gosnowflake.DSN(&gosnowflake.Config{
+		Account:   account,
+		User:      user,
+		Password:  password,
+		Database:  dbname,
+		Schema:    *snowflakeSchema,
+		Warehouse: *snowflakeWarehouse,
+		Role:      *snowflakeRole,
+	})
+	if err != nil {
+		log.Fatalf("Failed to make the snowflake dsn: %v", err)
+	}
+	return dsn
+}
+
+// openSnowflake is sql.Open, with the egress guard on the connections when there is one
+func openSnowflake(dsn string) (*sql.DB, error) {
+	cfg, err := gosnowflake.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	// the driver has its own transport, and the guard is on the default one
+	if egress != nil {
+		cfg.Transporter = http.DefaultTransport
+	}
+	return sql.OpenDB(gosnowflake.NewConnector(gosnowflake.SnowflakeDriver{}, *cfg)), nil
+}
+
+// quoteSnowflake quotes the name as it is in the database: the schema has upper case names in lower case
+func quoteSnowflake(name string) string {
+	if name == strings.ToLower(name) {
+		name = strings.ToUpper(name)
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// snowflakeName is the name as an unquoted one would be, when it is one
+func snowflakeName(name string) string {
+	if name == strings.ToUpper(name) {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+func getSnowflakeSchema(db *sql.DB) (*DBMetadata, error) {
+	rows, err := db.Query(`
+		SELECT c.table_name, c.column_name, COALESCE(c.comment, ''), COALESCE(t.comment, '')
+		FROM information_schema.columns c
+		JOIN information_schema.tables t
+			ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+		WHERE c.table_schema = CURRENT_SCHEMA()
+		ORDER BY c.table_name, c.ordinal_position`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metadata := DBMetadata{Tables: make(map[string][]string), Comments: make(map[string]string)}
+	for rows.Next() {
+		var table, column, comment, tableComment string
+		if err := rows.Scan(&table, &column, &comment, &tableComment); err != nil {
+			return nil, err
+		}
+		table, column = snowflakeName(table), snowflakeName(column)
+		metadata.Tables[table] = append(metadata.Tables[table], column)
+		if comment != "" {
+			metadata.Comments[table+"."+column] = comment
+		}
+		if tableComment != "" {
+			metadata.Comments[table] = tableComment
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// keys are nice to have, so a failure here is not fatal
+	if metadata.PrimaryKeys, err = getSnowflakePrimaryKeys(db); err != nil {
+		log.Printf("Failed to retrieve primary keys: %v", err)
+	}
+	if metadata.ForeignKeys, err = getSnowflakeForeignKeys(db); err != nil {
+		log.Printf("Failed to retrieve foreign keys: %v", err)
+	}
+	return &metadata, nil
+}
+
+// showRows reads what a SHOW command says, by column name, since which columns there are varies
+func showRows(db *sql.DB, query string) ([]map[string]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]map[string]string, 0)
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(columns))
+		for i, c := range columns {
+			row[strings.ToLower(c)] = values[i].String
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+func getSnowflakePrimaryKeys(db *sql.DB) (map[string][]string, error) {
+	rows, err := showRows(db, "SHOW PRIMARY KEYS IN SCHEMA")
+	if err != nil {
+		return nil, err
+	}
+	// the rows are in key_sequence order
+	keys := make(map[string][]string)
+	for _, r := range rows {
+		table := snowflakeName(r["table_name"])
+		keys[table] = append(keys[table], snowflakeName(r["column_name"]))
+	}
+	return keys, nil
+}
+
+func getSnowflakeForeignKeys(db *sql.DB) ([]ForeignKey, error) {
+	rows, err := showRows(db, "SHOW IMPORTED KEYS IN SCHEMA")
+	if err != nil {
+		return nil, err
+	}
+	fks := make([]ForeignKey, 0)
+	for _, r := range rows {
+		fks = append(fks, ForeignKey{
+			Name:      snowflakeName(r["fk_name"]),
+			Table:     snowflakeName(r["fk_table_name"]),
+			Column:    snowflakeName(r["fk_column_name"]),
+			RefTable:  snowflakeName(r["pk_table_name"]),
+			RefColumn: snowflakeName(r["pk_column_name"]),
+		})
+	}
+	return fks, nil
+}
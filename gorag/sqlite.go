@@ -0,0 +1,114 @@
+package gorag
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+/*
+  With -driver sqlite, -dbname is the file, opened read only, and the
+  schema comes from sqlite_master and the table_info and
+  foreign_key_list pragmas. SQLite has no comments or statistics to
+  put in the prompt. Names are kept in lower case, since SQLite ignores
+  their case and the checks compare them that way.
+*/
+const sqlitePrompt = `
+This is SQLite, not PostgreSQL. There is no ILIKE (LIKE ignores case for ASCII),
+no :: casts (use CAST(x AS INTEGER), CAST(x AS REAL) or CAST(x AS TEXT)), no
+DATE_TRUNC, EXTRACT or INTERVAL: dates are text, handled with date('now', '-7 days'),
+strftime('%Y-%m', x) and julianday(a) - julianday(b). Concatenate with ||, and
+booleans are 0 and 1.
+`
+
+func init() {
+	RegisterDialect("sqlite", builtinDialect{
+		name:       "SQLite",
+		hints:      sqlitePrompt,
+		dsn:        func(user, password, dbname, host string) string { return sqliteDSN(dbname) },
+		connect:    func(dsn string) (*sql.DB, error) { return sql.Open("sqlite", dsn) },
+		introspect: getSQLiteSchema,
+	})
+}
+
+func sqliteDSN(file string) string {
+	file = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(file)
+	return "file:" + file + "?mode=ro"
+}
+
+func getSQLiteSchema(db *sql.DB) (*DBMetadata, error) {
+	rows, err := db.Query(`
+		SELECT m.name, c.name, c.pk
+		FROM sqlite_master m, pragma_table_info(m.name) c
+		WHERE m.type IN ('table', 'view') AND m.name NOT LIKE 'sqlite_%'
+		ORDER BY m.name, c.cid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metadata := DBMetadata{Tables: make(map[string][]string), PrimaryKeys: make(map[string][]string)}
+	// pk is the column's place in the primary key, from 1
+	pks := make(map[string]map[int]string)
+	for rows.Next() {
+		var table, column string
+		var pk int
+		if err := rows.Scan(&table, &column, &pk); err != nil {
+			return nil, err
+		}
+		table, column = strings.ToLower(table), strings.ToLower(column)
+		metadata.Tables[table] = append(metadata.Tables[table], column)
+		if pk > 0 {
+			if pks[table] == nil {
+				pks[table] = make(map[int]string)
+			}
+			pks[table][pk] = column
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for table, columns := range pks {
+		for i := 1; i <= len(columns); i++ {
+			metadata.PrimaryKeys[table] = append(metadata.PrimaryKeys[table], columns[i])
+		}
+	}
+
+	// keys are nice to have, so a failure here is not fatal
+	if metadata.ForeignKeys, err = getSQLiteForeignKeys(db, metadata.PrimaryKeys); err != nil {
+		log.Printf("Failed to retrieve foreign keys: %v", err)
+	}
+	return &metadata, nil
+}
+
+func getSQLiteForeignKeys(db *sql.DB, primaryKeys map[string][]string) ([]ForeignKey, error) {
+	rows, err := db.Query(`
+		SELECT m.name, k.id, k.seq, k."table", k."from", COALESCE(k."to", '')
+		FROM sqlite_master m, pragma_foreign_key_list(m.name) k
+		WHERE m.type = 'table'
+		ORDER BY m.name, k.id, k.seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	fks := make([]ForeignKey, 0)
+	for rows.Next() {
+		var fk ForeignKey
+		var id, seq int
+		if err := rows.Scan(&fk.Table, &id, &seq, &fk.RefTable, &fk.Column, &fk.RefColumn); err != nil {
+			return nil, err
+		}
+		fk.Table, fk.Column = strings.ToLower(fk.Table), strings.ToLower(fk.Column)
+		fk.RefTable, fk.RefColumn = strings.ToLower(fk.RefTable), strings.ToLower(fk.RefColumn)
+		// a key that names no columns references the primary key
+		if fk.RefColumn == "" && seq < len(primaryKeys[fk.RefTable]) {
+			fk.RefColumn = primaryKeys[fk.RefTable][seq]
+		}
+		fk.Name = fmt.Sprintf("%s_fk%d", fk.Table, id)
+		fks = append(fks, fk)
+	}
+	return fks, rows.Err()
+}
@@ -0,0 +1,976 @@
+package gorag
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/*
+  We don't need a full SQL parser to answer simple questions about a
+  generated query, like which tables it reads or what kind of
+  statements it contains. A lexer that understands quoting and
+  comments gets us most of the way without misreading string literals.
+*/
+type sqlTokenKind int
+
+const (
+	sqlWord sqlTokenKind = iota
+	sqlQuotedIdent
+	sqlString
+	sqlNumber
+	sqlPunct
+)
+
+type sqlToken struct {
+	Kind    sqlTokenKind
+	Text    string
+	Pos     int  // byte offset in the query
+	Unclear bool // a quote that doesn't end, or that a server could end somewhere else
+}
+
+// upper is the keyword form of a bare word, and "" for anything else
+func (t sqlToken) upper() string {
+	if t.Kind != sqlWord {
+		return ""
+	}
+	return strings.ToUpper(t.Text)
+}
+
+// ident is the identifier a token names, with quotes removed
+func (t sqlToken) ident() string {
+	switch t.Kind {
+	case sqlWord:
+		return strings.ToLower(t.Text)
+	case sqlQuotedIdent:
+		// an unterminated quote at the very end is all there is
+		if len(t.Text) < 2 {
+			return ""
+		}
+		q := t.Text[:1]
+		if q == "[" {
+			return strings.ToLower(strings.ReplaceAll(t.Text[1:len(t.Text)-1], "]]", "]"))
+		}
+		name := strings.ReplaceAll(t.Text[1:len(t.Text)-1], q+q, q)
+		// sqlite, sql server, duckdb and bigquery ignore case in names, quoted or not
+		if *driver == "sqlite" || *driver == "sqlserver" || *driver == "duckdb" || *driver == "bigquery" {
+			return strings.ToLower(name)
+		}
+		// snowflake's unquoted names are upper case, so a quoted upper case name is the same as one
+		if *driver == "snowflake" {
+			return snowflakeName(name)
+		}
+		return name
+	}
+	return ""
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func indexRunes(rs, sub []rune) int {
+	for i := 0; i+len(sub) <= len(rs); i++ {
+		if string(rs[i:i+len(sub)]) == string(sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+func lexSQL(query string) []sqlToken {
+	rs := []rune(query)
+	// offs maps rune index to byte offset, so tokens can point back into the query
+	offs := make([]int, len(rs)+1)
+	n := 0
+	for i, r := range rs {
+		offs[i] = n
+		n += len(string(r))
+	}
+	offs[len(rs)] = n
+	tokens := make([]sqlToken, 0)
+	emit := func(kind sqlTokenKind, from, to int) {
+		tokens = append(tokens, sqlToken{Kind: kind, Text: string(rs[from:to]), Pos: offs[from]})
+	}
+	unclear := func() {
+		tokens[len(tokens)-1].Unclear = true
+	}
+	/*
+	  mysql runs what is in /*! ... comments, has # comments, only
+	  starts a -- comment before a space, and escapes with backslashes
+	  in strings. Lexed the postgres way, any of those could hide a
+	  table from the checks. bigquery and clickhouse have the #
+	  comments and the backslashes too, in quoted names as well, and
+	  bigquery's "double quotes" are strings, and it has '''triple
+	  quoted''' ones. postgres escapes with backslashes only in
+	  E'...' strings; in a plain one a backslash before a quote ends it
+	  or not as standard_conforming_strings says, so it is unclear.
+	*/
+	mysql := *driver == "mysql"
+	bigquery := *driver == "bigquery"
+	backslashes := bigquery || *driver == "clickhouse"
+	brackets := *driver == "sqlite" || *driver == "sqlserver"
+	postgres := *driver == "postgres"
+	executable := 0
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '-' && i+1 < len(rs) && rs[i+1] == '-' && (!mysql || i+2 >= len(rs) || unicode.IsSpace(rs[i+2]) || unicode.IsControl(rs[i+2])),
+			(mysql || backslashes) && r == '#':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case mysql && r == '/' && i+2 < len(rs) && rs[i+1] == '*' && rs[i+2] == '!':
+			i += 3
+			for i < len(rs) && unicode.IsDigit(rs[i]) {
+				i++
+			}
+			executable++
+		case executable > 0 && r == '*' && i+1 < len(rs) && rs[i+1] == '/':
+			i += 2
+			executable--
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			i += 2
+			for i < len(rs) && !(rs[i] == '*' && i+1 < len(rs) && rs[i+1] == '/') {
+				i++
+			}
+			i += 2
+		case bigquery && (r == '\'' || r == '"') && i+2 < len(rs) && rs[i+1] == r && rs[i+2] == r:
+			j := i + 3
+			for j < len(rs) && !(rs[j] == r && j+2 < len(rs) && rs[j+1] == r && rs[j+2] == r) {
+				if rs[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j += 2
+			open := j >= len(rs)
+			if open {
+				j = len(rs) - 1
+			}
+			emit(sqlString, i, j+1)
+			if open {
+				unclear()
+			}
+			i = j + 1
+		case postgres && (r == 'e' || r == 'E') && i+1 < len(rs) && rs[i+1] == '\'':
+			// E'...', where \' is a quote inside it
+			j := i + 2
+			for j < len(rs) {
+				if rs[j] == '\\' {
+					j += 2
+					continue
+				}
+				if rs[j] == '\'' {
+					if j+1 < len(rs) && rs[j+1] == '\'' {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			open := j >= len(rs)
+			if open {
+				j = len(rs) - 1
+			}
+			emit(sqlString, i, j+1)
+			if open {
+				unclear()
+			}
+			i = j + 1
+		case r == '\'' || r == '"' || r == '`':
+			j := i + 1
+			for j < len(rs) {
+				if (mysql && r != '`' || backslashes) && rs[j] == '\\' {
+					j += 2
+					continue
+				}
+				if rs[j] == r {
+					if j+1 < len(rs) && rs[j+1] == r {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			open := j >= len(rs)
+			if open {
+				j = len(rs) - 1
+			}
+			kind := sqlQuotedIdent
+			if r == '\'' || bigquery && r == '"' {
+				kind = sqlString
+			}
+			emit(kind, i, j+1)
+			if open || postgres && r == '\'' && strings.Contains(string(rs[i+1:j+1]), "\\'") {
+				unclear()
+			}
+			i = j + 1
+		case brackets && r == '[':
+			// sqlite and sql server quote [names], with ]] for a ]
+			j := i + 1
+			for j < len(rs) && (rs[j] != ']' || j+1 < len(rs) && rs[j+1] == ']') {
+				if rs[j] == ']' {
+					j++
+				}
+				j++
+			}
+			open := j >= len(rs)
+			if open {
+				j = len(rs) - 1
+			}
+			emit(sqlQuotedIdent, i, j+1)
+			if open {
+				unclear()
+			}
+			i = j + 1
+		case r == '$' && i+1 < len(rs) && (rs[i+1] == '$' || unicode.IsLetter(rs[i+1])):
+			// postgres dollar quoting: $$...$$ or $tag$...$tag$
+			j := i + 1
+			for j < len(rs) && rs[j] != '$' && isIdentRune(rs[j]) {
+				j++
+			}
+			if j >= len(rs) || rs[j] != '$' {
+				emit(sqlPunct, i, i+1)
+				i++
+				continue
+			}
+			tag := rs[i : j+1]
+			end := indexRunes(rs[j+1:], tag)
+			if end < 0 {
+				emit(sqlString, i, len(rs))
+				unclear()
+				i = len(rs)
+				continue
+			}
+			stop := j + 1 + end + len(tag)
+			emit(sqlString, i, stop)
+			i = stop
+		case unicode.IsDigit(r):
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			emit(sqlNumber, i, j)
+			i = j
+		case isIdentRune(r):
+			j := i
+			for j < len(rs) && isIdentRune(rs[j]) {
+				j++
+			}
+			emit(sqlWord, i, j)
+			i = j
+		default:
+			emit(sqlPunct, i, i+1)
+			i++
+		}
+	}
+	return tokens
+}
+
+// splitStatements breaks tokens on top level semicolons
+func splitStatements(tokens []sqlToken) [][]sqlToken {
+	statements := make([][]sqlToken, 0)
+	start := 0
+	for i, t := range tokens {
+		if t.Kind == sqlPunct && t.Text == ";" {
+			if i > start {
+				statements = append(statements, tokens[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if start < len(tokens) {
+		statements = append(statements, tokens[start:])
+	}
+	return statements
+}
+
+// qualifiedName reads schema.table starting at i, returning the name and the next index
+func qualifiedName(tokens []sqlToken, i int) (string, int) {
+	parts := make([]string, 0, 2)
+	for i < len(tokens) {
+		name := tokens[i].ident()
+		if name == "" {
+			break
+		}
+		parts = append(parts, name)
+		i++
+		if i < len(tokens) && tokens[i].Kind == sqlPunct && tokens[i].Text == "." {
+			i++
+			continue
+		}
+		break
+	}
+	return strings.Join(parts, "."), i
+}
+
+// keywords that can come before a table's name, eg: FROM LATERAL, DROP TABLE IF EXISTS
+var sqlNotTables = map[string]bool{
+	"LATERAL": true, "ONLY": true, "IF": true, "EXISTS": true, "NOT": true,
+}
+
+// fromListEnds end a FROM list, so a comma after them isn't another table
+var fromListEnds = map[string]bool{
+	"WHERE": true, "GROUP": true, "HAVING": true, "ORDER": true, "LIMIT": true, "OFFSET": true,
+	"FETCH": true, "UNION": true, "INTERSECT": true, "EXCEPT": true, "WINDOW": true, "QUALIFY": true,
+	"RETURNING": true, "SET": true, "SELECT": true, "VALUES": true, "PREWHERE": true, "SETTINGS": true,
+	"FORMAT": true, "OPTION": true, "CONNECT": true, "START": true,
+}
+
+// notAliases can follow a table in some dialect without being its alias, though they aren't keywords here
+var notAliases = map[string]bool{
+	"FINAL": true, "SAMPLE": true, "PREWHERE": true, "SETTINGS": true, "FORMAT": true, "GLOBAL": true,
+	"SEMI": true, "ANTI": true, "ASOF": true, "PASTE": true, "STRAIGHT_JOIN": true, "USE": true,
+	"FORCE": true, "IGNORE": true, "PARTITION": true, "AT": true, "BEFORE": true, "CHANGES": true,
+	"MATCH_RECOGNIZE": true, "CONNECT": true, "START": true, "PIVOT": true, "UNPIVOT": true,
+	"APPLY": true, "OPTION": true, "QUALIFY": true, "LOCK": true, "INDEXED": true, "OUTPUT": true,
+}
+
+/*
+  tableRef is a table a query names, where it names it. Select is the
+  token index of the SELECT whose FROM it's in, or -1 for the target of
+  UPDATE, INSERT INTO or TABLE, and for a FROM outside any SELECT, as
+  in DELETE FROM.
+*/
+type tableRef struct {
+	Table   string   // lower cased, with its schema if it has one
+	Alias   string   // "" when it has none
+	Columns []string // the alias' column list, eg: people AS p (a, b), which renames the columns
+	Select  int
+	Parens  bool // in a parenthesized join, eg: FROM (a JOIN b ON ...)
+}
+
+// tableScan reads the tables out of a statement's tokens, scoping CTE names to where they can be used
+type tableScan struct {
+	tokens []sqlToken
+	refs   []tableRef
+	from   map[int]bool // the SELECTs that have a FROM, by token index
+}
+
+/*
+  scanTables finds every table the tokens name, in FROM lists, joins,
+  subqueries and CTEs, and the targets of writes. A CTE's name stands
+  for the CTE only after it, and in its own body only WITH RECURSIVE,
+  so WITH t AS (SELECT * FROM t) still reads the table t. Whatever
+  it can't tell the meaning of in a FROM list is an error, rather
+  than a table left out, since the checks built on it would let that
+  table through.
+*/
+func scanTables(tokens []sqlToken) (*tableScan, error) {
+	s := &tableScan{tokens: tokens, from: make(map[int]bool)}
+	if err := s.query(0, len(tokens), nil, -1, false); err != nil {
+		return s, fmt.Errorf("can't tell which tables the query reads: %v", err)
+	}
+	return s, nil
+}
+
+// startsQuery is whether the token can start a query in parentheses
+func startsQuery(t sqlToken) bool {
+	switch t.upper() {
+	case "SELECT", "WITH", "VALUES", "TABLE":
+		return true
+	}
+	return false
+}
+
+// closing is the index of the ) that closes the ( at i, before hi
+func (s *tableScan) closing(i, hi int) (int, error) {
+	shut := map[string]string{"(": ")", "[": "]"}[s.tokens[i].Text]
+	depth := 0
+	for j := i; j < hi; j++ {
+		switch s.tokens[j].Text {
+		case s.tokens[i].Text:
+			depth++
+		case shut:
+			depth--
+			if depth == 0 {
+				return j, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("the %s at %d doesn't close", s.tokens[i].Text, s.tokens[i].Pos)
+}
+
+// isCall is whether the ( at i holds a function's arguments, where FROM isn't a table's
+func (s *tableScan) isCall(i int) bool {
+	if i == 0 {
+		return false
+	}
+	prev := s.tokens[i-1]
+	return prev.Kind == sqlQuotedIdent || prev.Kind == sqlWord && !isSQLKeyword(prev.upper())
+}
+
+// query reads the tokens from lo to hi, as a query, or as the inside of a parenthesized join
+func (s *tableScan) query(lo, hi int, ctes map[string]bool, sel int, join bool) error {
+	statementCTEs := ctes
+	inFrom := false
+	i := lo
+	if join {
+		next, err := s.fromItem(lo, hi, ctes, sel, true, "FROM")
+		if err != nil {
+			return err
+		}
+		i, inFrom = next, true
+	}
+	for i < hi {
+		t := s.tokens[i]
+		var err error
+		switch kw := t.upper(); {
+		case t.Text == "(" || t.Text == "[":
+			var end int
+			if end, err = s.closing(i, hi); err != nil {
+				return err
+			}
+			if t.Text == "[" {
+				// an array's commas aren't a FROM list's
+			} else if s.isCall(i) {
+				err = s.call(i+1, end, ctes)
+			} else {
+				err = s.query(i+1, end, ctes, -1, false)
+			}
+			i = end + 1
+		case t.Text == ";":
+			ctes, sel, inFrom = statementCTEs, -1, false
+			i++
+		case t.Text == "," && inFrom:
+			i, err = s.fromItem(i+1, hi, ctes, sel, join, "FROM")
+		case kw == "WITH" && s.startsCTEs(i, hi):
+			i, ctes, err = s.with(i, hi, ctes)
+		case kw == "SELECT":
+			sel, inFrom = i, false
+			i++
+		case kw == "FROM":
+			if sel >= 0 {
+				s.from[sel] = true
+			}
+			inFrom = true
+			i, err = s.fromItem(i+1, hi, ctes, sel, join, kw)
+		case kw == "JOIN" || kw == "APPLY" || kw == "STRAIGHT_JOIN":
+			inFrom = true
+			i, err = s.fromItem(i+1, hi, ctes, sel, join, "FROM")
+		case kw == "USING" && !(i+2 < hi && s.tokens[i+1].Text == "(" && !startsQuery(s.tokens[i+2])):
+			// MERGE ... USING s and DELETE ... USING s name tables, where JOIN ... USING (id) names columns
+			inFrom = true
+			i, err = s.fromItem(i+1, hi, ctes, sel, join, "FROM")
+		case kw == "INTO" || kw == "TABLE" || kw == "UPDATE" && s.updatesTable(i, hi):
+			inFrom = false
+			i, err = s.fromItem(i+1, hi, ctes, -1, false, kw)
+		default:
+			if fromListEnds[kw] {
+				inFrom = false
+			}
+			i++
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// call reads a function's arguments, which may hold subqueries
+func (s *tableScan) call(lo, hi int, ctes map[string]bool) error {
+	if lo < hi && startsQuery(s.tokens[lo]) {
+		return s.query(lo, hi, ctes, -1, false)
+	}
+	for i := lo; i < hi; i++ {
+		if s.tokens[i].Text != "(" {
+			continue
+		}
+		end, err := s.closing(i, hi)
+		if err != nil {
+			return err
+		}
+		if s.isCall(i) {
+			err = s.call(i+1, end, ctes)
+		} else {
+			err = s.query(i+1, end, ctes, -1, false)
+		}
+		if err != nil {
+			return err
+		}
+		i = end
+	}
+	return nil
+}
+
+// updatesTable is whether the UPDATE at i names a table, not FOR UPDATE or DO UPDATE SET
+func (s *tableScan) updatesTable(i, hi int) bool {
+	if i > 0 {
+		switch s.tokens[i-1].upper() {
+		case "FOR", "KEY", "DO", "THEN":
+			return false
+		}
+	}
+	return i+1 < hi && s.tokens[i+1].upper() != "SET" && s.tokens[i+1].upper() != "OF"
+}
+
+// startsCTEs is whether the WITH at i starts a list of CTEs, not WITH ORDINALITY or WITH (NOLOCK)
+func (s *tableScan) startsCTEs(i, hi int) bool {
+	j := i + 1
+	if j < hi && s.tokens[j].upper() == "RECURSIVE" {
+		j++
+	}
+	if j+1 >= hi || s.tokens[j].ident() == "" || s.tokens[j].Kind == sqlWord && isSQLKeyword(s.tokens[j].upper()) {
+		return false
+	}
+	return s.tokens[j+1].upper() == "AS" || s.tokens[j+1].Text == "("
+}
+
+// with reads the CTEs after the WITH at i, and returns where the query they feed starts, and the names it may use
+func (s *tableScan) with(i, hi int, ctes map[string]bool) (int, map[string]bool, error) {
+	j := i + 1
+	recursive := j < hi && s.tokens[j].upper() == "RECURSIVE"
+	if recursive {
+		j++
+	}
+	scope := make(map[string]bool)
+	for name := range ctes {
+		scope[name] = true
+	}
+	for j < hi {
+		name := s.tokens[j].ident()
+		j++
+		if j < hi && s.tokens[j].Text == "(" {
+			end, err := s.closing(j, hi)
+			if err != nil {
+				return 0, nil, err
+			}
+			j = end + 1
+		}
+		if j >= hi || s.tokens[j].upper() != "AS" {
+			return 0, nil, fmt.Errorf("the CTE %s has no AS", name)
+		}
+		j++
+		for j < hi && (s.tokens[j].upper() == "NOT" || s.tokens[j].upper() == "MATERIALIZED") {
+			j++
+		}
+		if j >= hi || s.tokens[j].Text != "(" {
+			return 0, nil, fmt.Errorf("the CTE %s has no query in parentheses", name)
+		}
+		end, err := s.closing(j, hi)
+		if err != nil {
+			return 0, nil, err
+		}
+		body := scope
+		if recursive {
+			body = map[string]bool{name: true}
+			for n := range scope {
+				body[n] = true
+			}
+		}
+		if err := s.query(j+1, end, body, -1, false); err != nil {
+			return 0, nil, err
+		}
+		next := map[string]bool{name: true}
+		for n := range scope {
+			next[n] = true
+		}
+		scope = next
+		j = end + 1
+		if j < hi && s.tokens[j].Text == "," {
+			j++
+			continue
+		}
+		break
+	}
+	return j, scope, nil
+}
+
+/*
+  fromItem reads the table, subquery, function or parenthesized join
+  at i, with its alias, and returns where it ends. After INTO and
+  TABLE, parentheses are a column list, not a function's arguments.
+*/
+func (s *tableScan) fromItem(i, hi int, ctes map[string]bool, sel int, parens bool, kw string) (int, error) {
+	for i < hi && sqlNotTables[s.tokens[i].upper()] {
+		i++
+	}
+	if i >= hi {
+		return 0, fmt.Errorf("nothing follows %s", kw)
+	}
+	t := s.tokens[i]
+	switch {
+	case t.Text == "(":
+		end, err := s.closing(i, hi)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case kw == "INTO" || kw == "TABLE":
+			// INSERT INTO (cols), or RETURNS TABLE (cols)
+			return i, nil
+		case i+1 < end && startsQuery(s.tokens[i+1]):
+			err = s.query(i+1, end, ctes, -1, false)
+		case i+1 < end && s.tokens[i+1].Text == "(":
+			// ((a JOIN b ON ...)), or ((SELECT ...)), is what's in the inner parentheses
+			inner, closeErr := s.closing(i+1, end)
+			if closeErr != nil {
+				return 0, closeErr
+			}
+			if inner == end-1 {
+				_, err = s.fromItem(i+1, end, ctes, sel, true, kw)
+			} else {
+				err = s.query(i+1, end, ctes, sel, true)
+			}
+		default:
+			err = s.query(i+1, end, ctes, sel, true)
+		}
+		if err != nil {
+			return 0, err
+		}
+		_, _, next, err := s.alias(end+1, hi, true)
+		return next, err
+	case t.Kind == sqlWord || t.Kind == sqlQuotedIdent:
+		name, next := qualifiedName(s.tokens, i)
+		if next < hi && s.tokens[next].Text == "(" && kw != "INTO" && kw != "TABLE" {
+			// a function, whose rows have no table's columns
+			end, err := s.closing(next, hi)
+			if err != nil {
+				return 0, err
+			}
+			if err := s.call(next+1, end, ctes); err != nil {
+				return 0, err
+			}
+			next = end + 1
+			if next+1 < hi && s.tokens[next].upper() == "WITH" && (s.tokens[next+1].upper() == "ORDINALITY" || s.tokens[next+1].upper() == "OFFSET") {
+				next += 2
+			}
+			_, _, next, err = s.alias(next, hi, true)
+			return next, err
+		}
+		if t.Kind == sqlWord && isSQLKeyword(t.upper()) {
+			return 0, fmt.Errorf("can't tell what %s names after %s", t.Text, kw)
+		}
+		ref := tableRef{Table: name, Select: sel, Parens: parens}
+		var err error
+		ref.Alias, ref.Columns, next, err = s.alias(next, hi, kw != "INTO")
+		if err != nil {
+			return 0, err
+		}
+		if !ctes[name] {
+			s.refs = append(s.refs, ref)
+		}
+		return next, nil
+	}
+	return 0, fmt.Errorf("can't tell what %s names after %s", t.Text, kw)
+}
+
+// alias reads the alias at i, if there is one, and its column list when columns is set
+func (s *tableScan) alias(i, hi int, columns bool) (string, []string, int, error) {
+	as := i < hi && s.tokens[i].upper() == "AS"
+	if as {
+		i++
+	}
+	if i >= hi {
+		if as {
+			return "", nil, i, fmt.Errorf("nothing follows AS")
+		}
+		return "", nil, i, nil
+	}
+	t := s.tokens[i]
+	if t.Kind != sqlQuotedIdent && (t.Kind != sqlWord || isSQLKeyword(t.upper()) || notAliases[t.upper()]) {
+		if as {
+			return "", nil, i, fmt.Errorf("can't tell what alias %s is", t.Text)
+		}
+		return "", nil, i, nil
+	}
+	alias := t.ident()
+	i++
+	if !columns || i >= hi || s.tokens[i].Text != "(" {
+		return alias, nil, i, nil
+	}
+	end, err := s.closing(i, hi)
+	if err != nil {
+		return "", nil, i, err
+	}
+	// AS p (a, b), or a function's AS r (a int, b text): the first name of each
+	names := make([]string, 0)
+	first := true
+	depth := 0
+	for _, c := range s.tokens[i+1 : end] {
+		switch {
+		case c.Text == "(":
+			depth++
+		case c.Text == ")":
+			depth--
+		case c.Text == "," && depth == 0:
+			first = true
+		case first && depth == 0:
+			names = append(names, c.ident())
+			first = false
+		}
+	}
+	return alias, names, end + 1, nil
+}
+
+/*
+  resolveTables lists the tables a query touches, lower cased and in
+  order of first appearance, leaving out CTE names where they stand
+  for the CTE, and functions. It's an error when something in a FROM
+  list can't be told apart, and the checks that keep tables from
+  being read refuse the query then.
+*/
+func resolveTables(query string) ([]string, error) {
+	scan, err := scanTables(lexSQL(query))
+	seen := make(map[string]bool)
+	tables := make([]string, 0)
+	for _, ref := range scan.refs {
+		if !seen[ref.Table] {
+			seen[ref.Table] = true
+			tables = append(tables, ref.Table)
+		}
+	}
+	return tables, err
+}
+
+// referencedTables is what resolveTables could tell, for what only describes a query, eg: the audit log
+func referencedTables(query string) []string {
+	tables, _ := resolveTables(query)
+	return tables
+}
+
+// sqlOperations lists the statement kinds in a query, eg: SELECT, DELETE
+func sqlOperations(query string) []string {
+	ops := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, stmt := range splitStatements(lexSQL(query)) {
+		op := statementKind(stmt)
+		if !seen[op] {
+			seen[op] = true
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+/*
+  statementKind is the first keyword, except that WITH takes the kind
+  of the statement the CTEs feed into, since WITH ... DELETE is a delete.
+*/
+func statementKind(stmt []sqlToken) string {
+	if len(stmt) == 0 {
+		return ""
+	}
+	if stmt[0].Text == "(" {
+		return "SELECT"
+	}
+	first := stmt[0].upper()
+	if first != "WITH" {
+		return first
+	}
+	depth := 0
+	for _, t := range stmt[1:] {
+		switch t.Text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if depth == 0 {
+			switch t.upper() {
+			case "SELECT", "INSERT", "UPDATE", "DELETE", "MERGE", "VALUES", "TABLE":
+				return t.upper()
+			}
+		}
+	}
+	return "WITH"
+}
+
+var sqlKeywords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "JOIN": true, "INNER": true,
+	"LEFT": true, "RIGHT": true, "FULL": true, "OUTER": true, "CROSS": true,
+	"ON": true, "USING": true, "GROUP": true, "ORDER": true, "BY": true,
+	"HAVING": true, "LIMIT": true, "OFFSET": true, "UNION": true, "EXCEPT": true,
+	"INTERSECT": true, "AS": true, "AND": true, "OR": true, "NOT": true,
+	"NATURAL": true, "WINDOW": true, "FETCH": true, "FOR": true, "SET": true,
+	"VALUES": true, "RETURNING": true, "WITH": true, "DEFAULT": true, "TABLESAMPLE": true,
+	"IN": true, "EXISTS": true, "ANY": true, "ALL": true, "SOME": true, "ARRAY": true,
+	"LATERAL": true, "WHEN": true, "THEN": true, "ELSE": true, "CASE": true, "INSERT": true,
+	"INTO": true, "UPDATE": true, "TABLE": true, "DELETE": true, "OVER": true,
+}
+
+func isSQLKeyword(word string) bool {
+	return sqlKeywords[word]
+}
+
+var sqlAggregates = map[string]bool{
+	"count": true, "sum": true, "avg": true, "min": true, "max": true,
+	"stddev": true, "stddev_pop": true, "stddev_samp": true, "variance": true,
+	"var_pop": true, "var_samp": true, "array_agg": true, "string_agg": true,
+	"json_agg": true, "jsonb_agg": true, "bool_and": true, "bool_or": true,
+	"every": true, "percentile_cont": true, "percentile_disc": true, "mode": true,
+	"corr": true, "covar_pop": true, "covar_samp": true, "regr_slope": true,
+}
+
+/*
+  selectShape describes the outermost SELECT of a single statement:
+  where its clauses start, and whether it aggregates. Indexes are into
+  Tokens, and -1 means the clause isn't there. Tail is where anything
+  after HAVING starts (WINDOW, ORDER BY, LIMIT, ...), or len(Tokens).
+*/
+type selectShape struct {
+	Tokens    []sqlToken
+	Select    int
+	From      int
+	Where     int
+	GroupBy   int
+	Having    int
+	OrderBy   int
+	Limit     int
+	Tail      int
+	SetOp     bool
+	Aggregate bool
+}
+
+func analyzeSelect(query string) (*selectShape, error) {
+	statements := splitStatements(lexSQL(query))
+	if len(statements) != 1 {
+		return nil, fmt.Errorf("expected one statement, got %d", len(statements))
+	}
+	tokens := statements[0]
+	shape := &selectShape{
+		Tokens: tokens, Select: -1, From: -1, Where: -1, GroupBy: -1,
+		Having: -1, OrderBy: -1, Limit: -1, Tail: len(tokens),
+	}
+	depth := 0
+	for i, t := range tokens {
+		switch t.Text {
+		case "(":
+			depth++
+			continue
+		case ")":
+			depth--
+			continue
+		}
+		if depth != 0 {
+			continue
+		}
+		next := ""
+		if i+1 < len(tokens) {
+			next = tokens[i+1].upper()
+		}
+		switch t.upper() {
+		case "SELECT":
+			if shape.Select < 0 {
+				shape.Select = i
+			}
+		case "FROM":
+			if shape.Select >= 0 && shape.From < 0 {
+				shape.From = i
+			}
+		case "WHERE":
+			if shape.Select >= 0 && shape.Where < 0 {
+				shape.Where = i
+			}
+		case "GROUP":
+			if next == "BY" && shape.Select >= 0 && shape.GroupBy < 0 {
+				shape.GroupBy = i
+				shape.Aggregate = true
+			}
+		case "HAVING":
+			if shape.Select >= 0 && shape.Having < 0 {
+				shape.Having = i
+				shape.Aggregate = true
+			}
+		case "UNION", "INTERSECT", "EXCEPT":
+			if shape.Select >= 0 {
+				shape.SetOp = true
+			}
+		case "ORDER", "LIMIT", "OFFSET", "FETCH", "WINDOW", "FOR":
+			if shape.Select < 0 {
+				continue
+			}
+			if t.upper() == "ORDER" && next == "BY" && shape.OrderBy < 0 {
+				shape.OrderBy = i
+			}
+			if t.upper() == "LIMIT" && shape.Limit < 0 {
+				shape.Limit = i
+			}
+			if i < shape.Tail {
+				shape.Tail = i
+			}
+		}
+	}
+	if shape.Select < 0 {
+		return nil, fmt.Errorf("not a select statement")
+	}
+
+	// aggregate calls in the select list make the whole result one group
+	end := shape.From
+	if end < 0 {
+		end = shape.Tail
+	}
+	depth = 0
+	for i := shape.Select + 1; i < end; i++ {
+		switch tokens[i].Text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if depth == 0 && i+1 < end && tokens[i+1].Text == "(" && sqlAggregates[tokens[i].ident()] {
+			// count(*) OVER (...) is a window, not an aggregate
+			closing := matchingParen(tokens, i+1)
+			if closing+1 < len(tokens) && tokens[closing+1].upper() == "OVER" {
+				continue
+			}
+			shape.Aggregate = true
+		}
+	}
+	return shape, nil
+}
+
+// matchingParen is the index of the ) that closes the ( at i
+func matchingParen(tokens []sqlToken, i int) int {
+	depth := 0
+	for j := i; j < len(tokens); j++ {
+		switch tokens[j].Text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return len(tokens) - 1
+}
+
+// offsetAt is the byte offset of token i, or the end of the last token when i is past the end
+func (s *selectShape) offsetAt(i int) int {
+	if i < len(s.Tokens) {
+		return s.Tokens[i].Pos
+	}
+	last := s.Tokens[len(s.Tokens)-1]
+	return last.Pos + len(last.Text)
+}
+
+// quoteIdent leaves plain lower case names alone, and double quotes anything else
+func quoteIdent(name string) string {
+	plain := name != "" && !unicode.IsDigit([]rune(name)[0]) && !isReserved(strings.ToUpper(name))
+	for _, r := range name {
+		if !(r == '_' || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9')) {
+			plain = false
+		}
+	}
+	if plain {
+		return name
+	}
+	return dialect().QuoteIdentifier(name)
+}
+
+// sqlLiteral quotes a value as a string literal, which postgres will coerce as needed
+func sqlLiteral(v string) string {
+	// in mysql, bigquery and clickhouse a backslash escapes, so it is doubled too
+	if *driver == "mysql" || *driver == "bigquery" || *driver == "clickhouse" {
+		v = strings.ReplaceAll(v, `\`, `\\`)
+	}
+	return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+}
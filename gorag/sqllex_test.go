@@ -0,0 +1,171 @@
+package gorag
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// withDriver lexes as the dialect until the test ends
+func withDriver(t *testing.T, name string) {
+	t.Helper()
+	old := *driver
+	*driver = name
+	t.Cleanup(func() { *driver = old })
+}
+
+// lexed is the tokens as kind:text, with ! after the ones that are unclear
+func lexed(query string) []string {
+	kinds := map[sqlTokenKind]string{sqlWord: "w", sqlQuotedIdent: "q", sqlString: "s", sqlNumber: "n", sqlPunct: "p"}
+	out := make([]string, 0)
+	for _, t := range lexSQL(query) {
+		s := kinds[t.Kind] + ":" + t.Text
+		if t.Unclear {
+			s += "!"
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestLexSQL(t *testing.T) {
+	cases := []struct {
+		driver string
+		query  string
+		want   string
+	}{
+		// postgres
+		{"postgres", `SELECT 'it''s', "a ""b"""`, `w:SELECT s:'it''s' p:, q:"a ""b"""`},
+		{"postgres", `SELECT E'\''; DROP TABLE t; --'`, `w:SELECT s:E'\'' p:; w:DROP w:TABLE w:t p:;`},
+		{"postgres", `SELECT e'a\\', 1`, `w:SELECT s:e'a\\' p:, n:1`},
+		{"postgres", `SELECT E'it''s'`, `w:SELECT s:E'it''s'`},
+		{"postgres", `SELECT '\'`, `w:SELECT s:'\'!`},
+		{"postgres", `SELECT 'a\b'`, `w:SELECT s:'a\b'`},
+		{"postgres", `SELECT 'abc`, `w:SELECT s:'abc!`},
+		{"postgres", `SELECT "abc`, `w:SELECT q:"abc!`},
+		{"postgres", `SELECT $$a ' b$$, $x$c$x$`, `w:SELECT s:$$a ' b$$ p:, s:$x$c$x$`},
+		{"postgres", `SELECT $x$c`, `w:SELECT s:$x$c!`},
+		{"postgres", "SELECT 1 -- x ' y\n/* ' */ FROM t", `w:SELECT n:1 w:FROM w:t`},
+		{"postgres", `SELECT e FROM t WHERE e='x'`, `w:SELECT w:e w:FROM w:t w:WHERE w:e p:= s:'x'`},
+		// mysql
+		{"mysql", `SELECT 'it\'s', "a\"b", ` + "`a``b`", `w:SELECT s:'it\'s' p:, q:"a\"b" p:, q:` + "`a``b`"},
+		{"mysql", "SELECT 1 # ' x\nFROM t", `w:SELECT n:1 w:FROM w:t`},
+		{"mysql", "SELECT 1 --x", `w:SELECT n:1 p:- p:- w:x`},
+		{"mysql", "SELECT 1 /*!50000 FROM t */", `w:SELECT n:1 w:FROM w:t`},
+		{"mysql", `SELECT E'\''`, `w:SELECT w:E s:'\''`},
+		// bigquery
+		{"bigquery", `SELECT "a\"b", '''x ' y'''`, `w:SELECT s:"a\"b" p:, s:'''x ' y'''`},
+		{"bigquery", "SELECT `a\\`b`", "w:SELECT q:`a\\`b`"},
+		{"bigquery", `SELECT '''x`, `w:SELECT s:'''x!`},
+		// clickhouse
+		{"clickhouse", `SELECT "a\"b" # c`, `w:SELECT q:"a\"b"`},
+		// sqlite and sql server
+		{"sqlite", `SELECT [a]]b] FROM [t`, `w:SELECT q:[a]]b] w:FROM q:[t!`},
+		{"sqlserver", `SELECT [a b], 'it''s'`, `w:SELECT q:[a b] p:, s:'it''s'`},
+		{"snowflake", `SELECT '\'`, `w:SELECT s:'\'`},
+	}
+	for _, c := range cases {
+		withDriver(t, c.driver)
+		if got := strings.Join(lexed(c.query), " "); got != c.want {
+			t.Errorf("%s: lexSQL(%q)\n got %s\nwant %s", c.driver, c.query, got, c.want)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	withDriver(t, "postgres")
+	if n := len(splitStatements(lexSQL(`SELECT E'\''; DROP TABLE t; --'`))); n != 2 {
+		t.Errorf("the escaped quote hides a statement: got %d statements, want 2", n)
+	}
+	if n := len(splitStatements(lexSQL(`SELECT ';'; `))); n != 1 {
+		t.Errorf("a semicolon in a string: got %d statements, want 1", n)
+	}
+}
+
+func TestReferencedTables(t *testing.T) {
+	cases := []struct {
+		driver string
+		query  string
+		want   []string
+	}{
+		{"postgres", `SELECT E'\'', x FROM t`, []string{"t"}},
+		{"postgres", `SELECT * FROM a JOIN s.b ON a.id = b.id`, []string{"a", "s.b"}},
+		{"postgres", `SELECT * FROM a x, b AS y`, []string{"a", "b"}},
+		{"postgres", `WITH w AS (SELECT * FROM a) SELECT * FROM w`, []string{"a"}},
+		{"postgres", `SELECT extract(year FROM d) FROM t`, []string{"t"}},
+		{"postgres", `SELECT 'FROM x' FROM "T"`, []string{"T"}},
+		{"postgres", `TABLE people`, []string{"people"}},
+		{"postgres", `SELECT * FROM (secret JOIN x ON true)`, []string{"secret", "x"}},
+		{"postgres", `SELECT * FROM ((secret s JOIN x ON true) JOIN y ON true)`, []string{"secret", "x", "y"}},
+		{"postgres", `SELECT * FROM a, (secret JOIN b ON true)`, []string{"a", "secret", "b"}},
+		{"postgres", `SELECT * FROM (SELECT * FROM a) s JOIN (VALUES (1)) v ON true`, []string{"a"}},
+		{"postgres", `SELECT * FROM a JOIN s.b ON a.id = b.id, c`, []string{"a", "s.b", "c"}},
+
+		// a CTE's name is the CTE only after it
+		{"postgres", `WITH salaries AS (SELECT * FROM salaries) SELECT * FROM salaries`, []string{"salaries"}},
+		{"postgres", `WITH a AS (SELECT 1), b AS (SELECT * FROM a) SELECT * FROM b`, []string{}},
+		{"postgres", `WITH b AS (SELECT * FROM a), a AS (SELECT 1) SELECT * FROM b`, []string{"a"}},
+		{"postgres", `WITH RECURSIVE r(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM r) SELECT * FROM r`, []string{}},
+		{"postgres", `SELECT * FROM (WITH s AS (SELECT 1) SELECT * FROM s) x, s`, []string{"s"}},
+		{"postgres", `WITH t AS MATERIALIZED (SELECT * FROM a) SELECT * FROM t; SELECT * FROM t`, []string{"a", "t"}},
+
+		// and the FROM list goes on past whatever is in it
+		{"postgres", `SELECT * FROM generate_series(1,2) g, salaries`, []string{"salaries"}},
+		{"postgres", `SELECT * FROM unnest(array[1, 2]) WITH ORDINALITY AS u(x, n), salaries`, []string{"salaries"}},
+		{"postgres", `SELECT * FROM orders "o", salaries`, []string{"orders", "salaries"}},
+		{"postgres", `SELECT * FROM orders AS o (a,b), salaries`, []string{"orders", "salaries"}},
+		{"postgres", `SELECT * FROM (orders), salaries`, []string{"orders", "salaries"}},
+		{"postgres", `SELECT * FROM orders TABLESAMPLE SYSTEM (10), salaries`, []string{"orders", "salaries"}},
+		{"postgres", `SELECT * FROM a LEFT JOIN LATERAL (SELECT * FROM b) x ON true, c`, []string{"a", "b", "c"}},
+		{"postgres", `SELECT * FROM a WHERE x IN (SELECT y FROM b) AND EXISTS (SELECT 1 FROM c)`, []string{"a", "b", "c"}},
+		{"postgres", `SELECT (SELECT max(x) FROM b), substring(s FROM 2) FROM a`, []string{"b", "a"}},
+		{"postgres", `SELECT * FROM a JOIN b USING (id), c`, []string{"a", "b", "c"}},
+		{"postgres", `UPDATE a SET x = 1 FROM b, c WHERE a.id = b.id`, []string{"a", "b", "c"}},
+		{"postgres", `DELETE FROM a USING b, c`, []string{"a", "b", "c"}},
+		{"postgres", `INSERT INTO a (x, y) SELECT x, y FROM b ON CONFLICT (x) DO UPDATE SET y = 1`, []string{"a", "b"}},
+		{"postgres", `SELECT * FROM a FOR UPDATE OF a`, []string{"a"}},
+		{"sqlserver", `SELECT * FROM a WITH (NOLOCK), b CROSS APPLY f(b.x) y`, []string{"a", "b"}},
+		{"clickhouse", `SELECT * FROM a FINAL, b`, []string{"a", "b"}},
+		{"mysql", `SELECT 'a\' FROM x' FROM t`, []string{"t"}},
+		{"sqlite", `SELECT * FROM [My Table]`, []string{"my table"}},
+	}
+	for _, c := range cases {
+		withDriver(t, c.driver)
+		if got := referencedTables(c.query); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: referencedTables(%q) = %v, want %v", c.driver, c.query, got, c.want)
+		}
+	}
+}
+
+func TestStatementKind(t *testing.T) {
+	withDriver(t, "postgres")
+	cases := map[string]string{
+		`SELECT 1`:                           "SELECT",
+		`(SELECT 1)`:                         "SELECT",
+		`WITH d AS (SELECT 1) DELETE FROM t`: "DELETE",
+		`WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d`: "SELECT",
+		`TABLE t`: "TABLE",
+		`E'x'`:    "",
+	}
+	for query, want := range cases {
+		if got := statementKind(splitStatements(lexSQL(query))[0]); got != want {
+			t.Errorf("statementKind(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestResolveTablesUnclear(t *testing.T) {
+	withDriver(t, "postgres")
+	for _, query := range []string{
+		`SELECT * FROM 'people.csv'`,
+		`SELECT * FROM orders, `,
+		`SELECT * FROM (orders`,
+		`SELECT * FROM orders AS WHERE true`,
+		`SELECT * FROM WHERE`,
+		`WITH t (SELECT 1) SELECT * FROM t`,
+	} {
+		if _, err := resolveTables(query); err == nil {
+			t.Errorf("resolveTables(%q) could tell which tables it reads", query)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package gorag
+
+import "os"
+
+/*
+  A profile can split the work between two models: the schema and the
+  question go to sql_model, which can be a powerful hosted one, while
+  anything with result data in it (summaries, the judge, empty result
+  diagnosis) only goes to data_model, which can run locally:
+
+    "profiles": {
+      "sales": {
+        "dsn": "...",
+        "sql_model": {"model": "gpt-4o"},
+        "data_model": {"url": "http://localhost:11434/v1", "model": "llama3.1"}
+      }
+    }
+
+  An endpoint without a url is -llm-url with OPENAI_API_KEY. One with a
+  url sends no key unless api_key_env names the variable that has it.
+  With a data model, sample rows stay out of the sql prompt.
+
+  -model is the model of both when the profile doesn't name one, in
+  place of the provider's default. Queries are written at -temperature,
+  0 so the same question gets the same query, and summaries at
+  -data-temperature, 0.7 so they read less like a form, or 0 with
+  -llm-cache-ttl, so they can be cached.
+*/
+var modelFlag = Flags.String("model", "", "the chat model, instead of the -llm provider's default, eg: gpt-4o-mini")
+var sqlTemperature = Flags.Float64("temperature", 0, "the temperature queries are written at")
+var dataTemperature = Flags.Float64("data-temperature", -1, "the temperature summaries and other answers about data are written at; 0.7 by default, or 0 with -llm-cache-ttl")
+var maxTokens = Flags.Int("max-tokens", 0, "the most tokens a reply may have; 0 leaves it to the provider")
+var topP = Flags.Float64("top-p", 0, "only sample from the likeliest tokens that make up this much probability, eg: 0.9; 0 leaves it to the provider")
+
+// stageTemperature is what calls for the stage ask for
+func stageTemperature(stage string) float64 {
+	if stage != "data" {
+		return *sqlTemperature
+	}
+	if *dataTemperature >= 0 {
+		return *dataTemperature
+	}
+	if *llmCacheTTL > 0 {
+		return 0
+	}
+	return 0.7
+}
+
+func (c *Client) target(stage, model string) modelTarget {
+	ep := c.SQLModel
+	if stage == "data" && c.DataModel != nil {
+		ep = c.DataModel
+	}
+	t := modelTarget{CompletionOptions: defaultOptions(c.APIKey), ctx: c.ctx}
+	t.Temperature, t.MaxTokens, t.TopP = stageTemperature(stage), *maxTokens, *topP
+	if *modelFlag != "" {
+		t.Model = *modelFlag
+	}
+	if ep != nil {
+		if ep.URL != "" {
+			t.URL, t.APIKey = ep.URL, ""
+		}
+		if ep.APIKeyEnv != "" {
+			t.APIKey = os.Getenv(ep.APIKeyEnv)
+		}
+		if ep.Model != "" {
+			t.Model = ep.Model
+		}
+	}
+	if stage == "sql" && c.route != nil && c.route.Model != "" {
+		t.Model = c.route.Model
+	}
+	// the judge is named by -judge, on whichever endpoint sees the data
+	if model != "" {
+		t.Model = model
+	}
+	if c.Trace != nil {
+		t.TraceParent, t.TraceState = c.Trace.child(), c.Trace.State
+	}
+	return t
+}
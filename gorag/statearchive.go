@@ -0,0 +1,246 @@
+package gorag
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+  gorag export-state puts a deployment in one archive: the config
+  (profiles, deny rules, saved questions, api key hashes, tables,
+  purposes, glossary, examples, budgets) and the extra metadata files
+  the profiles point at, and with -embeddings the embeddings cache.
+  gorag import-state unpacks it somewhere else. Profiles keep their
+  DSNs, so the archive holds credentials just as the config does, and
+  is written readable only by its owner. Schemas aren't in it; they
+  are introspected again where it is imported.
+*/
+type stateManifest struct {
+	Version    int       `json:"version"`
+	Created    time.Time `json:"created"`
+	Files      []string  `json:"files"` // metadata files, as the config names them
+	Embeddings bool      `json:"embeddings"`
+}
+
+// metadataFiles are the extra metadata files the deployment uses, that exist
+func metadataFiles(config *Config) []string {
+	names := map[string]bool{"metadata.json": true}
+	for _, p := range config.Profiles {
+		if p.Metadata != "" {
+			names[p.Metadata] = true
+		}
+	}
+	out := make([]string, 0, len(names))
+	for name := range names {
+		if _, err := os.Stat(name); err == nil {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// archiveName is where a file goes in the archive; absolute paths keep their place under abs/
+func archiveName(name string) string {
+	if filepath.IsAbs(name) {
+		return "files/abs/" + strings.TrimLeft(filepath.ToSlash(name), "/")
+	}
+	return "files/rel/" + filepath.ToSlash(filepath.Clean(name))
+}
+
+func addToTar(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func exportState(config *Config, w io.Writer, embeddings string) error {
+	config.mu.RLock()
+	configData, err := json.MarshalIndent(config, "", "  ")
+	files := metadataFiles(config)
+	config.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifest := stateManifest{Version: 1, Created: time.Now().UTC(), Files: files, Embeddings: embeddings != ""}
+	m, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := addToTar(tw, "manifest.json", m); err != nil {
+		return err
+	}
+	if err := addToTar(tw, "config.json", configData); err != nil {
+		return err
+	}
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := addToTar(tw, archiveName(name), data); err != nil {
+			return err
+		}
+	}
+	if embeddings != "" {
+		data, err := os.ReadFile(embeddings)
+		if err != nil {
+			return fmt.Errorf("failed to read the embeddings cache: %v", err)
+		}
+		if err := addToTar(tw, "embeddings.json", data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+/*
+  importState writes what the archive holds: the config and relative
+  metadata files under dir (an absolute path stays where it was), and
+  the embeddings to embeddings. Nothing that exists is overwritten
+  without force, and nothing is written at all if any of it would be.
+*/
+func importState(r io.Reader, configFile, dir, embeddings string, force bool) ([]string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a gorag state archive: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	contents := make(map[string][]byte)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		contents[h.Name] = data
+	}
+	var manifest stateManifest
+	if err := json.Unmarshal(contents["manifest.json"], &manifest); err != nil {
+		return nil, fmt.Errorf("not a gorag state archive: no manifest")
+	}
+	if manifest.Version != 1 {
+		return nil, fmt.Errorf("state archive version %d is newer than this gorag", manifest.Version)
+	}
+	if _, ok := contents["config.json"]; !ok {
+		return nil, fmt.Errorf("the state archive has no config")
+	}
+
+	// where each thing goes, checked before anything is written
+	if !filepath.IsAbs(configFile) {
+		configFile = filepath.Join(dir, configFile)
+	}
+	targets := map[string]string{configFile: "config.json"}
+	for _, name := range manifest.Files {
+		to := name
+		if !filepath.IsAbs(name) {
+			clean := filepath.Clean(name)
+			if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+				return nil, fmt.Errorf("the state archive names a file outside its directory: %s", name)
+			}
+			to = filepath.Join(dir, clean)
+		}
+		if _, ok := contents[archiveName(name)]; !ok {
+			return nil, fmt.Errorf("the state archive is missing %s", name)
+		}
+		targets[to] = archiveName(name)
+	}
+	if manifest.Embeddings {
+		if embeddings == "" {
+			embeddings = filepath.Join(dir, "embeddings.json")
+		}
+		targets[embeddings] = "embeddings.json"
+	}
+	written := make([]string, 0, len(targets))
+	for to := range targets {
+		if _, err := os.Stat(to); err == nil && !force {
+			return nil, fmt.Errorf("%s exists; use -force to overwrite it", to)
+		}
+		written = append(written, to)
+	}
+	sort.Strings(written)
+	for _, to := range written {
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(to, contents[targets[to]], 0600); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %v", to, err)
+		}
+	}
+	return written, nil
+}
+
+func runExportState(args []string) {
+	fs := commandFlags("export-state")
+	out := fs.String("out", "gorag-state.tar.gz", "the archive to write")
+	withEmbeddings := fs.Bool("embeddings", false, "include the -embeddings-cache file")
+	fs.Parse(args)
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	embeddings := ""
+	if *withEmbeddings {
+		if *embeddingsCache == "" {
+			log.Fatalf("-embeddings needs -embeddings-cache, which is where they are kept")
+		}
+		embeddings = *embeddingsCache
+	}
+	f, err := os.OpenFile(*out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", *out, err)
+	}
+	if err := exportState(config, f, embeddings); err != nil {
+		f.Close()
+		log.Fatalf("Failed to export state: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Failed to export state: %v", err)
+	}
+	log.Printf("Wrote %s; it holds the profiles' DSNs, keep it like the config", *out)
+}
+
+func runImportState(args []string) {
+	fs := commandFlags("import-state")
+	dir := fs.String("dir", ".", "where the config and relative metadata files go")
+	force := fs.Bool("force", false, "overwrite files that exist")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("usage: gorag import-state [-dir .] [-force] gorag-state.tar.gz")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer f.Close()
+	written, err := importState(f, *configFile, *dir, *embeddingsCache, *force)
+	if err != nil {
+		log.Fatalf("Failed to import state: %v", err)
+	}
+	for _, name := range written {
+		log.Printf("Wrote %s", name)
+	}
+}
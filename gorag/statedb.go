@@ -0,0 +1,256 @@
+package gorag
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+  gorag keeps its own tables in the gorag schema of the state database
+  (-state-dsn, or the database it answers from). Their DDL ships in the
+  binary as numbered migrations, statedb/NNNN_name.sql, and gorag db
+  migrate applies the ones a database doesn't have yet, each in its own
+  transaction along with its row in gorag.schema_migrations, under an
+  advisory lock so two upgrades at once take turns. Migrations only go
+  forward: a released one is never edited, and a change is a new one.
+  gorag db status says which a database has, and whether any applied
+  migration differs from the one in this binary.
+*/
+var stateDSN = Flags.String("state-dsn", os.Getenv("GORAG_STATE_DSN"), "database for gorag's own tables; empty uses the one it answers from")
+
+//go:embed statedb/*.sql
+var stateMigrationFiles embed.FS
+
+// an arbitrary key for pg_advisory_lock, so concurrent migrations wait for each other
+const stateMigrationLock = 7156236
+
+type stateMigration struct {
+	Version  int
+	Name     string
+	SQL      string
+	Checksum string
+}
+
+func stateMigrations() ([]stateMigration, error) {
+	entries, err := stateMigrationFiles.ReadDir("statedb")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]stateMigration, 0, len(entries))
+	for _, e := range entries {
+		num, name, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s is not named NNNN_name.sql", e.Name())
+		}
+		data, err := stateMigrationFiles.ReadFile(path.Join("statedb", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		out = append(out, stateMigration{Version: version, Name: name, SQL: string(data), Checksum: hex.EncodeToString(sum[:])})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	for i := 1; i < len(out); i++ {
+		if out[i].Version == out[i-1].Version {
+			return nil, fmt.Errorf("two migrations are numbered %d", out[i].Version)
+		}
+	}
+	return out, nil
+}
+
+type appliedMigration struct {
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// both *sql.DB and the *sql.Conn holding the lock
+type contextQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func appliedMigrations(ctx context.Context, db contextQueryer) (map[int]appliedMigration, error) {
+	applied := make(map[int]appliedMigration)
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT to_regclass('gorag.schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to look for gorag.schema_migrations: %v", err)
+	}
+	if !exists {
+		return applied, nil
+	}
+	rows, err := db.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM gorag.schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gorag.schema_migrations: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var m appliedMigration
+		if err := rows.Scan(&version, &m.Name, &m.Checksum, &m.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = m
+	}
+	return applied, rows.Err()
+}
+
+// migrateState applies what the database is missing, up to the version to (0 for all)
+func migrateState(db *sql.DB, to int) (int, error) {
+	migrations, err := stateMigrations()
+	if err != nil {
+		return 0, err
+	}
+	// the lock is held by a session, so everything here uses the one connection
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", stateMigrationLock); err != nil {
+		return 0, fmt.Errorf("failed to lock for migrating: %v", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", stateMigrationLock)
+
+	_, err = conn.ExecContext(ctx, `CREATE SCHEMA IF NOT EXISTS gorag;
+		CREATE TABLE IF NOT EXISTS gorag.schema_migrations (
+			version integer PRIMARY KEY,
+			name text NOT NULL,
+			checksum text NOT NULL,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create gorag.schema_migrations: %v", err)
+	}
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, m := range migrations {
+		if to > 0 && m.Version > to {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return n, err
+		}
+		if _, err := tx.Exec(m.SQL); err != nil {
+			tx.Rollback()
+			return n, fmt.Errorf("failed to apply migration %04d_%s: %v", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec("INSERT INTO gorag.schema_migrations (version, name, checksum) VALUES ($1, $2, $3)", m.Version, m.Name, m.Checksum); err != nil {
+			tx.Rollback()
+			return n, err
+		}
+		if err := tx.Commit(); err != nil {
+			return n, fmt.Errorf("failed to apply migration %04d_%s: %v", m.Version, m.Name, err)
+		}
+		log.Printf("Applied %04d_%s", m.Version, m.Name)
+		n++
+	}
+	return n, nil
+}
+
+func stateStatus(db *sql.DB) (string, error) {
+	migrations, err := stateMigrations()
+	if err != nil {
+		return "", err
+	}
+	applied, err := appliedMigrations(context.Background(), db)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	known := make(map[int]bool)
+	pending := 0
+	for _, m := range migrations {
+		known[m.Version] = true
+		a, ok := applied[m.Version]
+		switch {
+		case !ok:
+			sb.WriteString(fmt.Sprintf("%04d_%-30s pending\n", m.Version, m.Name))
+			pending++
+		case a.Checksum != m.Checksum:
+			sb.WriteString(fmt.Sprintf("%04d_%-30s applied %s, but differs from this version of gorag\n", m.Version, m.Name, a.AppliedAt.Format(time.RFC3339)))
+		default:
+			sb.WriteString(fmt.Sprintf("%04d_%-30s applied %s\n", m.Version, m.Name, a.AppliedAt.Format(time.RFC3339)))
+		}
+	}
+	versions := make([]int, 0)
+	for v := range applied {
+		if !known[v] {
+			versions = append(versions, v)
+		}
+	}
+	sort.Ints(versions)
+	for _, v := range versions {
+		sb.WriteString(fmt.Sprintf("%04d_%-30s applied by a newer gorag\n", v, applied[v].Name))
+	}
+	sb.WriteString(fmt.Sprintf("%d pending\n", pending))
+	return sb.String(), nil
+}
+
+func runDB(args []string) {
+	if len(args) == 0 || args[0] != "migrate" && args[0] != "status" && args[0] != "load-audit" {
+		log.Fatalf("usage: gorag db migrate [-to N] | gorag db status | gorag db load-audit -audit-log FILE")
+	}
+	fs := commandFlags("db " + args[0])
+	to := fs.Int("to", 0, "only migrate up to this version")
+	fs.Parse(args[1:])
+	if err := requirePostgres("gorag db"); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	enforceNoExternalCalls()
+	dsn := *stateDSN
+	if dsn == "" {
+		dsn = dsnFromFlags()
+	}
+	db, err := Connect(dsn)
+	if err != nil {
+		log.Fatalf("Failed to connect to the state database: %v", err)
+	}
+	defer db.Close()
+
+	if args[0] == "load-audit" {
+		if *auditLog == "" {
+			log.Fatalf("load-audit needs -audit-log")
+		}
+		n, err := loadAuditEvents(db, *auditLog)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Printf("Loaded %d audit events", n)
+		return
+	}
+	if args[0] == "status" {
+		status, err := stateStatus(db)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Print(status)
+		return
+	}
+	n, err := migrateState(db, *to)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Printf("Applied %d migrations", n)
+}
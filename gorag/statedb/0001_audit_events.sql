@@ -0,0 +1,26 @@
+-- The audit log, queryable: one row per event, as the json lines have them.
+CREATE TABLE gorag.audit_events (
+    id bigserial PRIMARY KEY,
+    time timestamptz NOT NULL,
+    event text NOT NULL,
+    run_id text,
+    "user" text,
+    profile text,
+    prompt text,
+    purpose text,
+    query text,
+    tables text[],
+    tags text[],
+    reason text,
+    error text,
+    duration_ms bigint,
+    trace_id text,
+    model text,
+    provider text,
+    prompt_tokens integer,
+    completion_tokens integer,
+    cost_usd double precision
+);
+
+CREATE INDEX audit_events_time ON gorag.audit_events (time);
+CREATE INDEX audit_events_run_id ON gorag.audit_events (run_id);
@@ -0,0 +1,4 @@
+-- Which examples each question's prompt had, and feedback on answers.
+ALTER TABLE gorag.audit_events
+    ADD COLUMN examples text[],
+    ADD COLUMN helpful boolean;
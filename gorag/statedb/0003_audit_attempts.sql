@@ -0,0 +1,7 @@
+-- Where a question failed, and how often its query was written and run.
+ALTER TABLE gorag.audit_events
+    ADD COLUMN stage text,
+    ADD COLUMN generations integer,
+    ADD COLUMN failed_generations integer,
+    ADD COLUMN executions integer,
+    ADD COLUMN failed_executions integer;
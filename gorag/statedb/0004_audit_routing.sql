@@ -0,0 +1,4 @@
+-- Which model a question was routed to by its difficulty.
+ALTER TABLE gorag.audit_events
+    ADD COLUMN route text,
+    ADD COLUMN difficulty double precision;
@@ -0,0 +1,3 @@
+-- The query an OPA policy ran instead of the generated one.
+ALTER TABLE gorag.audit_events
+    ADD COLUMN rewrite text;
@@ -0,0 +1,105 @@
+package gorag
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+  Column statistics tell the model what is actually in the data, so it
+  doesn't filter on years with no rows. They come from pg_stats, which
+  ANALYZE keeps up to date, so collecting them reads no tables. The
+  range is from the histogram bounds, which leave out the most common
+  values, so it is approximate, and a table that was never analyzed
+  has no stats at all.
+*/
+type ColumnStats struct {
+	Type     string  `json:"type"`
+	Min      string  `json:"min,omitempty"`
+	Max      string  `json:"max,omitempty"`
+	Distinct float64 `json:"distinct,omitempty"` // approximate
+}
+
+// rangeTypes are the types where min and max mean something to a question
+var rangeTypes = []string{"smallint", "integer", "bigint", "numeric", "real", "double precision", "date", "timestamp", "money"}
+
+func isRangeType(t string) bool {
+	for _, r := range rangeTypes {
+		if strings.HasPrefix(t, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// getColumnStats is keyed by table.column
+func getColumnStats(db *sql.DB) (map[string]*ColumnStats, error) {
+	rows, err := db.Query(`
+		SELECT s.tablename, s.attname, c.data_type, s.n_distinct, GREATEST(t.reltuples, 0),
+			(s.histogram_bounds::text::text[])[1],
+			(s.histogram_bounds::text::text[])[array_length(s.histogram_bounds::text::text[], 1)]
+		FROM pg_stats s
+		JOIN information_schema.columns c
+			ON c.table_schema = s.schemaname AND c.table_name = s.tablename AND c.column_name = s.attname
+		JOIN pg_class t ON t.relname = s.tablename
+		JOIN pg_namespace n ON n.oid = t.relnamespace AND n.nspname = s.schemaname
+		WHERE s.schemaname = 'public'
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := make(map[string]*ColumnStats)
+	for rows.Next() {
+		var table, column, dataType string
+		var nDistinct, tuples float64
+		var lo, hi sql.NullString
+		if err := rows.Scan(&table, &column, &dataType, &nDistinct, &tuples, &lo, &hi); err != nil {
+			return nil, err
+		}
+		s := &ColumnStats{Type: dataType, Distinct: nDistinct}
+		// negative n_distinct is a fraction of the rows
+		if nDistinct < 0 {
+			s.Distinct = -nDistinct * tuples
+		}
+		if isRangeType(dataType) {
+			s.Min, s.Max = lo.String, hi.String
+		}
+		stats[table+"."+column] = s
+	}
+	return stats, rows.Err()
+}
+
+// statsPrompt has ranges for numbers and dates, and counts for columns with few values
+func statsPrompt(schema *DBMetadata) string {
+	keys := make([]string, 0)
+	for key, s := range schema.Stats {
+		table, _, _ := strings.Cut(key, ".")
+		if _, ok := schema.Tables[table]; !ok {
+			continue
+		}
+		if s.Min != "" || (s.Distinct > 0 && s.Distinct <= 50) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString("\nWhat the data holds, approximately (from the planner's statistics):\n\n")
+	for _, key := range keys {
+		s := schema.Stats[key]
+		parts := make([]string, 0, 2)
+		if s.Min != "" {
+			parts = append(parts, fmt.Sprintf("from %s to %s", s.Min, s.Max))
+		}
+		if s.Distinct > 0 {
+			parts = append(parts, fmt.Sprintf("%.0f distinct values", s.Distinct))
+		}
+		sb.WriteString(fmt.Sprintf("%s (%s): %s\n", key, s.Type, strings.Join(parts, ", ")))
+	}
+	return sb.String()
+}
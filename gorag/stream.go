@@ -0,0 +1,192 @@
+package gorag
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+/*
+  A summary takes seconds to write, and nothing shows until the last
+  word of it. A Question with OnToken is told the summary a piece at
+  a time instead, as the model writes it, with the answer so far (the
+  query and rows) so they can be shown first: -stream prints it that
+  way, and the server sends it as server-sent events to a request
+  with "stream": true or Accept: text/event-stream. The openai and
+  ollama providers stream the reply; the others give it in one piece.
+  A summary with -judge-model isn't streamed, since it isn't shown
+  before the judge has read it, and nor is one with -anonymize, since
+  the pseudonyms are only put back once it is whole.
+*/
+var streamFlag = Flags.Bool("stream", true, "print the summary as the model writes it")
+
+type openaiStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openaiChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// openaiStream sends the request with stream set, telling opts.OnToken each piece of the reply
+func openaiStream(ctx context.Context, opts CompletionOptions, request OpenAIRequest) (string, error) {
+	request.Stream = true
+	request.StreamOptions = &openaiStreamOptions{IncludeUsage: true}
+	resp, err := openCompletion(ctx, opts, request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		var e openaiChunk
+		if json.Unmarshal(body, &e) == nil && e.Error != nil {
+			return "", fmt.Errorf("no response from OpenAI: %s", e.Error.Message)
+		}
+		return "", fmt.Errorf("no response from OpenAI: %s", resp.Status)
+	}
+	var sb strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		data = strings.TrimSpace(data)
+		if !ok || data == "" {
+			continue
+		}
+		if data == "[DONE]" {
+			break
+		}
+		var chunk openaiChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return "", fmt.Errorf("failed to read the streamed reply: %v", err)
+		}
+		if chunk.Error != nil {
+			return "", fmt.Errorf("no response from OpenAI: %s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil && opts.OnUsage != nil {
+			opts.OnUsage(chunk.Usage.PromptTokens, chunk.Usage.CompletionTokens)
+		}
+		for _, c := range chunk.Choices {
+			if c.Delta.Content != "" {
+				sb.WriteString(c.Delta.Content)
+				opts.OnToken(c.Delta.Content)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read the streamed reply: %v", err)
+	}
+	return sb.String(), nil
+}
+
+// ollamaStream sends a chat request with stream set, telling opts.OnToken each piece of the reply
+func ollamaStream(ctx context.Context, opts CompletionOptions, request ollamaChatRequest) (string, error) {
+	request.Stream = true
+	resp, err := ollamaOpen(ctx, opts, "/api/chat", request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var sb strings.Builder
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk ollamaChatResponse
+		if err := decoder.Decode(&chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("failed to read the streamed reply: %v", err)
+		}
+		if chunk.Error != "" {
+			return "", fmt.Errorf("ollama: %s", chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			sb.WriteString(chunk.Message.Content)
+			opts.OnToken(chunk.Message.Content)
+		}
+		if chunk.Done {
+			if opts.OnUsage != nil {
+				opts.OnUsage(chunk.PromptEvalCount, chunk.EvalCount)
+			}
+			break
+		}
+	}
+	return sb.String(), nil
+}
+
+// summaryStream is where the summary goes as it is written, if anywhere
+func (c *Client) summaryStream() func(string) {
+	if c.JudgeModel != "" || c.Pseudonyms != nil {
+		return nil
+	}
+	return c.streamTo
+}
+
+// eventStream writes server-sent events, starting the response with the first one
+type eventStream struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (s *eventStream) send(event string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(err.Error())
+		event = "error"
+	}
+	if !s.started {
+		s.started = true
+		s.w.Header().Set("Content-Type", "text/event-stream")
+		s.w.Header().Set("Cache-Control", "no-cache")
+		s.w.WriteHeader(http.StatusOK)
+	}
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data)
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// wantsStream is whether the request asked for its answer as server-sent events
+func wantsStream(r *http.Request, req askRequest) bool {
+	return req.Stream || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+/*
+  streamAsk answers as server-sent events: result, with the query and
+  rows, when the summary starts; token, with each piece of it; and
+  answer, with all of it, as /ask has it. A request refused before any
+  of that gets the usual error instead.
+*/
+func (s *Server) streamAsk(w http.ResponseWriter, r *http.Request, key *APIKey, req askRequest) {
+	events := &eventStream{w: w}
+	answer, status, err := s.ask(r, key, req, func(a *Answer, text string) {
+		if !events.started {
+			events.send("result", newAskResponse(a, nil))
+		}
+		events.send("token", text)
+	})
+	if answer == nil {
+		if !events.started {
+			writeError(w, status, err)
+		} else {
+			events.send("error", err.Error())
+		}
+		return
+	}
+	events.send("answer", newAskResponse(answer, err))
+}
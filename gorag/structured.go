@@ -0,0 +1,110 @@
+package gorag
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+/*
+  Asked for json, models like to fence it in markdown, or say a few
+  words first. Where the server can hold the reply to a schema, the
+  query prompt's {"query": "..."} is asked for with -response-format:
+  json_schema gives OpenAI the schema as a strict response_format, and
+  Ollama the schema as its format; json_object only asks for json.
+  A server that refuses a response format is asked again without one,
+  and not asked with one again. Replies that weren't held to anything,
+  from those servers and from Bedrock, are still looked through for
+  the json in them.
+*/
+var responseFormat = Flags.String("response-format", "json_schema", "hold query replies to their json: json_schema, json_object, or none")
+
+// ResponseSchema is the JSON schema a reply must match, for providers that can hold it to one
+type ResponseSchema struct {
+	Name   string
+	Schema map[string]interface{}
+}
+
+var querySchema = &ResponseSchema{
+	Name: "sql_query",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"query": map[string]interface{}{"type": "string", "description": "the SQL query"},
+		},
+		"required":             []string{"query"},
+		"additionalProperties": false,
+	},
+}
+
+// refusedFormat is the servers and models that said no to a response format
+var refusedFormat sync.Map
+
+func formatKey(opts CompletionOptions) string {
+	return opts.URL + "\x00" + opts.Model
+}
+
+// wantsFormat is the -response-format to ask for, or "" when there is none to ask for
+func wantsFormat(opts CompletionOptions) string {
+	if opts.Schema == nil || *responseFormat == "none" {
+		return ""
+	}
+	if _, refused := refusedFormat.Load(formatKey(opts)); refused {
+		return ""
+	}
+	return *responseFormat
+}
+
+// refuseFormat remembers that the server won't take a response format, when err says so
+func refuseFormat(opts CompletionOptions, err string) bool {
+	lower := strings.ToLower(err)
+	if !strings.Contains(lower, "response_format") && !strings.Contains(lower, "json_schema") && !strings.Contains(lower, "format") {
+		return false
+	}
+	refusedFormat.Store(formatKey(opts), true)
+	log.Printf("%s won't hold replies to a format (%s); asking without one", opts.URL, err)
+	return true
+}
+
+// openaiResponseFormat is the response_format for the request
+func openaiResponseFormat(opts CompletionOptions) interface{} {
+	switch wantsFormat(opts) {
+	case "json_schema":
+		return map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   opts.Schema.Name,
+				"strict": true,
+				"schema": opts.Schema.Schema,
+			},
+		}
+	case "json_object":
+		return map[string]string{"type": "json_object"}
+	}
+	return nil
+}
+
+// ollamaFormat is Ollama's format for the request
+func ollamaFormat(opts CompletionOptions) interface{} {
+	switch wantsFormat(opts) {
+	case "json_schema":
+		return opts.Schema.Schema
+	case "json_object":
+		return "json"
+	}
+	return nil
+}
+
+// parseJSONReply reads the reply into out, looking for the json in it only when the reply isn't just json
+func parseJSONReply(reply string, out interface{}) error {
+	if err := json.Unmarshal([]byte(strings.TrimSpace(reply)), out); err == nil {
+		return nil
+	}
+	found := findJson(reply)
+	if err := json.Unmarshal([]byte(found), out); err != nil {
+		return fmt.Errorf("failed to parse JSON response: %v\n%s", err, found)
+	}
+	return nil
+}
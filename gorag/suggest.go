@@ -0,0 +1,119 @@
+package gorag
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+  /suggest?q=prefix is for type-ahead in a UI. Candidates come from
+  the glossary, the saved questions, and the questions people have
+  actually asked successfully (from the audit log, by popularity).
+  Matching is on the start of the text or the start of any word.
+*/
+type Suggestion struct {
+	Text   string `json:"text"`
+	Source string `json:"source"`
+	Detail string `json:"detail,omitempty"`
+	score  float64
+}
+
+// popularQuestions counts successful asks in the audit log, re-reading it only when it changes
+type popularQuestions struct {
+	mu       sync.Mutex
+	filename string
+	modTime  time.Time
+	counts   map[string]int
+}
+
+func (p *popularQuestions) get() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.filename == "" {
+		return nil
+	}
+	info, err := os.Stat(p.filename)
+	if err != nil || (p.counts != nil && !info.ModTime().After(p.modTime)) {
+		return p.counts
+	}
+	counts := make(map[string]int)
+	err = readAuditLog(p.filename, func(e AuditEvent) {
+		if e.Event == "ask" && e.Error == "" && e.Prompt != "" {
+			counts[strings.TrimSpace(e.Prompt)]++
+		}
+	})
+	if err != nil {
+		return p.counts
+	}
+	p.counts = counts
+	p.modTime = info.ModTime()
+	return counts
+}
+
+// matchScore is 2 for a prefix match, 1 for a word prefix match, and 0 otherwise
+func matchScore(text, q string) float64 {
+	lower := strings.ToLower(text)
+	switch {
+	case q == "" || strings.HasPrefix(lower, q):
+		return 2
+	case strings.Contains(lower, " "+q):
+		return 1
+	}
+	return 0
+}
+
+func (s *Server) suggestions(q string, limit int) []Suggestion {
+	q = strings.ToLower(strings.TrimSpace(q))
+	out := make([]Suggestion, 0)
+	seen := make(map[string]bool)
+	add := func(sug Suggestion, weight float64) {
+		m := matchScore(sug.Text, q)
+		key := strings.ToLower(sug.Text)
+		if m == 0 || seen[key] {
+			return
+		}
+		seen[key] = true
+		sug.score = m * weight
+		out = append(out, sug)
+	}
+
+	s.Config.mu.RLock()
+	for _, sq := range s.Config.SavedQuestions {
+		add(Suggestion{Text: sq.Prompt, Source: "saved", Detail: sq.Name}, 10)
+	}
+	for term, definition := range s.Config.Glossary {
+		add(Suggestion{Text: term, Source: "glossary", Detail: definition}, 5)
+	}
+	s.Config.mu.RUnlock()
+	for prompt, n := range s.popular.get() {
+		add(Suggestion{Text: prompt, Source: "popular", Detail: strconv.Itoa(n) + " asks"}, 1+float64(n)/10)
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].score != out[j].score {
+			return out[i].score > out[j].score
+		}
+		return out[i].Text < out[j].Text
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func (s *Server) handleSuggest(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.authenticate(r); err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	limit := 10
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+	writeJSON(w, http.StatusOK, s.suggestions(r.URL.Query().Get("q"), limit))
+}
@@ -0,0 +1,139 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+)
+
+/*
+  Some tables share a schema with the ones people ask about but are
+  no business of generated SQL: salaries, personal data. The config
+  can list the tables it may touch and the ones it never may, by name
+  or a glob, eg: "hr.*" or "*_pii":
+
+    "allowed_tables": ["orders", "customers", "products"],
+    "denied_tables": ["salaries", "*_pii"]
+
+  A profile can have its own; its allowed_tables replace the config's,
+  and its denied_tables add to them. Denied wins over allowed, and with
+  an allow list, a table not on it is refused, catalogs included. The
+  tables a model may not use are left out of the schema it is shown,
+  and a query that names one anyway, by the tables it reads rather
+  than the words it has, is written again with why, up to -retries
+  times, then refused. Validate refuses them too, so a query from
+  anywhere else can't get to them either. A query whose FROM list
+  can't all be told apart is refused while there are lists at all,
+  since what can't be read can't be checked. Deny rules still match
+  on words, for anything the parse misses.
+*/
+
+// tableLists are the allowed and denied table patterns for the client's profile
+func (c *Client) tableLists() (allowed, denied []string) {
+	// the lists are of the database's tables, not gorag's own
+	if c.Config == nil || *usageFlag {
+		return nil, nil
+	}
+	c.Config.mu.RLock()
+	defer c.Config.mu.RUnlock()
+	allowed, denied = c.Config.AllowedTables, append([]string{}, c.Config.DeniedTables...)
+	if p, ok := c.Config.Profiles[c.Profile]; ok && c.Profile != "" {
+		if len(p.AllowedTables) > 0 {
+			allowed = p.AllowedTables
+		}
+		denied = append(denied, p.DeniedTables...)
+	}
+	return allowed, denied
+}
+
+// tableMatches is whether a pattern names the table; one without a schema matches it in any schema
+func tableMatches(patterns []string, table string) bool {
+	table = strings.ToLower(table)
+	_, bare, qualified := strings.Cut(table, ".")
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if ok, _ := path.Match(p, table); ok {
+			return true
+		}
+		if qualified && !strings.Contains(p, ".") {
+			if ok, _ := path.Match(p, bare); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tableRefusal is why generated SQL may not touch the table, or ""
+func tableRefusal(allowed, denied []string, table string) string {
+	switch {
+	case tableMatches(denied, table):
+		return fmt.Sprintf("table %s is denied", table)
+	case len(allowed) > 0 && !tableMatches(allowed, table):
+		return fmt.Sprintf("table %s isn't allowed", table)
+	}
+	return ""
+}
+
+// refusedTables are why the query may not run, for each table it reads that it may not
+func (c *Client) refusedTables(query string) []string {
+	allowed, denied := c.tableLists()
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil
+	}
+	refused := make([]string, 0)
+	tables, err := resolveTables(query)
+	if err != nil {
+		refused = append(refused, err.Error())
+	}
+	for _, table := range tables {
+		if why := tableRefusal(allowed, denied, table); why != "" {
+			refused = append(refused, why)
+		}
+	}
+	return refused
+}
+
+func (c *Client) checkTableAccess(query string) error {
+	if refused := c.refusedTables(query); len(refused) > 0 {
+		return fmt.Errorf("query refused, %s", strings.Join(refused, "; "))
+	}
+	return nil
+}
+
+// accessibleSchema is the schema without the tables generated SQL may not touch
+func (c *Client) accessibleSchema(s *DBMetadata) *DBMetadata {
+	allowed, denied := c.tableLists()
+	if s == nil || (len(allowed) == 0 && len(denied) == 0) {
+		return s
+	}
+	kept := make([]string, 0, len(s.Tables))
+	for table := range s.Tables {
+		if tableRefusal(allowed, denied, table) == "" {
+			kept = append(kept, table)
+		}
+	}
+	if len(kept) == len(s.Tables) {
+		return s
+	}
+	sort.Strings(kept)
+	return s.subset(kept)
+}
+
+// checkGeneratedTables asks for the query again when it reads tables it may not
+func (c *Client) checkGeneratedTables(r *askRun, query string) error {
+	refused := c.refusedTables(query)
+	if len(refused) == 0 {
+		return nil
+	}
+	if r.attempts >= c.Retries {
+		return fmt.Errorf("query refused, %s", strings.Join(refused, "; "))
+	}
+	r.attempts++
+	log.Printf("The query reads tables it may not, since %s; generating it again", strings.Join(refused, "; "))
+	r.feedback = fmt.Sprintf("\nA previous attempt at this request was\n\n%s\n\nwhich was refused because %s. Use only the tables in the schema above.\n",
+		query, strings.Join(refused, "; "))
+	return errRegenerate
+}
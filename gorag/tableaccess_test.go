@@ -0,0 +1,52 @@
+package gorag
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCheckTableAccess(t *testing.T) {
+	withDriver(t, "postgres")
+	config := newConfig("")
+	err := json.Unmarshal([]byte(`{"allowed_tables": ["orders", "customers", "salaries"], "denied_tables": ["salaries"]}`), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{Config: config}
+	cases := []struct {
+		query string
+		want  string // in the refusal, or "" when the query may run
+	}{
+		{`SELECT * FROM orders JOIN customers ON true`, ""},
+		{`WITH t AS (SELECT * FROM orders) SELECT * FROM t`, ""},
+		{`SELECT * FROM generate_series(1, 2) g, orders`, ""},
+		{`SELECT * FROM products`, "table products isn't allowed"},
+		{`SELECT * FROM salaries`, "table salaries is denied"},
+
+		// every way of naming a table is still that table
+		{`WITH salaries AS (SELECT * FROM salaries) SELECT * FROM salaries`, "table salaries is denied"},
+		{`SELECT * FROM generate_series(1,2) g, salaries`, "table salaries is denied"},
+		{`SELECT * FROM orders "o", salaries`, "table salaries is denied"},
+		{`SELECT * FROM orders AS o (a,b), salaries`, "table salaries is denied"},
+		{`SELECT * FROM (orders), salaries`, "table salaries is denied"},
+		{`SELECT * FROM orders TABLESAMPLE SYSTEM (10), salaries`, "table salaries is denied"},
+		{`SELECT * FROM orders "o", products`, "table products isn't allowed"},
+
+		// and what can't be told apart is refused
+		{`SELECT * FROM 'salaries.csv'`, "can't tell which tables"},
+		{`SELECT * FROM orders, (salaries`, "can't tell which tables"},
+	}
+	for _, tc := range cases {
+		got := ""
+		if err := c.checkTableAccess(tc.query); err != nil {
+			got = err.Error()
+		}
+		switch {
+		case tc.want == "" && got != "":
+			t.Errorf("%q was refused: %s", tc.query, got)
+		case tc.want != "" && !strings.Contains(got, tc.want):
+			t.Errorf("%q: got %q, want a refusal with %q", tc.query, got, tc.want)
+		}
+	}
+}
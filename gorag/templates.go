@@ -0,0 +1,133 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+/*
+  Answer templates shape the summary by what kind of question it is:
+
+    "answer_templates": {
+      "finance": {
+        "description": "revenue, costs, margins, forecasts",
+        "tone": "precise and formal, for the CFO",
+        "sections": ["headline number", "trend", "caveats"],
+        "max_words": 120
+      },
+      "support": { "description": "a customer's orders and tickets", "tone": "plain and friendly" }
+    }
+
+  Once templates are configured, the route stage gives every question
+  a category: the caller's, or the template the model finds fits it
+  best, or none when nothing does, which is the summary as it always
+  was. The summarizer writes the sections in order, under their names.
+*/
+type AnswerTemplate struct {
+	Description string   `json:"description,omitempty"` // which questions it is for, for the router
+	Tone        string   `json:"tone,omitempty"`
+	Sections    []string `json:"sections,omitempty"`
+	MaxWords    int      `json:"max_words,omitempty"`
+}
+
+func (c *Config) answerTemplates() map[string]*AnswerTemplate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make(map[string]*AnswerTemplate, len(c.AnswerTemplates))
+	for k, v := range c.AnswerTemplates {
+		out[k] = v
+	}
+	return out
+}
+
+// classifyCategory has the model pick the template for a question, or none
+func (c *Client) classifyCategory(prompt string, templates map[string]*AnswerTemplate) (string, error) {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(fmt.Sprintf("- %s: %s\n", name, templates[name].Description))
+	}
+	var out struct {
+		Category string `json:"category"`
+	}
+	err := c.llmJSON(fmt.Sprintf(`
+Classify this database question into the one of these categories it belongs to,
+or "none" if it belongs to none of them:
+
+%s
+Respond with json: { "category": "<name>" }
+
+Question: %s
+`, sb.String(), prompt), &out)
+	if err != nil {
+		return "", err
+	}
+	if out.Category == "none" {
+		return "", nil
+	}
+	if _, ok := templates[out.Category]; !ok {
+		return "", fmt.Errorf("model picked unknown category %q", out.Category)
+	}
+	return out.Category, nil
+}
+
+// categorize gives the question its category, when there are templates
+func (c *Client) categorize(q *Question, answer *Answer) error {
+	if c.Config == nil {
+		return nil
+	}
+	templates := c.Config.answerTemplates()
+	if len(templates) == 0 {
+		return nil
+	}
+	if q.Category == "" {
+		// on failure the summary just goes without a template
+		category, err := c.classifyCategory(q.Prompt, templates)
+		if err != nil {
+			log.Printf("Could not categorize the question: %v", err)
+		}
+		q.Category = category
+	}
+	if _, ok := templates[q.Category]; !ok && q.Category != "" {
+		return fmt.Errorf("unknown category: %s", q.Category)
+	}
+	answer.Category = q.Category
+	return nil
+}
+
+// answerTemplate is the template for the question's category, if it has one
+func (c *Client) answerTemplate(q *Question) *AnswerTemplate {
+	if c.Config == nil || q.Category == "" {
+		return nil
+	}
+	return c.Config.answerTemplates()[q.Category]
+}
+
+// prompt tells the summarizer how to write the answer
+func (t *AnswerTemplate) prompt() string {
+	if t == nil {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n\tWrite the answer this way.\n")
+	if t.Tone != "" {
+		sb.WriteString(fmt.Sprintf("\tTone: %s.\n", t.Tone))
+	}
+	if len(t.Sections) > 0 {
+		sb.WriteString("\tThese sections, in this order, each under its name:\n")
+		for _, s := range t.Sections {
+			sb.WriteString(fmt.Sprintf("\t- %s\n", s))
+		}
+		sb.WriteString("\tIf the rows say nothing for a section, say so in it rather than leave it out.\n")
+	}
+	if t.MaxWords > 0 {
+		sb.WriteString(fmt.Sprintf("\tAt most %d words.\n", t.MaxWords))
+	}
+	return sb.String()
+}
@@ -0,0 +1,74 @@
+package gorag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+  A generated query can be a cross join of two big tables, which runs
+  until someone kills it, holding a connection and the database's CPU
+  all the while. With -query-timeout, each query gorag runs for a
+  question is given that long, and then cancelled, on drivers that
+  cancel (postgres, mysql, sqlserver, snowflake and clickhouse do). On
+  postgres the connections also get it as their statement_timeout, so
+  the server stops the query itself even when nothing is left to
+  cancel it, eg: the process was killed.
+
+  An answer runs under the context of what asked for it: a served
+  question stops, model calls included, when its request goes away,
+  and one on the command line when it is interrupted. AskContext is Ask
+  with the caller's context.
+*/
+var queryTimeout = Flags.Duration("query-timeout", 0, "how long one query may run before it is cancelled, and postgres' statement_timeout; 0 lets it run")
+
+// runContext is the context of the run's request; model calls and queries stop when it is done
+func (c *Client) runContext() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// queryContext is the run's context, with QueryTimeout for one query
+func (c *Client) queryContext() (context.Context, context.CancelFunc) {
+	if c.QueryTimeout <= 0 {
+		return c.runContext(), func() {}
+	}
+	return context.WithTimeout(c.runContext(), c.QueryTimeout)
+}
+
+// queryError says why a query stopped, when it was cancelled rather than failed
+func (c *Client) queryError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded) && c.runContext().Err() == nil:
+		return fmt.Errorf("the query ran longer than the query timeout of %s, and was cancelled: %v", c.QueryTimeout, err)
+	case ctx.Err() != nil:
+		return fmt.Errorf("the query was cancelled: %v", ctx.Err())
+	}
+	return err
+}
+
+// withStatementTimeout is the postgres dsn with statement_timeout set to d, unless it sets one
+func withStatementTimeout(dsn string, d time.Duration) string {
+	if d <= 0 || strings.Contains(dsn, "statement_timeout") {
+		return dsn
+	}
+	ms := fmt.Sprint(d.Milliseconds())
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			// the driver says what is wrong with it
+			return dsn
+		}
+		q := u.Query()
+		q.Set("statement_timeout", ms)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return strings.TrimSpace(dsn + " statement_timeout=" + ms)
+}
@@ -0,0 +1,134 @@
+package gorag
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/*
+  Every query gorag runs to answer a question starts with a comment
+  saying gorag, which is how advise finds ours in pg_stat_statements.
+  When a request to the server carries a W3C traceparent, gorag stays
+  in that trace: the comment also names the trace and a span of our
+  own, so a DBA looking at a slow query in pg_stat_activity, a log or
+  pg_stat_statements (which keeps the text of the first one it saw)
+  can find the request it came from; each model call carries
+  traceparent and tracestate on to the model endpoint, and the audit
+  log records the trace id. The ids are checked to be hex before they
+  go anywhere near SQL.
+*/
+type traceContext struct {
+	TraceID  string
+	ParentID string
+	Flags    string
+	State    string
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n || strings.Trim(s, "0") == "" {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+// parseTraceparent takes version-traceid-parentid-flags, ignoring what a later version adds
+func parseTraceparent(header, state string) (*traceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return nil, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return nil, false
+	}
+	if !isHex(parts[1], 32) || !isHex(parts[2], 16) || len(parts[3]) != 2 {
+		return nil, false
+	}
+	if _, err := hex.DecodeString(parts[3]); err != nil {
+		return nil, false
+	}
+	// tracestate is passed on as it is, as long as it can be a header at all
+	if len(state) > 512 || strings.ContainsAny(state, "\r\n") {
+		state = ""
+	}
+	return &traceContext{TraceID: parts[1], ParentID: parts[2], Flags: parts[3], State: state}, true
+}
+
+func traceFromRequest(r *http.Request) *traceContext {
+	t, ok := parseTraceparent(r.Header.Get("traceparent"), r.Header.Get("tracestate"))
+	if !ok {
+		return nil
+	}
+	return t
+}
+
+func newSpanID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// child is the traceparent of a call we make, as a new span under the request's
+func (t *traceContext) child() string {
+	return fmt.Sprintf("00-%s-%s-%s", t.TraceID, newSpanID(), t.Flags)
+}
+
+func (t *traceContext) id() string {
+	if t == nil {
+		return ""
+	}
+	return t.TraceID
+}
+
+/*
+  annotate starts a query we are about to run with a comment saying
+  it is ours, and what it answers: the run, the user, a hash of the
+  question, and the trace if there is one. Database side auditing
+  (pgaudit, the postgres log, pg_stat_activity) sees the comment, so
+  every statement leads back to a run and a user in the audit log.
+  Values are query escaped, so nothing in them can end the comment.
+*/
+func (c *Client) annotate(query string) string {
+	tags := []string{"gorag"}
+	if q := c.Run; q != nil {
+		if q.RunID != "" {
+			tags = append(tags, "run="+url.QueryEscape(q.RunID))
+		}
+		if q.User != "" {
+			tags = append(tags, "user="+url.QueryEscape(q.User))
+		}
+		tags = append(tags, "question_hash="+questionHash(q.Prompt))
+	}
+	if c.Trace != nil {
+		tags = append(tags, "traceid="+c.Trace.TraceID, "spanid="+newSpanID())
+	}
+	return "/* " + strings.Join(tags, " ") + " */ " + query
+}
+
+// questionHash is the same for the same question, whoever asks it
+func questionHash(prompt string) string {
+	return sha256Hex([]byte(strings.TrimSpace(prompt)))[:16]
+}
+
+// forRun is the client answering one question, whose queries say so
+func (c *Client) forRun(q *Question) *Client {
+	rc := *c
+	rc.Run = q
+	return &rc
+}
+
+// withTrace is the client for one traced request; the cached client isn't changed
+func (c *Client) withTrace(t *traceContext) *Client {
+	if t == nil {
+		return c
+	}
+	tc := *c
+	tc.Trace = t
+	return &tc
+}
@@ -0,0 +1,148 @@
+package gorag
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/*
+  gorag can answer questions about its own use, by the same pipeline
+  as any other question, eg:
+
+    gorag -usage -prompt "which users asked the most expensive questions last week?"
+
+  With -usage, the database is the state database (-state-dsn, or the
+  one it answers from) and the schema is only gorag.audit_events,
+  which gorag db migrate creates, with what each kind of event holds
+  explained to the model as extra metadata. -audit-log is loaded into
+  that table first, the events newer than the newest it has, so the
+  answer is as current as the log; gorag db load-audit only loads, eg:
+  from a scheduler, so that several hosts' logs end up in one table.
+  The events are everyone's questions, so -usage is for whoever can
+  read the audit log already, and can't be served.
+*/
+var usageFlag = Flags.Bool("usage", false, "answer questions about gorag's own use, from its audit events in the state database")
+
+const usageTable = "gorag.audit_events"
+
+// usageColumns are gorag.audit_events' columns, as the state migrations make them
+var usageColumns = []string{
+	"id", "time", "event", "run_id", "user", "profile", "prompt", "purpose", "query", "tables", "tags",
+	"reason", "error", "duration_ms", "trace_id", "model", "provider", "prompt_tokens", "completion_tokens",
+	"cost_usd", "examples", "helpful", "stage", "generations", "failed_generations", "executions", "failed_executions",
+	"route", "difficulty", "rewrite",
+}
+
+// usageMetadata explains the events to the model, since the column names don't say which events have them
+var usageMetadata = map[string]string{
+	usageTable: "gorag's own audit log, one row per event. event is one of: ask (a question and the query " +
+		"that answered it), model_call (one call to a model), feedback (whether an answer was helpful), override " +
+		"(a purpose restriction overridden, with its reason), safety (a question refused as unsafe), " +
+		"opa (a policy's decision on a query, tagged allow, deny or rewrite), schema_change, script and gdpr.",
+	"time":        "when it happened, in UTC",
+	"run_id":      "the question the event belongs to: an ask and its model_call events share it, so a question's cost is the sum of cost_usd over the model_call events with its run_id",
+	"user":        `who asked; a reserved word, so always quote it as "user"`,
+	"prompt":      "the question, on ask events",
+	"query":       "the SQL that answered it, on ask events",
+	"tables":      "the tables the query read, a text array",
+	"error":       "why the question failed, and null when it worked; stage is the step it failed in",
+	"cost_usd":    "dollars, on model_call events, from the model's price and its prompt_tokens and completion_tokens",
+	"model":       "on model_call events the model called, on ask events the one that wrote the SQL",
+	"duration_ms": "on ask events, how long answering took, models included",
+	"generations": "on ask events, how many times the query was written, and failed_generations how many of those failed; " +
+		"executions and failed_executions are the same for running it",
+	"helpful": "on feedback events, the answer to the run_id was helpful or not",
+	"route":   "on ask events, easy or hard when questions are routed to a model by difficulty, and difficulty is the score from 0 to 1 it was routed by",
+	"rewrite": "on opa events tagged rewrite, the query the policy ran instead of query",
+}
+
+// usageSchema is the schema of the audit events table, with its key
+func usageSchema() *DBMetadata {
+	return &DBMetadata{
+		Tables:      map[string][]string{usageTable: usageColumns},
+		PrimaryKeys: map[string][]string{usageTable: {"id"}},
+	}
+}
+
+// checkUsage is why -usage can't be used here, or nil
+func checkUsage() error {
+	if *serve != "" {
+		return fmt.Errorf("-usage can't be served, since the audit events are everyone's questions")
+	}
+	return requirePostgres("-usage")
+}
+
+// nullIfEmpty stores an empty string as null, so a question that worked has a null error
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+/*
+  loadAuditEvents copies the events in the log that are newer than the
+  newest in gorag.audit_events, in one transaction, and says how many.
+  Times are compared as postgres keeps them, to the microsecond, so
+  loading the same log again adds nothing.
+*/
+func loadAuditEvents(db *sql.DB, filename string) (int, error) {
+	var exists bool
+	if err := db.QueryRow("SELECT to_regclass('gorag.audit_events') IS NOT NULL").Scan(&exists); err != nil {
+		return 0, fmt.Errorf("failed to look for %s: %v", usageTable, err)
+	}
+	if !exists {
+		return 0, fmt.Errorf("there is no %s; gorag db migrate makes it", usageTable)
+	}
+	var newest sql.NullTime
+	if err := db.QueryRow("SELECT max(time) FROM gorag.audit_events").Scan(&newest); err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", usageTable, err)
+	}
+	if filename == "" {
+		return 0, nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	insert, err := tx.Prepare(`INSERT INTO gorag.audit_events (time, event, run_id, "user", profile, prompt, purpose,
+		query, tables, tags, reason, error, duration_ms, trace_id, model, provider, prompt_tokens, completion_tokens,
+		cost_usd, examples, helpful, stage, generations, failed_generations, executions, failed_executions, route, difficulty,
+		rewrite)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load the audit log: %v", err)
+	}
+	defer insert.Close()
+	loaded := 0
+	var insertErr error
+	err = readAuditLog(filename, func(e AuditEvent) {
+		at := e.Time.UTC().Round(time.Microsecond)
+		if insertErr != nil || (newest.Valid && !at.After(newest.Time)) {
+			return
+		}
+		_, insertErr = insert.Exec(at, e.Event, nullIfEmpty(e.RunID), nullIfEmpty(e.User), nullIfEmpty(e.Profile),
+			nullIfEmpty(e.Prompt), nullIfEmpty(e.Purpose), nullIfEmpty(e.Query), pq.Array(e.Tables), pq.Array(e.Tags),
+			nullIfEmpty(e.Reason), nullIfEmpty(e.Error), e.DurationMs, nullIfEmpty(e.TraceID), nullIfEmpty(e.Model),
+			nullIfEmpty(e.Provider), e.PromptTokens, e.CompletionTokens, e.CostUSD, pq.Array(e.Examples), e.Helpful,
+			nullIfEmpty(e.Stage), e.Generations, e.FailedGenerations, e.Executions, e.FailedExecutions,
+			nullIfEmpty(e.Route), e.Difficulty, nullIfEmpty(e.Rewrite))
+		if insertErr == nil {
+			loaded++
+		}
+	})
+	if err == nil {
+		err = insertErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load the audit log: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to load the audit log: %v", err)
+	}
+	return loaded, nil
+}
@@ -0,0 +1,264 @@
+package gorag
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+  Columns with "embed_values": true in the config have their distinct
+  values embedded into the -vector-store, so that a question about
+  "widgit pro" or "Jon Smyth" is written against 'Widget Pro' and
+  'John Smith', the values that are really there:
+
+    "tables": {
+      "products": { "columns": { "name": { "embed_values": true } } }
+    }
+
+  The model picks out what the question names, each of those is
+  searched for in each column, and the nearest values, when they are
+  at least -value-match similar, go into the sql prompt as its values
+  part. The values are read once per schema, up to -embed-values-max a
+  column, and a column that is tagged or masked isn't embedded, nor is
+  anything when a separate data model keeps data from the sql model.
+*/
+var maxEmbedValues = Flags.Int("embed-values-max", 20000, "distinct values to embed from each embed_values column")
+var valueMatch = Flags.Float64("value-match", 0.8, "how similar a column value has to be to what a question names to be suggested")
+
+// valueBatch is how many values go to the embeddings api at once
+const valueBatch = 1000
+
+type valueColumn struct {
+	table      string
+	column     string
+	collection string
+	values     map[string]string // vector store id -> value
+}
+
+type valueIndex struct {
+	mu      sync.Mutex
+	built   bool
+	columns []*valueColumn
+
+	// the last question's matches, since a retry asks again
+	question string
+	at       time.Time
+	matches  []valueMatchFor
+}
+
+type valueMatchFor struct {
+	column  *valueColumn
+	value   string
+	mention string
+	score   float64
+}
+
+// one index per schema, as with the tables
+var valueIndexes = struct {
+	sync.Mutex
+	m map[*DBMetadata]*valueIndex
+}{m: make(map[*DBMetadata]*valueIndex)}
+
+// embedValueColumns are the table.column pairs the config wants embedded, that the schema has
+func (c *Client) embedValueColumns() [][2]string {
+	if c.Config == nil {
+		return nil
+	}
+	c.Config.mu.RLock()
+	defer c.Config.mu.RUnlock()
+	out := make([][2]string, 0)
+	for table, tc := range c.Config.Tables {
+		for column, cc := range tc.Columns {
+			if cc == nil || !cc.EmbedValues {
+				continue
+			}
+			if len(cc.Tags) > 0 || cc.Mask != "" {
+				log.Printf("Not embedding the values of %s.%s, which is tagged or masked", table, column)
+				continue
+			}
+			if c.Schema.hasColumn(table, column) {
+				out = append(out, [2]string{table, column})
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i][0]+"."+out[i][1] < out[j][0]+"."+out[j][1]
+	})
+	return out
+}
+
+// valueVectors reads and embeds the columns' values once, and puts them in the vector store
+func (c *Client) valueVectors() (*valueIndex, error) {
+	valueIndexes.Lock()
+	idx, ok := valueIndexes.m[c.Schema]
+	if !ok {
+		idx = &valueIndex{}
+		valueIndexes.m[c.Schema] = idx
+	}
+	valueIndexes.Unlock()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.built {
+		return idx, nil
+	}
+	for _, tc := range c.embedValueColumns() {
+		vc, err := c.embedColumnValues(tc[0], tc[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed the values of %s.%s: %v", tc[0], tc[1], err)
+		}
+		log.Printf("Embedded %d values of %s.%s", len(vc.values), tc[0], tc[1])
+		idx.columns = append(idx.columns, vc)
+	}
+	idx.built = true
+	return idx, nil
+}
+
+func (c *Client) embedColumnValues(table, column string) (*valueColumn, error) {
+	rows, err := c.DB.Query(distinctQuery(table, column, *maxEmbedValues))
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0)
+	for rows.Next() {
+		var v sql.NullString
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if s := strings.TrimSpace(v.String); s != "" && utf8.ValidString(s) {
+			values = append(values, s)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(values)
+
+	keys := make([]string, len(values))
+	for i, v := range values {
+		keys[i] = c.Pseudonyms.hide(v)
+	}
+	vc := &valueColumn{
+		table:      table,
+		column:     column,
+		collection: "values_" + embeddingKey(table + "." + column + "\x00" + strings.Join(keys, "\x00"))[:16],
+		values:     make(map[string]string, len(values)),
+	}
+	for from := 0; from < len(values); from += valueBatch {
+		to := from + valueBatch
+		if to > len(values) {
+			to = len(values)
+		}
+		vectors, err := cachedEmbed(*embeddingsCache, keys[from:to], func(missing []int) ([][]float64, error) {
+			some := make([]string, len(missing))
+			for j, i := range missing {
+				some[j] = values[from+i]
+			}
+			return c.embed(some)
+		})
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]string, to-from)
+		for i := range ids {
+			ids[i] = sha256Hex([]byte(keys[from+i]))[:16]
+			vc.values[ids[i]] = values[from+i]
+		}
+		if err := c.vectorStore().Upsert(vc.collection, ids, vectors); err != nil {
+			return nil, err
+		}
+	}
+	return vc, nil
+}
+
+// mentions are the things a question names, as it spells them
+func (c *Client) mentions(question string) ([]string, error) {
+	var out struct {
+		Mentions []string `json:"mentions"`
+	}
+	err := c.llmJSON(fmt.Sprintf(`
+List the specific things this database question names, as it spells them:
+people, companies, products, places, categories, statuses, codes. Not dates,
+numbers, or words that describe columns (like "revenue" or "customers").
+
+Respond with json: { "mentions": ["...", ...] }
+
+Question: %s
+`, question), &out)
+	return out.Mentions, err
+}
+
+// matchValues finds the column values nearest to what the question names
+func (c *Client) matchValues(question string) []valueMatchFor {
+	if c.DataModel != nil || len(c.embedValueColumns()) == 0 {
+		return nil
+	}
+	idx, err := c.valueVectors()
+	if err != nil {
+		log.Printf("No column values in the prompt: %v", err)
+		return nil
+	}
+	idx.mu.Lock()
+	if idx.question == question && time.Since(idx.at) < docsMemoFor {
+		matches := idx.matches
+		idx.mu.Unlock()
+		return matches
+	}
+	idx.mu.Unlock()
+
+	mentions, err := c.mentions(question)
+	if err != nil || len(mentions) == 0 {
+		if err != nil {
+			log.Printf("No column values in the prompt: %v", err)
+		}
+		return nil
+	}
+	vectors, err := c.embed(mentions)
+	if err != nil {
+		log.Printf("No column values in the prompt: %v", err)
+		return nil
+	}
+	matches := make([]valueMatchFor, 0)
+	for i, mention := range mentions {
+		for _, vc := range idx.columns {
+			found, err := c.vectorStore().Search(vc.collection, vectors[i], 1)
+			if err != nil {
+				log.Printf("Failed to search the values of %s.%s: %v", vc.table, vc.column, err)
+				continue
+			}
+			for _, m := range found {
+				if v, ok := vc.values[m.ID]; ok && m.Score >= *valueMatch {
+					matches = append(matches, valueMatchFor{vc, v, mention, m.Score})
+				}
+			}
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+
+	idx.mu.Lock()
+	idx.question, idx.at, idx.matches = question, time.Now(), matches
+	idx.mu.Unlock()
+	return matches
+}
+
+// valuesPrompt has the real values for what the question names
+func (c *Client) valuesPrompt(question string) string {
+	matches := c.matchValues(question)
+	if len(matches) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\nValues in the database for what the question names, to filter on exactly as written here:\n\n")
+	for _, m := range matches {
+		sb.WriteString(fmt.Sprintf("- %s.%s = %s (for %q)\n", m.column.table, m.column.column, sqlLiteral(m.value), m.mention))
+	}
+	return sb.String()
+}
@@ -0,0 +1,399 @@
+package gorag
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+  Embeddings are kept in a VectorStore, picked with -vector-store:
+
+    memory                     exact, in this process (the default)
+    hnsw                       an approximate index in this process, for bigger corpora
+    qdrant:http://host:6333    a Qdrant server, with QDRANT_API_KEY if it needs one
+    weaviate:http://host:8080  a Weaviate server, with WEAVIATE_API_KEY if it needs one
+
+  Nothing needs an extension in the database being asked about. A
+  collection is named for what is in it (eg: the hash of every table
+  description), so a changed schema gets a new one rather than mixing
+  with the old, and the ids stored are hashes too: the servers never
+  see a table name, which -anonymize would otherwise have to hide.
+  Similarity is cosine everywhere, 1 is the same direction.
+*/
+var vectorStoreSpec = Flags.String("vector-store", "memory", "where embeddings are searched: memory, hnsw, qdrant:URL or weaviate:URL")
+
+type VectorStore interface {
+	// Upsert stores vectors under their ids, replacing what the ids had
+	Upsert(collection string, ids []string, vectors [][]float64) error
+	// Search is the k ids nearest to the vector, most similar first
+	Search(collection string, vector []float64, k int) ([]VectorMatch, error)
+}
+
+type VectorMatch struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"` // cosine similarity
+}
+
+// parseVectorStore returns nil for memory, which a client has when it has no store
+func parseVectorStore(spec string) (VectorStore, error) {
+	kind, u, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "memory":
+		return nil, nil
+	case "hnsw":
+		return newHNSWStore(), nil
+	case "qdrant", "weaviate":
+		if parsed, err := url.Parse(u); err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("-vector-store %s needs a url, eg: %s:http://localhost:8080", kind, kind)
+		}
+		u = strings.TrimSuffix(u, "/")
+		if kind == "qdrant" {
+			return &qdrantStore{url: u, apiKey: os.Getenv("QDRANT_API_KEY")}, nil
+		}
+		return &weaviateStore{url: u, apiKey: os.Getenv("WEAVIATE_API_KEY")}, nil
+	}
+	return nil, fmt.Errorf("-vector-store must be memory, hnsw, qdrant:URL or weaviate:URL")
+}
+
+// vectorStoreURL is the server -vector-store talks to, if any
+func vectorStoreURL() string {
+	kind, u, _ := strings.Cut(*vectorStoreSpec, ":")
+	if kind == "qdrant" || kind == "weaviate" {
+		return u
+	}
+	return ""
+}
+
+func (c *Client) vectorStore() VectorStore {
+	if c.Vectors != nil {
+		return c.Vectors
+	}
+	return defaultVectors
+}
+
+type memoryStore struct {
+	mu          sync.RWMutex
+	collections map[string]map[string][]float64
+}
+
+var defaultVectors = &memoryStore{collections: make(map[string]map[string][]float64)}
+
+func (m *memoryStore) Upsert(collection string, ids []string, vectors [][]float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.collections[collection]
+	if !ok {
+		c = make(map[string][]float64)
+		m.collections[collection] = c
+	}
+	for i, id := range ids {
+		c[id] = vectors[i]
+	}
+	return nil
+}
+
+func (m *memoryStore) Search(collection string, vector []float64, k int) ([]VectorMatch, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]VectorMatch, 0, len(m.collections[collection]))
+	for id, v := range m.collections[collection] {
+		out = append(out, VectorMatch{id, cosineSimilarity(vector, v)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].ID < out[j].ID
+	})
+	if len(out) > k {
+		out = out[:k]
+	}
+	return out, nil
+}
+
+// vectorUUID is an id as the servers want it, which is a uuid
+func vectorUUID(collection, id string) string {
+	sum := sha256.Sum256([]byte(collection + "\x00" + id))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+var jsonClient = &http.Client{Timeout: 30 * time.Second}
+
+// vectorRequest sends json and decodes json; a missing thing is (false, nil)
+func jsonRequest(method, url string, header http.Header, in, out interface{}) (bool, error) {
+	if err := checkSinkHost(url); err != nil {
+		return false, err
+	}
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return false, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return false, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := jsonClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return false, fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return false, fmt.Errorf("failed to parse the response to %s %s: %v", method, url, err)
+		}
+	}
+	return true, nil
+}
+
+// vectorBatch is as many vectors as go in one request
+const vectorBatch = 256
+
+type qdrantStore struct {
+	url    string
+	apiKey string
+
+	mu      sync.Mutex
+	created map[string]bool
+}
+
+func (q *qdrantStore) header() http.Header {
+	h := make(http.Header)
+	if q.apiKey != "" {
+		h.Set("api-key", q.apiKey)
+	}
+	return h
+}
+
+func (q *qdrantStore) ensure(collection string, size int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.created[collection] {
+		return nil
+	}
+	u := q.url + "/collections/" + url.PathEscape(collection)
+	exists, err := jsonRequest("GET", u, q.header(), nil, nil)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		create := map[string]interface{}{"vectors": map[string]interface{}{"size": size, "distance": "Cosine"}}
+		if _, err := jsonRequest("PUT", u, q.header(), create, nil); err != nil {
+			return err
+		}
+	}
+	if q.created == nil {
+		q.created = make(map[string]bool)
+	}
+	q.created[collection] = true
+	return nil
+}
+
+func (q *qdrantStore) Upsert(collection string, ids []string, vectors [][]float64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := q.ensure(collection, len(vectors[0])); err != nil {
+		return err
+	}
+	u := q.url + "/collections/" + url.PathEscape(collection) + "/points?wait=true"
+	for start := 0; start < len(ids); start += vectorBatch {
+		end := start + vectorBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		points := make([]map[string]interface{}, 0, end-start)
+		for i := start; i < end; i++ {
+			points = append(points, map[string]interface{}{
+				"id":      vectorUUID(collection, ids[i]),
+				"vector":  vectors[i],
+				"payload": map[string]string{"gorag_id": ids[i]},
+			})
+		}
+		if _, err := jsonRequest("PUT", u, q.header(), map[string]interface{}{"points": points}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *qdrantStore) Search(collection string, vector []float64, k int) ([]VectorMatch, error) {
+	var out struct {
+		Result []struct {
+			Score   float64 `json:"score"`
+			Payload struct {
+				ID string `json:"gorag_id"`
+			} `json:"payload"`
+		} `json:"result"`
+	}
+	u := q.url + "/collections/" + url.PathEscape(collection) + "/points/search"
+	search := map[string]interface{}{"vector": vector, "limit": k, "with_payload": true}
+	found, err := jsonRequest("POST", u, q.header(), search, &out)
+	if err != nil || !found {
+		return nil, err
+	}
+	matches := make([]VectorMatch, 0, len(out.Result))
+	for _, r := range out.Result {
+		matches = append(matches, VectorMatch{r.Payload.ID, r.Score})
+	}
+	return matches, nil
+}
+
+type weaviateStore struct {
+	url    string
+	apiKey string
+
+	mu      sync.Mutex
+	created map[string]bool
+}
+
+var nonClassChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// weaviateClass is a collection as a class name, which starts with a capital
+func weaviateClass(collection string) string {
+	return "Gorag_" + nonClassChars.ReplaceAllString(collection, "_")
+}
+
+func (w *weaviateStore) header() http.Header {
+	h := make(http.Header)
+	if w.apiKey != "" {
+		h.Set("Authorization", "Bearer "+w.apiKey)
+	}
+	return h
+}
+
+func (w *weaviateStore) ensure(class string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.created[class] {
+		return nil
+	}
+	exists, err := jsonRequest("GET", w.url+"/v1/schema/"+class, w.header(), nil, nil)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		create := map[string]interface{}{
+			"class":             class,
+			"vectorizer":        "none",
+			"vectorIndexConfig": map[string]string{"distance": "cosine"},
+			"properties":        []map[string]interface{}{{"name": "goragId", "dataType": []string{"text"}}},
+		}
+		if _, err := jsonRequest("POST", w.url+"/v1/schema", w.header(), create, nil); err != nil {
+			return err
+		}
+	}
+	if w.created == nil {
+		w.created = make(map[string]bool)
+	}
+	w.created[class] = true
+	return nil
+}
+
+func (w *weaviateStore) Upsert(collection string, ids []string, vectors [][]float64) error {
+	class := weaviateClass(collection)
+	if err := w.ensure(class); err != nil {
+		return err
+	}
+	for start := 0; start < len(ids); start += vectorBatch {
+		end := start + vectorBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		objects := make([]map[string]interface{}, 0, end-start)
+		for i := start; i < end; i++ {
+			objects = append(objects, map[string]interface{}{
+				"class":      class,
+				"id":         vectorUUID(collection, ids[i]),
+				"properties": map[string]string{"goragId": ids[i]},
+				"vector":     vectors[i],
+			})
+		}
+		// a batch answers 200 with the objects that failed in it
+		var results []struct {
+			Result struct {
+				Errors *struct {
+					Error []struct {
+						Message string `json:"message"`
+					} `json:"error"`
+				} `json:"errors"`
+			} `json:"result"`
+		}
+		if _, err := jsonRequest("POST", w.url+"/v1/batch/objects", w.header(), map[string]interface{}{"objects": objects}, &results); err != nil {
+			return err
+		}
+		for _, r := range results {
+			if r.Result.Errors != nil && len(r.Result.Errors.Error) > 0 {
+				return fmt.Errorf("weaviate refused an object: %s", r.Result.Errors.Error[0].Message)
+			}
+		}
+	}
+	return nil
+}
+
+func (w *weaviateStore) Search(collection string, vector []float64, k int) ([]VectorMatch, error) {
+	class := weaviateClass(collection)
+	v, err := json.Marshal(vector)
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf(`{ Get { %s(nearVector: {vector: %s}, limit: %d) { goragId _additional { distance } } } }`, class, v, k)
+	var out struct {
+		Data struct {
+			Get map[string][]struct {
+				ID         string `json:"goragId"`
+				Additional struct {
+					Distance float64 `json:"distance"`
+				} `json:"_additional"`
+			} `json:"Get"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if _, err := jsonRequest("POST", w.url+"/v1/graphql", w.header(), map[string]string{"query": query}, &out); err != nil {
+		return nil, err
+	}
+	if len(out.Errors) > 0 {
+		// a class nobody has stored anything in yet is nothing found
+		if strings.Contains(out.Errors[0].Message, "Cannot query field") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("weaviate: %s", out.Errors[0].Message)
+	}
+	matches := make([]VectorMatch, 0, len(out.Data.Get[class]))
+	for _, r := range out.Data.Get[class] {
+		matches = append(matches, VectorMatch{r.ID, 1 - r.Additional.Distance})
+	}
+	return matches, nil
+}
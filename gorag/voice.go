@@ -0,0 +1,217 @@
+package gorag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+  -voice question.wav asks what is said in the recording, and writes
+  the summary, spoken, to -voice-out, so a kiosk can do without a
+  keyboard: arecord -d 8 -f cd -t wav | gorag -voice - && aplay ...
+  The server does the same at POST /query/audio. Speech goes to the
+  OpenAI audio api at -speech-url, which is -llm-url unless a local
+  Whisper (or piper, or anything else speaking that api) is there
+  instead. With -llm mock, the recording is taken to be the text
+  itself, and the speech is the summary's text.
+*/
+var voice = Flags.String("voice", "", "ask the question spoken in this audio file (- for stdin), and speak the answer into -voice-out")
+var voiceOut = Flags.String("voice-out", "answer.mp3", "with -voice, where the spoken answer is written")
+var speechURL = Flags.String("speech-url", "", "base url of the OpenAI compatible audio api, for -voice and /query/audio (-llm-url if empty)")
+var transcribeModel = Flags.String("transcribe-model", "whisper-1", "the speech to text model")
+var speechModel = Flags.String("speech-model", "tts-1", "the text to speech model")
+var speechVoice = Flags.String("speech-voice", "alloy", "the voice answers are spoken in")
+
+// maxAudioBytes is as much as the transcription api takes
+const maxAudioBytes = 25 << 20
+
+func speechBase() string {
+	if *speechURL != "" {
+		return strings.TrimRight(*speechURL, "/")
+	}
+	return strings.TrimRight(*llmURL, "/")
+}
+
+// audioExtensions name the upload by its content type, since that is how the api tells the format
+var audioExtensions = map[string]string{
+	"audio/wav": ".wav", "audio/x-wav": ".wav", "audio/wave": ".wav",
+	"audio/mpeg": ".mp3", "audio/mp3": ".mp3", "audio/mp4": ".m4a", "audio/x-m4a": ".m4a",
+	"audio/ogg": ".ogg", "audio/webm": ".webm", "audio/flac": ".flac",
+}
+
+// transcribe is the text of the speech in audio, whose file name says what format it is in
+func transcribe(apiKey, name string, audio []byte) (string, error) {
+	if *llmProvider == "mock" {
+		return strings.TrimSpace(string(audio)), nil
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("model", *transcribeModel)
+	part, err := form.CreateFormFile("file", name)
+	if err != nil {
+		return "", err
+	}
+	part.Write(audio)
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", speechBase()+"/audio/transcriptions", &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	data, err := audioRequest(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to transcribe: %v", err)
+	}
+	var out struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return "", fmt.Errorf("failed to transcribe: %v", err)
+	}
+	text := strings.TrimSpace(out.Text)
+	if text == "" {
+		return "", fmt.Errorf("no speech was heard")
+	}
+	return text, nil
+}
+
+// speak is text as mp3
+func speak(apiKey, text string) ([]byte, error) {
+	if *llmProvider == "mock" {
+		return []byte(text), nil
+	}
+	in, err := json.Marshal(map[string]string{
+		"model":           *speechModel,
+		"voice":           *speechVoice,
+		"input":           text,
+		"response_format": "mp3",
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", speechBase()+"/audio/speech", bytes.NewReader(in))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	audio, err := audioRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to speak the answer: %v", err)
+	}
+	return audio, nil
+}
+
+func audioRequest(req *http.Request) ([]byte, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, data)
+	}
+	return data, nil
+}
+
+// spokenQuestion is the question in the -voice recording
+func spokenQuestion(apiKey string) (string, error) {
+	var audio []byte
+	var err error
+	name := *voice
+	if name == "-" {
+		name = "question.wav"
+		audio, err = io.ReadAll(io.LimitReader(os.Stdin, maxAudioBytes))
+	} else {
+		audio, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", *voice, err)
+	}
+	return transcribe(apiKey, filepath.Base(name), audio)
+}
+
+// spokenAnswer is what is said for an answer: the summary, or what the rows are when there isn't one
+func spokenAnswer(answer *Answer) string {
+	if answer.Summary != "" {
+		return answer.Summary
+	}
+	return answer.Result
+}
+
+/*
+  handleQueryAudio is /ask by voice: the body is the recording, with
+  its Content-Type, and profile, purpose and category are parameters.
+  The answer is spoken back as audio/mpeg, with the question as it was
+  heard in X-Gorag-Transcript, or with Accept: application/json it is
+  the /ask response with the transcript and the audio in it.
+*/
+func (s *Server) handleQueryAudio(w http.ResponseWriter, r *http.Request) {
+	key, err := s.authenticate(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	kind, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	ext, ok := audioExtensions[kind]
+	if !ok {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("the body must be audio (wav, mp3, m4a, ogg, webm or flac), not %q", kind))
+		return
+	}
+	audio, err := io.ReadAll(io.LimitReader(r.Body, maxAudioBytes+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(audio) > maxAudioBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("the recording is over %d bytes", maxAudioBytes))
+		return
+	}
+	transcript, err := transcribe(s.defaultClient().APIKey, "question"+ext, audio)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	q := r.URL.Query()
+	req := askRequest{Prompt: transcript, Profile: q.Get("profile"), Purpose: q.Get("purpose"), Category: q.Get("category")}
+	answer, status, err := s.ask(r, key, req, nil)
+	if answer == nil {
+		writeError(w, status, err)
+		return
+	}
+	if err != nil {
+		writeJSON(w, status, newAskResponse(answer, err))
+		return
+	}
+	speech, err := speak(s.defaultClient().APIKey, spokenAnswer(answer))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, struct {
+			askResponse
+			Transcript string `json:"transcript"`
+			Audio      []byte `json:"audio"` // mp3, base64
+		}{newAskResponse(answer, nil), transcript, speech})
+		return
+	}
+	w.Header().Set("Content-Type", "audio/mpeg")
+	w.Header().Set("X-Gorag-Transcript", strings.Join(strings.Fields(transcript), " "))
+	w.Write(speech)
+}
@@ -0,0 +1,110 @@
+package gorag
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+  -warm does the slow first-time work before the server listens, so
+  the first person to ask doesn't pay for it: every profile is
+  connected and its schema loaded, -prune embeds each schema's tables,
+  the capabilities summary is written, and each model endpoint is
+  asked for its models with the key we'd use, so bad credentials stop
+  the server now instead of failing every question later. A profile
+  that can't be reached is logged and left for the first request, as
+  it would have been without -warm.
+*/
+var warm = Flags.Bool("warm", false, "at server start, connect profiles, embed schemas, check model credentials and fill caches before serving")
+
+func (s *Server) warmUp() error {
+	start := time.Now()
+	s.Config.mu.RLock()
+	profiles := []string{""}
+	for name := range s.Config.Profiles {
+		profiles = append(profiles, name)
+	}
+	s.Config.mu.RUnlock()
+	sort.Strings(profiles)
+
+	checked := make(map[string]bool)
+	for _, profile := range profiles {
+		label := profile
+		if label == "" {
+			label = "default"
+		}
+		client, err := s.clientFor(profile)
+		if err != nil {
+			log.Printf("Warm up: skipping profile %s: %v", label, err)
+			continue
+		}
+		for _, stage := range []string{"sql", "data"} {
+			t := client.target(stage, "")
+			if checked[t.URL+"\x00"+t.APIKey] {
+				continue
+			}
+			checked[t.URL+"\x00"+t.APIKey] = true
+			if err := checkCredentials(t); err != nil {
+				return fmt.Errorf("profile %s, %s model: %v", label, stage, err)
+			}
+		}
+		if client.PruneTables > 0 {
+			if _, err := client.tableVectors(); err != nil {
+				log.Printf("Warm up: couldn't embed the schema of %s: %v", label, err)
+			}
+		}
+		summary, err := client.Capabilities()
+		if err != nil {
+			log.Printf("Warm up: couldn't summarize %s: %v", label, err)
+		} else {
+			s.capabilities.mu.Lock()
+			s.capabilities.byClient[client] = summary
+			s.capabilities.mu.Unlock()
+		}
+		log.Printf("Warm up: profile %s ready, %d tables", label, len(client.Schema.Tables))
+	}
+	log.Printf("Warm up took %s", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// checkCredentials is that the -llm provider can reach the target with what it was given
+func checkCredentials(t modelTarget) error {
+	p, err := currentProvider()
+	if err != nil {
+		return err
+	}
+	// one that can't be checked is taken at its word
+	if c, ok := p.(checkingProvider); ok {
+		return c.Check(context.Background(), t.CompletionOptions)
+	}
+	return nil
+}
+
+// checkOpenAI lists the endpoint's models, which any OpenAI compatible server answers cheaply
+func checkOpenAI(ctx context.Context, t CompletionOptions) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimRight(t.URL, "/")+"/models", nil)
+	if err != nil {
+		return err
+	}
+	if t.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.APIKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("can't reach %s: %v", t.URL, err)
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%s rejected the api key: %s", t.URL, resp.Status)
+	case resp.StatusCode/100 != 2:
+		// some servers don't list models; that says nothing about the key
+		log.Printf("Warm up: %s/models answered %s, credentials not checked", t.URL, resp.Status)
+	}
+	return nil
+}
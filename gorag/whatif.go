@@ -0,0 +1,126 @@
+package gorag
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+/*
+  "What would revenue be if we raised prices 5%?" has no answer in the
+  database, only one worked out from it. A question worded like that
+  is first split by the model into the baseline question the data can
+  answer ("revenue by product this year"), what changes in the
+  scenario, and the assumptions that takes (volumes stay the same,
+  the raise applies to every product). The baseline query is written
+  as any other, then a SELECT over its rows, named baseline, works out
+  the scenario next to them, and the database runs the two as one:
+
+    WITH baseline AS (<baseline query>)
+    SELECT ..., revenue * 1.05 AS scenario_revenue FROM baseline
+
+  so the policies checking queries see all of it. The summary is told
+  it is a scenario, and the answer ends with its assumptions, whatever
+  the summary says. The model can also decide a question worded like
+  a what-if isn't one ("what would be the best month?"), and it is
+  answered as usual. -what-if=false answers all of them as usual.
+*/
+var whatIfFlag = Flags.Bool("what-if", true, "answer hypothetical questions, eg: what if prices rose 5%, as a baseline query and a scenario worked out from it")
+
+// WhatIf is how a hypothetical question was answered
+type WhatIf struct {
+	Baseline      string   `json:"baseline"`       // the question the data can answer
+	Scenario      string   `json:"scenario"`       // what changes
+	Assumptions   []string `json:"assumptions"`    // what the scenario takes for granted
+	BaselineQuery string   `json:"baseline_query"` // the query for Baseline
+	ScenarioQuery string   `json:"scenario_query"` // the SELECT over baseline's rows
+}
+
+var hypothetical = regexp.MustCompile(`(?i)\b(what if|what would|what'd|suppose|supposing|assuming|assume|hypothetical(ly)?|if we|if (the )?(price|prices|cost|costs|rate|rates)|would (be|happen|change|look))\b`)
+
+// planWhatIf splits a question worded as a what-if, or says it isn't one
+func (c *Client) planWhatIf(question string) (*WhatIf, error) {
+	if !c.WhatIf || !hypothetical.MatchString(question) {
+		return nil, nil
+	}
+	var plan struct {
+		WhatIf      bool     `json:"what_if"`
+		Baseline    string   `json:"baseline"`
+		Scenario    string   `json:"scenario"`
+		Assumptions []string `json:"assumptions"`
+	}
+	err := c.llmJSON(fmt.Sprintf(`
+A question about a database may ask about a hypothetical scenario, like
+"what would revenue be if we raised prices by 5%%?". The database can only
+answer the baseline ("revenue this year"), and the scenario has to be worked
+out from that ("revenue times 1.05").
+
+Say whether this question is one; words like "would" or "if" alone don't make
+it one: %s
+
+When it is, give the baseline question the data answers, with the figures the
+scenario needs, the scenario as a calculation on the baseline's figures, and
+every assumption the calculation makes (what stays the same, what it applies to).
+http response must be application/json:
+{ "what_if": true or false, "baseline": "...", "scenario": "...", "assumptions": ["...", ...] }
+`, question), &plan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan the what-if: %v", err)
+	}
+	if !plan.WhatIf || plan.Baseline == "" || plan.Scenario == "" {
+		return nil, nil
+	}
+	log.Printf("A what-if: the baseline is %q, and the scenario %q", plan.Baseline, plan.Scenario)
+	return &WhatIf{Baseline: plan.Baseline, Scenario: plan.Scenario, Assumptions: plan.Assumptions}, nil
+}
+
+// scenarioQuery writes the SELECT over the baseline's rows
+func (c *Client) scenarioQuery(w *WhatIf) (string, error) {
+	query, err := c.llmQuery(fmt.Sprintf(`
+This %s query answers "%s":
+
+%s
+
+Its rows are in a table named baseline. Write one SELECT over baseline, and
+nothing else, that keeps its columns and adds columns with the scenario worked
+out for each row, named to say they are the scenario, eg: scenario_revenue, and
+the change from the baseline where that helps.
+
+The scenario: %s
+Assuming: %s
+http response must be application/json:
+{ "query": "SELECT ... FROM baseline" }
+`, sqlDialect(), w.Baseline, w.BaselineQuery, w.Scenario, strings.Join(w.Assumptions, "; ")))
+	if err != nil {
+		return "", fmt.Errorf("failed to write the scenario: %v", err)
+	}
+	if ops := sqlOperations(query); len(ops) != 1 || ops[0] != "SELECT" {
+		return "", fmt.Errorf("the scenario must be one SELECT over baseline, not: %s", query)
+	}
+	return query, nil
+}
+
+// whatIfQuery is the baseline query with the scenario over it, for the database to run as one
+func whatIfQuery(w *WhatIf) string {
+	baseline := strings.TrimRight(strings.TrimSpace(w.BaselineQuery), ";")
+	scenario := strings.TrimRight(strings.TrimSpace(w.ScenarioQuery), ";")
+	return fmt.Sprintf("WITH baseline AS (\n%s\n)\n%s", baseline, scenario)
+}
+
+// whatIfPrompt is the question, as the summary should take it
+func whatIfPrompt(question string, w *WhatIf) string {
+	return fmt.Sprintf(`%s
+
+This is a hypothetical scenario. The rows have the baseline from the data
+(%s), and the scenario worked out from it (%s). Say which figures are actual
+and which are the scenario, and that the scenario is an estimate.`, question, w.Baseline, w.Scenario)
+}
+
+// withAssumptions ends the summary with the scenario's assumptions
+func withAssumptions(summary string, w *WhatIf) string {
+	if len(w.Assumptions) == 0 {
+		return summary + "\n\nScenario: " + w.Scenario
+	}
+	return summary + "\n\nScenario: " + w.Scenario + "\nAssumptions:\n- " + strings.Join(w.Assumptions, "\n- ")
+}
@@ -0,0 +1,215 @@
+package gorag
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+/*
+  With -allow-writes, a question may ask to change rows, eg: "mark the
+  test orders as cancelled", and the model may answer it with one
+  INSERT, UPDATE or DELETE. It runs in a transaction: on databases
+  with RETURNING (postgres, sqlite, duckdb) the rows it changed come
+  back, as they are after it, and the count and the first of them are
+  shown before asking whether to commit. Only y commits; anything else
+  rolls it back, and nothing changes. Everything else Validate checks
+  still holds, table access and deny rules included, a CTE or subquery
+  with a write in it is still refused, and so are writes that touch a
+  table with masks or a min_group_size, since what they change would
+  come back unhidden. It needs someone at the terminal to ask, as
+  -confirm does; a program that embeds gorag can set
+  Client.ConfirmWrites to ask its own way. Without it every query must
+  only read.
+*/
+var allowWrites = Flags.Bool("allow-writes", false, "let a question change rows with one INSERT, UPDATE or DELETE, run in a transaction that commits only when the answer is y")
+
+// writePreviewRows is how many of the changed rows are shown before asking to commit
+const writePreviewRows = 10
+
+type WriteConfirmer interface {
+	// ConfirmWrite is whether to commit the write, which changed affected rows, the first of them in preview
+	ConfirmWrite(query string, affected int64, preview string) bool
+}
+
+func (t terminalConfirmer) ConfirmWrite(query string, affected int64, preview string) bool {
+	fmt.Fprintf(t.out, "\n%s\n\nThis changes %d rows", strings.TrimSpace(query), affected)
+	if preview != "" {
+		fmt.Fprintf(t.out, ", which are now:\n\n%s\n", preview)
+	} else {
+		fmt.Fprint(t.out, ".\n")
+	}
+	fmt.Fprint(t.out, "\nCommit? [y/N] ")
+	return t.yes()
+}
+
+// writeKinds are the statements a question may change rows with
+var writeKinds = map[string]bool{"INSERT": true, "UPDATE": true, "DELETE": true}
+
+// isWrite is whether the query is one statement that changes rows
+func isWrite(query string) bool {
+	statements := splitStatements(lexSQL(query))
+	return len(statements) == 1 && writeKinds[statementKind(statements[0])]
+}
+
+// writesPrompt tells the model it may change rows
+const writesPrompt = `
+The user may ask to change rows. Then write one INSERT, UPDATE or DELETE statement,
+with a WHERE clause that picks only the rows the user means. It runs in a transaction,
+and the user sees the rows it changes before deciding whether to commit it.
+`
+
+// checkWrite refuses a write that could do more than change the rows of the tables it names
+func (c *Client) checkWrite(query string) error {
+	// the statement itself writes, but nothing inside it may
+	tokens := lexSQL(query)
+	if problem := unclearQuote(tokens); problem != "" {
+		return fmt.Errorf("refused a write, since %s: %s", problem, query)
+	}
+	stmt := splitStatements(tokens)[0]
+	if problem := tsqlBatchProblem(stmt); problem != "" {
+		return fmt.Errorf("refused a write, since %s: %s", problem, query)
+	}
+	for i, t := range stmt {
+		if i+1 >= len(stmt) {
+			break
+		}
+		next := stmt[i+1]
+		switch {
+		case t.Kind == sqlPunct && t.Text == "(" && writeKeywords[next.upper()]:
+			return fmt.Errorf("refused a write, since it has a %s inside it: %s", next.upper(), query)
+		case next.Text == "(" && isSideEffect(t):
+			return fmt.Errorf("refused a write, since %s does more than return a value: %s", t.Text, query)
+		}
+	}
+	if c.Config == nil {
+		return nil
+	}
+	for _, table := range referencedTables(query) {
+		m, err := c.Config.masks(table)
+		if err != nil {
+			return err
+		}
+		if tc := c.Config.tableConfig(table); len(m) > 0 || tc != nil && tc.MinGroupSize > 1 {
+			return fmt.Errorf("refused a write on table %s, since it has masks or a min_group_size, and what a write changes comes back unhidden", table)
+		}
+	}
+	return nil
+}
+
+// returning is the write with RETURNING *, on the databases that have it, or "" on the others
+func returning(query string) string {
+	if *driver != "postgres" && *driver != "sqlite" && *driver != "duckdb" {
+		return ""
+	}
+	for _, t := range lexSQL(query) {
+		if t.upper() == "RETURNING" {
+			return query
+		}
+	}
+	return trimStatement(query) + "\nRETURNING *"
+}
+
+// executeWrite runs the write in a transaction, and commits it only when ConfirmWrites says to
+func (c *Client) executeWrite(r *askRun) error {
+	// the transaction waits for the answer, which -query-timeout doesn't count
+	tx, err := c.DB.BeginTx(c.runContext(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin a transaction: %v", err)
+	}
+	// a rollback after the commit does nothing
+	defer tx.Rollback()
+	r.executions++
+	buf, affected, err := c.execWrite(tx, r.query)
+	if err != nil {
+		return c.retryExecution(r, err)
+	}
+	preview, _ := buf.head(c.MaxLLMBytes)
+	if !c.ConfirmWrites.ConfirmWrite(r.query, affected, preview) {
+		buf.Close()
+		return fmt.Errorf("the write, which changed %d rows, wasn't confirmed, so it was rolled back", affected)
+	}
+	if err := tx.Commit(); err != nil {
+		buf.Close()
+		return fmt.Errorf("failed to commit: %v", err)
+	}
+	log.Printf("Committed a write that changed %d rows", affected)
+	// the rows it changed aren't a series to look at
+	r.anomalies, r.forecast = false, false
+	r.wrote = true
+	r.buf = buf
+	r.result = fmt.Sprintf("The query changed %d rows, and was committed.", affected)
+	if preview != "" {
+		r.result += fmt.Sprintf(" The first %d of them are now:\n%s", buf.rows, preview)
+	}
+	r.answer.Result = r.result
+	r.answer.Rows = int(affected)
+	return nil
+}
+
+// execWrite runs the write, with the first rows it changed when the database returns them
+func (c *Client) execWrite(tx *sql.Tx, query string) (*resultBuffer, int64, error) {
+	ctx, cancel := c.queryContext()
+	defer cancel()
+	buf := newResultBuffer(c.BufferBytes)
+	withRows := returning(query)
+	if withRows == "" {
+		res, err := tx.ExecContext(ctx, c.annotate(query))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to execute query: %v", c.queryError(ctx, err))
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to count the rows changed: %v", err)
+		}
+		return buf, affected, nil
+	}
+	rows, err := tx.QueryContext(ctx, c.annotate(withRows))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to execute query: %v", c.queryError(ctx, err))
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get columns: %v", err)
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get columns: %v", err)
+	}
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	var affected int64
+	for rows.Next() {
+		// every row is counted, and the first are shown
+		affected++
+		if buf.rows >= writePreviewRows {
+			continue
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			buf.Close()
+			return nil, 0, fmt.Errorf("failed to scan row: %v", err)
+		}
+		row := make([]string, len(columns))
+		for i, col := range columns {
+			v := values[i]
+			if x, ok := v.([]byte); ok {
+				v = promptValue(types[i].DatabaseTypeName(), x)
+			}
+			row[i] = fmt.Sprintf("%s: %v", col, v)
+		}
+		if err := buf.writeRow(row, nil); err != nil {
+			buf.Close()
+			return nil, 0, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		buf.Close()
+		return nil, 0, fmt.Errorf("failed to execute query: %v", c.queryError(ctx, err))
+	}
+	return buf, affected, nil
+}
@@ -0,0 +1,37 @@
+package gorag
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckWrite(t *testing.T) {
+	cases := []struct {
+		driver string
+		query  string
+		want   string // in the error, or "" when the write may run
+	}{
+		{"postgres", `UPDATE orders SET status = 'cancelled' WHERE test`, ""},
+		{"postgres", `DELETE FROM orders WHERE id IN (SELECT id FROM orders WHERE test)`, ""},
+		{"postgres", `DELETE FROM orders WHERE id IN (DELETE FROM x RETURNING id)`, "DELETE inside it"},
+		{"postgres", `UPDATE orders SET a = pg_sleep(1)`, "does more than return a value"},
+		{"sqlserver", `UPDATE orders SET status = 'x' WHERE id = 1`, ""},
+		{"sqlserver", `UPDATE orders SET status = 'x' DROP TABLE orders`, "DROP starts another statement"},
+		{"sqlserver", `UPDATE orders SET status = 'x' SET NOCOUNT ON`, "SET starts another statement"},
+		{"sqlserver", "DELETE FROM orders WHERE id = 1\nEXEC xp_cmdshell 'dir'", "EXEC starts another statement"},
+	}
+	c := &Client{}
+	for _, tc := range cases {
+		withDriver(t, tc.driver)
+		got := ""
+		if err := c.checkWrite(tc.query); err != nil {
+			got = err.Error()
+		}
+		switch {
+		case tc.want == "" && got != "":
+			t.Errorf("%s: %q was refused: %s", tc.driver, tc.query, got)
+		case tc.want != "" && !strings.Contains(got, tc.want):
+			t.Errorf("%s: %q: got %q, want an error with %q", tc.driver, tc.query, got, tc.want)
+		}
+	}
+}